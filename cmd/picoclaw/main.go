@@ -8,16 +8,22 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	chromem "github.com/philippgille/chromem-go"
@@ -35,6 +41,7 @@ import (
 	"github.com/sipeed/picoclaw/pkg/memory"
 	"github.com/sipeed/picoclaw/pkg/migrate"
 	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/session"
 	"github.com/sipeed/picoclaw/pkg/skills"
 	"github.com/sipeed/picoclaw/pkg/state"
 	"github.com/sipeed/picoclaw/pkg/tools"
@@ -132,9 +139,16 @@ func main() {
 	case "onboard":
 		onboard()
 	case "agent":
-		agentCmd()
+		// Exit only once the command's deferred cleanup has run
+		if err := agentCmd(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
 	case "gateway":
-		gatewayCmd()
+		if err := gatewayCmd(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
 	case "status":
 		statusCmd()
 	case "migrate":
@@ -145,6 +159,8 @@ func main() {
 		cronCmd()
 	case "memory":
 		memoryCmd()
+	case "session":
+		sessionCmd()
 	case "skills":
 		if len(os.Args) < 3 {
 			skillsHelp()
@@ -215,6 +231,7 @@ func printHelp() {
 	fmt.Println("  status      Show picoclaw status")
 	fmt.Println("  cron        Manage scheduled tasks")
 	fmt.Println("  memory      Manage semantic memory (backfill)")
+	fmt.Println("  session     Inspect, export and manage conversation sessions")
 	fmt.Println("  migrate     Migrate from OpenClaw to PicoClaw")
 	fmt.Println("  skills      Manage skills (install, list, remove)")
 	fmt.Println("  version     Show version information")
@@ -374,9 +391,11 @@ func migrateHelp() {
 	fmt.Println("  picoclaw migrate --force      Migrate without confirmation")
 }
 
-func agentCmd() {
+func agentCmd() error {
 	message := ""
 	sessionKey := "cli:default"
+	agentName := ""
+	recordPath, replayPath := "", ""
 
 	args := os.Args[2:]
 	for i := 0; i < len(args); i++ {
@@ -394,6 +413,21 @@ func agentCmd() {
 				sessionKey = args[i+1]
 				i++
 			}
+		case "-a", "--agent":
+			if i+1 < len(args) {
+				agentName = args[i+1]
+				i++
+			}
+		case "--record":
+			if i+1 < len(args) {
+				recordPath = args[i+1]
+				i++
+			}
+		case "--replay":
+			if i+1 < len(args) {
+				replayPath = args[i+1]
+				i++
+			}
 		}
 	}
 
@@ -403,6 +437,13 @@ func agentCmd() {
 		os.Exit(1)
 	}
 
+	if agentName != "" {
+		if cfg, err = cfg.ForAgent(agentName); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Validate workspace SOUL.md to catch misconfigured volume mounts
 	soulPath := filepath.Join(cfg.WorkspacePath(), "SOUL.md")
 	if data, err := os.ReadFile(soulPath); err != nil {
@@ -416,7 +457,14 @@ func agentCmd() {
 		os.Exit(1)
 	}
 
-	provider, err := providers.CreateProviderWithFallback(cfg)
+	cassette, err := openCassette(recordPath, replayPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeCassette(cassette)
+
+	provider, err := createProvider(cfg, cassette)
 	if err != nil {
 		fmt.Printf("Error creating provider: %v\n", err)
 		os.Exit(1)
@@ -446,6 +494,7 @@ func agentCmd() {
 		fmt.Printf("%s Interactive mode (Ctrl+C to exit)\n\n", logo)
 		interactiveMode(agentLoop, sessionKey)
 	}
+	return verifyCassette(cassette)
 }
 
 func interactiveMode(agentLoop *agent.AgentLoop, sessionKey string) {
@@ -534,14 +583,79 @@ func simpleInteractiveMode(agentLoop *agent.AgentLoop, sessionKey string) {
 	}
 }
 
-func gatewayCmd() {
-	// Check for --debug flag
+// openCassette opens the cassette given with --record or --replay, or
+// returns nil if neither was given.
+func openCassette(recordPath, replayPath string) (*providers.Cassette, error) {
+	switch {
+	case recordPath != "" && replayPath != "":
+		return nil, fmt.Errorf("--record and --replay cannot be used together")
+	case recordPath != "":
+		fmt.Printf("📼 Recording LLM calls to %s\n", recordPath)
+		return providers.OpenCassette(recordPath, providers.CassetteRecord)
+	case replayPath != "":
+		fmt.Printf("📼 Replaying LLM calls from %s\n", replayPath)
+		return providers.OpenCassette(replayPath, providers.CassetteReplay)
+	}
+	return nil, nil
+}
+
+func closeCassette(cassette *providers.Cassette) {
+	if cassette == nil {
+		return
+	}
+	if err := cassette.Close(); err != nil {
+		fmt.Printf("Error closing cassette: %v\n", err)
+	}
+}
+
+// verifyCassette returns an error if a replay diverged from its cassette, so
+// scripted replays fail when the agent's behavior changes.
+func verifyCassette(cassette *providers.Cassette) error {
+	if cassette == nil || cassette.Mode() != providers.CassetteReplay {
+		return nil
+	}
+	if err := cassette.Verify(); err != nil {
+		return fmt.Errorf("replay mismatch: %w", err)
+	}
+	fmt.Println("📼 Replay matched the cassette")
+	return nil
+}
+
+// createProvider creates the LLM provider for cfg. With a replay cassette no
+// real provider is created, so replays need no credentials or network.
+func createProvider(cfg *config.Config, cassette *providers.Cassette) (providers.LLMProvider, error) {
+	if cassette != nil && cassette.Mode() == providers.CassetteReplay {
+		return cassette.Provider(nil), nil
+	}
+	provider, err := providers.CreateProviderWithFallback(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cassette != nil {
+		return cassette.Provider(provider), nil
+	}
+	return provider, nil
+}
+
+func gatewayCmd() error {
+	recordPath, replayPath := "", ""
+
 	args := os.Args[2:]
-	for _, arg := range args {
-		if arg == "--debug" || arg == "-d" {
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--debug", "-d":
 			logger.SetLevel(logger.DEBUG)
 			fmt.Println("🔍 Debug mode enabled")
-			break
+		case "--record":
+			if i+1 < len(args) {
+				recordPath = args[i+1]
+				i++
+			}
+		case "--replay":
+			if i+1 < len(args) {
+				replayPath = args[i+1]
+				i++
+			}
 		}
 	}
 
@@ -564,17 +678,37 @@ func gatewayCmd() {
 		os.Exit(1)
 	}
 
-	provider, err := providers.CreateProviderWithFallback(cfg)
+	cassette, err := openCassette(recordPath, replayPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeCassette(cassette)
+
+	provider, err := createProvider(cfg, cassette)
 	if err != nil {
 		fmt.Printf("Error creating provider: %v\n", err)
 		os.Exit(1)
 	}
 
 	msgBus := bus.NewMessageBus()
-	agentLoop := agent.NewAgentLoop(cfg, msgBus, provider)
+	router, err := agent.NewRouter(cfg, msgBus, func(agentCfg *config.Config) (providers.LLMProvider, error) {
+		if agentCfg == cfg {
+			return provider, nil
+		}
+		return createProvider(agentCfg, cassette)
+	})
+	if err != nil {
+		fmt.Printf("Error creating agents: %v\n", err)
+		os.Exit(1)
+	}
+	agentLoop := router.Default()
 
 	// Print agent startup info
 	fmt.Println("\n📦 Agent Status:")
+	if names := router.Names(); len(names) > 1 {
+		fmt.Printf("  • Agents: %s\n", strings.Join(names, ", "))
+	}
 	startupInfo := agentLoop.GetStartupInfo()
 	toolsInfo := startupInfo["tools"].(map[string]interface{})
 	skillsInfo := startupInfo["skills"].(map[string]interface{})
@@ -591,31 +725,18 @@ func gatewayCmd() {
 			"skills_available": skillsInfo["available"],
 		})
 
-	// Setup cron tool and service
-	cronService := setupCronTool(agentLoop, msgBus, cfg.WorkspacePath())
-
-	heartbeatService := heartbeat.NewHeartbeatService(
-		cfg.WorkspacePath(),
-		cfg.Heartbeat.Interval,
-		cfg.Heartbeat.Enabled,
-	)
-	heartbeatService.SetBus(msgBus)
-	heartbeatService.SetHandler(func(prompt, channel, chatID string) *tools.ToolResult {
-		// Use cli:direct as fallback if no valid channel
-		if channel == "" || chatID == "" {
-			channel, chatID = "cli", "direct"
-		}
-		// Use ProcessHeartbeat - no session history, each heartbeat is independent
-		response, err := agentLoop.ProcessHeartbeat(context.Background(), prompt, channel, chatID)
-		if err != nil {
-			return tools.ErrorResult(fmt.Sprintf("Heartbeat error: %v", err))
-		}
-		if response == "HEARTBEAT_OK" {
-			return tools.SilentResult("Heartbeat OK")
+	// Setup cron tool and service, and the heartbeat, once per workspace:
+	// agents sharing a workspace leave them to the first agent using it
+	var cronServices []*cron.CronService
+	var heartbeatServices []*heartbeat.HeartbeatService
+	scheduled := map[string]bool{}
+	router.ForEach(func(name string, al *agent.AgentLoop) {
+		if scheduled[al.Workspace()] {
+			return
 		}
-		// For heartbeat, always return silent - the subagent result will be
-		// sent to user via processSystemMessage when the async task completes
-		return tools.SilentResult(response)
+		scheduled[al.Workspace()] = true
+		cronServices = append(cronServices, setupCronTool(al, msgBus, al.Workspace()))
+		heartbeatServices = append(heartbeatServices, setupHeartbeat(al, msgBus, cfg.Heartbeat))
 	})
 
 	// Email monitor setup
@@ -640,6 +761,10 @@ func gatewayCmd() {
 				emChatID = parts[1]
 			}
 			emailMonitor = emailpkg.NewEmailMonitor(accounts, provider, cheapModel, cfg.WorkspacePath(), msgBus, emChannel, emChatID)
+			emailMonitor.SetLLMOptions(cfg.Agents.Generation.Resolve(config.GenerationSelector{
+				Model: cheapModel,
+				Task:  config.TaskTriage,
+			}).Options())
 			intervalMins := cfg.Tools.Email.Monitor.IntervalMins
 			if intervalMins < 1 {
 				intervalMins = 5
@@ -664,7 +789,9 @@ func gatewayCmd() {
 	// Wire manage_telegram tool if Telegram channel is available
 	if telegramChannel, ok := channelManager.GetChannel("telegram"); ok {
 		if tc, ok := telegramChannel.(*channels.TelegramChannel); ok {
-			agentLoop.RegisterTool(tools.NewManageTelegramTool(tc.GetBot()))
+			router.ForEach(func(name string, al *agent.AgentLoop) {
+				al.RegisterTool(tools.NewManageTelegramTool(tc.GetBot()))
+			})
 			logger.InfoC("telegram", "manage_telegram tool registered")
 		}
 	}
@@ -697,28 +824,50 @@ func gatewayCmd() {
 		fmt.Println("⚠ Warning: No channels enabled")
 	}
 
+	pidPath := filepath.Join(cfg.WorkspacePath(), gatewayPIDFile)
+	if err := os.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		fmt.Printf("Warning: could not write %s: %v\n", pidPath, err)
+	}
+	defer os.Remove(pidPath)
+
 	fmt.Printf("✓ Gateway started on %s:%d\n", cfg.Gateway.Host, cfg.Gateway.Port)
 	fmt.Println("Press Ctrl+C to stop")
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if err := cronService.Start(); err != nil {
-		fmt.Printf("Error starting cron service: %v\n", err)
+	for _, cronService := range cronServices {
+		if err := cronService.Start(); err != nil {
+			fmt.Printf("Error starting cron service: %v\n", err)
+		}
+
+		// Register weekly specialist self-improvement review (Sunday 3 AM)
+		cronService.AddJob("specialist-review", cron.CronSchedule{
+			Kind: "cron",
+			Expr: "0 3 * * 0",
+		}, "REVIEW_SPECIALISTS", false, "", "")
 	}
 	fmt.Println("✓ Cron service started")
 
-	// Register weekly specialist self-improvement review (Sunday 3 AM)
-	cronService.AddJob("specialist-review", cron.CronSchedule{
-		Kind: "cron",
-		Expr: "0 3 * * 0",
-	}, "REVIEW_SPECIALISTS", false, "", "")
-
-	if err := heartbeatService.Start(); err != nil {
-		fmt.Printf("Error starting heartbeat service: %v\n", err)
+	for _, heartbeatService := range heartbeatServices {
+		if err := heartbeatService.Start(); err != nil {
+			fmt.Printf("Error starting heartbeat service: %v\n", err)
+		}
 	}
 	fmt.Println("✓ Heartbeat service started")
 
+	// One janitor per sessions directory; agents may share a workspace
+	janitors := map[string]bool{}
+	router.ForEach(func(name string, al *agent.AgentLoop) {
+		if al.SessionRetentionEnabled() && !janitors[al.SessionsDir()] {
+			go al.RunSessionJanitor(ctx)
+			janitors[al.SessionsDir()] = true
+		}
+	})
+	if len(janitors) > 0 {
+		fmt.Println("✓ Session janitor started")
+	}
+
 	stateManager := state.NewManager(cfg.WorkspacePath())
 	deviceService := devices.NewService(devices.Config{
 		Enabled:    cfg.Devices.Enabled,
@@ -731,13 +880,17 @@ func gatewayCmd() {
 		fmt.Println("✓ Device event service started")
 	}
 
-	agentLoop.SetStreamUpdater(channelManager.CreateStreamUpdater())
+	streamUpdater := channelManager.CreateStreamUpdater()
+	router.ForEach(func(name string, al *agent.AgentLoop) {
+		al.SetStreamUpdater(streamUpdater)
+	})
 
 	if err := channelManager.StartAll(ctx); err != nil {
 		fmt.Printf("Error starting channels: %v\n", err)
 	}
+	channelManager.PublishCommands(ctx, agentLoop.Commands().List())
 
-	go agentLoop.Run(ctx)
+	go router.Run(ctx)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
@@ -749,11 +902,43 @@ func gatewayCmd() {
 		emailMonitor.Stop()
 	}
 	deviceService.Stop()
-	heartbeatService.Stop()
-	cronService.Stop()
-	agentLoop.Stop()
+	for _, heartbeatService := range heartbeatServices {
+		heartbeatService.Stop()
+	}
+	for _, cronService := range cronServices {
+		cronService.Stop()
+	}
+	router.Stop()
 	channelManager.StopAll(ctx)
 	fmt.Println("✓ Gateway stopped")
+	return verifyCassette(cassette)
+}
+
+// gatewayPIDFile is written to the workspace while the gateway runs, so
+// commands that change its files can tell.
+const gatewayPIDFile = "gateway.pid"
+
+// runningGateway returns the PID of the gateway running on workspace, or 0
+// if there is none.
+func runningGateway(workspace string) int {
+	data, err := os.ReadFile(filepath.Join(workspace, gatewayPIDFile))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return 0
+	}
+	// Signal 0 only checks the process exists. Where signals are not
+	// supported, finding the process is all there is to go on.
+	if err := p.Signal(syscall.Signal(0)); errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH) {
+		return 0
+	}
+	return pid
 }
 
 func statusCmd() {
@@ -796,6 +981,7 @@ func statusCmd() {
 		hasZhipu := cfg.Providers.Zhipu.APIKey != ""
 		hasGroq := cfg.Providers.Groq.APIKey != ""
 		hasVLLM := cfg.Providers.VLLM.APIBase != ""
+		hasOllama := cfg.Providers.Ollama.APIBase != ""
 
 		status := func(enabled bool) string {
 			if enabled {
@@ -814,6 +1000,14 @@ func statusCmd() {
 		} else {
 			fmt.Println("vLLM/Local: not set")
 		}
+		if hasOllama {
+			fmt.Printf("Ollama: ✓ %s\n", cfg.Providers.Ollama.APIBase)
+		} else {
+			fmt.Println("Ollama: not set")
+		}
+		for _, inst := range cfg.Providers.Instances {
+			fmt.Printf("Instance %s (%s): ✓ %s\n", inst.Name, inst.Kind, inst.APIBase)
+		}
 
 		store, _ := auth.LoadStore()
 		if store != nil && len(store.Credentials) > 0 {
@@ -1108,6 +1302,35 @@ func setupCronTool(agentLoop *agent.AgentLoop, msgBus *bus.MessageBus, workspace
 	return cronService
 }
 
+// setupHeartbeat creates the heartbeat service of agentLoop's workspace,
+// which runs the workspace's HEARTBEAT.md tasks with that agent.
+func setupHeartbeat(agentLoop *agent.AgentLoop, msgBus *bus.MessageBus, hc config.HeartbeatConfig) *heartbeat.HeartbeatService {
+	heartbeatService := heartbeat.NewHeartbeatService(
+		agentLoop.Workspace(),
+		hc.Interval,
+		hc.Enabled,
+	)
+	heartbeatService.SetBus(msgBus)
+	heartbeatService.SetHandler(func(prompt, channel, chatID string) *tools.ToolResult {
+		// Use cli:direct as fallback if no valid channel
+		if channel == "" || chatID == "" {
+			channel, chatID = "cli", "direct"
+		}
+		// Use ProcessHeartbeat - no session history, each heartbeat is independent
+		response, err := agentLoop.ProcessHeartbeat(context.Background(), prompt, channel, chatID)
+		if err != nil {
+			return tools.ErrorResult(fmt.Sprintf("Heartbeat error: %v", err))
+		}
+		if response == "HEARTBEAT_OK" {
+			return tools.SilentResult("Heartbeat OK")
+		}
+		// For heartbeat, always return silent - the subagent result will be
+		// sent to user via processSystemMessage when the async task completes
+		return tools.SilentResult(response)
+	})
+	return heartbeatService
+}
+
 func loadConfig() (*config.Config, error) {
 	return config.LoadConfig(getConfigPath())
 }
@@ -1527,6 +1750,351 @@ func skillsShowCmd(loader *skills.SkillsLoader, skillName string) {
 	fmt.Println(content)
 }
 
+func sessionCmd() {
+	if len(os.Args) < 3 {
+		sessionHelp()
+		return
+	}
+
+	if os.Args[2] == "migrate" {
+		sessionMigrateCmd()
+		return
+	}
+
+	args, err := parseSessionArgs(os.Args[3:])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		sessionHelp()
+		os.Exit(1)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	sessionsDir := filepath.Join(cfg.WorkspacePath(), "sessions")
+	store, err := session.OpenStore(cfg.Sessions.Store, sessionsDir)
+	if err != nil {
+		fmt.Printf("Error opening session store: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	switch os.Args[2] {
+	case "list":
+		err = sessionListCmd(store, args)
+	case "show":
+		args.format = session.FormatMarkdown
+		args.output = ""
+		err = sessionExportCmd(store, args)
+	case "export":
+		err = sessionExportCmd(store, args)
+	case "delete":
+		if pid := runningGateway(cfg.WorkspacePath()); pid != 0 {
+			err = fmt.Errorf("the gateway is running (pid %d) and would save the sessions it has loaded again; stop it first", pid)
+			break
+		}
+		err = sessionDeleteCmd(store, filepath.Join(sessionsDir, session.MediaDirname), args)
+	case "grep":
+		err = sessionGrepCmd(store, args)
+	default:
+		fmt.Printf("Unknown session command: %s\n", os.Args[2])
+		sessionHelp()
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func sessionHelp() {
+	fmt.Println("\nSession commands:")
+	fmt.Println("  list                 List sessions, most recent first")
+	fmt.Println("  show <key>           Print a session as a Markdown transcript")
+	fmt.Println("  export <key>         Export a session")
+	fmt.Println("  delete [key...]      Delete sessions by key or by filter")
+	fmt.Println("  grep <pattern>       Search messages with a regular expression")
+	fmt.Println("  migrate              Copy sessions from the JSON files into the SQLite store")
+	fmt.Println()
+	fmt.Println("Filters (list, delete, grep):")
+	fmt.Println("  --channel <name>     Only sessions of this channel (e.g. telegram)")
+	fmt.Println("  --since <when>       Only sessions active since a date (2006-01-02) or duration ago (12h, 7d)")
+	fmt.Println("  --until <when>       Only sessions last active before a date or duration ago")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --format <fmt>       Export format: md (default), jsonl (OpenAI messages) or html")
+	fmt.Println("  -o, --output <file>  Write the export to a file instead of stdout")
+	fmt.Println("  -i                   Case-insensitive grep")
+	fmt.Println("  -y, --yes            Delete without asking")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  picoclaw session list --channel telegram --since 7d")
+	fmt.Println("  picoclaw session export telegram:123456 --format html -o chat.html")
+	fmt.Println("  picoclaw session grep -i \"invoice\" --since 2026-01-01")
+}
+
+// sessionArgs are the parsed flags and arguments of a session subcommand.
+type sessionArgs struct {
+	filter     session.Filter
+	format     string
+	output     string
+	yes        bool
+	ignoreCase bool
+	positional []string
+}
+
+func parseSessionArgs(raw []string) (sessionArgs, error) {
+	args := sessionArgs{format: session.FormatMarkdown}
+	for i := 0; i < len(raw); i++ {
+		arg := raw[i]
+		value := func() (string, error) {
+			if i+1 >= len(raw) {
+				return "", fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			return raw[i], nil
+		}
+
+		var err error
+		switch arg {
+		case "--channel":
+			args.filter.Channel, err = value()
+		case "--since", "--until":
+			var v string
+			var t time.Time
+			if v, err = value(); err == nil {
+				if t, err = parseSessionTime(v); err == nil {
+					if arg == "--since" {
+						args.filter.Since = t
+					} else {
+						args.filter.Until = t
+					}
+				}
+			}
+		case "--format", "-f":
+			args.format, err = value()
+		case "--output", "-o":
+			args.output, err = value()
+		case "--yes", "-y":
+			args.yes = true
+		case "-i":
+			args.ignoreCase = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				err = fmt.Errorf("unknown option %s", arg)
+			}
+			args.positional = append(args.positional, arg)
+		}
+		if err != nil {
+			return args, err
+		}
+	}
+	return args, nil
+}
+
+// parseSessionTime reads a date ("2006-01-02", local time), an RFC 3339
+// timestamp, or a duration ago such as "12h" or "7d".
+func parseSessionTime(v string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (want 2006-01-02, RFC 3339, or a duration like 12h or 7d)", v)
+}
+
+func sessionListCmd(store session.SessionStore, args sessionArgs) error {
+	sessions, err := session.Find(store, args.filter)
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No sessions found.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tMESSAGES\tUPDATED\tSUMMARY")
+	for _, s := range sessions {
+		summary := strings.Join(strings.Fields(s.Summary), " ")
+		if len([]rune(summary)) > 50 {
+			summary = string([]rune(summary)[:50]) + "…"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", s.Key, len(s.Messages), s.Updated.Local().Format("2006-01-02 15:04"), summary)
+	}
+	w.Flush()
+	fmt.Printf("\n%d sessions\n", len(sessions))
+	return nil
+}
+
+func sessionExportCmd(store session.SessionStore, args sessionArgs) error {
+	if len(args.positional) != 1 {
+		return fmt.Errorf("expected one session key")
+	}
+	s, err := store.Load(args.positional[0])
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return fmt.Errorf("session %q not found", args.positional[0])
+	}
+
+	if args.output == "" {
+		return session.Export(os.Stdout, s, args.format)
+	}
+
+	var buf bytes.Buffer
+	if err := session.Export(&buf, s, args.format); err != nil {
+		return err
+	}
+	if err := os.WriteFile(args.output, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Exported %s (%d messages) to %s\n", s.Key, len(s.Messages), args.output)
+	return nil
+}
+
+func sessionDeleteCmd(store session.SessionStore, mediaDir string, args sessionArgs) error {
+	var keys, missing []string
+	for _, key := range args.positional {
+		s, err := store.Load(key)
+		if err != nil {
+			return fmt.Errorf("load %s: %w", key, err)
+		}
+		if s == nil {
+			fmt.Printf("No session %s\n", key)
+			missing = append(missing, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(args.positional) == 0 {
+		if args.filter == (session.Filter{}) {
+			return fmt.Errorf("give session keys or a filter (--channel, --since, --until)")
+		}
+		sessions, err := session.Find(store, args.filter)
+		if err != nil {
+			return err
+		}
+		for _, s := range sessions {
+			keys = append(keys, s.Key)
+		}
+	}
+	if len(keys) == 0 {
+		if len(missing) > 0 {
+			return fmt.Errorf("%d sessions not found", len(missing))
+		}
+		fmt.Println("No sessions found.")
+		return nil
+	}
+
+	if !args.yes {
+		for _, key := range keys {
+			fmt.Printf("  %s\n", key)
+		}
+		fmt.Printf("Delete %d sessions? (y/n): ", len(keys))
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" {
+			fmt.Println("Aborted.")
+			return nil
+		}
+	}
+
+	for _, key := range keys {
+		if err := store.Delete(key); err != nil {
+			return fmt.Errorf("delete %s: %w", key, err)
+		}
+	}
+	fmt.Printf("✓ Deleted %d sessions\n", len(keys))
+
+	removed, err := session.PruneMedia(store, mediaDir, time.Now())
+	if err != nil {
+		return fmt.Errorf("remove attachments: %w", err)
+	}
+	if removed > 0 {
+		fmt.Printf("✓ Removed %d attachments no session refers to\n", removed)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%d sessions not found", len(missing))
+	}
+	return nil
+}
+
+func sessionGrepCmd(store session.SessionStore, args sessionArgs) error {
+	if len(args.positional) != 1 {
+		return fmt.Errorf("expected one pattern")
+	}
+	pattern := args.positional[0]
+	if args.ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	sessions, err := session.Find(store, args.filter)
+	if err != nil {
+		return err
+	}
+	matches := session.Grep(sessions, re)
+	for _, m := range matches {
+		fmt.Printf("%s #%d %s: %s\n", m.Key, m.Index, m.Role, m.Line)
+	}
+	if len(matches) == 0 {
+		fmt.Println("No matches.")
+	}
+	return nil
+}
+
+func sessionMigrateCmd() {
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	sessionsDir := filepath.Join(cfg.WorkspacePath(), "sessions")
+	if _, err := os.Stat(sessionsDir); err != nil {
+		fmt.Printf("Sessions directory not found: %s\n", sessionsDir)
+		os.Exit(1)
+	}
+
+	from, err := session.OpenStore(session.StoreJSON, sessionsDir)
+	if err != nil {
+		fmt.Printf("Error opening JSON sessions: %v\n", err)
+		os.Exit(1)
+	}
+	to, err := session.OpenStore(session.StoreSQLite, sessionsDir)
+	if err != nil {
+		fmt.Printf("Error opening SQLite store: %v\n", err)
+		os.Exit(1)
+	}
+	defer to.Close()
+
+	copied, err := session.CopySessions(from, to)
+	if err != nil {
+		fmt.Printf("Migration failed after %d sessions: %v\n", copied, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✓ Migrated %d sessions to %s\n", copied, filepath.Join(sessionsDir, session.SQLiteFilename))
+	fmt.Println("The JSON files were left in place. To use the new store, set in your config:")
+	fmt.Println(`  "sessions": { "store": "sqlite" }`)
+}
+
 func memoryCmd() {
 	if len(os.Args) < 3 {
 		memoryHelp()
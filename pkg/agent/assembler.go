@@ -0,0 +1,272 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/media"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// imageTokens is roughly what providers charge for a full-size image.
+const imageTokens = 1600
+
+// contextAssembler fits the conversation summary and history into a token
+// budget before they are sent to the LLM. Large tool results from older
+// turns are trimmed first, then elided, and only then are whole turns
+// dropped, oldest first. Messages are never removed individually, so an
+// assistant tool call always keeps its results.
+//
+// Besides its own budgets, the history must fit in what is left of the
+// prompt budget once the system prompt, summary and new message are in.
+type contextAssembler struct {
+	promptTokens     int // everything sent, leaving a quarter of the window for the reply
+	historyTokens    int
+	summaryTokens    int
+	toolResultTokens int
+	keepRecentTurns  int
+}
+
+// elision records one change the assembler made to the context.
+type elision struct {
+	Index        int    // position in the original history
+	Role         string // role of the affected message
+	Tool         string // tool name, for tool results
+	Action       string // "trimmed", "elided" or "dropped"
+	TokensBefore int
+	TokensAfter  int
+}
+
+func newContextAssembler(cfg config.ContextBudgetConfig, contextWindow int) contextAssembler {
+	a := contextAssembler{
+		promptTokens:     contextWindow * 75 / 100,
+		historyTokens:    cfg.HistoryTokens,
+		summaryTokens:    cfg.SummaryTokens,
+		toolResultTokens: cfg.ToolResultTokens,
+		keepRecentTurns:  cfg.KeepRecentTurns,
+	}
+	if a.historyTokens <= 0 {
+		a.historyTokens = contextWindow * 75 / 100
+	}
+	if a.summaryTokens <= 0 {
+		a.summaryTokens = contextWindow / 8
+	}
+	if a.keepRecentTurns < 1 {
+		a.keepRecentTurns = 1
+	}
+	return a
+}
+
+// estimateMessageTokens estimates the tokens of a message, tool call
+// arguments, media parts and attachments included. Text counts a token per
+// three runes, so that CJK and other multi-byte characters are not
+// over-counted.
+func estimateMessageTokens(m providers.Message) int {
+	runes := utf8.RuneCountInString(m.Content)
+	for _, tc := range m.ToolCalls {
+		if tc.Function != nil {
+			runes += utf8.RuneCountInString(tc.Function.Arguments)
+		}
+	}
+	tokens := runes / 3
+	for _, part := range m.ContentParts {
+		tokens += estimatePartTokens(part)
+	}
+	for _, a := range m.Attachments {
+		tokens += estimateAttachmentTokens(a)
+	}
+	return tokens
+}
+
+func estimatePartTokens(part media.ContentPart) int {
+	if part.Type == "image" {
+		return imageTokens
+	}
+	return utf8.RuneCountInString(part.Text) / 3
+}
+
+// estimateAttachmentTokens estimates an attachment as if it were resent in
+// full; one that is gone is sent as its caption.
+func estimateAttachmentTokens(a media.Attachment) int {
+	info, err := os.Stat(a.Path)
+	switch {
+	case err != nil:
+		return utf8.RuneCountInString(a.Caption()) / 3
+	case strings.HasPrefix(a.MediaType, "image/"):
+		return imageTokens
+	default:
+		return int(info.Size()) / 3
+	}
+}
+
+// Assemble returns the summary and history to send, and what was changed to
+// fit them into the budget. reserved is the estimated size of everything
+// else sent with them: the system prompt and the new message. The history
+// slice is not modified.
+func (a contextAssembler) Assemble(history []providers.Message, summary string, reserved int) ([]providers.Message, string, []elision) {
+	var elisions []elision
+
+	if a.summaryTokens > 0 {
+		if before := utf8.RuneCountInString(summary) / 3; before > a.summaryTokens {
+			summary = truncateRunes(summary, a.summaryTokens*3) + "\n[... earlier summary trimmed to fit the context budget]"
+			elisions = append(elisions, elision{
+				Index: -1, Role: "summary", Action: "trimmed",
+				TokensBefore: before, TokensAfter: a.summaryTokens,
+			})
+		}
+	}
+
+	if len(history) == 0 {
+		return history, summary, elisions
+	}
+
+	budget := a.historyTokens
+	if room := a.promptTokens - reserved - utf8.RuneCountInString(summary)/3; room < budget {
+		budget = room
+	}
+
+	msgs := make([]providers.Message, len(history))
+	copy(msgs, history)
+	tokens := make([]int, len(msgs))
+	total := 0
+	for i, m := range msgs {
+		tokens[i] = estimateMessageTokens(m)
+		total += tokens[i]
+	}
+
+	// Turns start at user messages; tool results of the most recent turns
+	// are left intact.
+	var turnStarts []int
+	for i, m := range msgs {
+		if m.Role == "user" {
+			turnStarts = append(turnStarts, i)
+		}
+	}
+	recentStart := 0
+	if n := len(turnStarts); n >= a.keepRecentTurns {
+		recentStart = turnStarts[n-a.keepRecentTurns]
+	}
+	toolNames := toolNamesByCallID(msgs)
+
+	// 1. Trim large tool results from older turns
+	if a.toolResultTokens > 0 {
+		for i := 0; i < recentStart; i++ {
+			if msgs[i].Role != "tool" || tokens[i] <= a.toolResultTokens {
+				continue
+			}
+			content := msgs[i].Content
+			msgs[i].Content = truncateRunes(content, a.toolResultTokens*3) +
+				fmt.Sprintf("\n[... %d characters of this earlier tool result elided]", utf8.RuneCountInString(content)-a.toolResultTokens*3)
+			after := estimateMessageTokens(msgs[i])
+			elisions = append(elisions, elision{
+				Index: i, Role: "tool", Tool: toolNames[msgs[i].ToolCallID], Action: "trimmed",
+				TokensBefore: tokens[i], TokensAfter: after,
+			})
+			total += after - tokens[i]
+			tokens[i] = after
+		}
+	}
+
+	// 2. Over budget: elide older tool results entirely, oldest first
+	for i := 0; i < recentStart && total > budget; i++ {
+		if msgs[i].Role != "tool" {
+			continue
+		}
+		placeholder := msgs[i]
+		placeholder.Content = "[earlier tool result elided to fit the context budget]"
+		after := estimateMessageTokens(placeholder)
+		if after >= tokens[i] {
+			continue
+		}
+		msgs[i] = placeholder
+		elisions = append(elisions, elision{
+			Index: i, Role: "tool", Tool: toolNames[msgs[i].ToolCallID], Action: "elided",
+			TokensBefore: tokens[i], TokensAfter: after,
+		})
+		total += after - tokens[i]
+		tokens[i] = after
+	}
+
+	// 3. Still over budget: drop whole turns, oldest first, keeping the
+	// most recent one. Messages before the first user message belong to a
+	// turn whose start was already summarized away and go first.
+	start := 0
+	boundaries := append(turnStarts, len(msgs))
+	if len(turnStarts) == 0 || turnStarts[0] != 0 {
+		boundaries = append([]int{0}, boundaries...)
+	}
+	for b := 0; b+2 < len(boundaries) && total > budget; b++ {
+		end := boundaries[b+1]
+		for i := boundaries[b]; i < end; i++ {
+			elisions = append(elisions, elision{
+				Index: i, Role: msgs[i].Role, Tool: toolNames[msgs[i].ToolCallID], Action: "dropped",
+				TokensBefore: tokens[i],
+			})
+			total -= tokens[i]
+		}
+		start = end
+	}
+
+	return msgs[start:], summary, elisions
+}
+
+// logElisions reports in the debug log everything the assembler changed.
+func logElisions(sessionKey string, elisions []elision) {
+	if len(elisions) == 0 {
+		return
+	}
+
+	saved := 0
+	for _, e := range elisions {
+		saved += e.TokensBefore - e.TokensAfter
+		logger.DebugCF("agent", "Context elided",
+			map[string]interface{}{
+				"session_key":   sessionKey,
+				"index":         e.Index,
+				"role":          e.Role,
+				"tool":          e.Tool,
+				"action":        e.Action,
+				"tokens_before": e.TokensBefore,
+				"tokens_after":  e.TokensAfter,
+			})
+	}
+	logger.DebugCF("agent", "Context fitted to budget",
+		map[string]interface{}{
+			"session_key":  sessionKey,
+			"changes":      len(elisions),
+			"tokens_saved": saved,
+		})
+}
+
+// toolNamesByCallID maps tool call IDs to the names of the called tools.
+func toolNamesByCallID(msgs []providers.Message) map[string]string {
+	names := make(map[string]string)
+	for _, m := range msgs {
+		for _, tc := range m.ToolCalls {
+			name := tc.Name
+			if name == "" && tc.Function != nil {
+				name = tc.Function.Name
+			}
+			names[tc.ID] = name
+		}
+	}
+	return names
+}
+
+// truncateRunes returns the first n runes of s.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/media"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// toolTurn builds a turn in which the assistant reads a file of size chars.
+func toolTurn(id, question string, size int) []providers.Message {
+	return []providers.Message{
+		{Role: "user", Content: question},
+		{Role: "assistant", ToolCalls: []providers.ToolCall{{
+			ID: id, Type: "function",
+			Function: &providers.FunctionCall{Name: "read_file", Arguments: `{"path":"notes.md"}`},
+		}}},
+		{Role: "tool", Content: strings.Repeat("x", size), ToolCallID: id},
+		{Role: "assistant", Content: "done"},
+	}
+}
+
+// checkToolPairs fails if a tool result lacks its assistant tool call.
+func checkToolPairs(t *testing.T, msgs []providers.Message) {
+	t.Helper()
+	calls := make(map[string]bool)
+	for _, m := range msgs {
+		for _, tc := range m.ToolCalls {
+			calls[tc.ID] = true
+		}
+		if m.Role == "tool" && !calls[m.ToolCallID] {
+			t.Errorf("Tool result %s has no matching tool call", m.ToolCallID)
+		}
+	}
+}
+
+func TestContextAssembler_TrimsOldToolResults(t *testing.T) {
+	a := newContextAssembler(config.ContextBudgetConfig{ToolResultTokens: 100, KeepRecentTurns: 1}, 100000)
+
+	var history []providers.Message
+	history = append(history, toolTurn("call_1", "first", 3000)...)
+	history = append(history, toolTurn("call_2", "second", 3000)...)
+
+	got, _, elisions := a.Assemble(history, "", 0)
+	if len(got) != len(history) {
+		t.Fatalf("Expected no messages dropped, got %d of %d", len(got), len(history))
+	}
+	if len(elisions) != 1 || elisions[0].Action != "trimmed" || elisions[0].Tool != "read_file" || elisions[0].Index != 2 {
+		t.Fatalf("Expected the first turn's read_file result to be trimmed, got %+v", elisions)
+	}
+	if !strings.Contains(got[2].Content, "characters of this earlier tool result elided") {
+		t.Errorf("Expected an elision note, got %q", got[2].Content)
+	}
+	if len(got[6].Content) != 3000 {
+		t.Errorf("Expected the most recent turn's result to stay whole, got %d chars", len(got[6].Content))
+	}
+	if len(history[2].Content) != 3000 {
+		t.Error("Assemble must not modify the history it is given")
+	}
+}
+
+func TestContextAssembler_DropsWholeTurnsOverBudget(t *testing.T) {
+	a := newContextAssembler(config.ContextBudgetConfig{HistoryTokens: 50, KeepRecentTurns: 1}, 100000)
+
+	var history []providers.Message
+	history = append(history, providers.Message{Role: "assistant", Content: strings.Repeat("y", 300)})
+	history = append(history, toolTurn("call_1", "first", 600)...)
+	history = append(history, toolTurn("call_2", "second", 60)...)
+
+	got, _, elisions := a.Assemble(history, "", 0)
+	checkToolPairs(t, got)
+	if len(got) != 4 || got[0].Content != "second" {
+		t.Fatalf("Expected only the last turn to remain, got %+v", got)
+	}
+
+	dropped := 0
+	for _, e := range elisions {
+		if e.Action == "dropped" {
+			dropped++
+		}
+	}
+	if dropped != 5 {
+		t.Errorf("Expected 5 dropped messages to be reported, got %d in %+v", dropped, elisions)
+	}
+}
+
+func TestContextAssembler_TrimsSummary(t *testing.T) {
+	a := newContextAssembler(config.ContextBudgetConfig{SummaryTokens: 10}, 100000)
+
+	_, summary, elisions := a.Assemble(nil, strings.Repeat("s", 300), 0)
+	if !strings.HasPrefix(summary, strings.Repeat("s", 30)+"\n") || len(elisions) != 1 || elisions[0].Role != "summary" {
+		t.Errorf("Expected the summary to be trimmed to 30 chars, got %q, %+v", summary, elisions)
+	}
+}
+
+func TestContextAssembler_LeavesRoomForThePrompt(t *testing.T) {
+	a := newContextAssembler(config.ContextBudgetConfig{KeepRecentTurns: 1}, 1000)
+
+	var history []providers.Message
+	history = append(history, toolTurn("call_1", "first", 300)...)
+	history = append(history, toolTurn("call_2", "second", 30)...)
+
+	if got, _, _ := a.Assemble(history, "", 0); len(got) != len(history) {
+		t.Fatalf("Expected the history to fit without a system prompt, got %d of %d messages", len(got), len(history))
+	}
+	got, _, _ := a.Assemble(history, "", 740)
+	checkToolPairs(t, got)
+	if len(got) != 4 || got[0].Content != "second" {
+		t.Errorf("Expected only the last turn to fit next to a large system prompt, got %+v", got)
+	}
+}
+
+func TestEstimateMessageTokens_Media(t *testing.T) {
+	dir := t.TempDir()
+	notes := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notes, []byte(strings.Repeat("n", 3000)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	photo := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(photo, []byte("jpeg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		msg  providers.Message
+		want int
+	}{
+		{"text", providers.Message{Content: strings.Repeat("t", 300)}, 100},
+		{"image part", providers.Message{ContentParts: []media.ContentPart{{Type: "image", Data: "aGk="}}}, imageTokens},
+		{"file part", providers.Message{ContentParts: []media.ContentPart{{Type: "text", Text: strings.Repeat("f", 600)}}}, 200},
+		{"image attachment", providers.Message{Attachments: []media.Attachment{{Path: photo, MediaType: "image/jpeg"}}}, imageTokens},
+		{"file attachment", providers.Message{Attachments: []media.Attachment{{Path: notes, MediaType: "text/plain"}}}, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateMessageTokens(tt.msg); got != tt.want {
+				t.Errorf("estimateMessageTokens() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/media"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/session"
+)
+
+// storeAttachments saves inbound media under workspace/sessions/media so the
+// session history can refer to it on later turns.
+func (al *AgentLoop) storeAttachments(parts []media.ContentPart) []media.Attachment {
+	dir := filepath.Join(al.SessionsDir(), session.MediaDirname)
+	var attachments []media.Attachment
+	for _, part := range parts {
+		a, err := media.Store(dir, part)
+		if err != nil {
+			logger.WarnCF("agent", "Failed to store attachment",
+				map[string]interface{}{
+					"file":  part.FileName,
+					"error": err.Error(),
+				})
+			continue
+		}
+		if a != nil {
+			attachments = append(attachments, *a)
+		}
+	}
+	return attachments
+}
+
+// userMessage is the session record of a user message and its media.
+func (al *AgentLoop) userMessage(content string, parts []media.ContentPart) providers.Message {
+	return providers.Message{
+		Role:        "user",
+		Content:     content,
+		Attachments: al.storeAttachments(parts),
+	}
+}
+
+// rehydrateAttachments replaces the attachment references in history with
+// content parts, for the newest budget attachments, and with captions for
+// the rest or for files that can no longer be read. It modifies the
+// messages in place, which must be copies of the session's.
+func rehydrateAttachments(history []providers.Message, budget int) {
+	for i := len(history) - 1; i >= 0; i-- {
+		msg := &history[i]
+		if len(msg.Attachments) == 0 {
+			continue
+		}
+
+		loaded := make([]*media.ContentPart, len(msg.Attachments))
+		for j := len(msg.Attachments) - 1; j >= 0 && budget > 0; j-- {
+			part, err := media.Load(msg.Attachments[j])
+			if err != nil {
+				logger.DebugCF("agent", "Attachment no longer available",
+					map[string]interface{}{
+						"path":  msg.Attachments[j].Path,
+						"error": err.Error(),
+					})
+				continue
+			}
+			loaded[j] = part
+			budget--
+		}
+
+		var parts []media.ContentPart
+		var captions []string
+		for j, a := range msg.Attachments {
+			if loaded[j] != nil {
+				parts = append(parts, *loaded[j])
+			} else {
+				captions = append(captions, a.Caption())
+			}
+		}
+
+		if len(captions) > 0 {
+			content := strings.Join(captions, "\n")
+			if msg.Content != "" {
+				content = msg.Content + "\n" + content
+			}
+			msg.Content = content
+		}
+		if len(parts) > 0 {
+			msg.ContentParts = parts
+		}
+		msg.Attachments = nil
+	}
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/media"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+func TestRehydrateAttachments(t *testing.T) {
+	dir := t.TempDir()
+	store := func(part media.ContentPart) media.Attachment {
+		t.Helper()
+		a, err := media.Store(dir, part)
+		if err != nil || a == nil {
+			t.Fatalf("Store(%s) = %v, %v", part.FileName, a, err)
+		}
+		return *a
+	}
+	image := func(name, data string) media.ContentPart {
+		return media.ContentPart{
+			Type:      "image",
+			MediaType: "image/png",
+			Data:      base64.StdEncoding.EncodeToString([]byte(data)),
+			FileName:  name,
+		}
+	}
+
+	old := store(image("old.png", "old pixels"))
+	gone := store(image("gone.png", "gone pixels"))
+	notes := store(media.ContentPart{Type: "text", Text: "--- Content of notes.txt ---\nhi", FileName: "notes.txt"})
+	recent := store(image("recent.png", "recent pixels"))
+	if err := os.Remove(gone.Path); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := media.Store(dir, media.ContentPart{Type: "text", Text: "[Unsupported file: x.bin, 3 bytes]"}); a != nil || err != nil {
+		t.Errorf("Expected notes without a file name not to be stored, got %v, %v", a, err)
+	}
+
+	history := []providers.Message{
+		{Role: "user", Content: "look at this", Attachments: []media.Attachment{old}},
+		{Role: "assistant", Content: "nice"},
+		{Role: "user", Content: "and these", Attachments: []media.Attachment{notes, gone, recent}},
+	}
+	rehydrateAttachments(history, 2)
+
+	for _, msg := range history {
+		if len(msg.Attachments) != 0 {
+			t.Errorf("Expected attachments to be resolved, got %+v", msg.Attachments)
+		}
+	}
+
+	// The newest two readable attachments are resent, in order
+	parts := history[2].ContentParts
+	if len(parts) != 2 || parts[0].Text != "--- Content of notes.txt ---\nhi" || parts[1].FileName != "recent.png" {
+		t.Fatalf("Unexpected parts for the recent message: %+v", parts)
+	}
+	if data, _ := base64.StdEncoding.DecodeString(parts[1].Data); string(data) != "recent pixels" || parts[1].MediaType != "image/png" {
+		t.Errorf("Expected the stored image back, got %q (%s)", data, parts[1].MediaType)
+	}
+	if !strings.Contains(history[2].Content, "[earlier image: gone.png") {
+		t.Errorf("Expected a caption for the missing file, got %q", history[2].Content)
+	}
+
+	// Past the budget, attachments become captions
+	if len(history[0].ContentParts) != 0 || !strings.HasPrefix(history[0].Content, "look at this\n[earlier image: old.png, saved at ") {
+		t.Errorf("Expected the old image as a caption, got %q with %d parts", history[0].Content, len(history[0].ContentParts))
+	}
+}
@@ -0,0 +1,454 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/metrics"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// Commands returns the agent's slash-command registry. Channels read it to
+// publish command menus; callers may register additional commands.
+func (al *AgentLoop) Commands() *commands.Registry {
+	return al.commands
+}
+
+// registerBuiltinCommands adds the built-in session commands.
+func (al *AgentLoop) registerBuiltinCommands() {
+	for _, cmd := range []commands.Command{
+		{
+			Name:        "help",
+			Description: "List available commands",
+			Immediate:   true,
+			Handler: func(ctx context.Context, req commands.Request) (string, error) {
+				return al.commands.Help(req.Role), nil
+			},
+		},
+		{
+			Name:        "reset",
+			Description: "Clear this conversation's history",
+			Handler:     al.cmdReset,
+		},
+		{
+			Name:        "session",
+			Description: "Show information about this conversation",
+			Immediate:   true,
+			Handler:     al.cmdSession,
+		},
+		{
+			Name:        "cost",
+			Description: "Show token usage and cost of this conversation",
+			Immediate:   true,
+			Handler:     al.cmdCost,
+		},
+		{
+			Name:        "tools",
+			Description: "List the tools the agent can use",
+			Immediate:   true,
+			Handler:     al.cmdTools,
+		},
+		{
+			Name:        "specialists",
+			Description: "List the available specialists",
+			Immediate:   true,
+			Handler:     al.cmdSpecialists,
+		},
+		{
+			Name:        "summary",
+			Description: "Show the summary of earlier conversation",
+			Immediate:   true,
+			Handler:     al.cmdSummary,
+		},
+		{
+			Name:        "stop",
+			Description: "Stop the current task",
+			Immediate:   true,
+			Handler:     al.cmdStop,
+		},
+		{
+			Name:        "continue",
+			Args:        "[instructions]",
+			Description: "Resume a task that ran out of steps",
+			Handler:     al.cmdContinue,
+		},
+		{
+			Name:        "undo",
+			Description: "Remove the last exchange from the conversation",
+			Handler:     al.cmdUndo,
+		},
+		{
+			Name:        "retry",
+			Args:        "[model]",
+			Description: "Regenerate the last answer, optionally with another model (admins)",
+			Handler:     al.cmdRetry,
+		},
+		{
+			Name:        "plan",
+			Args:        "[on|off]",
+			Description: "Toggle plan mode: read-only tools, answers are plans",
+			Handler:     al.cmdPlan,
+		},
+		{
+			Name:        "approve",
+			Args:        "[instructions]",
+			Description: "Carry out the plan proposed in plan mode",
+			Handler:     al.cmdApprove,
+		},
+		{
+			Name:        "model",
+			Args:        "[name]",
+			Description: "Show or switch the model",
+			Role:        commands.RoleAdmin,
+			Immediate:   true,
+			Handler:     al.cmdModel,
+		},
+		{
+			Name:        "link",
+			Args:        "[specialist|none]",
+			Description: "Link this forum topic to a specialist",
+			Role:        commands.RoleAdmin,
+			Handler:     al.cmdLink,
+		},
+	} {
+		al.commands.Register(cmd)
+	}
+}
+
+// runCommand runs cmd for msg with the sender's role.
+func (al *AgentLoop) runCommand(ctx context.Context, cmd commands.Command, args []string, msg bus.InboundMessage) (string, error) {
+	role := al.senderRole(msg)
+	logger.InfoCF("agent", "Running command",
+		map[string]interface{}{
+			"command":     cmd.Name,
+			"role":        role.String(),
+			"session_key": msg.SessionKey,
+		})
+
+	return al.commands.Execute(ctx, cmd, commands.Request{
+		Message: msg,
+		Args:    args,
+		Role:    role,
+	})
+}
+
+// senderRole returns the command role of the message's sender. Internal
+// senders (system, cron) are admins.
+func (al *AgentLoop) senderRole(msg bus.InboundMessage) commands.Role {
+	if msg.Channel == "system" || msg.SenderID == "cron" || al.cfg == nil {
+		return commands.RoleAdmin
+	}
+	return commands.RoleFor(al.cfg.Commands.Admins, msg.SenderID)
+}
+
+func (al *AgentLoop) cmdReset(ctx context.Context, req commands.Request) (string, error) {
+	key := laneKey(req.Message)
+	al.sessions.Reset(key)
+	if err := al.sessions.Save(key); err != nil {
+		return "", fmt.Errorf("failed to save session: %w", err)
+	}
+	return "Conversation history cleared.", nil
+}
+
+func (al *AgentLoop) cmdSession(ctx context.Context, req commands.Request) (string, error) {
+	key := laneKey(req.Message)
+	history := al.sessions.GetHistory(key)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Session: `%s`\n", key)
+	fmt.Fprintf(&sb, "Model: `%s`\n", al.GetModel())
+	fmt.Fprintf(&sb, "Messages: %d\n", len(history))
+	if al.sessions.GetSummary(key) != "" {
+		sb.WriteString("Summary: yes (see /summary)\n")
+	} else {
+		sb.WriteString("Summary: none\n")
+	}
+	if threadID := req.Message.Metadata["thread_id"]; threadID != "" {
+		if specialist := al.topicMappings.LookupSpecialist(req.Message.ChatID, threadID); specialist != "" {
+			fmt.Fprintf(&sb, "Specialist: `%s`\n", specialist)
+		}
+	}
+	if al.sessions.GetPlanMode(key) {
+		sb.WriteString("Plan mode: on (see /plan)\n")
+	}
+	if task := al.sessions.GetContinuation(key); task != "" {
+		sb.WriteString("Unfinished task: yes (see /continue)\n")
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+func (al *AgentLoop) cmdCost(ctx context.Context, req commands.Request) (string, error) {
+	if al.tracker == nil {
+		return "Usage tracking is not available.", nil
+	}
+
+	key := laneKey(req.Message)
+	usage, err := al.tracker.Sum(func(e metrics.TokenEvent) bool {
+		return e.SessionKey == key
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read usage: %w", err)
+	}
+	if usage.Calls == 0 {
+		return "No usage recorded for this conversation yet.", nil
+	}
+
+	return fmt.Sprintf("This conversation: %d LLM calls, %d input + %d output tokens (%d cached, %.0f%% cache hit rate), $%.4f",
+		usage.Calls, usage.InputTokens, usage.OutputTokens, usage.CacheRead, usage.CacheHitRate()*100, usage.CostUSD), nil
+}
+
+func (al *AgentLoop) cmdTools(ctx context.Context, req commands.Request) (string, error) {
+	summaries := al.tools.GetSummaries()
+	if len(summaries) == 0 {
+		return "No tools are available.", nil
+	}
+	sort.Strings(summaries)
+	return "Available tools:\n" + strings.Join(summaries, "\n"), nil
+}
+
+func (al *AgentLoop) cmdSpecialists(ctx context.Context, req commands.Request) (string, error) {
+	available := al.specialistLoader.ListSpecialists()
+	if len(available) == 0 {
+		return "No specialists have been created yet.", nil
+	}
+
+	lines := make([]string, 0, len(available))
+	for _, s := range available {
+		if s.Description != "" {
+			lines = append(lines, fmt.Sprintf("- `%s` - %s", s.Name, s.Description))
+		} else {
+			lines = append(lines, fmt.Sprintf("- `%s`", s.Name))
+		}
+	}
+	sort.Strings(lines)
+	return "Specialists:\n" + strings.Join(lines, "\n"), nil
+}
+
+func (al *AgentLoop) cmdSummary(ctx context.Context, req commands.Request) (string, error) {
+	summary := al.sessions.GetSummary(laneKey(req.Message))
+	if summary == "" {
+		return "This conversation has no summary yet.", nil
+	}
+	return "Summary of earlier conversation:\n" + summary, nil
+}
+
+// cmdStop cancels the session's running turn, including its LLM stream and
+// tools, and drops any messages queued behind it.
+func (al *AgentLoop) cmdStop(ctx context.Context, req commands.Request) (string, error) {
+	stopped, dropped := al.stopTurn(laneKey(req.Message))
+	switch {
+	case stopped && dropped > 0:
+		return fmt.Sprintf("Stopped the current task and dropped %d queued message(s).", dropped), nil
+	case stopped:
+		return "Stopped the current task.", nil
+	case dropped > 0:
+		return fmt.Sprintf("Dropped %d queued message(s).", dropped), nil
+	default:
+		return "Nothing to stop.", nil
+	}
+}
+
+// cmdUndo removes the last turn: the user message and everything the agent
+// added in response, tool calls and results included.
+func (al *AgentLoop) cmdUndo(ctx context.Context, req commands.Request) (string, error) {
+	key := laneKey(req.Message)
+	turn, ok := al.sessions.PopTurn(key)
+	if !ok {
+		return "There is nothing to undo.", nil
+	}
+	if err := al.sessions.Save(key); err != nil {
+		return "", fmt.Errorf("failed to save session: %w", err)
+	}
+	return fmt.Sprintf("Removed the last exchange: \"%s\"", utils.Truncate(turn[0].Content, 60)), nil
+}
+
+// cmdRetry replaces the last turn with a fresh answer to the same message,
+// using the model given as argument if any. Like /model, choosing the model
+// is for admins, and the model must be one the provider lists.
+func (al *AgentLoop) cmdRetry(ctx context.Context, req commands.Request) (string, error) {
+	var model string
+	if len(req.Args) > 0 {
+		if req.Role < commands.RoleAdmin {
+			return fmt.Sprintf("/retry with a model requires the %s role.", commands.RoleAdmin), nil
+		}
+		model = req.Args[0]
+		provider, id := al.providerFor(model)
+		if models, err := listModels(ctx, provider); err == nil && models != nil && !hasModel(models, id) {
+			return fmt.Sprintf("Unknown model `%s`.\n\n%s", model, formatModelList(models, al.GetModel())), nil
+		}
+	}
+
+	key := laneKey(req.Message)
+	turn, ok := al.sessions.PopTurn(key)
+	if !ok {
+		return "There is no answer to retry.", nil
+	}
+	logger.InfoCF("agent", "Retrying last turn",
+		map[string]interface{}{
+			"session_key":      key,
+			"model":            model,
+			"removed_messages": len(turn),
+		})
+
+	// Rebuild the message as first sent: with its media, and with the task
+	// or plan of a /continue or /approve turn
+	stored := turn[0].Content
+	first := turn[:1]
+	rehydrateAttachments(first, len(first[0].Attachments))
+	return al.runUserTurn(ctx, req.Message, processOptions{
+		UserMessage:  first[0].Content,
+		Media:        first[0].ContentParts,
+		Model:        model,
+		ResumeTask:   resumedTask(stored),
+		ApprovedPlan: approvedPlan(stored),
+	})
+}
+
+// cmdContinue resumes a task that ran out of tool iterations, with a fresh
+// iteration budget.
+func (al *AgentLoop) cmdContinue(ctx context.Context, req commands.Request) (string, error) {
+	task := al.sessions.GetContinuation(laneKey(req.Message))
+	if task == "" {
+		return "There is no unfinished task to continue.", nil
+	}
+	return al.runUserTurn(ctx, req.Message, processOptions{
+		UserMessage: continuationPrompt(task, strings.Join(req.Args, " ")),
+		ResumeTask:  task,
+	})
+}
+
+// cmdPlan toggles plan mode, or sets it with "on" or "off". In plan mode
+// the agent only sees read-only tools and answers with a plan.
+func (al *AgentLoop) cmdPlan(ctx context.Context, req commands.Request) (string, error) {
+	key := laneKey(req.Message)
+	on := !al.sessions.GetPlanMode(key)
+	if len(req.Args) > 0 {
+		switch strings.ToLower(req.Args[0]) {
+		case "on":
+			on = true
+		case "off":
+			on = false
+		default:
+			return "Usage: /plan [on|off]", nil
+		}
+	}
+
+	al.sessions.SetPlanMode(key, on)
+	if err := al.sessions.Save(key); err != nil {
+		return "", fmt.Errorf("failed to save session: %w", err)
+	}
+	if on {
+		return "Plan mode on. I'll only use read-only tools and answer with a numbered plan; use /approve to carry it out.", nil
+	}
+	return "Plan mode off.", nil
+}
+
+// cmdApprove carries out the plan from the last plan-mode turn with the
+// full toolset. The session stays in plan mode for later messages.
+func (al *AgentLoop) cmdApprove(ctx context.Context, req commands.Request) (string, error) {
+	plan := al.sessions.GetPlan(laneKey(req.Message))
+	if plan == "" {
+		return "There is no plan to approve.", nil
+	}
+	return al.runUserTurn(ctx, req.Message, processOptions{
+		UserMessage:  approvedPlanPrompt(plan, strings.Join(req.Args, " ")),
+		ApprovedPlan: plan,
+	})
+}
+
+// cmdModel shows the current model, or switches to the named one. With a
+// provider that lists its models, such as Ollama, it shows them too, and
+// pulls a model that is not there yet if the provider can.
+func (al *AgentLoop) cmdModel(ctx context.Context, req commands.Request) (string, error) {
+	models, err := listModels(ctx, al.provider)
+	if err != nil {
+		logger.WarnCF("agent", "Failed to list models",
+			map[string]interface{}{
+				"error": err.Error(),
+			})
+	}
+
+	if len(req.Args) == 0 {
+		reply := fmt.Sprintf("Current model: `%s`", al.GetModel())
+		if len(models) > 0 {
+			reply += "\n\n" + formatModelList(models, al.GetModel())
+		}
+		return reply, nil
+	}
+
+	newModel := req.Args[0]
+	provider, id := al.providerFor(newModel)
+	if provider != al.provider {
+		// Check the model against the instance serving it
+		models, err = listModels(ctx, provider)
+	}
+	if err == nil && models != nil && !hasModel(models, id) {
+		if puller, ok := provider.(providers.ModelPuller); ok {
+			al.pullModel(puller, newModel, id, req.Message)
+			return fmt.Sprintf("`%s` is not available yet; pulling it. I'll switch once it's ready.", newModel), nil
+		}
+		return fmt.Sprintf("Unknown model `%s`.\n\n%s", newModel, formatModelList(models, al.GetModel())), nil
+	}
+
+	oldModel := al.GetModel()
+	al.SetModel(newModel)
+	al.refreshContextWindow(ctx, newModel)
+	logger.InfoCF("agent", fmt.Sprintf("Model switched: %s -> %s", oldModel, newModel), nil)
+	return fmt.Sprintf("Model switched: `%s` -> `%s`", oldModel, newModel), nil
+}
+
+// cmdLink handles topic-specialist mapping.
+// /link           — show current topic's specialist mapping
+// /link <name>    — link this topic to a specialist
+// /link none      — unlink this topic
+func (al *AgentLoop) cmdLink(ctx context.Context, req commands.Request) (string, error) {
+	msg := req.Message
+	threadID, ok := msg.Metadata["thread_id"]
+	if !ok || threadID == "" {
+		return "The /link command must be used from within a forum topic.", nil
+	}
+
+	if len(req.Args) == 0 {
+		// Show current mapping
+		current := al.topicMappings.LookupSpecialist(msg.ChatID, threadID)
+		if current == "" {
+			return "This topic is not linked to any specialist.", nil
+		}
+		return fmt.Sprintf("This topic is linked to specialist: `%s`", current), nil
+	}
+
+	name := req.Args[0]
+	if name == "none" || name == "unlink" {
+		if err := al.topicMappings.RemoveMapping(msg.ChatID, threadID); err != nil {
+			return fmt.Sprintf("Failed to unlink topic: %v", err), nil
+		}
+		return "Topic unlinked from specialist.", nil
+	}
+
+	// Verify specialist exists
+	if !al.specialistLoader.Exists(name) {
+		available := al.specialistLoader.ListSpecialists()
+		var names []string
+		for _, s := range available {
+			names = append(names, s.Name)
+		}
+		return fmt.Sprintf("Specialist `%s` not found. Available: %s", name, strings.Join(names, ", ")), nil
+	}
+
+	if err := al.topicMappings.SetMapping(msg.ChatID, threadID, name); err != nil {
+		return fmt.Sprintf("Failed to link topic: %v", err), nil
+	}
+	return fmt.Sprintf("Topic linked to specialist: `%s`", name), nil
+}
@@ -17,11 +17,14 @@ import (
 )
 
 type ContextBuilder struct {
-	workspace         string
-	skillsLoader      *skills.SkillsLoader
-	specialistLoader  *specialists.SpecialistLoader
-	memory            *MemoryStore
-	tools             *tools.ToolRegistry // Direct reference to tool registry
+	workspace          string
+	skillsLoader       *skills.SkillsLoader
+	specialistLoader   *specialists.SpecialistLoader
+	memory             *MemoryStore
+	tools              *tools.ToolRegistry // Direct reference to tool registry
+	soulPath           string              // Overrides the workspace SOUL.md when set
+	historyAttachments int                 // Earlier attachments resent in full; older ones are captioned
+	now                func() time.Time
 }
 
 func getGlobalConfigDir() string {
@@ -43,6 +46,7 @@ func NewContextBuilder(workspace string) *ContextBuilder {
 		workspace:    workspace,
 		skillsLoader: skills.NewSkillsLoader(workspace, globalSkillsDir, builtinSkillsDir),
 		memory:       NewMemoryStore(workspace),
+		now:          time.Now,
 	}
 }
 
@@ -51,13 +55,27 @@ func (cb *ContextBuilder) SetToolsRegistry(registry *tools.ToolRegistry) {
 	cb.tools = registry
 }
 
+// SetHistoryAttachments sets how many attachments from earlier messages are
+// resent to the model, newest first. Older ones are replaced by captions.
+func (cb *ContextBuilder) SetHistoryAttachments(n int) {
+	cb.historyAttachments = n
+}
+
+// SetSoulPath makes the agent load its SOUL from path instead of the
+// workspace's SOUL.md. Relative paths are resolved against the workspace.
+func (cb *ContextBuilder) SetSoulPath(path string) {
+	if path != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(cb.workspace, path)
+	}
+	cb.soulPath = path
+}
+
 // SetSpecialistLoader sets the specialist loader for system prompt generation.
 func (cb *ContextBuilder) SetSpecialistLoader(loader *specialists.SpecialistLoader) {
 	cb.specialistLoader = loader
 }
 
 func (cb *ContextBuilder) getIdentity() string {
-	now := time.Now().Format("2006-01-02 15:04 (Monday)")
 	workspacePath, _ := filepath.Abs(filepath.Join(cb.workspace))
 	runtime := fmt.Sprintf("%s %s, Go %s", runtime.GOOS, runtime.GOARCH, runtime.Version())
 
@@ -68,9 +86,6 @@ func (cb *ContextBuilder) getIdentity() string {
 
 You are Saleh, a personal AI assistant running on your own VPS.
 
-## Current Time
-%s
-
 ## Runtime
 %s
 
@@ -120,7 +135,7 @@ When a user asks you to do something on the server, DO IT. Use exec to run comma
 9. **Never hedge unnecessarily** — If you know the answer, say it directly. If you have an opinion, state it with a confidence level. Don't pad with disclaimers nobody asked for.
 
 10. **Specialists are your team** — When a question falls in a specialist's domain, delegate to them via consult_specialist. Don't try to do their job yourself with less context.`,
-		now, runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, workspacePath)
+		runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, workspacePath)
 }
 
 func (cb *ContextBuilder) buildToolsSection() string {
@@ -200,6 +215,9 @@ func (cb *ContextBuilder) LoadBootstrapFiles() string {
 	var result string
 	for _, filename := range bootstrapFiles {
 		filePath := filepath.Join(cb.workspace, filename)
+		if filename == "SOUL.md" && cb.soulPath != "" {
+			filePath = cb.soulPath
+		}
 		if data, err := os.ReadFile(filePath); err == nil {
 			result += fmt.Sprintf("## %s\n\n%s\n\n", filename, string(data))
 		}
@@ -254,9 +272,10 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 	messages = append(messages, providers.Message{
 		Role:    "system",
 		Content: systemPrompt,
-	})
+	}, cb.currentTime())
 
 	messages = append(messages, history...)
+	rehydrateAttachments(messages[2:], cb.historyAttachments)
 
 	// Build user message — multimodal if media parts are present
 	userMsg := providers.Message{
@@ -297,9 +316,7 @@ func (cb *ContextBuilder) BuildSpecialistMessages(history []providers.Message, s
 	}
 
 	// Build specialist system prompt — minimal, persona-focused
-	now := time.Now().Format("2006-01-02 15:04 (Monday)")
-
-	systemPrompt := persona + "\n\n## Current Time\n" + now
+	systemPrompt := persona
 
 	// Add USER.md for user context
 	userMD := filepath.Join(cb.workspace, "USER.md")
@@ -348,8 +365,10 @@ func (cb *ContextBuilder) BuildSpecialistMessages(history []providers.Message, s
 
 	messages := []providers.Message{
 		{Role: "system", Content: systemPrompt},
+		cb.currentTime(),
 	}
 	messages = append(messages, history...)
+	rehydrateAttachments(messages[2:], cb.historyAttachments)
 
 	userMsg := providers.Message{
 		Role:    "user",
@@ -363,6 +382,16 @@ func (cb *ContextBuilder) BuildSpecialistMessages(history []providers.Message, s
 	return messages
 }
 
+// currentTime returns the current time as a system message of its own. It
+// follows the system prompt, which then stays the same from call to call and
+// can be served from the provider's prompt cache.
+func (cb *ContextBuilder) currentTime() providers.Message {
+	return providers.Message{
+		Role:    "system",
+		Content: "## Current Time\n" + cb.now().Format("2006-01-02 15:04 (Monday)"),
+	}
+}
+
 func (cb *ContextBuilder) AddToolResult(messages []providers.Message, toolCallID, toolName, result string) []providers.Message {
 	messages = append(messages, providers.Message{
 		Role:       "tool",
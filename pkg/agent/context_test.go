@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/specialists"
+)
+
+// TestBuildMessages_StableSystemPrompt verifies the system prompt is byte
+// for byte the same at different times, so providers can cache it, and that
+// the current time follows it.
+func TestBuildMessages_StableSystemPrompt(t *testing.T) {
+	workspace := t.TempDir()
+	specialistDir := filepath.Join(workspace, "specialists", "chef")
+	if err := os.MkdirAll(specialistDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(specialistDir, "SPECIALIST.md"), []byte("You are a chef."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cb := NewContextBuilder(workspace)
+	cb.SetSpecialistLoader(specialists.NewSpecialistLoader(workspace))
+
+	builds := map[string]func() []providers.Message{
+		"main": func() []providers.Message {
+			return cb.BuildMessages(nil, "", "hi", nil, "telegram", "chat1")
+		},
+		"specialist": func() []providers.Message {
+			return cb.BuildSpecialistMessages(nil, "", "hi", nil, "telegram", "chat1", "chef")
+		},
+	}
+	for name, build := range builds {
+		t.Run(name, func(t *testing.T) {
+			cb.now = func() time.Time { return time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC) }
+			first := build()
+			cb.now = func() time.Time { return time.Date(2026, 10, 17, 18, 45, 0, 0, time.UTC) }
+			second := build()
+
+			if first[0].Content != second[0].Content {
+				t.Error("Expected the same system prompt at different times")
+			}
+			if strings.Contains(first[0].Content, "2026-10-16") {
+				t.Error("Expected no current time in the system prompt")
+			}
+			if first[1].Role != "system" || !strings.Contains(first[1].Content, "2026-10-16 09:30 (Friday)") {
+				t.Errorf("Expected the current time after the system prompt, got %+v", first[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// generationOptions returns the provider options for a call made with the
+// main model: the agent defaults overlaid with the generation profiles that
+// sel selects.
+func generationOptions(cfg *config.Config, sel config.GenerationSelector) map[string]interface{} {
+	defaults := cfg.Agents.Defaults
+	base := map[string]interface{}{
+		"temperature": defaults.Temperature,
+	}
+	if defaults.MaxTokens > 0 {
+		base["max_tokens"] = defaults.MaxTokens
+	}
+	return providers.MergeOptions(base, cfg.Agents.Generation.Resolve(sel).Options())
+}
+
+// taskOptions returns the generation profile overrides for a background task.
+// Each task call keeps its own defaults for anything the profiles leave unset.
+func taskOptions(cfg *config.Config, model, task string) map[string]interface{} {
+	return cfg.Agents.Generation.Resolve(config.GenerationSelector{
+		Model: model,
+		Task:  task,
+	}).Options()
+}
@@ -9,18 +9,18 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
-	"unicode/utf8"
 
 	chromem "github.com/philippgille/chromem-go"
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/constants"
 	"github.com/sipeed/picoclaw/pkg/logger"
@@ -37,18 +37,11 @@ import (
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
-// thinkTagRe matches <think>...</think> reasoning blocks (including multiline).
-var thinkTagRe = regexp.MustCompile(`(?s)<think>.*?</think>\s*`)
-
-func stripThinkingTags(s string) string {
-	return strings.TrimSpace(thinkTagRe.ReplaceAllString(s, ""))
-}
-
 // stripThinkingTagsForStream strips both closed and unclosed <think> blocks.
 // Used during streaming where the closing tag may not have arrived yet.
 func stripThinkingTagsForStream(s string) string {
 	// Strip closed <think>...</think> blocks
-	s = thinkTagRe.ReplaceAllString(s, "")
+	s = providers.StripThinkingTags(s)
 	// Strip unclosed <think>... at the end (closing tag hasn't arrived yet)
 	if idx := strings.LastIndex(s, "<think>"); idx != -1 {
 		if !strings.Contains(s[idx:], "</think>") {
@@ -59,15 +52,19 @@ func stripThinkingTagsForStream(s string) string {
 }
 
 type AgentLoop struct {
+	cfg            *config.Config
 	bus            *bus.MessageBus
 	provider       providers.LLMProvider
 	workspace      string
 	model          string
-	contextWindow  int // Maximum context window size in tokens
+	modelMu        sync.RWMutex
+	contextWindow  int // Maximum context window size in tokens; guarded by modelMu, like assembler
 	maxIterations  int
+	maxParallel    int // Maximum concurrent tool calls per LLM response
 	sessions       *session.SessionManager
 	state          *state.Manager
 	contextBuilder *ContextBuilder
+	assembler      contextAssembler
 	tools          *tools.ToolRegistry
 	running        atomic.Bool
 	summarizing    sync.Map // Tracks which sessions are currently being summarized
@@ -75,6 +72,8 @@ type AgentLoop struct {
 	vectorStore    *memory.VectorStore
 	extractor      *memory.KnowledgeExtractor
 	tracker        *metrics.Tracker
+	approvals      *tools.ApprovalManager // nil unless tool approval is enabled
+	commands       *commands.Registry
 
 	// Cheap model for background tasks (summarization, extraction)
 	cheapModel string
@@ -83,20 +82,63 @@ type AgentLoop struct {
 	topicMappings    *state.TopicMappingStore
 	specialistLoader *specialists.SpecialistLoader
 
-	// Rate limiting: sliding window per sender
-	rateLimiter map[string][]int64
-	rateMu      sync.Mutex
+	// Per-sender message rate limits and usage budgets
+	quotas *quotaEnforcer
+
+	// Per-session dispatch: each session has its own ordered lane, and at most
+	// maxConcurrentTurns turns run at once across all lanes.
+	maxConcurrentTurns int
+	turnSlots          chan struct{}
+	lanesMu            sync.Mutex
+	lanes              map[string]*sessionLane
+}
+
+// sessionLane serializes message processing for a single session. Messages
+// wait in pending until the lane gets a turn slot; while a turn is running,
+// new messages for the session go to interrupts so the turn can pick them up.
+type sessionLane struct {
+	pending    chan bus.InboundMessage
+	interrupts chan bus.InboundMessage
+	active     bool // guarded by AgentLoop.lanesMu
+	// cancel stops the running turn; nil when idle. Guarded by
+	// AgentLoop.lanesMu.
+	cancel context.CancelCauseFunc
+}
+
+// errTurnStopped is the cancellation cause of a turn stopped with /stop.
+var errTurnStopped = errors.New("turn stopped by user")
+
+// turnStopped reports whether ctx belongs to a turn stopped with /stop.
+func turnStopped(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errTurnStopped)
+}
+
+// stoppedNote closes the history of a stopped turn, so the saved
+// conversation still ends with an assistant message.
+const stoppedNote = "[Stopped by the user before finishing.]"
 
-	// Message injection: routes new messages to active session or pending queue
-	pendingMu     sync.Mutex
-	pendingMsgs   chan bus.InboundMessage // messages for non-active sessions
-	interruptCh   chan bus.InboundMessage // messages for the currently active session
-	activeSession string                  // session key being processed right now
+// answeredBy returns the model that answered a call made with model: the
+// fallback the provider reports, or else model itself.
+func answeredBy(model string, resp *providers.LLMResponse) string {
+	if resp.Model != "" {
+		return resp.Model
+	}
+	return model
 }
 
+// fallbackNote tells the user that a fallback model wrote the answer.
+func fallbackNote(model, usedModel string) string {
+	return fmt.Sprintf("\n\n_(Answered by `%s`: `%s` was unavailable.)_", usedModel, model)
+}
+
+// laneIdleTimeout is how long an empty session lane lingers before its
+// worker goroutine exits.
+const laneIdleTimeout = 30 * time.Second
+
 // processOptions configures how a message is processed
 type processOptions struct {
 	SessionKey      string              // Session identifier for history/context
+	LaneKey         string              // Lane the turn runs on, as laneKey gives it; its interrupts join the turn
 	Channel         string              // Target channel for tool execution
 	ChatID          string              // Target chat ID for tool execution
 	UserMessage     string              // User message content (may include prefix)
@@ -107,6 +149,19 @@ type processOptions struct {
 	NoHistory       bool                // If true, don't load session history (for heartbeat)
 	Specialist      string              // If set, run as this specialist persona
 	Metadata        map[string]string   // Inbound message metadata (thread_id, etc.)
+	ResumeTask      string              // Original task when resuming it with /continue
+	Model           string              // Model for this turn only (/retry); empty uses the agent's model
+	PlanMode        bool                // Read-only tools only; the answer becomes the plan for /approve
+	ApprovedPlan    string              // Plan being carried out with /approve; lifts plan mode for the turn
+	SenderID        string              // Sender the turn's LLM usage is attributed to, for quotas
+}
+
+// turnModel returns the model a turn runs with.
+func (al *AgentLoop) turnModel(opts processOptions) string {
+	if opts.Model != "" {
+		return opts.Model
+	}
+	return al.GetModel()
 }
 
 // createToolRegistry creates a tool registry with common tools.
@@ -205,9 +260,43 @@ func createSpecialistToolRegistry(workspace string, cfg *config.Config, msgBus *
 	return createToolRegistry(workspace, true, cfg, msgBus, vectorStore)
 }
 
+// newApprovalManager creates the approval manager for the configured timeout.
+func newApprovalManager(cfg config.ApprovalConfig, msgBus *bus.MessageBus) *tools.ApprovalManager {
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	return tools.NewApprovalManager(msgBus, timeout)
+}
+
+// approvalPolicy converts the configured approval rules. Rules with invalid
+// argument patterns still require approval for every call to their tool.
+func approvalPolicy(cfg config.ApprovalConfig) *tools.ApprovalPolicy {
+	rules := make([]tools.ApprovalRule, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		rules = append(rules, tools.ApprovalRule{Tool: rule.Tool, Args: rule.Args})
+	}
+	policy, err := tools.NewApprovalPolicy(rules)
+	if err != nil {
+		logger.WarnCF("agent", "Invalid tool approval rule", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	return policy
+}
+
 func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers.LLMProvider) *AgentLoop {
+	return newAgentLoop(cfg, msgBus, provider, nil)
+}
+
+// newAgentLoop creates an agent that keeps its sessions and usage in shared,
+// or in a workspace state of its own if shared is nil.
+func newAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers.LLMProvider, shared *workspaceState) *AgentLoop {
 	workspace := cfg.WorkspacePath()
 	os.MkdirAll(workspace, 0755)
+	if shared == nil {
+		shared = newWorkspaceState(cfg)
+	}
 
 	restrict := cfg.Agents.Defaults.RestrictToWorkspace
 
@@ -231,6 +320,7 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 						cheapModel = cfg.Agents.Defaults.Model
 					}
 					extractor = memory.NewKnowledgeExtractor(provider, cheapModel, vs)
+					extractor.SetLLMOptions(taskOptions(cfg, cheapModel, config.TaskExtraction))
 					// Initialize graph-augmented memory
 					relationStore := memory.NewRelationStore(workspace)
 					extractor.SetRelationStore(relationStore)
@@ -247,11 +337,19 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	// Create tool registry for main agent
 	toolsRegistry := createToolRegistry(workspace, restrict, cfg, msgBus, vectorStore)
 
+	// Subagents and specialists record their usage against the turn's sender
+	tracker := shared.tracker
+	delegated := &delegatedProvider{LLMProvider: provider, tracker: tracker}
+
 	// Create subagent manager with its own tool registry
-	subagentManager := tools.NewSubagentManager(provider, cfg.Agents.Defaults.Model, workspace, msgBus)
+	subagentManager := tools.NewSubagentManager(delegated, cfg.Agents.Defaults.Model, workspace, msgBus)
 	subagentTools := createToolRegistry(workspace, restrict, cfg, msgBus, vectorStore)
 	// Subagent doesn't need spawn/subagent tools to avoid recursion
 	subagentManager.SetTools(subagentTools)
+	subagentManager.SetLLMOptions(generationOptions(cfg, config.GenerationSelector{
+		Model: cfg.Agents.Defaults.Model,
+		Task:  config.TaskSubagent,
+	}))
 
 	// Register spawn tool (for main agent)
 	spawnTool := tools.NewSpawnTool(subagentManager)
@@ -264,25 +362,50 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	// Register specialist tools (full tool access, workspace-restricted)
 	specialistLoader := specialists.NewSpecialistLoader(workspace)
 	specialistTools := createSpecialistToolRegistry(workspace, cfg, msgBus, vectorStore)
+	specialistOptions := func(specialist string) map[string]interface{} {
+		return generationOptions(cfg, config.GenerationSelector{
+			Model:      cfg.Agents.Defaults.Model,
+			Specialist: specialist,
+		})
+	}
 	consultTool := tools.NewConsultSpecialistTool(tools.ConsultSpecialistConfig{
 		Loader:      specialistLoader,
-		Provider:    provider,
+		Provider:    delegated,
 		Model:       cfg.Agents.Defaults.Model,
 		Tools:       specialistTools,
 		VectorStore: vectorStore,
 		Extractor:   extractor,
 		MaxIter:     cfg.Agents.Defaults.MaxToolIterations,
+		MaxParallel: cfg.Agents.Defaults.MaxParallelTools,
 		Workspace:   workspace,
+		LLMOptions:  specialistOptions,
 	})
 	toolsRegistry.Register(consultTool)
-	toolsRegistry.Register(tools.NewCreateSpecialistTool(specialistLoader, provider, cfg.Agents.Defaults.Model, workspace, extractor, vectorStore))
+	createSpecialistTool := tools.NewCreateSpecialistTool(specialistLoader, delegated, cfg.Agents.Defaults.Model, workspace, extractor, vectorStore)
+	createSpecialistTool.SetLLMOptions(specialistOptions)
+	toolsRegistry.Register(createSpecialistTool)
 	toolsRegistry.Register(tools.NewFeedSpecialistTool(specialistLoader, vectorStore, extractor))
 
 	// Topic-specialist linking tool
 	topicMappings := state.NewTopicMappingStore(workspace)
 	toolsRegistry.Register(tools.NewLinkTopicTool(topicMappings, specialistLoader))
 
-	sessionsManager := session.NewSessionManager(filepath.Join(workspace, "sessions"))
+	// Side-effecting tools wait for the user's approval when enabled
+	var approvals *tools.ApprovalManager
+	if cfg.Tools.Approval.Enabled {
+		approvals = newApprovalManager(cfg.Tools.Approval, msgBus)
+		policy := approvalPolicy(cfg.Tools.Approval)
+		for _, registry := range []*tools.ToolRegistry{toolsRegistry, subagentTools, specialistTools} {
+			registry.SetApproval(policy, approvals)
+		}
+	}
+
+	// Restrict every registry to the agent's tool allowlist
+	if len(cfg.Agents.Defaults.Tools) > 0 {
+		for _, registry := range []*tools.ToolRegistry{toolsRegistry, subagentTools, specialistTools} {
+			registry.SetAllowlist(cfg.Agents.Defaults.Tools)
+		}
+	}
 
 	// Create state manager for atomic state persistence
 	stateManager := state.NewManager(workspace)
@@ -291,179 +414,301 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	contextBuilder := NewContextBuilder(workspace)
 	contextBuilder.SetToolsRegistry(toolsRegistry)
 	contextBuilder.SetSpecialistLoader(specialistLoader)
+	contextBuilder.SetSoulPath(cfg.Agents.Defaults.Soul)
+	contextBuilder.SetHistoryAttachments(cfg.Agents.Defaults.Context.HistoryAttachments)
 
 	cheapModel := cfg.Agents.Defaults.CheapModel
 	if cheapModel == "" {
 		cheapModel = cfg.Agents.Defaults.Model
 	}
 
-	tracker := metrics.NewTracker(workspace)
+	maxConcurrentTurns := cfg.Agents.Defaults.MaxConcurrentTurns
+	if maxConcurrentTurns <= 0 {
+		maxConcurrentTurns = 1
+	}
 
-	return &AgentLoop{
+	al := &AgentLoop{
+		cfg:              cfg,
 		bus:              msgBus,
 		provider:         provider,
 		workspace:        workspace,
 		model:            cfg.Agents.Defaults.Model,
 		cheapModel:       cheapModel,
-		rateLimiter:      make(map[string][]int64),
+		quotas:           shared.quotas,
 		contextWindow:    cfg.Agents.Defaults.MaxTokens, // Restore context window for summarization
 		maxIterations:    cfg.Agents.Defaults.MaxToolIterations,
-		sessions:         sessionsManager,
+		maxParallel:      cfg.Agents.Defaults.MaxParallelTools,
+		sessions:         shared.sessions,
 		state:            stateManager,
 		contextBuilder:   contextBuilder,
+		assembler:        newContextAssembler(cfg.Agents.Defaults.Context, cfg.Agents.Defaults.MaxTokens),
 		tools:            toolsRegistry,
 		summarizing:      sync.Map{},
 		vectorStore:      vectorStore,
 		extractor:        extractor,
 		tracker:          tracker,
+		approvals:        approvals,
+		commands:         commands.NewRegistry(),
 		topicMappings:    topicMappings,
 		specialistLoader: specialistLoader,
+
+		maxConcurrentTurns: maxConcurrentTurns,
+		turnSlots:          make(chan struct{}, maxConcurrentTurns),
+		lanes:              make(map[string]*sessionLane),
+	}
+	al.registerBuiltinCommands()
+	// The provider may be slow to answer or not up yet; until it does, the
+	// configured max_tokens sizes the context
+	go al.refreshContextWindow(context.Background(), al.model)
+	return al
+}
+
+// openSessions opens the session manager of cfg's workspace.
+// workspaceState is shared by the agents of one workspace, so that each
+// sees the others' sessions and their usage counts against the same quotas.
+type workspaceState struct {
+	sessions *session.SessionManager
+	tracker  *metrics.Tracker
+	quotas   *quotaEnforcer
+}
+
+func newWorkspaceState(cfg *config.Config) *workspaceState {
+	tracker := metrics.NewTracker(cfg.WorkspacePath())
+	return &workspaceState{
+		sessions: openSessions(cfg),
+		tracker:  tracker,
+		quotas:   newQuotaEnforcer(cfg.Quotas, tracker),
+	}
+}
+
+func openSessions(cfg *config.Config) *session.SessionManager {
+	sessionsDir := filepath.Join(cfg.WorkspacePath(), "sessions")
+	store, err := session.OpenStore(cfg.Sessions.Store, sessionsDir)
+	if err != nil {
+		logger.ErrorCF("agent", "Failed to open session store, falling back to JSON files",
+			map[string]interface{}{
+				"store": cfg.Sessions.Store,
+				"error": err.Error(),
+			})
+		return session.NewSessionManager(sessionsDir)
 	}
+	return session.NewSessionManagerWithStore(store)
 }
 
+// Run consumes inbound messages until ctx is cancelled or Stop is called.
+// Messages for the same session are processed in arrival order; different
+// sessions run in parallel, up to the configured number of concurrent turns.
 func (al *AgentLoop) Run(ctx context.Context) error {
 	al.running.Store(true)
-	al.pendingMsgs = make(chan bus.InboundMessage, 100)
-	al.interruptCh = make(chan bus.InboundMessage, 10)
-
-	// Start router goroutine: reads from bus and routes to pending or interrupt
-	go al.routeMessages(ctx)
 
 	for al.running.Load() {
-		select {
-		case <-ctx.Done():
+		msg, ok := al.bus.ConsumeInbound(ctx)
+		if !ok {
 			return nil
-		case msg, ok := <-al.pendingMsgs:
-			if !ok {
-				return nil
-			}
-
-			// Mark this session as active so new messages for it go to interruptCh
-			al.pendingMu.Lock()
-			al.activeSession = msg.SessionKey
-			al.pendingMu.Unlock()
+		}
+		al.handleInbound(ctx, msg)
+	}
+	return nil
+}
 
-			response, err := al.processMessage(ctx, msg)
-			if err != nil {
-				response = fmt.Sprintf("Error processing message: %v", err)
-			}
+// handleInbound hands a message to its session lane, either as an interrupt
+// (if the session has a turn in progress) or as the next queued message.
+func (al *AgentLoop) handleInbound(ctx context.Context, msg bus.InboundMessage) {
+	// Answers to pending tool approvals go straight to the waiting turn
+	if al.approvals != nil && al.approvals.HandleReply(msg) {
+		return
+	}
 
-			// Clear active session
-			al.pendingMu.Lock()
-			al.activeSession = ""
-			al.pendingMu.Unlock()
-
-			if response != "" {
-				// Check if the message tool already sent a response during this round.
-				alreadySent := false
-				if tool, ok := al.tools.Get("message"); ok {
-					if mt, ok := tool.(*tools.MessageTool); ok {
-						alreadySent = mt.HasSentInRound()
-					}
-				}
+	// Quota check (skip system/cron messages)
+	if msg.Channel != "system" && msg.SenderID != "cron" {
+		cmd, args, isCommand := al.commands.Match(msg.Content)
+		if !al.checkQuota(msg, isCommand) {
+			return
+		}
 
-				if !alreadySent {
-					al.bus.PublishOutbound(bus.OutboundMessage{
-						Channel:  msg.Channel,
-						ChatID:   msg.ChatID,
-						Content:  response,
-						Metadata: msg.Metadata,
-					})
+		// Immediate commands answer right away instead of waiting behind
+		// the session's running turn, and without holding up other sessions.
+		if isCommand && cmd.Immediate {
+			go func() {
+				response, err := al.runCommand(ctx, cmd, args, msg)
+				if err != nil {
+					response = fmt.Sprintf("Error processing message: %v", err)
 				}
-			}
+				al.bus.PublishOutbound(bus.OutboundMessage{
+					Channel:  msg.Channel,
+					ChatID:   msg.ChatID,
+					Content:  response,
+					Metadata: msg.Metadata,
+				})
+			}()
+			return
 		}
 	}
 
-	return nil
+	al.dispatch(ctx, msg)
 }
 
-// routeMessages reads from the bus and routes messages to either the interrupt
-// channel (if the message targets the currently active session) or the pending
-// queue (for normal sequential processing).
-func (al *AgentLoop) routeMessages(ctx context.Context) {
-	for {
-		msg, ok := al.bus.ConsumeInbound(ctx)
-		if !ok {
+// laneKey returns the key of the lane a message is processed on.
+func laneKey(msg bus.InboundMessage) string {
+	if msg.SessionKey != "" {
+		return msg.SessionKey
+	}
+	return fmt.Sprintf("%s:%s", msg.Channel, msg.ChatID)
+}
+
+// dispatch routes a message to its session lane, starting a lane worker if
+// the session has none.
+func (al *AgentLoop) dispatch(ctx context.Context, msg bus.InboundMessage) {
+	key := laneKey(msg)
+
+	al.lanesMu.Lock()
+	defer al.lanesMu.Unlock()
+
+	lane, ok := al.lanes[key]
+	if !ok {
+		lane = &sessionLane{
+			pending:    make(chan bus.InboundMessage, 100),
+			interrupts: make(chan bus.InboundMessage, 10),
+		}
+		al.lanes[key] = lane
+		go al.runLane(ctx, key, lane)
+	}
+
+	if lane.active && msg.Channel != "system" {
+		// This message targets a session with a turn in progress — inject it
+		logger.InfoCF("agent", "Routing message to interrupt channel",
+			map[string]interface{}{
+				"session_key": msg.SessionKey,
+				"preview":     utils.Truncate(msg.Content, 60),
+			})
+		select {
+		case lane.interrupts <- msg:
 			return
+		default:
+			// Interrupt buffer full — fall back to the pending queue
 		}
+	}
 
-		// Rate limit check (skip system/cron messages)
-		if msg.Channel != "system" && msg.SenderID != "cron" {
-			senderKey := fmt.Sprintf("%s:%s", msg.Channel, msg.SenderID)
-			if !al.checkRateLimit(senderKey) {
-				al.bus.PublishOutbound(bus.OutboundMessage{
-					Channel: msg.Channel,
-					ChatID:  msg.ChatID,
-					Content: "Slow down — max 20 messages per minute.",
-				})
-				continue
+	select {
+	case lane.pending <- msg:
+	default:
+		logger.ErrorCF("agent", "Pending channel full, dropping message",
+			map[string]interface{}{
+				"session_key": msg.SessionKey,
+				"preview":     utils.Truncate(msg.Content, 60),
+			})
+	}
+}
+
+// runLane processes one session's messages in order. It exits when ctx is
+// cancelled or after the lane has been idle for laneIdleTimeout.
+func (al *AgentLoop) runLane(ctx context.Context, key string, lane *sessionLane) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-lane.pending:
+			select {
+			case al.turnSlots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			al.processLaneMessage(ctx, lane, msg)
+			<-al.turnSlots
+		case <-time.After(laneIdleTimeout):
+			al.lanesMu.Lock()
+			if len(lane.pending) == 0 && !lane.active {
+				delete(al.lanes, key)
+				al.lanesMu.Unlock()
+				return
 			}
+			al.lanesMu.Unlock()
 		}
+	}
+}
 
-		al.pendingMu.Lock()
-		active := al.activeSession
-		al.pendingMu.Unlock()
+// stopTurn cancels a session's running turn and discards the messages
+// waiting on its lane. It reports whether a turn was running and how many
+// queued messages were dropped.
+func (al *AgentLoop) stopTurn(key string) (bool, int) {
+	al.lanesMu.Lock()
+	defer al.lanesMu.Unlock()
 
-		if active != "" && msg.SessionKey == active && msg.Channel != "system" {
-			// This message targets the session currently being processed — inject it
-			logger.InfoCF("agent", "Routing message to interrupt channel",
-				map[string]interface{}{
-					"session_key": msg.SessionKey,
-					"preview":     utils.Truncate(msg.Content, 60),
-				})
+	lane, ok := al.lanes[key]
+	if !ok {
+		return false, 0
+	}
+	stopped := lane.cancel != nil
+	if stopped {
+		lane.cancel(errTurnStopped)
+		lane.cancel = nil
+	}
+
+	dropped := 0
+	for _, ch := range []chan bus.InboundMessage{lane.interrupts, lane.pending} {
+		for drained := false; !drained; {
 			select {
-			case al.interruptCh <- msg:
+			case <-ch:
+				dropped++
 			default:
-				// Interrupt buffer full — try pending queue (non-blocking)
-				select {
-				case al.pendingMsgs <- msg:
-				default:
-					logger.ErrorCF("agent", "Both interrupt and pending channels full, dropping message",
-						map[string]interface{}{
-							"session_key": msg.SessionKey,
-							"preview":     utils.Truncate(msg.Content, 60),
-						})
-				}
+				drained = true
 			}
-		} else {
+		}
+	}
+	return stopped, dropped
+}
+
+// processLaneMessage runs one turn for a lane and publishes its response.
+func (al *AgentLoop) processLaneMessage(ctx context.Context, lane *sessionLane, msg bus.InboundMessage) {
+	// The turn runs under its own context so /stop can cancel it
+	turnCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Mark the lane active so new messages for this session become interrupts
+	al.lanesMu.Lock()
+	lane.active = true
+	lane.cancel = cancel
+	al.lanesMu.Unlock()
+
+	ec := tools.NewExecutionContext(msg.Channel, msg.ChatID, msg.Metadata)
+	ec.SenderID = msg.SenderID
+	response, err := al.processMessage(tools.WithExecutionContext(turnCtx, ec), msg)
+	if err != nil {
+		response = fmt.Sprintf("Error processing message: %v", err)
+	}
+
+	// Interrupts the turn did not pick up are queued behind anything already
+	// pending; holding lanesMu keeps newer messages from overtaking them.
+	al.lanesMu.Lock()
+	lane.active = false
+	lane.cancel = nil
+	for requeued := false; !requeued; {
+		select {
+		case m := <-lane.interrupts:
 			select {
-			case al.pendingMsgs <- msg:
+			case lane.pending <- m:
 			default:
-				logger.ErrorCF("agent", "Pending channel full, dropping message",
+				logger.ErrorCF("agent", "Pending channel full, dropping interrupt",
 					map[string]interface{}{
-						"session_key": msg.SessionKey,
-						"preview":     utils.Truncate(msg.Content, 60),
+						"session_key": m.SessionKey,
+						"preview":     utils.Truncate(m.Content, 60),
 					})
 			}
+		default:
+			requeued = true
 		}
 	}
-}
-
-// checkRateLimit enforces a 20-messages-per-minute sliding window per sender.
-// Returns true if the message is allowed, false if rate exceeded.
-func (al *AgentLoop) checkRateLimit(senderKey string) bool {
-	al.rateMu.Lock()
-	defer al.rateMu.Unlock()
-
-	now := time.Now().UnixMilli()
-	windowStart := now - 60_000 // 1 minute window
-
-	// Filter out old timestamps
-	timestamps := al.rateLimiter[senderKey]
-	valid := timestamps[:0]
-	for _, ts := range timestamps {
-		if ts > windowStart {
-			valid = append(valid, ts)
-		}
-	}
+	al.lanesMu.Unlock()
 
-	if len(valid) >= 20 {
-		al.rateLimiter[senderKey] = valid
-		return false
+	// Skip the response if the message tool already sent one during this turn
+	if response != "" && !ec.MessageSent() {
+		al.bus.PublishOutbound(bus.OutboundMessage{
+			Channel:  msg.Channel,
+			ChatID:   msg.ChatID,
+			Content:  response,
+			Metadata: msg.Metadata,
+		})
 	}
-
-	al.rateLimiter[senderKey] = append(valid, now)
-	return true
 }
 
 func (al *AgentLoop) Stop() {
@@ -539,14 +784,24 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 		return al.processSystemMessage(ctx, msg)
 	}
 
-	// Handle /model command — lets user switch model mid-session
-	if resp, handled := al.handleModelCommand(msg.Content); handled {
-		return resp, nil
+	// Slash commands (/help, /model, /reset, ...)
+	if cmd, args, ok := al.commands.Match(msg.Content); ok {
+		return al.runCommand(ctx, cmd, args, msg)
 	}
 
-	// Handle /link command — maps forum topics to specialists
-	if resp, handled := al.handleLinkCommand(msg); handled {
-		return resp, nil
+	// Process as user message
+	return al.runUserTurn(ctx, msg, processOptions{UserMessage: msg.Content})
+}
+
+// runUserTurn runs an agent turn for a user message. turn carries the prompt
+// and any per-turn overrides (ResumeTask for /continue, Model and Media for
+// /retry); the remaining options are derived from msg. The session is the
+// message's lane, as for commands, so state they set applies to the turn.
+func (al *AgentLoop) runUserTurn(ctx context.Context, msg bus.InboundMessage, turn processOptions) (string, error) {
+	key := laneKey(msg)
+	parts := turn.Media
+	if parts == nil {
+		parts = msg.Media
 	}
 
 	// Check if this topic is mapped to a specialist
@@ -555,18 +810,23 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 		specialist = al.topicMappings.LookupSpecialist(msg.ChatID, threadID)
 	}
 
-	// Process as user message
 	return al.runAgentLoop(ctx, processOptions{
-		SessionKey:      msg.SessionKey,
+		SessionKey:      key,
+		LaneKey:         key,
 		Channel:         msg.Channel,
 		ChatID:          msg.ChatID,
-		UserMessage:     msg.Content,
-		Media:           msg.Media,
+		UserMessage:     turn.UserMessage,
+		Media:           parts,
 		DefaultResponse: "I hit an issue processing that. Let me try a different approach — could you give me a bit more context?",
 		EnableSummary:   true,
 		SendResponse:    false,
 		Specialist:      specialist,
 		Metadata:        msg.Metadata,
+		ResumeTask:      turn.ResumeTask,
+		Model:           turn.Model,
+		PlanMode:        turn.ApprovedPlan == "" && al.sessions.GetPlanMode(key),
+		ApprovedPlan:    turn.ApprovedPlan,
+		SenderID:        msg.SenderID,
 	})
 }
 
@@ -614,7 +874,7 @@ func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMe
 		go func() {
 			bgCtx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 			defer cancel()
-			specialistpkg.ReviewAllSpecialists(bgCtx, al.specialistLoader, al.provider, al.cheapModel, al.vectorStore, al.workspace)
+			specialistpkg.ReviewAllSpecialists(bgCtx, al.specialistLoader, al.provider, al.cheapModel, taskOptions(al.cfg, al.cheapModel, config.TaskReview), al.vectorStore, al.workspace)
 		}()
 		return "", nil
 	}
@@ -632,84 +892,22 @@ func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMe
 	return "", nil
 }
 
-// handleModelCommand intercepts /model commands from the user.
-// /model — shows current model
-// /model <name> — switches to a different model
-func (al *AgentLoop) handleModelCommand(content string) (string, bool) {
-	trimmed := strings.TrimSpace(content)
-	if !strings.HasPrefix(trimmed, "/model") {
-		return "", false
-	}
-
-	parts := strings.Fields(trimmed)
-	if len(parts) == 1 {
-		// Just "/model" — show current
-		return fmt.Sprintf("Current model: `%s`", al.model), true
-	}
-
-	newModel := parts[1]
-	oldModel := al.model
-	al.model = newModel
-	logger.InfoCF("agent", fmt.Sprintf("Model switched: %s -> %s", oldModel, newModel), nil)
-	return fmt.Sprintf("Model switched: `%s` -> `%s`", oldModel, newModel), true
-}
-
-// handleLinkCommand handles /link commands for topic-specialist mapping.
-// /link           — show current topic's specialist mapping
-// /link <name>    — link this topic to a specialist
-// /link none      — unlink this topic
-func (al *AgentLoop) handleLinkCommand(msg bus.InboundMessage) (string, bool) {
-	trimmed := strings.TrimSpace(msg.Content)
-	if !strings.HasPrefix(trimmed, "/link") {
-		return "", false
-	}
-
-	threadID, ok := msg.Metadata["thread_id"]
-	if !ok || threadID == "" {
-		return "The /link command must be used from within a forum topic.", true
-	}
-
-	parts := strings.Fields(trimmed)
-	if len(parts) == 1 {
-		// Show current mapping
-		current := al.topicMappings.LookupSpecialist(msg.ChatID, threadID)
-		if current == "" {
-			return "This topic is not linked to any specialist.", true
-		}
-		return fmt.Sprintf("This topic is linked to specialist: `%s`", current), true
-	}
-
-	name := parts[1]
-	if name == "none" || name == "unlink" {
-		if err := al.topicMappings.RemoveMapping(msg.ChatID, threadID); err != nil {
-			return fmt.Sprintf("Failed to unlink topic: %v", err), true
-		}
-		return "Topic unlinked from specialist.", true
-	}
-
-	// Verify specialist exists
-	if !al.specialistLoader.Exists(name) {
-		available := al.specialistLoader.ListSpecialists()
-		var names []string
-		for _, s := range available {
-			names = append(names, s.Name)
-		}
-		return fmt.Sprintf("Specialist `%s` not found. Available: %s", name, strings.Join(names, ", ")), true
-	}
-
-	if err := al.topicMappings.SetMapping(msg.ChatID, threadID, name); err != nil {
-		return fmt.Sprintf("Failed to link topic: %v", err), true
-	}
-	return fmt.Sprintf("Topic linked to specialist: `%s`", name), true
-}
-
 // SetModel changes the active model at runtime.
 func (al *AgentLoop) SetModel(model string) {
+	al.modelMu.Lock()
+	defer al.modelMu.Unlock()
 	al.model = model
 }
 
+// Workspace returns the agent's workspace directory.
+func (al *AgentLoop) Workspace() string {
+	return al.workspace
+}
+
 // GetModel returns the current active model.
 func (al *AgentLoop) GetModel() string {
+	al.modelMu.RLock()
+	defer al.modelMu.RUnlock()
 	return al.model
 }
 
@@ -733,13 +931,14 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 		}
 	}
 
-	// 1. Update tool contexts
-	al.updateToolContexts(opts.Channel, opts.ChatID)
-
-	// 1b. Pass conversation summary to session-aware tools (e.g., specialist)
+	// 1. Attach per-turn tool context; the conversation summary gives
+	// session-aware tools (e.g., specialist) context continuity
+	ec := tools.ExecutionContextFrom(ctx).Derive(opts.Channel, opts.ChatID, opts.Metadata)
 	if !opts.NoHistory {
-		al.updateSessionContext(opts.SessionKey)
+		ec.SessionSummary = al.sessionContextSummary(opts.SessionKey)
 	}
+	ec.ReadOnly = opts.PlanMode
+	ctx = tools.WithExecutionContext(ctx, ec)
 
 	// 2. Build messages (skip history for heartbeat)
 	var history []providers.Message
@@ -747,37 +946,42 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 	if !opts.NoHistory {
 		history = al.sessions.GetHistory(opts.SessionKey)
 		summary = al.sessions.GetSummary(opts.SessionKey)
-	}
 
-	var messages []providers.Message
-	if opts.Specialist != "" {
-		messages = al.contextBuilder.BuildSpecialistMessages(
-			history,
-			summary,
-			opts.UserMessage,
-			opts.Media,
-			opts.Channel,
-			opts.ChatID,
-			opts.Specialist,
-		)
-	} else {
-		messages = al.contextBuilder.BuildMessages(
-			history,
-			summary,
-			opts.UserMessage,
-			opts.Media,
-			opts.Channel,
-			opts.ChatID,
-		)
+		// Fit summary and history into the token budget, next to the
+		// system prompt and new message they are sent with
+		var elisions []elision
+		_, assembler := al.contextBudget()
+		reserved := al.estimateTokens(al.buildMessages(nil, "", opts))
+		history, summary, elisions = assembler.Assemble(history, summary, reserved)
+		logElisions(opts.SessionKey, elisions)
 	}
 
-	// 3. Save user message to session (skip for NoHistory to prevent unbounded growth)
+	messages := al.buildMessages(history, summary, opts)
+
+	// 3. Save user message to session as the start of a new turn (skip for
+	// NoHistory to prevent unbounded growth)
 	if !opts.NoHistory {
-		al.sessions.AddMessage(opts.SessionKey, "user", opts.UserMessage)
+		al.sessions.StartTurn(opts.SessionKey)
+		al.sessions.AddFullMessage(opts.SessionKey, al.userMessage(opts.UserMessage, opts.Media))
 	}
 
 	// 4. Run LLM iteration loop
 	finalContent, iteration, usedSpecialist, err := al.runLLMIteration(ctx, messages, opts)
+	if errors.Is(err, errTurnStopped) {
+		// Completed tool calls and their results are already in the
+		// session; close the turn so the saved history stays well formed.
+		// The /stop command itself confirms to the user.
+		logger.InfoCF("agent", "Turn stopped by user",
+			map[string]interface{}{
+				"session_key": opts.SessionKey,
+				"iterations":  iteration,
+			})
+		if !opts.NoHistory {
+			al.sessions.AddMessage(opts.SessionKey, "assistant", stoppedNote)
+			al.sessions.Save(opts.SessionKey)
+		}
+		return "", nil
+	}
 	if err != nil {
 		return "", err
 	}
@@ -792,6 +996,13 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 
 	// 6. Save final assistant message to session (skip for NoHistory to prevent unbounded growth)
 	if !opts.NoHistory {
+		// In plan mode the answer is the plan awaiting /approve; carrying
+		// out a plan consumes it
+		if opts.PlanMode {
+			al.sessions.SetPlan(opts.SessionKey, finalContent)
+		} else if opts.ApprovedPlan != "" {
+			al.sessions.SetPlan(opts.SessionKey, "")
+		}
 		al.sessions.AddMessage(opts.SessionKey, "assistant", finalContent)
 		al.sessions.Save(opts.SessionKey)
 	}
@@ -850,12 +1061,17 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 	iteration := 0
 	var finalContent string
 	usedSpecialist := false
+	answered := false
+	route := al.routeTurn(ctx, opts)
 
 	for iteration < al.maxIterations {
+		if turnStopped(ctx) {
+			return "", iteration, usedSpecialist, errTurnStopped
+		}
 		iteration++
 
 		// Check for injected messages at each iteration boundary
-		messages = al.drainInterrupts(messages, opts.SessionKey)
+		messages = al.drainInterrupts(messages, opts)
 
 		logger.DebugCF("agent", "LLM iteration",
 			map[string]interface{}{
@@ -863,18 +1079,30 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				"max":       al.maxIterations,
 			})
 
-		// Build tool definitions
+		// Build tool definitions; plan mode offers only read-only tools
 		providerToolDefs := al.tools.ToProviderDefs()
+		if opts.PlanMode {
+			providerToolDefs = al.tools.ReadOnlyProviderDefs()
+		}
+		model := al.turnModel(opts)
+		if route.cheap {
+			model = al.cheapModel
+		}
+		llmOpts := generationOptions(al.cfg, config.GenerationSelector{
+			Model:      model,
+			Channel:    opts.Channel,
+			Specialist: opts.Specialist,
+		})
 
 		// Log LLM request details
 		logger.DebugCF("agent", "LLM request",
 			map[string]interface{}{
 				"iteration":         iteration,
-				"model":             al.model,
+				"model":             model,
+				"route":             route.String(),
 				"messages_count":    len(messages),
 				"tools_count":       len(providerToolDefs),
-				"max_tokens":        8192,
-				"temperature":       0.7,
+				"llm_options":       llmOpts,
 				"system_prompt_len": len(messages[0].Content),
 			})
 
@@ -886,12 +1114,6 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				"tools_json":    formatToolsForLog(providerToolDefs),
 			})
 
-		// Call LLM (with streaming if available)
-		llmOpts := map[string]interface{}{
-			"max_tokens":  8192,
-			"temperature": 0.4,
-		}
-
 		var response *providers.LLMResponse
 		var err error
 		var notifier *bus.StreamNotifier
@@ -911,14 +1133,17 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				}
 			}
 			notifier = bus.NewStreamNotifier(1500*time.Millisecond, filteredCb)
-			response, err = sp.ChatStream(ctx, messages, providerToolDefs, al.model, llmOpts, func(delta string) {
+			response, err = sp.ChatStream(ctx, messages, providerToolDefs, model, llmOpts, func(delta string) {
 				notifier.Append(delta)
 			})
 			notifier.Flush()
 		} else {
-			response, err = al.provider.Chat(ctx, messages, providerToolDefs, al.model, llmOpts)
+			response, err = al.provider.Chat(ctx, messages, providerToolDefs, model, llmOpts)
 		}
 
+		if turnStopped(ctx) {
+			return "", iteration, usedSpecialist, errTurnStopped
+		}
 		if err != nil {
 			logger.ErrorCF("agent", "LLM call failed",
 				map[string]interface{}{
@@ -928,15 +1153,27 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 			return "", iteration, usedSpecialist, fmt.Errorf("LLM call failed: %w", err)
 		}
 
+		usedModel := answeredBy(model, response)
+		if usedModel != model {
+			logger.WarnCF("agent", "Answered by a fallback model",
+				map[string]interface{}{
+					"iteration": iteration,
+					"model":     model,
+					"answered":  usedModel,
+				})
+		}
+
 		// Record token usage
 		if al.tracker != nil && response.Usage != nil {
 			toolNames := make([]string, 0, len(response.ToolCalls))
 			for _, tc := range response.ToolCalls {
 				toolNames = append(toolNames, tc.Name)
 			}
-			go al.tracker.Record(metrics.TokenEvent{
+			event := metrics.TokenEvent{
 				SessionKey:   opts.SessionKey,
-				Model:        al.model,
+				Channel:      opts.Channel,
+				SenderID:     opts.SenderID,
+				Model:        usedModel,
 				InputTokens:  response.Usage.PromptTokens,
 				OutputTokens: response.Usage.CompletionTokens,
 				CacheRead:    response.Usage.CacheReadInputTokens,
@@ -944,20 +1181,47 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				Specialist:   opts.Specialist,
 				ToolsUsed:    toolNames,
 				Iteration:    iteration,
-			})
+				Route:        route.String(),
+			}
+			if usedModel != model {
+				event.FallbackFrom = model
+			}
+			go al.tracker.Record(event)
+		}
+
+		// Tools that act or start longer work are left to the main model
+		if route.cheap {
+			if tool, ok := al.needsMainModel(response.ToolCalls); ok {
+				logger.InfoCF("agent", "Escalating turn to the main model",
+					map[string]interface{}{
+						"iteration": iteration,
+						"model":     model,
+						"tool":      tool,
+					})
+				route = modelRoute{reason: "escalated"}
+				// The main model redoes the step, so it does not use up an iteration
+				iteration--
+				if notifier != nil && stripThinkingTagsForStream(notifier.FullText()) != "" {
+					streamCb(escalationPreview)
+				}
+				continue
+			}
 		}
 
 		// Strip <think>...</think> reasoning blocks (e.g. MiniMax, DeepSeek)
-		response.Content = stripThinkingTags(response.Content)
+		response.Content = providers.StripThinkingTags(response.Content)
 
 		// Check if no tool calls - we're done
 		if len(response.ToolCalls) == 0 {
 			finalContent = response.Content
+			if usedModel != model && finalContent != "" {
+				finalContent += fallbackNote(model, usedModel)
+			}
 
 			// Edge case: LLM gave a final answer, but a new user message arrived.
 			// Temporarily append assistant message to check for interrupts.
 			messages = append(messages, providers.Message{Role: "assistant", Content: finalContent})
-			injected := al.drainInterrupts(messages, opts.SessionKey)
+			injected := al.drainInterrupts(messages, opts)
 			if len(injected) > len(messages) {
 				// New messages were injected — save and send current answer, then continue
 				if !opts.NoHistory {
@@ -982,6 +1246,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 					"iteration":     iteration,
 					"content_chars": len(finalContent),
 				})
+			answered = true
 			break
 		}
 
@@ -1020,13 +1285,9 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 			al.sessions.AddFullMessage(opts.SessionKey, assistantMsg)
 		}
 
-		// Execute tool calls
-		for _, tc := range response.ToolCalls {
-			// Track consult_specialist usage to skip double extraction
-			if tc.Name == "consult_specialist" {
-				usedSpecialist = true
-			}
-
+		// Execute tool calls; independent calls run concurrently and results
+		// come back in call order
+		toolResults := al.tools.ExecuteToolCalls(ctx, response.ToolCalls, al.maxParallel, func(ctx context.Context, tc providers.ToolCall) *tools.ToolResult {
 			// Log tool call with arguments preview
 			argsJSON, _ := json.Marshal(tc.Arguments)
 			argsPreview := utils.Truncate(string(argsJSON), 200)
@@ -1047,7 +1308,16 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				}
 			}
 
-			toolResult := al.tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID, asyncCallback, opts.Metadata)
+			return al.tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID, asyncCallback, opts.Metadata)
+		})
+
+		for i, tc := range response.ToolCalls {
+			// Track consult_specialist usage to skip double extraction
+			if tc.Name == "consult_specialist" {
+				usedSpecialist = true
+			}
+
+			toolResult := toolResults[i]
 
 			// Send ForUser content to user immediately if not Silent
 			if !toolResult.Silent && toolResult.ForUser != "" && opts.SendResponse {
@@ -1083,34 +1353,140 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 		}
 	}
 
+	// Out of tool iterations without a final answer: report progress instead
+	// of falling back to the default response, and remember the task so
+	// /continue can resume it with a fresh budget.
+	if !answered && iteration > 0 {
+		logger.WarnCF("agent", "Tool iteration budget exhausted",
+			map[string]interface{}{
+				"session_key": opts.SessionKey,
+				"iterations":  iteration,
+			})
+		finalContent = al.summarizeProgress(ctx, messages, opts, iteration)
+	}
+
+	if !opts.NoHistory {
+		if answered {
+			al.sessions.SetContinuation(opts.SessionKey, "")
+		} else if iteration > 0 {
+			task := opts.ResumeTask
+			if task == "" {
+				task = opts.UserMessage
+			}
+			al.sessions.SetContinuation(opts.SessionKey, task)
+		}
+	}
+
 	return finalContent, iteration, usedSpecialist, nil
 }
 
-// drainInterrupts non-blocking reads all pending messages from interruptCh
-// and appends them as user messages to the conversation. Returns the updated
-// messages slice (unchanged if no interrupts).
-func (al *AgentLoop) drainInterrupts(messages []providers.Message, sessionKey string) []providers.Message {
-	if al.interruptCh == nil {
+const progressSummaryPrompt = "You have run out of tool calls for this turn. Without calling any tools, " +
+	"briefly summarize what you have done so far, what you found, and what still remains to finish the task."
+
+// summarizeProgress makes a final tool-less LLM call after the tool iteration
+// budget is exhausted, so the user learns what was done and what remains.
+// The partial work itself is already in the session history.
+func (al *AgentLoop) summarizeProgress(ctx context.Context, messages []providers.Message, opts processOptions, iteration int) string {
+	hint := ""
+	if !opts.NoHistory {
+		hint = "\n\nReply /continue to pick up where I left off."
+	}
+	fallback := "I ran out of steps before finishing this task." + hint
+
+	model := al.turnModel(opts)
+	llmOpts := generationOptions(al.cfg, config.GenerationSelector{
+		Model:      model,
+		Channel:    opts.Channel,
+		Specialist: opts.Specialist,
+	})
+
+	request := make([]providers.Message, len(messages), len(messages)+1)
+	copy(request, messages)
+	request = append(request, providers.Message{Role: "user", Content: progressSummaryPrompt})
+
+	response, err := al.provider.Chat(ctx, request, nil, model, llmOpts)
+	if err != nil {
+		logger.WarnCF("agent", "Progress summary failed",
+			map[string]interface{}{
+				"session_key": opts.SessionKey,
+				"error":       err.Error(),
+			})
+		return fallback
+	}
+
+	if al.tracker != nil && response.Usage != nil {
+		go al.tracker.Record(metrics.TokenEvent{
+			SessionKey:   opts.SessionKey,
+			Channel:      opts.Channel,
+			SenderID:     opts.SenderID,
+			Model:        answeredBy(model, response),
+			InputTokens:  response.Usage.PromptTokens,
+			OutputTokens: response.Usage.CompletionTokens,
+			CacheRead:    response.Usage.CacheReadInputTokens,
+			CacheCreate:  response.Usage.CacheCreationInputTokens,
+			Specialist:   opts.Specialist,
+			Iteration:    iteration + 1,
+		})
+	}
+
+	content := strings.TrimSpace(providers.StripThinkingTags(response.Content))
+	if content == "" {
+		return fallback
+	}
+	return content + hint
+}
+
+// continuationPrompt builds the user message that resumes an unfinished task.
+func continuationPrompt(task, extra string) string {
+	prompt := continuationIntro + task
+	if extra != "" {
+		prompt += extraInstructionsHeader + extra
+	}
+	return prompt
+}
+
+const (
+	continuationIntro = "Continue working on the unfinished task from earlier in this conversation. " +
+		"Your previous tool calls and their results are above; pick up where you left off " +
+		"instead of starting over.\n\nOriginal task:\n"
+	extraInstructionsHeader = "\n\nAdditional instructions:\n"
+)
+
+// resumedTask returns the original task of a message built by
+// continuationPrompt, or "" for any other message.
+func resumedTask(prompt string) string {
+	return promptSubject(prompt, continuationIntro)
+}
+
+// promptSubject returns what follows intro in prompt, without additional
+// instructions, or "" if prompt does not start with intro.
+func promptSubject(prompt, intro string) string {
+	rest, ok := strings.CutPrefix(prompt, intro)
+	if !ok {
+		return ""
+	}
+	subject, _, _ := strings.Cut(rest, extraInstructionsHeader)
+	return subject
+}
+
+// drainInterrupts non-blocking reads all pending interrupts on the turn's
+// lane and appends them as user messages to the conversation and session.
+// Returns the updated messages slice (unchanged if no interrupts).
+func (al *AgentLoop) drainInterrupts(messages []providers.Message, opts processOptions) []providers.Message {
+	if opts.LaneKey == "" {
+		return messages
+	}
+	al.lanesMu.Lock()
+	lane := al.lanes[opts.LaneKey]
+	al.lanesMu.Unlock()
+	if lane == nil {
 		return messages
 	}
 
 	injected := false
 	for {
 		select {
-		case msg := <-al.interruptCh:
-			if msg.SessionKey != sessionKey {
-				// Wrong session — re-queue for normal processing
-				select {
-				case al.pendingMsgs <- msg:
-				default:
-					logger.ErrorCF("agent", "Pending channel full, dropping misrouted interrupt",
-						map[string]interface{}{
-							"target_session": msg.SessionKey,
-							"active_session": sessionKey,
-						})
-				}
-				continue
-			}
+		case msg := <-lane.interrupts:
 			userMsg := providers.Message{
 				Role:    "user",
 				Content: msg.Content,
@@ -1119,11 +1495,11 @@ func (al *AgentLoop) drainInterrupts(messages []providers.Message, sessionKey st
 				userMsg.ContentParts = msg.Media
 			}
 			messages = append(messages, userMsg)
-			al.sessions.AddMessage(sessionKey, "user", msg.Content)
+			al.sessions.AddFullMessage(opts.SessionKey, al.userMessage(msg.Content, msg.Media))
 			injected = true
 			logger.InfoCF("agent", "Injected interrupt message into conversation",
 				map[string]interface{}{
-					"session_key": sessionKey,
+					"session_key": opts.SessionKey,
 					"preview":     utils.Truncate(msg.Content, 60),
 				})
 		default:
@@ -1138,22 +1514,10 @@ func (al *AgentLoop) drainInterrupts(messages []providers.Message, sessionKey st
 	}
 }
 
-// updateToolContexts updates the context for tools that need channel/chatID info.
-func (al *AgentLoop) updateToolContexts(channel, chatID string) {
-	// Set context on all tools that implement ContextualTool
-	contextualTools := []string{"message", "spawn", "subagent", "consult_specialist", "link_topic", "manage_telegram"}
-	for _, name := range contextualTools {
-		if tool, ok := al.tools.Get(name); ok {
-			if ct, ok := tool.(tools.ContextualTool); ok {
-				ct.SetContext(channel, chatID)
-			}
-		}
-	}
-}
-
-// updateSessionContext passes conversation summary to session-aware tools
-// so they maintain context continuity (e.g., specialist knows what's been discussed).
-func (al *AgentLoop) updateSessionContext(sessionKey string) {
+// sessionContextSummary returns the conversation summary handed to
+// session-aware tools so they maintain context continuity (e.g., specialist
+// knows what's been discussed).
+func (al *AgentLoop) sessionContextSummary(sessionKey string) string {
 	summary := al.sessions.GetSummary(sessionKey)
 	// Also include recent history as lightweight context if no summary yet
 	if summary == "" {
@@ -1180,25 +1544,15 @@ func (al *AgentLoop) updateSessionContext(sessionKey string) {
 		}
 	}
 
-	if summary == "" {
-		return
-	}
-
-	// Set on all tools that implement SessionAwareTool
-	for _, name := range al.tools.List() {
-		if tool, ok := al.tools.Get(name); ok {
-			if sa, ok := tool.(tools.SessionAwareTool); ok {
-				sa.SetSessionSummary(summary)
-			}
-		}
-	}
+	return summary
 }
 
 // maybeSummarize triggers summarization if the session history exceeds thresholds.
 func (al *AgentLoop) maybeSummarize(sessionKey string) {
 	newHistory := al.sessions.GetHistory(sessionKey)
 	tokenEstimate := al.estimateTokens(newHistory)
-	threshold := al.contextWindow * 75 / 100
+	contextWindow, _ := al.contextBudget()
+	threshold := contextWindow * 75 / 100
 
 	if len(newHistory) > 40 || tokenEstimate > threshold {
 		if _, loading := al.summarizing.LoadOrStore(sessionKey, true); !loading {
@@ -1295,7 +1649,8 @@ func (al *AgentLoop) summarizeSession(sessionKey string) {
 
 	// Oversized Message Guard
 	// Skip messages larger than 50% of context window to prevent summarizer overflow
-	maxMessageTokens := al.contextWindow / 2
+	contextWindow, _ := al.contextBudget()
+	maxMessageTokens := contextWindow / 2
 	validMessages := make([]providers.Message, 0)
 	omitted := false
 
@@ -1329,14 +1684,14 @@ func (al *AgentLoop) summarizeSession(sessionKey string) {
 
 		// Merge them
 		mergePrompt := fmt.Sprintf("Merge these two conversation summaries into one cohesive summary:\n\n1: %s\n\n2: %s", s1, s2)
-		resp, err := al.provider.Chat(ctx, []providers.Message{{Role: "user", Content: mergePrompt}}, nil, al.cheapModel, map[string]interface{}{
+		resp, err := al.provider.Chat(ctx, []providers.Message{{Role: "user", Content: mergePrompt}}, nil, al.cheapModel, providers.MergeOptions(map[string]interface{}{
 			"max_tokens":  1024,
 			"temperature": 0.3,
-		})
+		}, taskOptions(al.cfg, al.cheapModel, config.TaskSummary)))
 		if err == nil {
-			finalSummary = stripThinkingTags(resp.Content)
+			finalSummary = providers.StripThinkingTags(resp.Content)
 		} else {
-			finalSummary = stripThinkingTags(s1) + " " + stripThinkingTags(s2)
+			finalSummary = providers.StripThinkingTags(s1) + " " + providers.StripThinkingTags(s2)
 		}
 	} else {
 		finalSummary, _ = al.summarizeBatch(ctx, validMessages, summary)
@@ -1364,14 +1719,45 @@ func (al *AgentLoop) summarizeBatch(ctx context.Context, batch []providers.Messa
 		prompt += fmt.Sprintf("%s: %s\n", m.Role, m.Content)
 	}
 
-	response, err := al.provider.Chat(ctx, []providers.Message{{Role: "user", Content: prompt}}, nil, al.cheapModel, map[string]interface{}{
+	response, err := al.provider.Chat(ctx, []providers.Message{{Role: "user", Content: prompt}}, nil, al.cheapModel, providers.MergeOptions(map[string]interface{}{
 		"max_tokens":  1024,
 		"temperature": 0.3,
-	})
+	}, taskOptions(al.cfg, al.cheapModel, config.TaskSummary)))
 	if err != nil {
 		return "", err
 	}
-	return stripThinkingTags(response.Content), nil
+	return providers.StripThinkingTags(response.Content), nil
+}
+
+// buildMessages builds the messages of a turn: the main or specialist
+// system prompt, the history and the user's message.
+func (al *AgentLoop) buildMessages(history []providers.Message, summary string, opts processOptions) []providers.Message {
+	var messages []providers.Message
+	if opts.Specialist != "" {
+		messages = al.contextBuilder.BuildSpecialistMessages(
+			history,
+			summary,
+			opts.UserMessage,
+			opts.Media,
+			opts.Channel,
+			opts.ChatID,
+			opts.Specialist,
+		)
+	} else {
+		messages = al.contextBuilder.BuildMessages(
+			history,
+			summary,
+			opts.UserMessage,
+			opts.Media,
+			opts.Channel,
+			opts.ChatID,
+		)
+	}
+
+	if opts.PlanMode && len(messages) > 0 && messages[0].Role == "system" {
+		messages[0].Content += "\n\n" + planModePrompt
+	}
+	return messages
 }
 
 // estimateTokens estimates the number of tokens in a message list.
@@ -1381,7 +1767,7 @@ func (al *AgentLoop) summarizeBatch(ctx context.Context, batch []providers.Messa
 func (al *AgentLoop) estimateTokens(messages []providers.Message) int {
 	total := 0
 	for _, m := range messages {
-		total += utf8.RuneCountInString(m.Content) / 3
+		total += estimateMessageTokens(m)
 	}
 	return total
 }
@@ -2,13 +2,21 @@ package agent
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
 	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/media"
+	"github.com/sipeed/picoclaw/pkg/metrics"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/tools"
 )
@@ -527,3 +535,986 @@ func TestToolResult_UserFacingToolDoesSendMessage(t *testing.T) {
 		t.Errorf("Expected 'Command output: hello world', got: %s", response)
 	}
 }
+
+// blockingProvider blocks on "slow" messages until release is closed and
+// answers everything else immediately.
+type blockingProvider struct {
+	release chan struct{}
+}
+
+func (m *blockingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	last := messages[len(messages)-1].Content
+	if last == "slow" {
+		select {
+		case <-m.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return &providers.LLMResponse{Content: last + " reply"}, nil
+}
+
+func (m *blockingProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+// TestRun_ProcessesSessionsConcurrently verifies a long turn in one session
+// does not block other sessions.
+func TestRun_ProcessesSessionsConcurrently(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:          tmpDir,
+				Model:              "test-model",
+				MaxTokens:          4096,
+				MaxToolIterations:  10,
+				MaxConcurrentTurns: 2,
+			},
+		},
+	}
+
+	msgBus := bus.NewMessageBus()
+	provider := &blockingProvider{release: make(chan struct{})}
+	al := NewAgentLoop(cfg, msgBus, provider)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go al.Run(ctx)
+
+	msgBus.PublishInbound(bus.InboundMessage{
+		Channel: "test", SenderID: "user1", ChatID: "chat1", Content: "slow", SessionKey: "test:chat1",
+	})
+	msgBus.PublishInbound(bus.InboundMessage{
+		Channel: "test", SenderID: "user2", ChatID: "chat2", Content: "fast", SessionKey: "test:chat2",
+	})
+
+	outCtx, outCancel := context.WithTimeout(ctx, responseTimeout)
+	defer outCancel()
+
+	out, ok := msgBus.SubscribeOutbound(outCtx)
+	if !ok {
+		t.Fatal("Timed out waiting for the unblocked session's response")
+	}
+	if out.Content != "fast reply" || out.ChatID != "chat2" {
+		t.Fatalf("Expected 'fast reply' for chat2 first, got %q for %s", out.Content, out.ChatID)
+	}
+
+	close(provider.release)
+
+	out, ok = msgBus.SubscribeOutbound(outCtx)
+	if !ok {
+		t.Fatal("Timed out waiting for the slow session's response")
+	}
+	if out.Content != "slow reply" || out.ChatID != "chat1" {
+		t.Errorf("Expected 'slow reply' for chat1, got %q for %s", out.Content, out.ChatID)
+	}
+}
+
+// TestRun_ImmediateCommandsDoNotBlock verifies a slow immediate command
+// does not hold up messages of other sessions.
+func TestRun_ImmediateCommandsDoNotBlock(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Workspace = t.TempDir()
+
+	msgBus := bus.NewMessageBus()
+	al := NewAgentLoop(cfg, msgBus, &blockingProvider{})
+	release := make(chan struct{})
+	al.commands.Register(commands.Command{
+		Name:      "wait",
+		Immediate: true,
+		Handler: func(ctx context.Context, req commands.Request) (string, error) {
+			<-release
+			return "waited", nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go al.Run(ctx)
+
+	msgBus.PublishInbound(bus.InboundMessage{
+		Channel: "test", SenderID: "user1", ChatID: "chat1", Content: "/wait", SessionKey: "test:chat1",
+	})
+	msgBus.PublishInbound(bus.InboundMessage{
+		Channel: "test", SenderID: "user2", ChatID: "chat2", Content: "fast", SessionKey: "test:chat2",
+	})
+
+	outCtx, outCancel := context.WithTimeout(ctx, responseTimeout)
+	defer outCancel()
+
+	out, ok := msgBus.SubscribeOutbound(outCtx)
+	if !ok || out.Content != "fast reply" {
+		t.Fatalf("Expected 'fast reply' first, got %q (ok=%v)", out.Content, ok)
+	}
+
+	close(release)
+	out, ok = msgBus.SubscribeOutbound(outCtx)
+	if !ok || out.Content != "waited" || out.ChatID != "chat1" {
+		t.Errorf("Expected the command's reply for chat1, got %q for %s (ok=%v)", out.Content, out.ChatID, ok)
+	}
+}
+
+// recordingProvider captures the options of the last Chat call.
+type recordingProvider struct {
+	lastOpts map[string]interface{}
+}
+
+func (m *recordingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.lastOpts = opts
+	return &providers.LLMResponse{Content: "OK"}, nil
+}
+
+func (m *recordingProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+// TestProcessMessage_UsesGenerationProfiles verifies turns use the configured
+// defaults and the profile selected for the channel.
+func TestProcessMessage_UsesGenerationProfiles(t *testing.T) {
+	topP := 0.8
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         2048,
+				Temperature:       0.6,
+				MaxToolIterations: 10,
+			},
+			Generation: config.GenerationConfig{
+				Profiles: map[string]config.GenerationProfile{
+					"chatty": {TopP: &topP, Stop: []string{"END"}},
+				},
+				Channels: map[string]string{"telegram": "chatty"},
+			},
+		},
+	}
+
+	provider := &recordingProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	helper := testHelper{al: al}
+
+	tests := []struct {
+		channel string
+		want    map[string]interface{}
+	}{
+		{
+			channel: "discord",
+			want:    map[string]interface{}{"max_tokens": 2048, "temperature": 0.6},
+		},
+		{
+			channel: "telegram",
+			want:    map[string]interface{}{"max_tokens": 2048, "temperature": 0.6, "top_p": 0.8, "stop": []string{"END"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			helper.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+				Channel:    tt.channel,
+				SenderID:   "user1",
+				ChatID:     "chat1",
+				Content:    "hello",
+				SessionKey: tt.channel + ":chat1",
+			})
+			if !reflect.DeepEqual(provider.lastOpts, tt.want) {
+				t.Errorf("Chat options = %v, want %v", provider.lastOpts, tt.want)
+			}
+		})
+	}
+}
+
+// toolLoopProvider keeps calling mock_custom for toolRounds tool-enabled calls,
+// then answers. Calls without tools get a progress summary.
+type toolLoopProvider struct {
+	toolRounds int
+	calls      int
+	lastPrompt string // last user message sent to the model
+}
+
+func (m *toolLoopProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	if last := messages[len(messages)-1]; last.Role == "user" {
+		m.lastPrompt = last.Content
+	}
+	if len(tools) == 0 {
+		return &providers.LLMResponse{Content: "Checked two sources, one left."}, nil
+	}
+	m.calls++
+	if m.calls > m.toolRounds {
+		return &providers.LLMResponse{Content: "All done."}, nil
+	}
+	return &providers.LLMResponse{
+		ToolCalls: []providers.ToolCall{{ID: "call_1", Name: "mock_custom", Arguments: map[string]interface{}{}}},
+	}, nil
+}
+
+func (m *toolLoopProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+// TestProcessMessage_ContinueAfterIterationLimit verifies an exhausted tool
+// budget yields a progress summary and that /continue resumes the task.
+func TestProcessMessage_ContinueAfterIterationLimit(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 2,
+			},
+		},
+	}
+
+	provider := &toolLoopProvider{toolRounds: 3}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	al.RegisterTool(&mockCustomTool{})
+	helper := testHelper{al: al}
+
+	msg := bus.InboundMessage{
+		Channel:    "telegram",
+		SenderID:   "user1",
+		ChatID:     "chat1",
+		Content:    "compare the three sources",
+		SessionKey: "telegram:chat1",
+	}
+
+	response := helper.executeAndGetResponse(t, context.Background(), msg)
+	if !strings.HasPrefix(response, "Checked two sources, one left.") || !strings.Contains(response, "/continue") {
+		t.Errorf("Expected progress summary with /continue hint, got %q", response)
+	}
+	if got := al.sessions.GetContinuation(msg.SessionKey); got != msg.Content {
+		t.Errorf("Expected continuation %q, got %q", msg.Content, got)
+	}
+
+	msg.Content = "/continue"
+	response = helper.executeAndGetResponse(t, context.Background(), msg)
+	if response != "All done." {
+		t.Errorf("Expected resumed task to finish, got %q", response)
+	}
+	if !strings.Contains(provider.lastPrompt, "compare the three sources") {
+		t.Errorf("Expected resumed turn to carry the original task, got %q", provider.lastPrompt)
+	}
+	if got := al.sessions.GetContinuation(msg.SessionKey); got != "" {
+		t.Errorf("Expected continuation to be cleared, got %q", got)
+	}
+
+	response = helper.executeAndGetResponse(t, context.Background(), msg)
+	if response != "There is no unfinished task to continue." {
+		t.Errorf("Unexpected response to /continue without a task: %q", response)
+	}
+}
+
+// modelEchoProvider answers with the model it was asked to use.
+type modelEchoProvider struct{}
+
+func (m *modelEchoProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	return &providers.LLMResponse{Content: "answered by " + model}, nil
+}
+
+func (m *modelEchoProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+// TestRouter_RoutesMessagesToAgents verifies each chat is answered by the
+// agent its route selects, with that agent's model and tool allowlist.
+func TestRouter_RoutesMessagesToAgents(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "main-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+			Named: map[string]config.AgentConfig{
+				"family": {
+					Workspace: t.TempDir(),
+					Model:     "family-model",
+					Tools:     []string{"read_file", "message"},
+				},
+			},
+			Routes: []config.AgentRoute{
+				{Agent: "family", Channel: "telegram", ChatID: "family-chat"},
+			},
+		},
+	}
+
+	msgBus := bus.NewMessageBus()
+	router, err := NewRouter(cfg, msgBus, func(*config.Config) (providers.LLMProvider, error) {
+		return &modelEchoProvider{}, nil
+	})
+	if err != nil {
+		t.Fatalf("NewRouter failed: %v", err)
+	}
+
+	family, ok := router.Agent("family")
+	if !ok {
+		t.Fatal("Expected a family agent")
+	}
+	names := family.tools.List()
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"message", "read_file"}) {
+		t.Errorf("Expected family tools [message read_file], got %v", names)
+	}
+	if router.Default().tools.Count() <= 2 {
+		t.Error("Expected the default agent to keep its full toolset")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go router.Run(ctx)
+
+	for chatID, want := range map[string]string{
+		"family-chat": "answered by family-model",
+		"work-chat":   "answered by main-model",
+	} {
+		msgBus.PublishInbound(bus.InboundMessage{
+			Channel: "telegram", SenderID: "user1", ChatID: chatID, Content: "hi", SessionKey: "telegram:" + chatID,
+		})
+
+		outCtx, outCancel := context.WithTimeout(ctx, responseTimeout)
+		out, ok := msgBus.SubscribeOutbound(outCtx)
+		outCancel()
+		if !ok {
+			t.Fatalf("Timed out waiting for the %s response", chatID)
+		}
+		if out.Content != want {
+			t.Errorf("Chat %s: expected %q, got %q", chatID, want, out.Content)
+		}
+	}
+}
+
+// TestRouter_SharesSessionsPerWorkspace verifies agents of one workspace use
+// one session manager, so they do not overwrite each other's sessions.
+func TestRouter_SharesWorkspaceState(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Workspace = t.TempDir()
+	cfg.Agents.Named = map[string]config.AgentConfig{
+		"helper": {Model: "helper-model"},
+		"family": {Workspace: t.TempDir()},
+	}
+
+	router, err := NewRouter(cfg, bus.NewMessageBus(), func(*config.Config) (providers.LLMProvider, error) {
+		return &modelEchoProvider{}, nil
+	})
+	if err != nil {
+		t.Fatalf("NewRouter failed: %v", err)
+	}
+	helper, _ := router.Agent("helper")
+	family, _ := router.Agent("family")
+	main := router.Default()
+	if helper.sessions != main.sessions || helper.tracker != main.tracker || helper.quotas != main.quotas {
+		t.Error("Expected agents of one workspace to share sessions, usage and quotas")
+	}
+	if family.sessions == main.sessions || family.tracker == main.tracker || family.quotas == main.quotas {
+		t.Error("Expected an agent with its own workspace to have its own sessions, usage and quotas")
+	}
+}
+
+// TestProcessMessage_Commands verifies built-in slash commands run without an
+// LLM call and that admin-only commands check the sender's role.
+func TestProcessMessage_Commands(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Commands: config.CommandsConfig{Admins: config.FlexibleStringSlice{"admin1"}},
+	}
+
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &modelEchoProvider{})
+	helper := testHelper{al: al}
+
+	msg := bus.InboundMessage{
+		Channel:    "telegram",
+		SenderID:   "user1",
+		ChatID:     "chat1",
+		Content:    "hello",
+		SessionKey: "telegram:chat1",
+	}
+	helper.executeAndGetResponse(t, context.Background(), msg)
+	if len(al.sessions.GetHistory(msg.SessionKey)) == 0 {
+		t.Fatal("Expected the turn to be saved to history")
+	}
+
+	msg.Content = "/help"
+	if response := helper.executeAndGetResponse(t, context.Background(), msg); !strings.Contains(response, "/reset") || strings.Contains(response, "/model") {
+		t.Errorf("Expected help without admin commands, got %q", response)
+	}
+
+	msg.Content = "/model other-model"
+	if response := helper.executeAndGetResponse(t, context.Background(), msg); response != "/model requires the admin role." {
+		t.Errorf("Expected role check, got %q", response)
+	}
+
+	msg.SenderID = "admin1"
+	if response := helper.executeAndGetResponse(t, context.Background(), msg); response != "Model switched: `test-model` -> `other-model`" {
+		t.Errorf("Expected model switch, got %q", response)
+	}
+
+	msg.Content = "/reset@picoclaw_bot"
+	if response := helper.executeAndGetResponse(t, context.Background(), msg); response != "Conversation history cleared." {
+		t.Errorf("Expected reset confirmation, got %q", response)
+	}
+	if n := len(al.sessions.GetHistory(msg.SessionKey)); n != 0 {
+		t.Errorf("Expected empty history after /reset, got %d messages", n)
+	}
+}
+
+// TestRun_StopCancelsRunningTurn verifies /stop cancels an in-flight turn,
+// confirms to the user and leaves a well-formed history.
+func TestRun_StopCancelsRunningTurn(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+
+	msgBus := bus.NewMessageBus()
+	al := NewAgentLoop(cfg, msgBus, &blockingProvider{release: make(chan struct{})})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go al.Run(ctx)
+
+	msg := bus.InboundMessage{
+		Channel: "test", SenderID: "user1", ChatID: "chat1", Content: "slow", SessionKey: "test:chat1",
+	}
+	msgBus.PublishInbound(msg)
+
+	// Wait for the turn to start
+	deadline := time.Now().Add(responseTimeout)
+	for len(al.sessions.GetHistory(msg.SessionKey)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the turn to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	msg.Content = "/stop"
+	msgBus.PublishInbound(msg)
+
+	outCtx, outCancel := context.WithTimeout(ctx, responseTimeout)
+	defer outCancel()
+	out, ok := msgBus.SubscribeOutbound(outCtx)
+	if !ok {
+		t.Fatal("Timed out waiting for the /stop confirmation")
+	}
+	if out.Content != "Stopped the current task." {
+		t.Errorf("Expected stop confirmation, got %q", out.Content)
+	}
+
+	for {
+		history := al.sessions.GetHistory(msg.SessionKey)
+		if len(history) == 2 {
+			if history[0].Role != "user" || history[1].Role != "assistant" || history[1].Content != stoppedNote {
+				t.Errorf("Unexpected history after stop: %+v", history)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the stopped turn to be saved, history: %+v", history)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The stopped turn must not publish a reply of its own
+	quietCtx, quietCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer quietCancel()
+	if out, ok := msgBus.SubscribeOutbound(quietCtx); ok {
+		t.Errorf("Expected no reply from the stopped turn, got %q", out.Content)
+	}
+}
+
+// TestRun_InterruptsJoinTheRunningTurn verifies a message sent during a turn
+// is taken into it, also for messages without a session key.
+func TestRun_InterruptsJoinTheRunningTurn(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Workspace = t.TempDir()
+
+	msgBus := bus.NewMessageBus()
+	provider := &blockingProvider{release: make(chan struct{})}
+	al := NewAgentLoop(cfg, msgBus, provider)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go al.Run(ctx)
+
+	msg := bus.InboundMessage{Channel: "test", SenderID: "user1", ChatID: "chat1", Content: "slow"}
+	msgBus.PublishInbound(msg)
+
+	// Wait for the turn to start
+	deadline := time.Now().Add(responseTimeout)
+	for len(al.sessions.GetHistory("test:chat1")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the turn to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	msg.Content = "fast"
+	msgBus.PublishInbound(msg)
+	for {
+		al.lanesMu.Lock()
+		queued := len(al.lanes["test:chat1"].interrupts)
+		al.lanesMu.Unlock()
+		if queued == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the interrupt to be queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(provider.release)
+
+	outCtx, outCancel := context.WithTimeout(ctx, responseTimeout)
+	defer outCancel()
+	for _, want := range []string{"slow reply", "fast reply"} {
+		out, ok := msgBus.SubscribeOutbound(outCtx)
+		if !ok || out.Content != want {
+			t.Fatalf("Expected %q, got %q (ok=%v)", want, out.Content, ok)
+		}
+	}
+
+	var users []string
+	for _, m := range al.sessions.GetHistory("test:chat1") {
+		if m.Role == "user" {
+			users = append(users, m.Content)
+		}
+	}
+	if strings.Join(users, ",") != "slow,fast" {
+		t.Errorf("Expected the interrupt in the session once, got user messages %v", users)
+	}
+	if turns := al.sessions.Snapshot("test:chat1").Turns; len(turns) != 1 {
+		t.Errorf("Expected the interrupt to join the running turn, got turns %v", turns)
+	}
+}
+
+// TestProcessMessage_UndoAndRetry verifies /undo drops the last exchange and
+// /retry regenerates it with the model an admin asks for.
+func TestProcessMessage_UndoAndRetry(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Commands: config.CommandsConfig{Admins: config.FlexibleStringSlice{"admin1"}},
+	}
+
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &modelEchoProvider{})
+	helper := testHelper{al: al}
+
+	msg := bus.InboundMessage{
+		Channel:    "telegram",
+		SenderID:   "user1",
+		ChatID:     "chat1",
+		SessionKey: "telegram:chat1",
+	}
+	for _, content := range []string{"first", "second"} {
+		msg.Content = content
+		helper.executeAndGetResponse(t, context.Background(), msg)
+	}
+
+	msg.Content = "/retry other-model"
+	if response := helper.executeAndGetResponse(t, context.Background(), msg); !strings.Contains(response, "requires the admin role") {
+		t.Errorf("Expected a user's /retry with a model to be refused, got %q", response)
+	}
+	if history := al.sessions.GetHistory(msg.SessionKey); len(history) != 4 || history[3].Content != "answered by test-model" {
+		t.Errorf("Expected a refused /retry to keep the last turn, got %+v", history)
+	}
+
+	msg.SenderID = "admin1"
+	if response := helper.executeAndGetResponse(t, context.Background(), msg); response != "answered by other-model" {
+		t.Errorf("Expected retry with other-model, got %q", response)
+	}
+	history := al.sessions.GetHistory(msg.SessionKey)
+	if len(history) != 4 || history[2].Content != "second" || history[3].Content != "answered by other-model" {
+		t.Errorf("Expected the retried turn to replace the last one, got %+v", history)
+	}
+	if al.GetModel() != "test-model" {
+		t.Errorf("Expected /retry not to change the agent's model, got %q", al.GetModel())
+	}
+
+	msg.Content = "/undo"
+	helper.executeAndGetResponse(t, context.Background(), msg)
+	history = al.sessions.GetHistory(msg.SessionKey)
+	if len(history) != 2 || history[0].Content != "first" {
+		t.Errorf("Expected only the first exchange after /undo, got %+v", history)
+	}
+
+	helper.executeAndGetResponse(t, context.Background(), msg)
+	if response := helper.executeAndGetResponse(t, context.Background(), msg); response != "There is nothing to undo." {
+		t.Errorf("Expected nothing to undo, got %q", response)
+	}
+}
+
+// mediaEchoProvider answers with the number of media parts of the last user
+// message.
+type mediaEchoProvider struct{}
+
+func (m *mediaEchoProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	last := messages[len(messages)-1]
+	return &providers.LLMResponse{Content: fmt.Sprintf("%s with %d parts", last.Content, len(last.ContentParts))}, nil
+}
+
+func (m *mediaEchoProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+// TestProcessMessage_RetryRebuildsTheTurn verifies /retry resends the
+// retried message's media, and resumes the same task when retrying a
+// /continue turn.
+func TestProcessMessage_RetryRebuildsTheTurn(t *testing.T) {
+	t.Run("media", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.Agents.Defaults.Workspace = t.TempDir()
+		al := NewAgentLoop(cfg, bus.NewMessageBus(), &mediaEchoProvider{})
+		helper := testHelper{al: al}
+
+		msg := bus.InboundMessage{
+			Channel:    "telegram",
+			SenderID:   "user1",
+			ChatID:     "chat1",
+			SessionKey: "telegram:chat1",
+			Content:    "what's this?",
+			Media:      []media.ContentPart{{Type: "image", MediaType: "image/png", Data: "aGVsbG8=", FileName: "a.png"}},
+		}
+		helper.executeAndGetResponse(t, context.Background(), msg)
+
+		msg.Content, msg.Media = "/retry", nil
+		if response := helper.executeAndGetResponse(t, context.Background(), msg); response != "what's this? with 1 parts" {
+			t.Errorf("Expected the retried message with its image, got %q", response)
+		}
+	})
+
+	t.Run("continue", func(t *testing.T) {
+		cfg := config.DefaultConfig()
+		cfg.Agents.Defaults.Workspace = t.TempDir()
+		cfg.Agents.Defaults.MaxToolIterations = 2
+		al := NewAgentLoop(cfg, bus.NewMessageBus(), &toolLoopProvider{toolRounds: 100})
+		al.RegisterTool(&mockCustomTool{})
+		helper := testHelper{al: al}
+
+		msg := bus.InboundMessage{
+			Channel:    "telegram",
+			SenderID:   "user1",
+			ChatID:     "chat1",
+			SessionKey: "telegram:chat1",
+		}
+		for _, content := range []string{"compare the three sources", "/continue", "/retry"} {
+			msg.Content = content
+			helper.executeAndGetResponse(t, context.Background(), msg)
+		}
+		if got := al.sessions.GetContinuation(msg.SessionKey); got != "compare the three sources" {
+			t.Errorf("Expected the retried turn to resume the original task, got continuation %q", got)
+		}
+	})
+}
+
+func TestProcessMessage_CassetteReplay(t *testing.T) {
+	cassettePath := filepath.Join(t.TempDir(), "conversation.jsonl")
+	newLoop := func(provider providers.LLMProvider) *AgentLoop {
+		cfg := &config.Config{
+			Agents: config.AgentsConfig{
+				Defaults: config.AgentDefaults{
+					Workspace:         t.TempDir(),
+					Model:             "test-model",
+					MaxTokens:         4096,
+					MaxToolIterations: 10,
+				},
+			},
+		}
+		return NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	}
+	converse := func(al *AgentLoop) []string {
+		helper := testHelper{al: al}
+		var responses []string
+		for _, content := range []string{"first", "second"} {
+			responses = append(responses, helper.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+				Channel:    "telegram",
+				SenderID:   "user1",
+				ChatID:     "chat1",
+				SessionKey: "telegram:chat1",
+				Content:    content,
+			}))
+		}
+		return responses
+	}
+
+	rec, err := providers.OpenCassette(cassettePath, providers.CassetteRecord)
+	if err != nil {
+		t.Fatalf("OpenCassette(record): %v", err)
+	}
+	recorded := converse(newLoop(rec.Provider(&modelEchoProvider{})))
+	rec.Close()
+
+	play, err := providers.OpenCassette(cassettePath, providers.CassetteReplay)
+	if err != nil {
+		t.Fatalf("OpenCassette(replay): %v", err)
+	}
+	replayed := converse(newLoop(play.Provider(nil)))
+
+	if len(replayed) != 2 || replayed[0] != recorded[0] || replayed[1] != recorded[1] {
+		t.Errorf("Replayed responses %q, recorded %q", replayed, recorded)
+	}
+	if n := play.Remaining(); n != 0 {
+		t.Errorf("Expected every recorded call to be replayed, %d remain", n)
+	}
+}
+
+// toolListProvider answers every call and records the tools it was offered
+// and the last user message.
+type toolListProvider struct {
+	mu       sync.Mutex
+	offered  [][]string
+	lastUser string
+}
+
+func (p *toolListProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Function.Name)
+	}
+	p.offered = append(p.offered, names)
+	p.lastUser = messages[len(messages)-1].Content
+	return &providers.LLMResponse{Content: "1. Write notes.md"}, nil
+}
+
+func (p *toolListProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+func (p *toolListProvider) lastOffered() map[string]bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	offered := make(map[string]bool)
+	for _, name := range p.offered[len(p.offered)-1] {
+		offered[name] = true
+	}
+	return offered
+}
+
+func TestProcessMessage_PlanMode(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+
+	provider := &toolListProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	helper := testHelper{al: al}
+
+	msg := bus.InboundMessage{
+		Channel:    "telegram",
+		SenderID:   "user1",
+		ChatID:     "chat1",
+		SessionKey: "telegram:chat1",
+	}
+
+	msg.Content = "/approve"
+	if response := helper.executeAndGetResponse(t, context.Background(), msg); response != "There is no plan to approve." {
+		t.Errorf("Expected no plan to approve, got %q", response)
+	}
+
+	msg.Content = "/plan"
+	if response := helper.executeAndGetResponse(t, context.Background(), msg); !strings.HasPrefix(response, "Plan mode on.") {
+		t.Fatalf("Expected plan mode to be turned on, got %q", response)
+	}
+
+	msg.Content = "Update my notes"
+	helper.executeAndGetResponse(t, context.Background(), msg)
+	offered := provider.lastOffered()
+	if !offered["read_file"] || offered["write_file"] || offered["exec"] {
+		t.Errorf("Expected only read-only tools in plan mode, got %v", offered)
+	}
+	if plan := al.sessions.GetPlan(msg.SessionKey); plan != "1. Write notes.md" {
+		t.Errorf("Expected the answer to be kept as the plan, got %q", plan)
+	}
+
+	msg.Content = "/approve keep it short"
+	helper.executeAndGetResponse(t, context.Background(), msg)
+	if offered := provider.lastOffered(); !offered["write_file"] {
+		t.Errorf("Expected the full toolset for an approved plan, got %v", offered)
+	}
+	if !strings.Contains(provider.lastUser, "1. Write notes.md") || !strings.Contains(provider.lastUser, "keep it short") {
+		t.Errorf("Expected the plan and instructions in the approval prompt, got %q", provider.lastUser)
+	}
+	if plan := al.sessions.GetPlan(msg.SessionKey); plan != "" {
+		t.Errorf("Expected the plan to be consumed, got %q", plan)
+	}
+	if !al.sessions.GetPlanMode(msg.SessionKey) {
+		t.Error("Expected the session to stay in plan mode after /approve")
+	}
+
+	msg.Content = "/plan off"
+	helper.executeAndGetResponse(t, context.Background(), msg)
+	msg.Content = "Update my notes"
+	helper.executeAndGetResponse(t, context.Background(), msg)
+	if offered := provider.lastOffered(); !offered["write_file"] {
+		t.Errorf("Expected the full toolset with plan mode off, got %v", offered)
+	}
+}
+
+// TestProcessMessage_PlanModeWithoutSessionKey verifies plan mode holds for
+// messages that carry no session key, which /plan keys by channel and chat.
+func TestProcessMessage_PlanModeWithoutSessionKey(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Workspace = t.TempDir()
+
+	provider := &toolListProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	helper := testHelper{al: al}
+
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "user1", ChatID: "chat1"}
+	msg.Content = "/plan"
+	helper.executeAndGetResponse(t, context.Background(), msg)
+	msg.Content = "Update my notes"
+	helper.executeAndGetResponse(t, context.Background(), msg)
+
+	if offered := provider.lastOffered(); offered["write_file"] || offered["exec"] {
+		t.Errorf("Expected only read-only tools in plan mode, got %v", offered)
+	}
+	if plan := al.sessions.GetPlan("telegram:chat1"); plan != "1. Write notes.md" {
+		t.Errorf("Expected the plan to be kept in the chat's session, got %q", plan)
+	}
+}
+
+// localModelProvider serves a fixed set of models, like Ollama.
+type localModelProvider struct {
+	mockProvider
+	windows map[string]int
+}
+
+func (m *localModelProvider) ContextWindow(ctx context.Context, model string) (int, error) {
+	if !strings.Contains(model, ":") {
+		model += ":latest"
+	}
+	return m.windows[model], nil
+}
+
+func (m *localModelProvider) ListModels(ctx context.Context) ([]providers.ModelInfo, error) {
+	var models []providers.ModelInfo
+	for name := range m.windows {
+		models = append(models, providers.ModelInfo{Name: name})
+	}
+	return models, nil
+}
+
+func TestAgentLoop_ContextWindowFromProvider(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "small:latest",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	provider := &localModelProvider{windows: map[string]int{"small:latest": 8192, "large:latest": 131072}}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+
+	// The window is asked for in the background
+	deadline := time.Now().Add(responseTimeout)
+	for {
+		window, assembler := al.contextBudget()
+		if window == 8192 && assembler.historyTokens == 8192*75/100 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the model's 8192-token window, got %d (history budget %d)", window, assembler.historyTokens)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	reply, err := al.cmdModel(context.Background(), commands.Request{Args: []string{"missing"}})
+	if err != nil || !strings.Contains(reply, "Unknown model `missing`") || al.GetModel() != "small:latest" {
+		t.Errorf("Expected an unknown model to be refused, got %q, %v", reply, err)
+	}
+	reply, err = al.cmdRetry(context.Background(), commands.Request{Args: []string{"missing"}, Role: commands.RoleAdmin})
+	if err != nil || !strings.Contains(reply, "Unknown model `missing`") {
+		t.Errorf("Expected /retry with an unknown model to be refused, got %q, %v", reply, err)
+	}
+
+	if _, err := al.cmdModel(context.Background(), commands.Request{Args: []string{"large"}}); err != nil {
+		t.Fatalf("cmdModel: %v", err)
+	}
+	if window, _ := al.contextBudget(); al.GetModel() != "large" || window != 131072 {
+		t.Errorf("Expected the switch to resize the window, got model %q, window %d", al.GetModel(), window)
+	}
+}
+
+// fallbackAnswerProvider answers as if a fallback model had stepped in.
+type fallbackAnswerProvider struct{}
+
+func (m *fallbackAnswerProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	return &providers.LLMResponse{
+		Content: "Hello",
+		Model:   "backup-model",
+		Usage:   &providers.UsageInfo{PromptTokens: 10, CompletionTokens: 2},
+	}, nil
+}
+
+func (m *fallbackAnswerProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+// TestProcessMessage_NotesFallbackModel verifies an answer from a fallback
+// model says so and is attributed to that model in the metrics.
+func TestProcessMessage_NotesFallbackModel(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Workspace = t.TempDir()
+	cfg.Agents.Defaults.Model = "main-model"
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &fallbackAnswerProvider{})
+
+	response := testHelper{al: al}.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel:    "telegram",
+		SenderID:   "user1",
+		ChatID:     "chat1",
+		Content:    "hi",
+		SessionKey: "telegram:chat1",
+	})
+	if want := "Hello" + fallbackNote("main-model", "backup-model"); response != want {
+		t.Errorf("Response = %q, want %q", response, want)
+	}
+
+	// Token usage is recorded asynchronously
+	deadline := time.Now().Add(responseTimeout)
+	for {
+		usage, err := al.tracker.Sum(func(e metrics.TokenEvent) bool {
+			return e.Model == "backup-model" && e.FallbackFrom == "main-model"
+		})
+		if err == nil && usage.Calls == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected one call attributed to the fallback model, got %+v (%v)", usage, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
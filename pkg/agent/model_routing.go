@@ -0,0 +1,164 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/metrics"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+const (
+	defaultRoutingMaxChars = 280
+	classifierTimeout      = 3 * time.Second
+
+	// escalationPreview replaces a streamed preview of the cheap model's
+	// reply once the main model takes the turn over.
+	escalationPreview = "Thinking 💭"
+)
+
+// defaultComplexTools are the tools a cheap model hands over to the main
+// model by calling: they act on the world or start longer work.
+var defaultComplexTools = []string{
+	"exec", "write_file", "edit_file", "append_file",
+	"spawn", "subagent", "cron", "email", "consult_specialist",
+}
+
+const classifierPrompt = "Decide which model should answer the user's message. " +
+	"Reply with one word: SIMPLE for greetings, thanks, small talk and quick factual questions; " +
+	"COMPLEX for anything that needs reasoning, planning, code, writing or several steps."
+
+// modelRoute is the model a turn was routed to, and why. The zero route
+// means routing is off and the turn uses the main model.
+type modelRoute struct {
+	cheap  bool
+	reason string
+}
+
+// String returns the route as recorded in token events, e.g. "cheap:rules".
+func (r modelRoute) String() string {
+	if r.reason == "" {
+		return ""
+	}
+	if r.cheap {
+		return "cheap:" + r.reason
+	}
+	return "main:" + r.reason
+}
+
+// routeTurn decides whether the cheap model can answer a turn. Any rule that
+// asks for the main model wins; the classifier, if enabled, decides the rest.
+func (al *AgentLoop) routeTurn(ctx context.Context, opts processOptions) modelRoute {
+	rc := al.cfg.Agents.Defaults.Routing
+	if !rc.Enabled || opts.Model != "" || al.cheapModel == "" || al.cheapModel == al.GetModel() {
+		return modelRoute{}
+	}
+
+	maxChars := rc.MaxChars
+	if maxChars <= 0 {
+		maxChars = defaultRoutingMaxChars
+	}
+	switch {
+	case len(opts.Media) > 0:
+		return modelRoute{reason: "attachments"}
+	case opts.PlanMode || opts.ApprovedPlan != "" || opts.ResumeTask != "":
+		return modelRoute{reason: "task"}
+	case opts.Specialist != "" && !containsFold(rc.CheapSpecialists, opts.Specialist):
+		return modelRoute{reason: "specialist"}
+	case containsFold(rc.MainChannels, opts.Channel):
+		return modelRoute{reason: "channel"}
+	case matchesKeyword(opts.UserMessage, rc.Keywords):
+		return modelRoute{reason: "keyword"}
+	case utf8.RuneCountInString(opts.UserMessage) > maxChars:
+		return modelRoute{reason: "length"}
+	}
+
+	if rc.Classifier {
+		return al.classifyTurn(ctx, opts)
+	}
+	return modelRoute{cheap: true, reason: "rules"}
+}
+
+// classifyTurn asks the cheap model whether it can answer the message. When
+// the classifier fails or is slow, the main model answers: the classifier
+// holds up every turn it sees, so it gets a few seconds at most.
+func (al *AgentLoop) classifyTurn(ctx context.Context, opts processOptions) modelRoute {
+	ctx, cancel := context.WithTimeout(ctx, classifierTimeout)
+	defer cancel()
+
+	messages := []providers.Message{
+		{Role: "system", Content: classifierPrompt},
+		{Role: "user", Content: opts.UserMessage},
+	}
+	response, err := al.provider.Chat(ctx, messages, nil, al.cheapModel, providers.MergeOptions(map[string]interface{}{
+		"max_tokens":  8,
+		"temperature": 0.0,
+	}, taskOptions(al.cfg, al.cheapModel, config.TaskTriage)))
+	if err != nil {
+		logger.WarnCF("agent", "Routing classifier failed",
+			map[string]interface{}{
+				"session_key": opts.SessionKey,
+				"error":       err.Error(),
+			})
+		return modelRoute{reason: "classifier"}
+	}
+
+	if al.tracker != nil && response.Usage != nil {
+		go al.tracker.Record(metrics.TokenEvent{
+			SessionKey:   opts.SessionKey,
+			Channel:      opts.Channel,
+			SenderID:     opts.SenderID,
+			Model:        answeredBy(al.cheapModel, response),
+			InputTokens:  response.Usage.PromptTokens,
+			OutputTokens: response.Usage.CompletionTokens,
+			Route:        "classifier",
+		})
+	}
+
+	verdict := strings.ToUpper(providers.StripThinkingTags(response.Content))
+	return modelRoute{cheap: strings.Contains(verdict, "SIMPLE") && !strings.Contains(verdict, "COMPLEX"), reason: "classifier"}
+}
+
+// needsMainModel reports whether the cheap model called a tool it should
+// leave to the main model, and which.
+func (al *AgentLoop) needsMainModel(calls []providers.ToolCall) (string, bool) {
+	complexTools := al.cfg.Agents.Defaults.Routing.ComplexTools
+	if len(complexTools) == 0 {
+		complexTools = defaultComplexTools
+	}
+	for _, tc := range calls {
+		if containsFold(complexTools, tc.Name) {
+			return tc.Name, true
+		}
+	}
+	return "", false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesKeyword(message string, keywords []string) bool {
+	message = strings.ToLower(message)
+	for _, k := range keywords {
+		if k != "" && strings.Contains(message, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
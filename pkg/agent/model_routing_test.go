@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/media"
+	"github.com/sipeed/picoclaw/pkg/metrics"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// routedProvider answers the routing classifier with verdict, calls exec
+// from the cheap model when asked to run something, and otherwise names the
+// model that answered.
+type routedProvider struct {
+	verdict string
+
+	mu     sync.Mutex
+	models []string // models of the turn's calls, which offer tools
+}
+
+func (p *routedProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	usage := &providers.UsageInfo{PromptTokens: 10, CompletionTokens: 2}
+	if messages[0].Content == classifierPrompt {
+		return &providers.LLMResponse{Content: p.verdict, Usage: usage}, nil
+	}
+	if len(tools) > 0 {
+		p.mu.Lock()
+		p.models = append(p.models, model)
+		p.mu.Unlock()
+	}
+	if model == "cheap-model" && strings.Contains(messages[len(messages)-1].Content, "run") {
+		return &providers.LLMResponse{
+			ToolCalls: []providers.ToolCall{{ID: "call_1", Name: "exec", Arguments: map[string]interface{}{"command": "uptime"}}},
+			Usage:     usage,
+		}, nil
+	}
+	return &providers.LLMResponse{Content: "answered by " + model, Usage: usage}, nil
+}
+
+func (p *routedProvider) GetDefaultModel() string {
+	return "main-model"
+}
+
+func newRoutingLoop(t *testing.T, provider providers.LLMProvider, routing config.ModelRoutingConfig) *AgentLoop {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Workspace = t.TempDir()
+	cfg.Agents.Defaults.Model = "main-model"
+	cfg.Agents.Defaults.CheapModel = "cheap-model"
+	cfg.Agents.Defaults.Routing = routing
+	return NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+}
+
+func TestRouteTurn(t *testing.T) {
+	rules := config.ModelRoutingConfig{
+		Enabled:          true,
+		MaxChars:         40,
+		Keywords:         []string{"Refactor"},
+		MainChannels:     []string{"email"},
+		CheapSpecialists: []string{"chef"},
+	}
+	al := newRoutingLoop(t, &routedProvider{}, rules)
+	off := newRoutingLoop(t, &routedProvider{}, config.ModelRoutingConfig{})
+
+	tests := []struct {
+		name string
+		al   *AgentLoop
+		opts processOptions
+		want string
+	}{
+		{"short message", al, processOptions{UserMessage: "thanks!"}, "cheap:rules"},
+		{"long message", al, processOptions{UserMessage: strings.Repeat("why ", 20)}, "main:length"},
+		{"keyword", al, processOptions{UserMessage: "please refactor this"}, "main:keyword"},
+		{"channel", al, processOptions{UserMessage: "hi", Channel: "email"}, "main:channel"},
+		{"attachment", al, processOptions{UserMessage: "what's this?", Media: []media.ContentPart{{}}}, "main:attachments"},
+		{"plan", al, processOptions{UserMessage: "tidy up", PlanMode: true}, "main:task"},
+		{"specialist", al, processOptions{UserMessage: "hi", Specialist: "finance"}, "main:specialist"},
+		{"cheap specialist", al, processOptions{UserMessage: "hi", Specialist: "chef"}, "cheap:rules"},
+		{"explicit model", al, processOptions{UserMessage: "hi", Model: "other-model"}, ""},
+		{"routing off", off, processOptions{UserMessage: "hi"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.al.routeTurn(t.Context(), tt.opts).String(); got != tt.want {
+				t.Errorf("routeTurn() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteTurn_Classifier(t *testing.T) {
+	tests := []struct {
+		verdict string
+		want    string
+	}{
+		{"SIMPLE", "cheap:classifier"},
+		{"complex", "main:classifier"},
+		{"not sure", "main:classifier"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.verdict, func(t *testing.T) {
+			al := newRoutingLoop(t, &routedProvider{verdict: tt.verdict}, config.ModelRoutingConfig{Enabled: true, Classifier: true})
+			if got := al.routeTurn(t.Context(), processOptions{UserMessage: "what time is it in Tokyo?"}).String(); got != tt.want {
+				t.Errorf("routeTurn() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestProcessMessage_EscalatesComplexTools verifies a cheap model calling a
+// complex tool hands the turn to the main model, and that both calls record
+// their route.
+func TestProcessMessage_EscalatesComplexTools(t *testing.T) {
+	provider := &routedProvider{}
+	al := newRoutingLoop(t, provider, config.ModelRoutingConfig{Enabled: true})
+
+	response := testHelper{al: al}.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel:    "telegram",
+		SenderID:   "user1",
+		ChatID:     "chat1",
+		Content:    "run uptime",
+		SessionKey: "telegram:chat1",
+	})
+	if response != "answered by main-model" {
+		t.Errorf("Response = %q, want the main model's answer", response)
+	}
+	provider.mu.Lock()
+	models := strings.Join(provider.models, ",")
+	provider.mu.Unlock()
+	if models != "cheap-model,main-model" {
+		t.Errorf("Models called = %s, want cheap-model,main-model", models)
+	}
+
+	// Token usage is recorded asynchronously
+	deadline := time.Now().Add(responseTimeout)
+	for {
+		cheap, err1 := al.tracker.Sum(func(e metrics.TokenEvent) bool {
+			return e.Model == "cheap-model" && e.Route == "cheap:rules"
+		})
+		escalated, err2 := al.tracker.Sum(func(e metrics.TokenEvent) bool {
+			return e.Model == "main-model" && e.Route == "main:escalated"
+		})
+		if err1 == nil && err2 == nil && cheap.Calls == 1 && escalated.Calls == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected one cheap and one escalated call, got %+v and %+v", cheap, escalated)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestProcessMessage_EscalationKeepsIterations verifies the escalated step
+// does not use up the turn's iteration budget.
+func TestProcessMessage_EscalationKeepsIterations(t *testing.T) {
+	al := newRoutingLoop(t, &routedProvider{}, config.ModelRoutingConfig{Enabled: true})
+	al.maxIterations = 1
+
+	response := testHelper{al: al}.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel:    "telegram",
+		SenderID:   "user1",
+		ChatID:     "chat1",
+		Content:    "run uptime",
+		SessionKey: "telegram:chat1",
+	})
+	if response != "answered by main-model" {
+		t.Errorf("Response = %q, want the main model's answer", response)
+	}
+}
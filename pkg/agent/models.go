@@ -0,0 +1,160 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// contextWindowTimeout bounds asking the provider for a model's context size.
+const contextWindowTimeout = 10 * time.Second
+
+// refreshContextWindow sizes the context budget for model, if the provider
+// knows the model's context window. Otherwise the configured max_tokens
+// stays in effect.
+func (al *AgentLoop) refreshContextWindow(ctx context.Context, model string) {
+	provider, id := al.providerFor(model)
+	p, ok := provider.(providers.ContextWindowProvider)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, contextWindowTimeout)
+	defer cancel()
+	window, err := p.ContextWindow(ctx, id)
+	if err == nil && window <= 0 {
+		err = fmt.Errorf("invalid context window %d", window)
+	}
+	if err != nil {
+		logger.WarnCF("agent", "Failed to get the model's context window",
+			map[string]interface{}{
+				"model": model,
+				"error": err.Error(),
+			})
+		return
+	}
+
+	al.modelMu.Lock()
+	if al.model != model {
+		// Switched to another model while asking
+		al.modelMu.Unlock()
+		return
+	}
+	al.contextWindow = window
+	al.assembler = newContextAssembler(al.cfg.Agents.Defaults.Context, window)
+	al.modelMu.Unlock()
+	logger.InfoCF("agent", "Context window set from the model",
+		map[string]interface{}{
+			"model":          model,
+			"context_window": window,
+		})
+}
+
+// contextBudget returns the context window and the assembler sized for it.
+func (al *AgentLoop) contextBudget() (int, contextAssembler) {
+	al.modelMu.RLock()
+	defer al.modelMu.RUnlock()
+	return al.contextWindow, al.assembler
+}
+
+// providerFor returns the provider serving model and the model ID to send
+// it, following named provider instances.
+func (al *AgentLoop) providerFor(model string) (providers.LLMProvider, string) {
+	if r, ok := al.provider.(providers.ModelResolver); ok {
+		return r.ResolveModel(model)
+	}
+	return al.provider, model
+}
+
+// listModels returns p's models, or nil if it cannot list them.
+func listModels(ctx context.Context, p providers.LLMProvider) ([]providers.ModelInfo, error) {
+	lister, ok := p.(providers.ModelLister)
+	if !ok {
+		return nil, nil
+	}
+	return lister.ListModels(ctx)
+}
+
+// formatModelList lists models for /model, marking the current one.
+func formatModelList(models []providers.ModelInfo, current string) string {
+	var sb strings.Builder
+	sb.WriteString("Available models:")
+	for _, m := range models {
+		marker := ""
+		if m.Name == current {
+			marker = " ✓"
+		}
+		if m.Description != "" {
+			fmt.Fprintf(&sb, "\n- `%s` (%s)%s", m.Name, m.Description, marker)
+		} else {
+			fmt.Fprintf(&sb, "\n- `%s`%s", m.Name, marker)
+		}
+	}
+	return sb.String()
+}
+
+// hasModel reports whether models includes name. Names without a tag match
+// the "latest" tag, as Ollama resolves them.
+func hasModel(models []providers.ModelInfo, name string) bool {
+	if !strings.Contains(name, ":") {
+		name += ":latest"
+	}
+	for _, m := range models {
+		if m.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pullModel downloads model, known to puller as id, in the background,
+// posting its progress to the chat msg came from, and switches to it once
+// it is ready.
+func (al *AgentLoop) pullModel(puller providers.ModelPuller, model, id string, msg bus.InboundMessage) {
+	reply := func(content string) {
+		al.bus.PublishOutbound(bus.OutboundMessage{
+			Channel:  msg.Channel,
+			ChatID:   msg.ChatID,
+			Content:  content,
+			Metadata: msg.Metadata,
+		})
+	}
+
+	go func() {
+		lastStatus := ""
+		err := puller.Pull(context.Background(), id, func(p providers.PullProgress) {
+			// Report each stage once, not every progress tick
+			if p.Status == lastStatus || p.Status == "success" {
+				return
+			}
+			lastStatus = p.Status
+			reply(fmt.Sprintf("⏬ `%s`: %s", model, p.Status))
+		})
+		if err != nil {
+			logger.ErrorCF("agent", "Model pull failed",
+				map[string]interface{}{
+					"model": model,
+					"error": err.Error(),
+				})
+			reply(fmt.Sprintf("Failed to pull `%s`: %v", model, err))
+			return
+		}
+
+		oldModel := al.GetModel()
+		al.SetModel(model)
+		al.refreshContextWindow(context.Background(), model)
+		logger.InfoCF("agent", fmt.Sprintf("Model switched: %s -> %s", oldModel, model), nil)
+		reply(fmt.Sprintf("Pulled `%s`. Model switched: `%s` -> `%s`", model, oldModel, model))
+	}()
+}
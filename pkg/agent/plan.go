@@ -0,0 +1,30 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+// planModePrompt is added to the system prompt of turns in plan mode.
+const planModePrompt = `## Plan Mode
+
+Plan mode is on: only read-only tools are available and nothing may be changed. Investigate as much as you need, then reply with a numbered plan of the steps you would take, naming the tools you would use for each. Do not claim to have done any of them. The user will review the plan and run /approve to have you carry it out.`
+
+// approvedPlanPrompt builds the user message that carries out an approved
+// plan with the full toolset.
+func approvedPlanPrompt(plan, extra string) string {
+	prompt := approvedPlanIntro + plan
+	if extra != "" {
+		prompt += extraInstructionsHeader + extra
+	}
+	return prompt
+}
+
+const approvedPlanIntro = "I approve the plan you proposed. Carry it out now; all tools are available again.\n\nPlan:\n"
+
+// approvedPlan returns the plan of a message built by approvedPlanPrompt, or
+// "" for any other message.
+func approvedPlan(prompt string) string {
+	return promptSubject(prompt, approvedPlanIntro)
+}
@@ -0,0 +1,250 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/metrics"
+	"github.com/sipeed/picoclaw/pkg/providers"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// quotaEnforcer applies the configured usage limits to inbound messages.
+// Message windows are counted here; token and cost limits use the tracker's
+// running totals of the sender's LLM calls.
+type quotaEnforcer struct {
+	cfg     config.QuotasConfig
+	tracker *metrics.Tracker
+	now     func() time.Time
+
+	mu       sync.Mutex
+	windows  map[string][]time.Time // sender key -> recent message times
+	notified map[string]string      // sender and limit -> period owners were last told about
+}
+
+// quotaExceeded describes a limit a sender has hit.
+type quotaExceeded struct {
+	Limit   string // "messages", "tokens_per_day", "usd_per_day" or "usd_per_month"
+	Period  string // the period the limit applies to, e.g. "2026-10-16"
+	Message string // reply for the sender
+}
+
+func newQuotaEnforcer(cfg config.QuotasConfig, tracker *metrics.Tracker) *quotaEnforcer {
+	return &quotaEnforcer{
+		cfg:      cfg,
+		tracker:  tracker,
+		now:      time.Now,
+		windows:  make(map[string][]time.Time),
+		notified: make(map[string]string),
+	}
+}
+
+// limitsFor returns the limits that apply to the sender of msg.
+func (q *quotaEnforcer) limitsFor(msg bus.InboundMessage, role commands.Role) config.QuotaLimits {
+	if limits, ok := lookupSender(q.cfg.Users, msg.SenderID); ok {
+		return limits
+	}
+	if limits, ok := q.cfg.Roles[role.String()]; ok {
+		return limits
+	}
+	if limits, ok := q.cfg.Channels[msg.Channel]; ok {
+		return limits
+	}
+	return q.cfg.Default
+}
+
+// lookupSender finds senderID in a map keyed by sender ID or username, as
+// commands.RoleFor matches admins.
+func lookupSender(entries map[string]config.QuotaLimits, senderID string) (config.QuotaLimits, bool) {
+	id, username, _ := strings.Cut(senderID, "|")
+	for _, key := range []string{senderID, id, username, "@" + username} {
+		if key == "" || key == "@" {
+			continue
+		}
+		if limits, ok := entries[key]; ok {
+			return limits, true
+		}
+	}
+	return config.QuotaLimits{}, false
+}
+
+// Check counts msg against the sender's limits and reports the first one
+// exceeded, or nil if the message may proceed. Usage limits are only
+// checked when checkUsage is set, so commands such as /cost still work for
+// a sender over budget.
+func (q *quotaEnforcer) Check(msg bus.InboundMessage, role commands.Role, checkUsage bool) *quotaExceeded {
+	limits := q.limitsFor(msg, role)
+	now := q.now()
+
+	if limits.Messages > 0 {
+		if exceeded := q.checkWindow(msg, limits, now); exceeded != nil {
+			return exceeded
+		}
+	}
+
+	if !checkUsage || q.tracker == nil || (limits.TokensPerDay <= 0 && limits.USDPerDay <= 0 && limits.USDPerMonth <= 0) {
+		return nil
+	}
+
+	usage := q.tracker.SenderUsage(msg.Channel, msg.SenderID, now)
+	switch {
+	case limits.TokensPerDay > 0 && usage.DayTokens >= limits.TokensPerDay:
+		return &quotaExceeded{
+			Limit:  "tokens_per_day",
+			Period: usage.Day,
+			Message: fmt.Sprintf("You've used your daily allowance of %d tokens. It resets at midnight.",
+				limits.TokensPerDay),
+		}
+	case limits.USDPerDay > 0 && usage.DayCost >= limits.USDPerDay:
+		return &quotaExceeded{
+			Limit:  "usd_per_day",
+			Period: usage.Day,
+			Message: fmt.Sprintf("You've used your daily budget of $%.2f. It resets at midnight.",
+				limits.USDPerDay),
+		}
+	case limits.USDPerMonth > 0 && usage.MonthCost >= limits.USDPerMonth:
+		return &quotaExceeded{
+			Limit:  "usd_per_month",
+			Period: usage.Month,
+			Message: fmt.Sprintf("You've used your monthly budget of $%.2f. It resets on the 1st.",
+				limits.USDPerMonth),
+		}
+	}
+	return nil
+}
+
+// checkWindow enforces the sliding message window, recording msg if it is
+// allowed.
+func (q *quotaEnforcer) checkWindow(msg bus.InboundMessage, limits config.QuotaLimits, now time.Time) *quotaExceeded {
+	window := time.Duration(limits.WindowSeconds) * time.Second
+	if window <= 0 {
+		window = time.Minute
+	}
+	senderKey := fmt.Sprintf("%s:%s", msg.Channel, msg.SenderID)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	times := q.windows[senderKey]
+	valid := times[:0]
+	for _, t := range times {
+		if now.Sub(t) < window {
+			valid = append(valid, t)
+		}
+	}
+	if len(valid) >= limits.Messages {
+		q.windows[senderKey] = valid
+		wait := window - now.Sub(valid[0])
+		return &quotaExceeded{
+			Limit:  "messages",
+			Period: valid[0].Format(time.RFC3339),
+			Message: fmt.Sprintf("Slow down — max %d messages per %s. Try again in %s.",
+				limits.Messages, window, wait.Round(time.Second)),
+		}
+	}
+	q.windows[senderKey] = append(valid, now)
+	return nil
+}
+
+// firstNotice reports whether owners have not yet been told about this
+// sender hitting this limit in this period, and marks them told. Only the
+// latest period is kept per sender and limit.
+func (q *quotaEnforcer) firstNotice(msg bus.InboundMessage, exceeded *quotaExceeded) bool {
+	key := strings.Join([]string{msg.Channel, msg.SenderID, exceeded.Limit}, "|")
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.notified[key] == exceeded.Period {
+		return false
+	}
+	q.notified[key] = exceeded.Period
+	return true
+}
+
+// checkQuota applies the sender's quotas to msg. When a limit is hit it
+// replies to the sender, notifies the owners once per limit and period, and
+// returns false.
+func (al *AgentLoop) checkQuota(msg bus.InboundMessage, isCommand bool) bool {
+	exceeded := al.quotas.Check(msg, al.senderRole(msg), !isCommand)
+	if exceeded == nil {
+		return true
+	}
+
+	logger.WarnCF("agent", "Quota exceeded",
+		map[string]interface{}{
+			"channel":   msg.Channel,
+			"sender_id": msg.SenderID,
+			"limit":     exceeded.Limit,
+		})
+	al.bus.PublishOutbound(bus.OutboundMessage{
+		Channel:  msg.Channel,
+		ChatID:   msg.ChatID,
+		Content:  exceeded.Message,
+		Metadata: msg.Metadata,
+	})
+
+	if !al.quotas.firstNotice(msg, exceeded) {
+		return false
+	}
+	notice := fmt.Sprintf("Quota alert: %s on %s hit the %s limit.", msg.SenderID, msg.Channel, exceeded.Limit)
+	for _, target := range al.quotas.cfg.Notify {
+		channel, chatID, ok := strings.Cut(target, ":")
+		if !ok || channel == "" || chatID == "" {
+			logger.WarnCF("agent", "Invalid quota notify target, expected channel:chat_id",
+				map[string]interface{}{
+					"target": target,
+				})
+			continue
+		}
+		al.bus.PublishOutbound(bus.OutboundMessage{
+			Channel: channel,
+			ChatID:  chatID,
+			Content: notice,
+		})
+	}
+	return false
+}
+
+// delegatedProvider records the usage of LLM calls made inside tools, such
+// as subagents and specialists, against the sender of the turn that made
+// them, so delegated work counts towards the sender's quotas.
+type delegatedProvider struct {
+	providers.LLMProvider
+	tracker *metrics.Tracker
+}
+
+func (p *delegatedProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	response, err := p.LLMProvider.Chat(ctx, messages, tools, model, options)
+	if err == nil && response.Usage != nil {
+		p.record(ctx, model, response)
+	}
+	return response, err
+}
+
+func (p *delegatedProvider) record(ctx context.Context, model string, response *providers.LLMResponse) {
+	event := metrics.TokenEvent{
+		Model:        answeredBy(model, response),
+		InputTokens:  response.Usage.PromptTokens,
+		OutputTokens: response.Usage.CompletionTokens,
+		CacheRead:    response.Usage.CacheReadInputTokens,
+		CacheCreate:  response.Usage.CacheCreationInputTokens,
+	}
+	if ec := tools.ExecutionContextFrom(ctx); ec != nil {
+		event.Channel = ec.Channel
+		event.SenderID = ec.SenderID
+	}
+	go p.tracker.Record(event)
+}
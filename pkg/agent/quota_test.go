@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/metrics"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+func TestQuotaEnforcer_LimitsFor(t *testing.T) {
+	q := newQuotaEnforcer(config.QuotasConfig{
+		Default:  config.QuotaLimits{Messages: 20},
+		Channels: map[string]config.QuotaLimits{"discord": {Messages: 10}},
+		Roles:    map[string]config.QuotaLimits{"admin": {}},
+		Users:    map[string]config.QuotaLimits{"@alice": {Messages: 5}, "42": {Messages: 7}},
+	}, nil)
+
+	tests := []struct {
+		name     string
+		channel  string
+		senderID string
+		role     commands.Role
+		want     int
+	}{
+		{"default", "telegram", "1|bob", commands.RoleUser, 20},
+		{"channel", "discord", "1|bob", commands.RoleUser, 10},
+		{"admin role is unlimited", "discord", "1|bob", commands.RoleAdmin, 0},
+		{"user by username", "discord", "1|alice", commands.RoleAdmin, 5},
+		{"user by id", "telegram", "42|carol", commands.RoleUser, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := bus.InboundMessage{Channel: tt.channel, SenderID: tt.senderID}
+			if got := q.limitsFor(msg, tt.role).Messages; got != tt.want {
+				t.Errorf("Messages = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaEnforcer_MessageWindow(t *testing.T) {
+	q := newQuotaEnforcer(config.QuotasConfig{
+		Default: config.QuotaLimits{Messages: 2, WindowSeconds: 60},
+	}, nil)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	q.now = func() time.Time { return now }
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "1"}
+
+	for i := 0; i < 2; i++ {
+		if exceeded := q.Check(msg, commands.RoleUser, true); exceeded != nil {
+			t.Fatalf("message %d: unexpected %q", i+1, exceeded.Message)
+		}
+	}
+	exceeded := q.Check(msg, commands.RoleUser, true)
+	if exceeded == nil || exceeded.Limit != "messages" {
+		t.Fatalf("third message: got %+v, want the messages limit", exceeded)
+	}
+	if !strings.Contains(exceeded.Message, "Try again in 1m0s") {
+		t.Errorf("Message = %q, want it to say when to retry", exceeded.Message)
+	}
+
+	other := bus.InboundMessage{Channel: "telegram", SenderID: "2"}
+	if exceeded := q.Check(other, commands.RoleUser, true); exceeded != nil {
+		t.Errorf("other sender: unexpected %q", exceeded.Message)
+	}
+
+	now = now.Add(time.Minute)
+	if exceeded := q.Check(msg, commands.RoleUser, true); exceeded != nil {
+		t.Errorf("after the window: unexpected %q", exceeded.Message)
+	}
+}
+
+func TestQuotaEnforcer_UsageLimits(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.Local)
+	tracker := metrics.NewTracker(t.TempDir())
+	// Each call costs tokens * $18/M at the default (Sonnet) pricing.
+	record := func(at time.Time, senderID string, tokens int) {
+		tracker.Record(metrics.TokenEvent{
+			Timestamp:    at.Format(time.RFC3339),
+			Channel:      "telegram",
+			SenderID:     senderID,
+			Model:        "claude-sonnet-4-20250514",
+			InputTokens:  tokens,
+			OutputTokens: tokens,
+		})
+	}
+	record(now.Add(-time.Hour), "1", 10000)    // today: 20k tokens, $0.18
+	record(now.AddDate(0, 0, -3), "1", 50000)  // this month: $1.08 in all
+	record(now.AddDate(0, -1, 0), "1", 100000) // last month
+	record(now.Add(-time.Hour), "2", 100000)   // another sender
+
+	tests := []struct {
+		name   string
+		limits config.QuotaLimits
+		want   string
+	}{
+		{"under limits", config.QuotaLimits{TokensPerDay: 30000, USDPerDay: 0.5, USDPerMonth: 2}, ""},
+		{"daily tokens", config.QuotaLimits{TokensPerDay: 20000}, "tokens_per_day"},
+		{"daily budget", config.QuotaLimits{USDPerDay: 0.15}, "usd_per_day"},
+		{"monthly budget", config.QuotaLimits{USDPerMonth: 1}, "usd_per_month"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQuotaEnforcer(config.QuotasConfig{Default: tt.limits}, tracker)
+			q.now = func() time.Time { return now }
+			msg := bus.InboundMessage{Channel: "telegram", SenderID: "1"}
+
+			exceeded := q.Check(msg, commands.RoleUser, true)
+			got := ""
+			if exceeded != nil {
+				got = exceeded.Limit
+			}
+			if got != tt.want {
+				t.Errorf("limit = %q, want %q", got, tt.want)
+			}
+			if exceeded := q.Check(msg, commands.RoleUser, false); exceeded != nil {
+				t.Errorf("commands should skip usage limits, got %q", exceeded.Limit)
+			}
+		})
+	}
+}
+
+func TestQuotaEnforcer_NotifiesOncePerPeriod(t *testing.T) {
+	q := newQuotaEnforcer(config.QuotasConfig{}, nil)
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "1"}
+
+	notices := []struct {
+		period string
+		want   bool
+	}{
+		{"2026-03-15", true},
+		{"2026-03-15", false},
+		{"2026-03-16", true},
+	}
+	for _, n := range notices {
+		if got := q.firstNotice(msg, &quotaExceeded{Limit: "usd_per_day", Period: n.period}); got != n.want {
+			t.Errorf("firstNotice(%s) = %v, want %v", n.period, got, n.want)
+		}
+	}
+	if len(q.notified) != 1 {
+		t.Errorf("Expected only the latest period to be kept, got %v", q.notified)
+	}
+}
+
+func TestDelegatedProvider_RecordsSender(t *testing.T) {
+	tracker := metrics.NewTracker(t.TempDir())
+	provider := &delegatedProvider{LLMProvider: &fallbackAnswerProvider{}, tracker: tracker}
+
+	ec := tools.NewExecutionContext("telegram", "chat1", nil)
+	ec.SenderID = "1"
+	if _, err := provider.Chat(tools.WithExecutionContext(t.Context(), ec), nil, nil, "main-model", nil); err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+
+	// Token usage is recorded asynchronously
+	deadline := time.Now().Add(responseTimeout)
+	for tracker.SenderUsage("telegram", "1", time.Now()).DayTokens != 12 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the call to count towards the sender, got %+v", tracker.SenderUsage("telegram", "1", time.Now()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,76 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/session"
+)
+
+// SessionRetentionEnabled reports whether RunSessionJanitor has anything to do.
+func (al *AgentLoop) SessionRetentionEnabled() bool {
+	return al.cfg.Sessions.Retention.Enabled()
+}
+
+// SessionsDir returns the directory the agent's sessions are kept in. Agents
+// sharing a workspace share it, and need only one janitor.
+func (al *AgentLoop) SessionsDir() string {
+	return filepath.Join(al.workspace, "sessions")
+}
+
+// RunSessionJanitor archives sessions per the retention config until ctx is
+// done. Session summaries are indexed into the vector store, when there is
+// one, before they are archived under workspace/sessions/archive; turns were
+// indexed as they happened. Attachments that no session refers to any more
+// are then removed.
+func (al *AgentLoop) RunSessionJanitor(ctx context.Context) {
+	cfg := al.cfg.Sessions.Retention
+	if !cfg.Enabled() {
+		return
+	}
+
+	policy := session.RetentionPolicy{
+		IdleTTL:     time.Duration(cfg.IdleDays) * 24 * time.Hour,
+		MaxMessages: cfg.MaxMessages,
+	}
+	if len(cfg.ChannelIdleDays) > 0 {
+		policy.ChannelTTL = make(map[string]time.Duration, len(cfg.ChannelIdleDays))
+		for channel, days := range cfg.ChannelIdleDays {
+			policy.ChannelTTL[channel] = time.Duration(days) * 24 * time.Hour
+		}
+	}
+
+	var index session.ArchiveIndexer
+	if al.vectorStore != nil {
+		index = func(ctx context.Context, archiveID string, s *session.Session) error {
+			if s.Summary == "" {
+				return nil
+			}
+			return al.vectorStore.IndexSummary(ctx, archiveID, s.Key, s.Summary, s.Updated)
+		}
+	}
+
+	interval := time.Duration(cfg.IntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	archiveDir := filepath.Join(al.SessionsDir(), "archive")
+	logger.InfoCF("agent", "Session janitor started",
+		map[string]interface{}{
+			"idle_days":    cfg.IdleDays,
+			"max_messages": cfg.MaxMessages,
+			"archive_dir":  archiveDir,
+		})
+	janitor := session.NewJanitor(al.sessions, policy, archiveDir, index)
+	janitor.SetMediaDir(filepath.Join(al.SessionsDir(), session.MediaDirname))
+	janitor.Run(ctx, interval)
+}
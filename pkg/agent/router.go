@@ -0,0 +1,150 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// Inspired by and based on nanobot: https://github.com/HKUDS/nanobot
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// ProviderFactory creates the LLM provider an agent runs with.
+type ProviderFactory func(cfg *config.Config) (providers.LLMProvider, error)
+
+// Router runs several named agents on one message bus. Each inbound message
+// goes to the agent selected by the agents.routes rules, and messages no rule
+// matches go to the default agent.
+type Router struct {
+	cfg     *config.Config
+	bus     *bus.MessageBus
+	agents  map[string]*AgentLoop
+	running atomic.Bool
+}
+
+// NewRouter creates an AgentLoop for the default agent and for every named
+// agent in cfg, each with its own workspace, model, tools and SOUL.
+func NewRouter(cfg *config.Config, msgBus *bus.MessageBus, newProvider ProviderFactory) (*Router, error) {
+	if err := cfg.Agents.ValidateAgents(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Agents.Generation.Validate(); err != nil {
+		return nil, err
+	}
+
+	r := &Router{
+		cfg:    cfg,
+		bus:    msgBus,
+		agents: make(map[string]*AgentLoop),
+	}
+	// Agents sharing a workspace share its sessions, so they do not
+	// overwrite each other's session files, and its usage, so quotas
+	// count every agent's turns
+	workspaces := make(map[string]*workspaceState)
+	for _, name := range cfg.Agents.AgentNames() {
+		agentCfg, err := cfg.ForAgent(name)
+		if err != nil {
+			return nil, err
+		}
+		provider, err := newProvider(agentCfg)
+		if err != nil {
+			return nil, fmt.Errorf("agent %s: %w", name, err)
+		}
+		workspace := agentCfg.WorkspacePath()
+		if workspaces[workspace] == nil {
+			workspaces[workspace] = newWorkspaceState(agentCfg)
+		}
+		r.agents[name] = newAgentLoop(agentCfg, msgBus, provider, workspaces[workspace])
+
+		logger.InfoCF("agent", "Agent created",
+			map[string]interface{}{
+				"agent":     name,
+				"model":     agentCfg.Agents.Defaults.Model,
+				"workspace": agentCfg.WorkspacePath(),
+			})
+	}
+	return r, nil
+}
+
+// Default returns the default agent.
+func (r *Router) Default() *AgentLoop {
+	return r.agents[config.DefaultAgentName]
+}
+
+// Agent returns the named agent.
+func (r *Router) Agent(name string) (*AgentLoop, bool) {
+	al, ok := r.agents[name]
+	return al, ok
+}
+
+// Names returns the agent names, the default agent first.
+func (r *Router) Names() []string {
+	names := make([]string, 0, len(r.agents))
+	for name := range r.agents {
+		if name != config.DefaultAgentName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{config.DefaultAgentName}, names...)
+}
+
+// ForEach calls fn for every agent, the default agent first.
+func (r *Router) ForEach(fn func(name string, al *AgentLoop)) {
+	for _, name := range r.Names() {
+		fn(name, r.agents[name])
+	}
+}
+
+// Route returns the name of the agent that handles msg. System messages are
+// routed by the conversation they report back to ("channel:chat_id").
+func (r *Router) Route(msg bus.InboundMessage) string {
+	channel, chatID := msg.Channel, msg.ChatID
+	if channel == "system" {
+		if origin, originChat, ok := strings.Cut(msg.ChatID, ":"); ok {
+			channel, chatID = origin, originChat
+		}
+	}
+	return r.cfg.Agents.Route(channel, chatID, msg.Metadata["thread_id"])
+}
+
+// Run consumes inbound messages and hands each to its agent until ctx is
+// cancelled or Stop is called.
+func (r *Router) Run(ctx context.Context) error {
+	r.running.Store(true)
+	for _, al := range r.agents {
+		al.running.Store(true)
+	}
+
+	for r.running.Load() {
+		msg, ok := r.bus.ConsumeInbound(ctx)
+		if !ok {
+			return nil
+		}
+
+		al, ok := r.agents[r.Route(msg)]
+		if !ok {
+			al = r.Default()
+		}
+		al.handleInbound(ctx, msg)
+	}
+	return nil
+}
+
+// Stop stops the router and every agent.
+func (r *Router) Stop() {
+	r.running.Store(false)
+	for _, al := range r.agents {
+		al.Stop()
+	}
+}
@@ -17,6 +17,16 @@ type OutboundMessage struct {
 	ChatID   string            `json:"chat_id"`
 	Content  string            `json:"content"`
 	Metadata map[string]string `json:"metadata,omitempty"`
+	Buttons  []Button          `json:"buttons,omitempty"`
+}
+
+// Button is an inline reply button. Channels that support buttons show them
+// under the message; pressing one arrives as an InboundMessage whose Content
+// is Data. Other channels send the message text alone, so it should also
+// explain how to reply in words.
+type Button struct {
+	Text string `json:"text"`
+	Data string `json:"data"`
 }
 
 type MessageHandler func(InboundMessage) error
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
 	"github.com/sipeed/picoclaw/pkg/media"
 )
 
@@ -23,6 +24,13 @@ type StreamingChannel interface {
 	StreamUpdate(ctx context.Context, chatID string, partialContent string)
 }
 
+// CommandMenuChannel is a channel that can show the agent's slash commands
+// in its native command menu.
+type CommandMenuChannel interface {
+	Channel
+	PublishCommands(ctx context.Context, cmds []commands.Command) error
+}
+
 type BaseChannel struct {
 	config    interface{}
 	bus       *bus.MessageBus
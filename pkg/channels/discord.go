@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/media"
@@ -61,6 +63,7 @@ func (c *DiscordChannel) Start(ctx context.Context) error {
 
 	c.ctx = ctx
 	c.session.AddHandler(c.handleMessage)
+	c.session.AddHandler(c.handleInteraction)
 
 	if err := c.session.Open(); err != nil {
 		return fmt.Errorf("failed to open discord session: %w", err)
@@ -124,6 +127,99 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 	}
 }
 
+// PublishCommands registers cmds as global application (slash) commands,
+// each with an optional free-text "args" option.
+func (c *DiscordChannel) PublishCommands(ctx context.Context, cmds []commands.Command) error {
+	if c.session.State == nil || c.session.State.User == nil {
+		return fmt.Errorf("discord session not ready")
+	}
+
+	appCommands := make([]*discordgo.ApplicationCommand, 0, len(cmds))
+	for _, cmd := range cmds {
+		appCmd := &discordgo.ApplicationCommand{
+			Name:        cmd.Name,
+			Description: utils.Truncate(cmd.Description, 100),
+		}
+		if cmd.Args != "" {
+			appCmd.Options = []*discordgo.ApplicationCommandOption{{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "args",
+				Description: utils.Truncate(cmd.Args, 100),
+			}}
+		}
+		appCommands = append(appCommands, appCmd)
+	}
+
+	if _, err := c.session.ApplicationCommandBulkOverwrite(c.session.State.User.ID, "", appCommands, discordgo.WithContext(ctx)); err != nil {
+		return fmt.Errorf("failed to register discord commands: %w", err)
+	}
+	return nil
+}
+
+// handleInteraction turns a slash command interaction into an inbound
+// "/name args" message. The interaction is acknowledged right away; the
+// agent's reply arrives as a normal channel message.
+func (c *DiscordChannel) handleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i == nil || i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	user := i.User
+	if i.Member != nil && i.Member.User != nil {
+		user = i.Member.User
+	}
+	if user == nil {
+		return
+	}
+
+	if !c.IsAllowed(user.ID) {
+		_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "Not allowed",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
+	}
+
+	data := i.ApplicationCommandData()
+	content := "/" + data.Name
+	for _, opt := range data.Options {
+		if opt.Name == "args" {
+			if args := strings.TrimSpace(opt.StringValue()); args != "" {
+				content += " " + args
+			}
+		}
+	}
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Content: content},
+	}); err != nil {
+		logger.ErrorCF("discord", "Failed to acknowledge interaction", map[string]any{
+			"error": err.Error(),
+		})
+	}
+
+	senderName := user.Username
+	if user.Discriminator != "" && user.Discriminator != "0" {
+		senderName += "#" + user.Discriminator
+	}
+
+	metadata := map[string]string{
+		"user_id":        user.ID,
+		"username":       user.Username,
+		"display_name":   senderName,
+		"guild_id":       i.GuildID,
+		"channel_id":     i.ChannelID,
+		"is_dm":          fmt.Sprintf("%t", i.GuildID == ""),
+		"is_interaction": "true",
+	}
+
+	c.HandleMessage(user.ID, i.ChannelID, content, nil, metadata)
+}
+
 // appendContent 安全地追加内容到现有文本
 func appendContent(content, suffix string) string {
 	if content == "" {
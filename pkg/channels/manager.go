@@ -12,6 +12,7 @@ import (
 	"sync"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/constants"
 	"github.com/sipeed/picoclaw/pkg/logger"
@@ -215,6 +216,26 @@ func (m *Manager) StartAll(ctx context.Context) error {
 	return nil
 }
 
+// PublishCommands publishes cmds to the command menus of the running
+// channels that support one.
+func (m *Manager) PublishCommands(ctx context.Context, cmds []commands.Command) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for name, channel := range m.channels {
+		menu, ok := channel.(CommandMenuChannel)
+		if !ok || !channel.IsRunning() {
+			continue
+		}
+		if err := menu.PublishCommands(ctx, cmds); err != nil {
+			logger.ErrorCF("channels", "Failed to publish commands", map[string]interface{}{
+				"channel": name,
+				"error":   err.Error(),
+			})
+		}
+	}
+}
+
 func (m *Manager) StopAll(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
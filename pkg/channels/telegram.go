@@ -16,6 +16,7 @@ import (
 	tu "github.com/mymmrac/telego/telegoutil"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/media"
@@ -112,6 +113,8 @@ func (c *TelegramChannel) Start(ctx context.Context) error {
 				}
 				if update.Message != nil {
 					c.handleMessage(ctx, update)
+				} else if update.CallbackQuery != nil {
+					c.handleCallbackQuery(ctx, update.CallbackQuery)
 				}
 			}
 		}
@@ -144,6 +147,12 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 		}
 	}
 
+	// Messages with buttons (e.g. approval requests) are sent on their own
+	// and leave the "Thinking..." placeholder for the final reply
+	if len(msg.Buttons) > 0 {
+		return c.sendWithButtons(ctx, chatID, threadID, msg)
+	}
+
 	// Composite key for placeholder/thinking lookup
 	key := compositeKey(chatID, threadID)
 
@@ -187,6 +196,108 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 	return nil
 }
 
+// sendWithButtons sends msg with its buttons as an inline keyboard.
+func (c *TelegramChannel) sendWithButtons(ctx context.Context, chatID int64, threadID int, msg bus.OutboundMessage) error {
+	buttons := make([]telego.InlineKeyboardButton, 0, len(msg.Buttons))
+	for _, b := range msg.Buttons {
+		buttons = append(buttons, tu.InlineKeyboardButton(b.Text).WithCallbackData(b.Data))
+	}
+
+	tgMsg := tu.Message(tu.ID(chatID), markdownToTelegramHTML(msg.Content))
+	tgMsg.ParseMode = telego.ModeHTML
+	tgMsg.ReplyMarkup = tu.InlineKeyboard(tu.InlineKeyboardRow(buttons...))
+	if threadID != 0 {
+		tgMsg.MessageThreadID = threadID
+	}
+
+	if _, err := c.bot.SendMessage(ctx, tgMsg); err != nil {
+		logger.ErrorCF("telegram", "HTML parse failed, falling back to plain text", map[string]interface{}{
+			"error": err.Error(),
+		})
+		tgMsg.Text = msg.Content
+		tgMsg.ParseMode = ""
+		_, err = c.bot.SendMessage(ctx, tgMsg)
+		return err
+	}
+	return nil
+}
+
+// stopKeyboard is the Stop button shown under the "Thinking..." placeholder
+// while a turn runs. Pressing it sends /stop; the final reply replaces the
+// placeholder and drops the button.
+func stopKeyboard() *telego.InlineKeyboardMarkup {
+	return tu.InlineKeyboard(tu.InlineKeyboardRow(
+		tu.InlineKeyboardButton("⏹ Stop").WithCallbackData("/stop"),
+	))
+}
+
+// handleCallbackQuery turns an inline button press into an inbound message
+// whose content is the button's data, and removes the keyboard so the
+// button cannot be pressed twice.
+func (c *TelegramChannel) handleCallbackQuery(ctx context.Context, query *telego.CallbackQuery) {
+	user := query.From
+	userID := fmt.Sprintf("%d", user.ID)
+	senderID := userID
+	if user.Username != "" {
+		senderID = fmt.Sprintf("%s|%s", userID, user.Username)
+	}
+
+	if !c.IsAllowed(userID) && !c.IsAllowed(senderID) {
+		_ = c.bot.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText("Not allowed"))
+		return
+	}
+
+	_ = c.bot.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID))
+
+	if query.Message == nil || query.Data == "" {
+		return
+	}
+
+	chat := query.Message.GetChat()
+	_, _ = c.bot.EditMessageReplyMarkup(ctx, &telego.EditMessageReplyMarkupParams{
+		ChatID:    tu.ID(chat.ID),
+		MessageID: query.Message.GetMessageID(),
+	})
+
+	metadata := map[string]string{
+		"user_id":     userID,
+		"username":    user.Username,
+		"first_name":  user.FirstName,
+		"is_group":    fmt.Sprintf("%t", chat.Type != "private"),
+		"is_callback": "true",
+	}
+	if m := query.Message.Message(); m != nil && m.MessageThreadID != 0 {
+		metadata["thread_id"] = fmt.Sprintf("%d", m.MessageThreadID)
+		metadata["is_forum_topic"] = "true"
+	}
+
+	c.HandleMessage(senderID, fmt.Sprintf("%d", chat.ID), query.Data, nil, metadata)
+}
+
+// PublishCommands sets the bot's command menu. Telegram allows at most 100
+// commands, so any beyond that are left out of the menu.
+func (c *TelegramChannel) PublishCommands(ctx context.Context, cmds []commands.Command) error {
+	botCommands := make([]telego.BotCommand, 0, len(cmds))
+	for _, cmd := range cmds {
+		if len(botCommands) == 100 {
+			break
+		}
+		description := cmd.Description
+		if cmd.Args != "" {
+			description += " " + cmd.Args
+		}
+		botCommands = append(botCommands, telego.BotCommand{
+			Command:     cmd.Name,
+			Description: utils.Truncate(description, 256),
+		})
+	}
+
+	if err := c.bot.SetMyCommands(ctx, &telego.SetMyCommandsParams{Commands: botCommands}); err != nil {
+		return fmt.Errorf("failed to set bot commands: %w", err)
+	}
+	return nil
+}
+
 func (c *TelegramChannel) StreamUpdate(ctx context.Context, chatID string, partialContent string) {
 	// Try composite keys with all stored placeholders matching this chatID prefix
 	numChatID, err := parseChatID(chatID)
@@ -216,6 +327,7 @@ func (c *TelegramChannel) StreamUpdate(ctx context.Context, chatID string, parti
 	htmlContent := markdownToTelegramHTML(partialContent + " ...")
 	editMsg := tu.EditMessageText(tu.ID(numChatID), pID.(int), htmlContent)
 	editMsg.ParseMode = telego.ModeHTML
+	editMsg.ReplyMarkup = stopKeyboard()
 
 	// Silently ignore edit failures (content unchanged, partial HTML, etc.)
 	_, _ = c.bot.EditMessageText(ctx, editMsg)
@@ -409,6 +521,7 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, update telego.Updat
 
 	// Send "Thinking..." to the correct topic
 	thinkMsg := tu.Message(tu.ID(chatID), "Thinking... 💭")
+	thinkMsg.ReplyMarkup = stopKeyboard()
 	if threadID != 0 {
 		thinkMsg.MessageThreadID = threadID
 	}
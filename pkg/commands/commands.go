@@ -0,0 +1,173 @@
+// Package commands provides the registry of slash commands users can send
+// in chat, such as /help or /model. Agents register and run the commands;
+// channels read the registry to publish command menus.
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+)
+
+// Role is the permission level a command requires.
+type Role int
+
+const (
+	RoleUser Role = iota
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	if r == RoleAdmin {
+		return "admin"
+	}
+	return "user"
+}
+
+// Request is a single invocation of a command.
+type Request struct {
+	Message bus.InboundMessage
+	Args    []string
+	Role    Role // role of the sender
+}
+
+// Handler runs a command and returns the reply for the user.
+type Handler func(ctx context.Context, req Request) (string, error)
+
+// Command describes a slash command.
+type Command struct {
+	Name        string // without the leading slash
+	Args        string // argument usage, e.g. "[model]"
+	Description string
+	Role        Role
+	// Immediate commands run as soon as they arrive, even while the session
+	// has a turn in progress, instead of waiting for their turn.
+	Immediate bool
+	Handler   Handler
+}
+
+// Usage returns the command's usage line, e.g. "/model [name]".
+func (c Command) Usage() string {
+	if c.Args == "" {
+		return "/" + c.Name
+	}
+	return "/" + c.Name + " " + c.Args
+}
+
+// Registry holds the available slash commands.
+type Registry struct {
+	mu       sync.RWMutex
+	commands map[string]Command
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		commands: make(map[string]Command),
+	}
+}
+
+// Register adds cmd, replacing any command with the same name.
+func (r *Registry) Register(cmd Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands[strings.ToLower(cmd.Name)] = cmd
+}
+
+// Get returns the named command.
+func (r *Registry) Get(name string) (Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cmd, ok := r.commands[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// List returns all commands sorted by name.
+func (r *Registry) List() []Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		list = append(list, cmd)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Match parses content as a slash command and looks it up. Messages that are
+// not commands, or name an unknown command, do not match.
+func (r *Registry) Match(content string) (Command, []string, bool) {
+	name, args, ok := Parse(content)
+	if !ok {
+		return Command{}, nil, false
+	}
+	cmd, ok := r.Get(name)
+	if !ok {
+		return Command{}, nil, false
+	}
+	return cmd, args, true
+}
+
+// Execute runs cmd if the sender's role allows it.
+func (r *Registry) Execute(ctx context.Context, cmd Command, req Request) (string, error) {
+	if req.Role < cmd.Role {
+		return fmt.Sprintf("/%s requires the %s role.", cmd.Name, cmd.Role), nil
+	}
+	return cmd.Handler(ctx, req)
+}
+
+// Help lists the commands available to role.
+func (r *Registry) Help(role Role) string {
+	var sb strings.Builder
+	sb.WriteString("Available commands:\n")
+	for _, cmd := range r.List() {
+		if role < cmd.Role {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s — %s\n", cmd.Usage(), cmd.Description)
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// Parse splits a message like "/model gpt-4o" into the command name and its
+// arguments. A "@botname" suffix on the name, as Telegram adds in groups, is
+// dropped.
+func Parse(content string) (string, []string, bool) {
+	fields := strings.Fields(content)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") || len(fields[0]) == 1 {
+		return "", nil, false
+	}
+	name := strings.TrimPrefix(fields[0], "/")
+	if idx := strings.Index(name, "@"); idx >= 0 {
+		name = name[:idx]
+	}
+	if name == "" {
+		return "", nil, false
+	}
+	return strings.ToLower(name), fields[1:], true
+}
+
+// RoleFor returns the role of senderID given the configured admins. Sender
+// IDs may be compound ("id|username") and admin entries may be an ID or a
+// username with or without a leading "@". With no admins configured,
+// everyone is an admin.
+func RoleFor(admins []string, senderID string) Role {
+	if len(admins) == 0 {
+		return RoleAdmin
+	}
+
+	id, username, _ := strings.Cut(senderID, "|")
+	for _, admin := range admins {
+		admin = strings.TrimPrefix(admin, "@")
+		if admin == senderID || admin == id || (username != "" && admin == username) {
+			return RoleAdmin
+		}
+	}
+	return RoleUser
+}
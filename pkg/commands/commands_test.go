@@ -0,0 +1,102 @@
+package commands
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		content string
+		name    string
+		args    []string
+		ok      bool
+	}{
+		{"/help", "help", []string{}, true},
+		{"/model gpt-4o", "model", []string{"gpt-4o"}, true},
+		{"/Model@picoclaw_bot  a b", "model", []string{"a", "b"}, true},
+		{"  /reset  ", "reset", []string{}, true},
+		{"hello /help", "", nil, false},
+		{"/", "", nil, false},
+		{"/@bot", "", nil, false},
+		{"", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		name, args, ok := Parse(tt.content)
+		if ok != tt.ok || name != tt.name || (ok && !reflect.DeepEqual(args, tt.args)) {
+			t.Errorf("Parse(%q) = %q, %v, %v; want %q, %v, %v", tt.content, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestRoleFor(t *testing.T) {
+	admins := []string{"123", "@alice"}
+	tests := []struct {
+		admins   []string
+		senderID string
+		want     Role
+	}{
+		{nil, "999", RoleAdmin},
+		{admins, "123", RoleAdmin},
+		{admins, "123|bob", RoleAdmin},
+		{admins, "456|alice", RoleAdmin},
+		{admins, "456|bob", RoleUser},
+		{admins, "456", RoleUser},
+	}
+
+	for _, tt := range tests {
+		if got := RoleFor(tt.admins, tt.senderID); got != tt.want {
+			t.Errorf("RoleFor(%v, %q) = %s, want %s", tt.admins, tt.senderID, got, tt.want)
+		}
+	}
+}
+
+func TestRegistry_ExecuteAndHelp(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Command{
+		Name:        "echo",
+		Args:        "<text>",
+		Description: "Repeat text",
+		Handler: func(ctx context.Context, req Request) (string, error) {
+			return strings.Join(req.Args, " "), nil
+		},
+	})
+	r.Register(Command{
+		Name:        "admin",
+		Description: "Admin only",
+		Role:        RoleAdmin,
+		Handler: func(ctx context.Context, req Request) (string, error) {
+			return "ok", nil
+		},
+	})
+
+	if _, _, ok := r.Match("/unknown"); ok {
+		t.Error("Expected unknown command not to match")
+	}
+
+	cmd, args, ok := r.Match("/echo hi there")
+	if !ok {
+		t.Fatal("Expected /echo to match")
+	}
+	if got, _ := r.Execute(context.Background(), cmd, Request{Args: args}); got != "hi there" {
+		t.Errorf("Expected %q, got %q", "hi there", got)
+	}
+
+	cmd, _, _ = r.Match("/admin")
+	if got, _ := r.Execute(context.Background(), cmd, Request{Role: RoleUser}); got != "/admin requires the admin role." {
+		t.Errorf("Expected role check, got %q", got)
+	}
+	if got, _ := r.Execute(context.Background(), cmd, Request{Role: RoleAdmin}); got != "ok" {
+		t.Errorf("Expected admin to run the command, got %q", got)
+	}
+
+	if help := r.Help(RoleUser); !strings.Contains(help, "/echo <text> — Repeat text") || strings.Contains(help, "/admin") {
+		t.Errorf("Unexpected user help: %q", help)
+	}
+	if help := r.Help(RoleAdmin); !strings.Contains(help, "/admin") {
+		t.Errorf("Expected admin help to list /admin, got %q", help)
+	}
+}
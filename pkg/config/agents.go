@@ -0,0 +1,122 @@
+package config
+
+import "fmt"
+
+// DefaultAgentName is the name of the agent built from AgentDefaults. It
+// handles every message no routing rule sends elsewhere.
+const DefaultAgentName = "default"
+
+// AgentConfig defines a named agent. Unset fields inherit from AgentDefaults.
+// Agents sharing a workspace share its sessions; its cron jobs and heartbeat
+// run with the first of them, the default agent first.
+type AgentConfig struct {
+	Workspace string   `json:"workspace,omitempty"`
+	Model     string   `json:"model,omitempty"`
+	Tools     []string `json:"tools,omitempty"` // tool allowlist; empty inherits the default
+	Soul      string   `json:"soul,omitempty"`  // SOUL.md to use instead of the workspace's
+}
+
+// AgentRoute sends messages to Agent. Empty fields match anything; when
+// several routes match, the most specific wins (thread over chat over
+// channel), and among equally specific routes the first listed.
+type AgentRoute struct {
+	Agent    string `json:"agent"`
+	Channel  string `json:"channel,omitempty"`
+	ChatID   string `json:"chat_id,omitempty"`
+	ThreadID string `json:"thread_id,omitempty"`
+}
+
+// specificity scores how narrowly r matches, or returns -1 if it does not
+// match the given conversation.
+func (r AgentRoute) specificity(channel, chatID, threadID string) int {
+	score := 0
+	for _, f := range []struct {
+		want, got string
+		weight    int
+	}{
+		{r.Channel, channel, 1},
+		{r.ChatID, chatID, 2},
+		{r.ThreadID, threadID, 4},
+	} {
+		if f.want == "" {
+			continue
+		}
+		if f.want != f.got {
+			return -1
+		}
+		score += f.weight
+	}
+	return score
+}
+
+// Route returns the name of the agent that handles messages from the given
+// channel, chat and forum thread.
+func (a AgentsConfig) Route(channel, chatID, threadID string) string {
+	best, bestScore := DefaultAgentName, -1
+	for _, r := range a.Routes {
+		if score := r.specificity(channel, chatID, threadID); score > bestScore {
+			best, bestScore = r.Agent, score
+		}
+	}
+	return best
+}
+
+// AgentNames returns the default agent's name followed by the named agents.
+func (a AgentsConfig) AgentNames() []string {
+	names := []string{DefaultAgentName}
+	for name := range a.Named {
+		names = append(names, name)
+	}
+	return names
+}
+
+// ValidateAgents checks that named agents and routes refer to valid agents.
+func (a AgentsConfig) ValidateAgents() error {
+	if _, ok := a.Named[DefaultAgentName]; ok {
+		return fmt.Errorf("agent name %q is reserved for agents.defaults", DefaultAgentName)
+	}
+	for i, r := range a.Routes {
+		if r.Agent == DefaultAgentName {
+			continue
+		}
+		if _, ok := a.Named[r.Agent]; !ok {
+			return fmt.Errorf("route %d refers to unknown agent %q", i, r.Agent)
+		}
+	}
+	return nil
+}
+
+// ForAgent returns the configuration the named agent runs with: a copy of c
+// whose defaults are overridden by the agent's own settings. The default
+// agent gets c itself.
+func (c *Config) ForAgent(name string) (*Config, error) {
+	if name == "" || name == DefaultAgentName {
+		return c, nil
+	}
+
+	c.mu.RLock()
+	agent, ok := c.Agents.Named[name]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown agent %q", name)
+	}
+
+	clone, err := c.Clone()
+	if err != nil {
+		return nil, err
+	}
+	defaults := &clone.Agents.Defaults
+	if agent.Workspace != "" {
+		defaults.Workspace = agent.Workspace
+	}
+	if agent.Model != "" {
+		defaults.Model = agent.Model
+	}
+	if len(agent.Tools) > 0 {
+		defaults.Tools = agent.Tools
+	}
+	if agent.Soul != "" {
+		defaults.Soul = agent.Soul
+	}
+	return clone, nil
+}
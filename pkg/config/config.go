@@ -51,24 +51,151 @@ type Config struct {
 	Tools     ToolsConfig     `json:"tools"`
 	Heartbeat HeartbeatConfig `json:"heartbeat"`
 	Devices   DevicesConfig   `json:"devices"`
+	Commands  CommandsConfig  `json:"commands"`
+	Quotas    QuotasConfig    `json:"quotas"`
+	Sessions  SessionsConfig  `json:"sessions"`
 	mu        sync.RWMutex
 }
 
+// CommandsConfig controls who may run admin slash commands. Admins lists
+// sender IDs or usernames; when it is empty, everyone is an admin.
+type CommandsConfig struct {
+	Admins FlexibleStringSlice `json:"admins,omitempty" env:"PICOCLAW_COMMANDS_ADMINS"`
+}
+
+// SessionsConfig selects where conversation sessions are stored and how
+// long they are kept.
+type SessionsConfig struct {
+	Store     string                 `json:"store" env:"PICOCLAW_SESSIONS_STORE"` // "json" (one file per session, default) or "sqlite"
+	Retention SessionRetentionConfig `json:"retention"`
+}
+
+// SessionRetentionConfig archives old sessions, and the oldest turns of long
+// ones, to workspace/sessions/archive after indexing their summaries into
+// the vector store. Zero values keep everything.
+type SessionRetentionConfig struct {
+	IdleDays        int            `json:"idle_days" env:"PICOCLAW_SESSIONS_RETENTION_IDLE_DAYS"`               // archive sessions idle this many days
+	ChannelIdleDays map[string]int `json:"channel_idle_days,omitempty"`                                         // per-channel overrides of idle_days; 0 keeps the channel's sessions
+	MaxMessages     int            `json:"max_messages" env:"PICOCLAW_SESSIONS_RETENTION_MAX_MESSAGES"`         // archive the oldest turns beyond this many messages
+	IntervalMinutes int            `json:"interval_minutes" env:"PICOCLAW_SESSIONS_RETENTION_INTERVAL_MINUTES"` // how often the gateway checks, default 60
+}
+
+// Enabled reports whether any retention limit is set.
+func (c SessionRetentionConfig) Enabled() bool {
+	if c.IdleDays > 0 || c.MaxMessages > 0 {
+		return true
+	}
+	for _, days := range c.ChannelIdleDays {
+		if days > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// QuotasConfig limits how much each sender may use the agent. The limits
+// for a sender are the first entry found in Users (by sender ID or
+// username), Roles ("user" or "admin"), Channels, and finally Default; an
+// entry replaces the less specific ones entirely, so an empty entry means no
+// limits. Notify lists "channel:chat_id" targets told when a limit is hit.
+type QuotasConfig struct {
+	Default  QuotaLimits            `json:"default"`
+	Channels map[string]QuotaLimits `json:"channels,omitempty"`
+	Roles    map[string]QuotaLimits `json:"roles,omitempty"`
+	Users    map[string]QuotaLimits `json:"users,omitempty"`
+	Notify   FlexibleStringSlice    `json:"notify,omitempty" env:"PICOCLAW_QUOTAS_NOTIFY"`
+}
+
+// QuotaLimits is one set of usage limits. Zero fields are not limited.
+// Token and cost limits count the sender's LLM usage recorded by the
+// metrics tracker, per calendar day or month in local time.
+type QuotaLimits struct {
+	Messages      int     `json:"messages,omitempty"`       // messages per window
+	WindowSeconds int     `json:"window_seconds,omitempty"` // defaults to 60
+	TokensPerDay  int     `json:"tokens_per_day,omitempty"`
+	USDPerDay     float64 `json:"usd_per_day,omitempty"`
+	USDPerMonth   float64 `json:"usd_per_month,omitempty"`
+}
+
 type AgentsConfig struct {
-	Defaults AgentDefaults `json:"defaults"`
+	Defaults   AgentDefaults          `json:"defaults"`
+	Generation GenerationConfig       `json:"generation"`
+	Named      map[string]AgentConfig `json:"named,omitempty"`
+	Routes     []AgentRoute           `json:"routes,omitempty"`
 }
 
 type AgentDefaults struct {
-	Workspace           string  `json:"workspace" env:"PICOCLAW_AGENTS_DEFAULTS_WORKSPACE"`
-	RestrictToWorkspace bool    `json:"restrict_to_workspace" env:"PICOCLAW_AGENTS_DEFAULTS_RESTRICT_TO_WORKSPACE"`
-	Provider            string  `json:"provider" env:"PICOCLAW_AGENTS_DEFAULTS_PROVIDER"`
-	Model               string  `json:"model" env:"PICOCLAW_AGENTS_DEFAULTS_MODEL"`
-	CheapModel          string  `json:"cheap_model" env:"PICOCLAW_AGENTS_DEFAULTS_CHEAP_MODEL"`
-	MaxTokens           int     `json:"max_tokens" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOKENS"`
-	Temperature         float64 `json:"temperature" env:"PICOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
-	MaxToolIterations   int     `json:"max_tool_iterations" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
-	FallbackProvider    string  `json:"fallback_provider,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_FALLBACK_PROVIDER"`
-	FallbackModel       string  `json:"fallback_model,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_FALLBACK_MODEL"`
+	Workspace           string   `json:"workspace" env:"PICOCLAW_AGENTS_DEFAULTS_WORKSPACE"`
+	RestrictToWorkspace bool     `json:"restrict_to_workspace" env:"PICOCLAW_AGENTS_DEFAULTS_RESTRICT_TO_WORKSPACE"`
+	Provider            string   `json:"provider" env:"PICOCLAW_AGENTS_DEFAULTS_PROVIDER"`
+	Model               string   `json:"model" env:"PICOCLAW_AGENTS_DEFAULTS_MODEL"`
+	CheapModel          string   `json:"cheap_model" env:"PICOCLAW_AGENTS_DEFAULTS_CHEAP_MODEL"`
+	MaxTokens           int      `json:"max_tokens" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOKENS"`
+	Temperature         float64  `json:"temperature" env:"PICOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
+	MaxToolIterations   int      `json:"max_tool_iterations" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
+	MaxConcurrentTurns  int      `json:"max_concurrent_turns" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_CONCURRENT_TURNS"`
+	MaxParallelTools    int      `json:"max_parallel_tools" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_PARALLEL_TOOLS"`
+	FallbackProvider    string   `json:"fallback_provider,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_FALLBACK_PROVIDER"`
+	FallbackModel       string   `json:"fallback_model,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_FALLBACK_MODEL"`
+	Tools               []string `json:"tools,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_TOOLS"` // tool allowlist; empty allows all
+	Soul                string   `json:"soul,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_SOUL"`   // SOUL.md to use instead of the workspace's
+
+	// Fallbacks are tried in order after fallback_provider/fallback_model
+	Fallbacks        []FallbackConfig `json:"fallbacks,omitempty"`
+	FallbackCooldown int              `json:"fallback_cooldown_seconds,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_FALLBACK_COOLDOWN_SECONDS"` // how long a failing provider is skipped; default 60
+
+	Context ContextBudgetConfig `json:"context"`
+	Routing ModelRoutingConfig  `json:"routing"`
+}
+
+// FallbackConfig is a provider/model pair to try when the ones before it
+// fail. The provider may be a named instance, or the model "instance/model";
+// without either the primary provider serves the model.
+type FallbackConfig struct {
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model"`
+}
+
+// FallbackChain returns the fallbacks in the order they are tried, starting
+// with fallback_provider/fallback_model.
+func (d AgentDefaults) FallbackChain() []FallbackConfig {
+	var chain []FallbackConfig
+	if d.FallbackModel != "" {
+		chain = append(chain, FallbackConfig{Provider: d.FallbackProvider, Model: d.FallbackModel})
+	}
+	for _, fb := range d.Fallbacks {
+		if fb.Model != "" {
+			chain = append(chain, fb)
+		}
+	}
+	return chain
+}
+
+// ContextBudgetConfig budgets the tokens of the conversation sent with each
+// LLM call. Zero token budgets are derived from max_tokens. Whatever the
+// budgets, history is cut so that it fits in 75% of the context window
+// along with the system prompt, summary and new message.
+type ContextBudgetConfig struct {
+	HistoryTokens    int `json:"history_tokens" env:"PICOCLAW_AGENTS_DEFAULTS_CONTEXT_HISTORY_TOKENS"`         // default: 75% of max_tokens
+	SummaryTokens    int `json:"summary_tokens" env:"PICOCLAW_AGENTS_DEFAULTS_CONTEXT_SUMMARY_TOKENS"`         // default: 1/8 of max_tokens
+	ToolResultTokens int `json:"tool_result_tokens" env:"PICOCLAW_AGENTS_DEFAULTS_CONTEXT_TOOL_RESULT_TOKENS"` // older tool results are trimmed to this size
+	KeepRecentTurns  int `json:"keep_recent_turns" env:"PICOCLAW_AGENTS_DEFAULTS_CONTEXT_KEEP_RECENT_TURNS"`   // recent turns whose tool results are kept whole
+
+	HistoryAttachments int `json:"history_attachments" env:"PICOCLAW_AGENTS_DEFAULTS_CONTEXT_HISTORY_ATTACHMENTS"` // earlier images and files resent in full, newest first; older ones become captions
+}
+
+// ModelRoutingConfig routes each user turn to cheap_model or model. A turn
+// goes to the main model when any rule asks for it; otherwise the classifier,
+// if enabled, decides, and else the cheap model answers.
+type ModelRoutingConfig struct {
+	Enabled    bool `json:"enabled" env:"PICOCLAW_AGENTS_DEFAULTS_ROUTING_ENABLED"`
+	MaxChars   int  `json:"max_chars,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_ROUTING_MAX_CHARS"`   // longer messages go to the main model; default 280
+	Classifier bool `json:"classifier,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_ROUTING_CLASSIFIER"` // ask the cheap model whether the remaining turns are simple
+
+	Keywords         []string `json:"keywords,omitempty"`          // messages containing any of these go to the main model
+	MainChannels     []string `json:"main_channels,omitempty"`     // channels always answered by the main model
+	CheapSpecialists []string `json:"cheap_specialists,omitempty"` // specialists the cheap model may answer; others use the main model
+	ComplexTools     []string `json:"complex_tools,omitempty"`     // tools the cheap model escalates to the main model by calling; empty uses a built-in list
 }
 
 type ChannelsConfig struct {
@@ -176,6 +303,7 @@ type ProvidersConfig struct {
 	Groq          ProviderConfig `json:"groq"`
 	Zhipu         ProviderConfig `json:"zhipu"`
 	VLLM          ProviderConfig `json:"vllm"`
+	Ollama        ProviderConfig `json:"ollama"`
 	Gemini        ProviderConfig `json:"gemini"`
 	Nvidia        ProviderConfig `json:"nvidia"`
 	Moonshot      ProviderConfig `json:"moonshot"`
@@ -183,6 +311,37 @@ type ProvidersConfig struct {
 	DeepSeek      ProviderConfig `json:"deepseek"`
 	GitHubCopilot ProviderConfig `json:"github_copilot"`
 	MiniMax       ProviderConfig `json:"minimax"`
+
+	// Instances are named providers, addressable as "name/model". They sit
+	// alongside the fixed providers above.
+	Instances []ProviderInstance `json:"instances,omitempty"`
+
+	Retry RetryConfig `json:"retry"`
+}
+
+// RetryConfig controls how failed requests to HTTP providers are retried:
+// rate limits, server errors, network errors and streams that drop before
+// the first token.
+type RetryConfig struct {
+	MaxRetries      int `json:"max_retries" env:"PICOCLAW_PROVIDERS_RETRY_MAX_RETRIES"`             // retries after the first attempt; 0 disables retrying
+	BaseDelayMS     int `json:"base_delay_ms" env:"PICOCLAW_PROVIDERS_RETRY_BASE_DELAY_MS"`         // delay before the first retry, doubled for each one after
+	MaxDelaySeconds int `json:"max_delay_seconds" env:"PICOCLAW_PROVIDERS_RETRY_MAX_DELAY_SECONDS"` // longest delay; a longer Retry-After is not waited for
+}
+
+// ProviderInstance is a named provider of a registered kind, such as
+// "openai" for any OpenAI-compatible endpoint.
+type ProviderInstance struct {
+	Name        string            `json:"name"`
+	Kind        string            `json:"kind"`
+	APIKey      string            `json:"api_key,omitempty"`
+	APIBase     string            `json:"api_base,omitempty"`
+	Proxy       string            `json:"proxy,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Models      map[string]string `json:"models,omitempty"` // alias -> model ID
+	AuthMethod  string            `json:"auth_method,omitempty"`
+	ConnectMode string            `json:"connect_mode,omitempty"`
+	KeepAlive   string            `json:"keep_alive,omitempty"`
+	NumCtx      int               `json:"num_ctx,omitempty"`
 }
 
 type ProviderConfig struct {
@@ -191,6 +350,8 @@ type ProviderConfig struct {
 	Proxy       string `json:"proxy,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_PROXY"`
 	AuthMethod  string `json:"auth_method,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_AUTH_METHOD"`
 	ConnectMode string `json:"connect_mode,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_CONNECT_MODE"` //only for Github Copilot, `stdio` or `grpc`
+	KeepAlive   string `json:"keep_alive,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_KEEP_ALIVE"`     // only for Ollama, e.g. "30m" or "-1"
+	NumCtx      int    `json:"num_ctx,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_NUM_CTX"`           // only for Ollama; 0 sends 4096
 }
 
 type GatewayConfig struct {
@@ -246,6 +407,22 @@ type MemoryConfig struct {
 	EmbeddingModel   string `json:"embedding_model" env:"PICOCLAW_MEMORY_EMBEDDING_MODEL"`
 }
 
+// ApprovalConfig makes matching tool calls wait for the user's approval,
+// asked for in the chat the call came from. Turns with no chat to ask, such
+// as "picoclaw agent" on the CLI, cannot run matching calls.
+type ApprovalConfig struct {
+	Enabled        bool           `json:"enabled" env:"PICOCLAW_TOOLS_APPROVAL_ENABLED"`
+	TimeoutSeconds int            `json:"timeout_seconds" env:"PICOCLAW_TOOLS_APPROVAL_TIMEOUT_SECONDS"`
+	Rules          []ApprovalRule `json:"rules,omitempty"`
+}
+
+// ApprovalRule requires approval for calls to Tool. When Args is set, only
+// calls whose arguments match every pattern (argument name -> regexp) do.
+type ApprovalRule struct {
+	Tool string            `json:"tool"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
 type MCPConfig struct {
 	Servers []MCPServerConfig `json:"servers,omitempty"`
 }
@@ -259,11 +436,12 @@ type MCPServerConfig struct {
 }
 
 type ToolsConfig struct {
-	Web    WebToolsConfig `json:"web"`
-	Moodle MoodleConfig   `json:"moodle"`
-	Email  EmailConfig    `json:"email"`
-	Memory MemoryConfig   `json:"memory"`
-	MCP    MCPConfig      `json:"mcp,omitempty"`
+	Web      WebToolsConfig `json:"web"`
+	Moodle   MoodleConfig   `json:"moodle"`
+	Email    EmailConfig    `json:"email"`
+	Memory   MemoryConfig   `json:"memory"`
+	MCP      MCPConfig      `json:"mcp,omitempty"`
+	Approval ApprovalConfig `json:"approval"`
 }
 
 func DefaultConfig() *Config {
@@ -278,6 +456,13 @@ func DefaultConfig() *Config {
 				MaxTokens:           8192,
 				Temperature:         0.4,
 				MaxToolIterations:   20,
+				MaxConcurrentTurns:  4,
+				MaxParallelTools:    4,
+				Context: ContextBudgetConfig{
+					ToolResultTokens:   1000,
+					KeepRecentTurns:    1,
+					HistoryAttachments: 2,
+				},
 			},
 		},
 		Channels: ChannelsConfig{
@@ -353,10 +538,16 @@ func DefaultConfig() *Config {
 			Groq:         ProviderConfig{},
 			Zhipu:        ProviderConfig{},
 			VLLM:         ProviderConfig{},
+			Ollama:       ProviderConfig{},
 			Gemini:       ProviderConfig{},
 			Nvidia:       ProviderConfig{},
 			Moonshot:     ProviderConfig{},
 			ShengSuanYun: ProviderConfig{},
+			Retry: RetryConfig{
+				MaxRetries:      2,
+				BaseDelayMS:     500,
+				MaxDelaySeconds: 30,
+			},
 		},
 		Gateway: GatewayConfig{
 			Host: "0.0.0.0",
@@ -388,6 +579,27 @@ func DefaultConfig() *Config {
 				KnowledgeExtract: true,
 				EmbeddingModel:   "text-embedding-3-small",
 			},
+			Approval: ApprovalConfig{
+				Enabled:        false,
+				TimeoutSeconds: 300,
+				Rules: []ApprovalRule{
+					{Tool: "exec"},
+					{Tool: "write_file"},
+					{Tool: "edit_file"},
+					{Tool: "append_file"},
+					{Tool: "email", Args: map[string]string{"action": "^(send|reply)$"}},
+					{Tool: "manage_telegram"},
+				},
+			},
+		},
+		Quotas: QuotasConfig{
+			Default: QuotaLimits{Messages: 20, WindowSeconds: 60},
+		},
+		Sessions: SessionsConfig{
+			Store: "json",
+			Retention: SessionRetentionConfig{
+				IntervalMinutes: 60,
+			},
 		},
 		Heartbeat: HeartbeatConfig{
 			Enabled:  true,
@@ -422,6 +634,22 @@ func LoadConfig(path string) (*Config, error) {
 	return cfg, nil
 }
 
+// Clone returns a deep copy of c.
+func (c *Config) Clone() (*Config, error) {
+	c.mu.RLock()
+	data, err := json.Marshal(c)
+	c.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	clone := &Config{}
+	if err := json.Unmarshal(data, clone); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
 func SaveConfig(path string, cfg *Config) error {
 	cfg.mu.RLock()
 	defer cfg.mu.RUnlock()
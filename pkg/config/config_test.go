@@ -1,6 +1,7 @@
 package config
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -177,3 +178,146 @@ func TestConfig_Complete(t *testing.T) {
 		t.Error("Heartbeat should be enabled by default")
 	}
 }
+
+// TestGenerationConfig_Resolve verifies profile selection and precedence
+func TestGenerationConfig_Resolve(t *testing.T) {
+	low, high := 0.1, 0.9
+	g := GenerationConfig{
+		Profiles: map[string]GenerationProfile{
+			"precise":  {Temperature: &low, MaxTokens: 1024},
+			"creative": {Temperature: &high, TopP: &high},
+			"short":    {MaxTokens: 128, Stop: []string{"END"}},
+			"thinking": {ReasoningEffort: "high"},
+		},
+		Models:      map[string]string{"gpt-5": "thinking"},
+		Channels:    map[string]string{"telegram": "creative"},
+		Specialists: map[string]string{"finance": "precise"},
+		Tasks:       map[string]string{TaskTriage: "short", TaskSummary: "missing"},
+	}
+
+	tests := []struct {
+		name string
+		sel  GenerationSelector
+		want map[string]interface{}
+	}{
+		{
+			name: "nothing selected",
+			sel:  GenerationSelector{Model: "other"},
+			want: map[string]interface{}{},
+		},
+		{
+			name: "model profile",
+			sel:  GenerationSelector{Model: "gpt-5"},
+			want: map[string]interface{}{"reasoning_effort": "high"},
+		},
+		{
+			name: "specialist overrides channel",
+			sel:  GenerationSelector{Channel: "telegram", Specialist: "finance"},
+			want: map[string]interface{}{"temperature": low, "top_p": high, "max_tokens": 1024},
+		},
+		{
+			name: "task overrides specialist",
+			sel:  GenerationSelector{Specialist: "finance", Task: TaskTriage},
+			want: map[string]interface{}{"temperature": low, "max_tokens": 128, "stop": []string{"END"}},
+		},
+		{
+			name: "unknown profile is ignored",
+			sel:  GenerationSelector{Task: TaskSummary},
+			want: map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := g.Resolve(tt.sel).Options()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve(%+v).Options() = %v, want %v", tt.sel, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerationConfig_Validate(t *testing.T) {
+	g := GenerationConfig{
+		Profiles: map[string]GenerationProfile{
+			"thinking": {ReasoningEffort: "high"},
+			"short":    {MaxTokens: 128},
+		},
+		Models:   map[string]string{"gpt-5": "thinking", "claude-sonnet-4-5": "short"},
+		Channels: map[string]string{"telegram": "thinking"},
+	}
+	if err := g.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+
+	g.Models["anthropic/claude-opus-4"] = "thinking"
+	if err := g.Validate(); err == nil {
+		t.Error("Expected reasoning_effort for a Claude model to be rejected")
+	}
+}
+
+func TestAgentsConfig_Route(t *testing.T) {
+	agents := AgentsConfig{
+		Named: map[string]AgentConfig{
+			"team":   {},
+			"family": {},
+			"topic":  {},
+		},
+		Routes: []AgentRoute{
+			{Agent: "team", Channel: "slack"},
+			{Agent: "family", Channel: "telegram", ChatID: "-100"},
+			{Agent: "topic", Channel: "telegram", ChatID: "-100", ThreadID: "7"},
+		},
+	}
+
+	tests := []struct {
+		name                      string
+		channel, chatID, threadID string
+		want                      string
+	}{
+		{"channel route", "slack", "C1", "", "team"},
+		{"chat route", "telegram", "-100", "", "family"},
+		{"thread beats chat", "telegram", "-100", "7", "topic"},
+		{"other thread falls back to chat", "telegram", "-100", "8", "family"},
+		{"unmatched goes to default", "telegram", "42", "", DefaultAgentName},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := agents.Route(tt.channel, tt.chatID, tt.threadID); got != tt.want {
+				t.Errorf("Route(%q, %q, %q) = %q, want %q", tt.channel, tt.chatID, tt.threadID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_ForAgent(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Agents.Named = map[string]AgentConfig{
+		"family": {Workspace: "/tmp/family", Model: "family-model", Tools: []string{"read_file"}},
+	}
+
+	agentCfg, err := cfg.ForAgent("family")
+	if err != nil {
+		t.Fatalf("ForAgent failed: %v", err)
+	}
+	if agentCfg.Agents.Defaults.Model != "family-model" || agentCfg.WorkspacePath() != "/tmp/family" {
+		t.Errorf("Expected family overrides, got model %q workspace %q", agentCfg.Agents.Defaults.Model, agentCfg.WorkspacePath())
+	}
+	if !reflect.DeepEqual(agentCfg.Agents.Defaults.Tools, []string{"read_file"}) {
+		t.Errorf("Expected tool allowlist [read_file], got %v", agentCfg.Agents.Defaults.Tools)
+	}
+	if agentCfg.Agents.Defaults.MaxTokens != cfg.Agents.Defaults.MaxTokens {
+		t.Errorf("Expected unset fields to inherit defaults")
+	}
+	if cfg.Agents.Defaults.Model == "family-model" {
+		t.Error("ForAgent must not modify the original config")
+	}
+
+	if got, _ := cfg.ForAgent(DefaultAgentName); got != cfg {
+		t.Error("Expected the default agent to use the original config")
+	}
+	if _, err := cfg.ForAgent("missing"); err == nil {
+		t.Error("Expected an error for an unknown agent")
+	}
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Background task names that generation profiles can be selected for.
+const (
+	TaskSummary    = "summary"
+	TaskExtraction = "extraction"
+	TaskTriage     = "triage"
+	TaskReview     = "review"
+	TaskSubagent   = "subagent"
+)
+
+// GenerationProfile is a named set of sampling parameters sent with LLM
+// requests. Unset fields leave the caller's own value in place.
+type GenerationProfile struct {
+	MaxTokens       int      `json:"max_tokens,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	Stop            []string `json:"stop,omitempty"`
+	ReasoningEffort string   `json:"reasoning_effort,omitempty"` // OpenAI-compatible and Codex models; Claude ignores it
+}
+
+// GenerationConfig holds the named generation profiles and the rules that
+// select them. Each selector map goes from a model, channel, specialist or
+// task name to a profile name.
+type GenerationConfig struct {
+	Profiles    map[string]GenerationProfile `json:"profiles,omitempty"`
+	Models      map[string]string            `json:"models,omitempty"`
+	Channels    map[string]string            `json:"channels,omitempty"`
+	Specialists map[string]string            `json:"specialists,omitempty"`
+	Tasks       map[string]string            `json:"tasks,omitempty"`
+}
+
+// GenerationSelector describes the LLM call a profile is resolved for.
+// Empty fields do not select anything.
+type GenerationSelector struct {
+	Model      string
+	Channel    string
+	Specialist string
+	Task       string
+}
+
+// Resolve merges the profiles selected by the model, channel, specialist and
+// task, in that order; later profiles override fields set by earlier ones.
+// Selectors that name an unknown profile are ignored.
+func (g GenerationConfig) Resolve(sel GenerationSelector) GenerationProfile {
+	var resolved GenerationProfile
+	for _, name := range []string{
+		g.Models[sel.Model],
+		g.Channels[sel.Channel],
+		g.Specialists[sel.Specialist],
+		g.Tasks[sel.Task],
+	} {
+		if name == "" {
+			continue
+		}
+		if profile, ok := g.Profiles[name]; ok {
+			resolved = resolved.merge(profile)
+		}
+	}
+	return resolved
+}
+
+// Validate checks that no Claude model is given a profile with
+// reasoning_effort, which Claude does not support. Profiles selected by
+// channel, specialist or task may still reach a Claude model; the provider
+// drops the option then.
+func (g GenerationConfig) Validate() error {
+	for model, name := range g.Models {
+		if g.Profiles[name].ReasoningEffort != "" && strings.Contains(strings.ToLower(model), "claude") {
+			return fmt.Errorf("generation profile %q sets reasoning_effort, which Claude model %s does not support", name, model)
+		}
+	}
+	return nil
+}
+
+func (p GenerationProfile) merge(o GenerationProfile) GenerationProfile {
+	if o.MaxTokens > 0 {
+		p.MaxTokens = o.MaxTokens
+	}
+	if o.Temperature != nil {
+		p.Temperature = o.Temperature
+	}
+	if o.TopP != nil {
+		p.TopP = o.TopP
+	}
+	if len(o.Stop) > 0 {
+		p.Stop = o.Stop
+	}
+	if o.ReasoningEffort != "" {
+		p.ReasoningEffort = o.ReasoningEffort
+	}
+	return p
+}
+
+// Options returns the profile's set fields as provider options
+// (max_tokens, temperature, top_p, stop, reasoning_effort).
+func (p GenerationProfile) Options() map[string]interface{} {
+	options := make(map[string]interface{})
+	if p.MaxTokens > 0 {
+		options["max_tokens"] = p.MaxTokens
+	}
+	if p.Temperature != nil {
+		options["temperature"] = *p.Temperature
+	}
+	if p.TopP != nil {
+		options["top_p"] = *p.TopP
+	}
+	if len(p.Stop) > 0 {
+		options["stop"] = p.Stop
+	}
+	if p.ReasoningEffort != "" {
+		options["reasoning_effort"] = p.ReasoningEffort
+	}
+	return options
+}
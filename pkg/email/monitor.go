@@ -26,8 +26,9 @@ type EmailMonitor struct {
 	scriptPath string
 	workspace  string
 	msgBus     *bus.MessageBus
-	channel    string // target Telegram channel
-	chatID     string // target Telegram chat
+	channel    string                 // target Telegram channel
+	chatID     string                 // target Telegram chat
+	llmOptions map[string]interface{} // generation overrides for triage calls
 
 	mu      sync.Mutex
 	running bool
@@ -39,6 +40,20 @@ type triageResult struct {
 	Summary string `json:"summary"`
 }
 
+// triageSchema is the response schema of the triage prompt.
+var triageSchema = &providers.ResponseSchema{
+	Name:        "email_triage",
+	Description: "Classification and summary of an email",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"action":  map[string]interface{}{"type": "string", "enum": []string{"urgent", "delivery_arrived", "normal"}},
+			"summary": map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"action", "summary"},
+	},
+}
+
 type emailEntry struct {
 	UID     string `json:"uid"`
 	From    string `json:"from"`
@@ -91,6 +106,12 @@ func NewEmailMonitor(
 }
 
 // Start begins the email monitoring loop at the given interval.
+// SetLLMOptions sets generation options (e.g. from the "triage" profile)
+// applied on top of the triage call's defaults.
+func (m *EmailMonitor) SetLLMOptions(options map[string]interface{}) {
+	m.llmOptions = options
+}
+
 func (m *EmailMonitor) Start(intervalMins int) {
 	m.mu.Lock()
 	if m.running {
@@ -235,27 +256,17 @@ Return ONLY JSON: {"action": "urgent|delivery_arrived|normal", "summary": "1-sen
 	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
-	resp, err := m.provider.Chat(ctx, []providers.Message{
+	var result triageResult
+	err := providers.ChatJSON(ctx, m.provider, []providers.Message{
 		{Role: "user", Content: prompt},
-	}, nil, m.cheapModel, map[string]interface{}{
+	}, m.cheapModel, providers.MergeOptions(map[string]interface{}{
 		"max_tokens":  128,
 		"temperature": 0.1,
-	})
+	}, m.llmOptions), triageSchema, &result)
 	if err != nil {
 		// Default to normal on error
 		return triageResult{Action: "normal", Summary: email.Subject}
 	}
-
-	content := strings.TrimSpace(resp.Content)
-	content = strings.TrimPrefix(content, "```json")
-	content = strings.TrimPrefix(content, "```")
-	content = strings.TrimSuffix(content, "```")
-	content = strings.TrimSpace(content)
-
-	var result triageResult
-	if err := json.Unmarshal([]byte(content), &result); err != nil {
-		return triageResult{Action: "normal", Summary: email.Subject}
-	}
 	return result
 }
 
@@ -0,0 +1,152 @@
+package media
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Store saves an image or file part in dir and returns a reference to it.
+// Files are named by content hash, so storing the same media twice writes
+// one file. Parts that are only notes, such as "[Unsupported file: ...]",
+// are not stored and yield nil.
+func Store(dir string, part ContentPart) (*Attachment, error) {
+	var data []byte
+	mediaType := part.MediaType
+	switch {
+	case part.Type == "image":
+		decoded, err := base64.StdEncoding.DecodeString(part.Data)
+		if err != nil {
+			return nil, fmt.Errorf("decode image %s: %w", part.FileName, err)
+		}
+		data = decoded
+	case part.Type == "text" && part.FileName != "":
+		data = []byte(part.Text)
+		mediaType = "text/plain"
+	default:
+		return nil, nil
+	}
+
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:12]) + storedExt(part.FileName, mediaType)
+	path := filepath.Join(dir, name)
+
+	if _, err := os.Stat(path); err == nil {
+		// Stored again: keep it clear of Prune's cutoff
+		now := time.Now()
+		os.Chtimes(path, now, now)
+	} else {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return nil, err
+		}
+	}
+
+	return &Attachment{
+		Path:      path,
+		MediaType: mediaType,
+		FileName:  part.FileName,
+	}, nil
+}
+
+// Prune removes the files in dir that keep does not want, except those
+// stored since cutoff, which may belong to messages not yet saved. It
+// returns how many files it removed.
+func Prune(dir string, keep func(name string) bool, cutoff time.Time) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, e := range entries {
+		if e.IsDir() || keep(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// storedExt picks the extension of a stored file, from the original name or
+// else the MIME type.
+func storedExt(fileName, mediaType string) string {
+	if ext := strings.ToLower(filepath.Ext(fileName)); ext != "" {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// Load reads a stored attachment back into a ContentPart.
+func Load(a Attachment) (*ContentPart, error) {
+	info, err := os.Stat(a.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(a.MediaType, "image/") {
+		if info.Size() > maxImageSize {
+			return nil, fmt.Errorf("image too large: %s", a.Path)
+		}
+		data, err := os.ReadFile(a.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &ContentPart{
+			Type:      "image",
+			MediaType: a.MediaType,
+			Data:      base64.StdEncoding.EncodeToString(data),
+			FileName:  a.FileName,
+		}, nil
+	}
+
+	// Stored text parts already include their "--- Content of ---" framing
+	data, err := os.ReadFile(a.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &ContentPart{
+		Type:     "text",
+		Text:     string(data),
+		FileName: a.FileName,
+	}, nil
+}
+
+// Caption describes an attachment in place of its content.
+func (a Attachment) Caption() string {
+	kind := "file"
+	if strings.HasPrefix(a.MediaType, "image/") {
+		kind = "image"
+	}
+	name := a.FileName
+	if name == "" {
+		name = filepath.Base(a.Path)
+	}
+	return fmt.Sprintf("[earlier %s: %s, saved at %s]", kind, name, a.Path)
+}
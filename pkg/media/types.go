@@ -10,3 +10,11 @@ type ContentPart struct {
 	Data      string `json:"data"`       // base64-encoded image data
 	FileName  string `json:"file_name"`  // original filename
 }
+
+// Attachment references a ContentPart saved to disk, so that session
+// history can carry media without embedding it.
+type Attachment struct {
+	Path      string `json:"path"`       // stored file
+	MediaType string `json:"media_type"` // MIME type, e.g. "image/jpeg"
+	FileName  string `json:"file_name"`  // original filename
+}
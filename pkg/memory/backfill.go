@@ -108,32 +108,11 @@ func backfillSession(ctx context.Context, path string, store *VectorStore, extra
 	// Parse channel and chatID from session key (e.g. "telegram:123456")
 	channel, chatID := parseSessionKey(sess.Key)
 
-	// Walk through messages, pairing user messages with the next assistant response
-	for i := 0; i < len(sess.Messages); i++ {
-		msg := sess.Messages[i]
-		if msg.Role != "user" || msg.Content == "" {
-			continue
-		}
-
-		// Find the next assistant message (skip tool messages)
-		assistantMsg := ""
-		for j := i + 1; j < len(sess.Messages); j++ {
-			if sess.Messages[j].Role == "assistant" && sess.Messages[j].Content != "" {
-				assistantMsg = sess.Messages[j].Content
-				break
-			}
-			if sess.Messages[j].Role == "user" {
-				// Next user message before an assistant response — no pair
-				break
-			}
-		}
-
-		if assistantMsg == "" {
-			continue
-		}
+	for _, pair := range conversationPairs(sess.Messages) {
+		userMsg, assistantMsg := pair[0], pair[1]
 
 		if opts.DryRun {
-			preview := msg.Content
+			preview := userMsg
 			runes := []rune(preview)
 			if len(runes) > 80 {
 				preview = string(runes[:80]) + "..."
@@ -144,12 +123,12 @@ func backfillSession(ctx context.Context, path string, store *VectorStore, extra
 		}
 
 		// Index the conversation turn
-		store.IndexConversation(ctx, sess.Key, channel, chatID, msg.Content, assistantMsg)
+		store.IndexConversation(ctx, sess.Key, channel, chatID, userMsg, assistantMsg)
 		stats.TurnsIndexed++
 
 		// Optionally extract knowledge
 		if opts.ExtractKnowledge && extractor != nil {
-			extractor.ExtractAndConsolidate(ctx, msg.Content, assistantMsg, sess.Key, "", KnowledgeIndexOpts{})
+			extractor.ExtractAndConsolidate(ctx, userMsg, assistantMsg, sess.Key, "", KnowledgeIndexOpts{})
 		}
 
 		// Small delay to avoid hammering the embedding API
@@ -159,6 +138,32 @@ func backfillSession(ctx context.Context, path string, store *VectorStore, extra
 	return nil
 }
 
+// conversationPairs pairs each user message with the assistant's next text
+// response, skipping tool messages in between. User messages that got no
+// response before the next user message are left out.
+func conversationPairs(messages []providers.Message) [][2]string {
+	var pairs [][2]string
+	for i := 0; i < len(messages); i++ {
+		msg := messages[i]
+		if msg.Role != "user" || msg.Content == "" {
+			continue
+		}
+
+		// Find the next assistant message (skip tool messages)
+		for j := i + 1; j < len(messages); j++ {
+			if messages[j].Role == "assistant" && messages[j].Content != "" {
+				pairs = append(pairs, [2]string{msg.Content, messages[j].Content})
+				break
+			}
+			if messages[j].Role == "user" {
+				// Next user message before an assistant response — no pair
+				break
+			}
+		}
+	}
+	return pairs
+}
+
 // parseSessionKey extracts channel and chatID from a session key like "telegram:123456".
 func parseSessionKey(key string) (channel, chatID string) {
 	parts := strings.SplitN(key, ":", 2)
@@ -2,9 +2,7 @@ package memory
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"regexp"
 	"strings"
 	"time"
 
@@ -12,15 +10,13 @@ import (
 	"github.com/sipeed/picoclaw/pkg/providers"
 )
 
-// thinkTagRe matches <think>...</think> reasoning blocks from models like DeepSeek/MiniMax.
-var thinkTagRe = regexp.MustCompile(`(?s)<think>.*?</think>\s*`)
-
 // KnowledgeExtractor extracts and consolidates knowledge from conversations.
 type KnowledgeExtractor struct {
 	provider      providers.LLMProvider
 	model         string
 	store         *VectorStore
 	relationStore *RelationStore
+	llmOptions    map[string]interface{} // generation overrides for extraction calls
 }
 
 // ExtractedFact represents a single fact extracted from a conversation.
@@ -36,6 +32,68 @@ type ConsolidationAction struct {
 	NewFact string `json:"new_fact"` // merged/updated fact text (for UPDATE)
 }
 
+// factsSchema is the response schema of the fact extraction prompts.
+var factsSchema = &providers.ResponseSchema{
+	Name:        "extracted_facts",
+	Description: "Facts extracted from the content",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"facts": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"fact":     map[string]interface{}{"type": "string"},
+						"category": map[string]interface{}{"type": "string"},
+					},
+					"required": []string{"fact", "category"},
+				},
+			},
+		},
+		"required": []string{"facts"},
+	},
+}
+
+// consolidationSchema is the response schema of the consolidation prompt.
+var consolidationSchema = &providers.ResponseSchema{
+	Name:        "consolidation_action",
+	Description: "What to do with the new fact",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"action":   map[string]interface{}{"type": "string", "enum": []string{"ADD", "UPDATE", "DELETE", "NOOP"}},
+			"fact_id":  map[string]interface{}{"type": "string"},
+			"new_fact": map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"action"},
+	},
+}
+
+// relationsSchema is the response schema of the relation extraction prompt.
+var relationsSchema = &providers.ResponseSchema{
+	Name:        "extracted_relations",
+	Description: "Entity relationships as subject, predicate, object triples",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"relations": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"s": map[string]interface{}{"type": "string"},
+						"p": map[string]interface{}{"type": "string"},
+						"o": map[string]interface{}{"type": "string"},
+					},
+					"required": []string{"s", "p", "o"},
+				},
+			},
+		},
+		"required": []string{"relations"},
+	},
+}
+
 // NewKnowledgeExtractor creates a new extractor.
 func NewKnowledgeExtractor(provider providers.LLMProvider, model string, store *VectorStore) *KnowledgeExtractor {
 	return &KnowledgeExtractor{
@@ -45,6 +103,12 @@ func NewKnowledgeExtractor(provider providers.LLMProvider, model string, store *
 	}
 }
 
+// SetLLMOptions sets generation options (e.g. from the "extraction" profile)
+// applied on top of each extraction call's defaults.
+func (ke *KnowledgeExtractor) SetLLMOptions(options map[string]interface{}) {
+	ke.llmOptions = options
+}
+
 // SetRelationStore sets the relation store for graph-augmented memory extraction.
 func (ke *KnowledgeExtractor) SetRelationStore(rs *RelationStore) {
 	ke.relationStore = rs
@@ -111,16 +175,16 @@ const extractionPrompt = `Extract key facts about the user from this conversatio
 - Relationships (people mentioned)
 - Important context (events, decisions, states)
 
-Return a JSON array of facts. Each fact should be a self-contained statement.
-If no meaningful facts can be extracted, return an empty array [].
+Return a JSON object with a "facts" array. Each fact should be a self-contained statement.
+If no meaningful facts can be extracted, return {"facts": []}.
 
 Categories: biographical, preference, task, relationship, contextual
 
 Example output:
-[
+{"facts": [
   {"fact": "User is a student at QMUL", "category": "biographical"},
   {"fact": "User prefers dark mode in all apps", "category": "preference"}
-]
+]}
 
 CONVERSATION:
 User: %s
@@ -153,37 +217,20 @@ func (ke *KnowledgeExtractor) extractFacts(ctx context.Context, userMsg, assista
 
 	prompt := fmt.Sprintf(extractionPrompt, userMsg, truncate(assistantMsg, 2000))
 
-	resp, err := ke.provider.Chat(ctx, []providers.Message{
+	var result struct {
+		Facts []ExtractedFact `json:"facts"`
+	}
+	err := providers.ChatJSON(ctx, ke.provider, []providers.Message{
 		{Role: "user", Content: prompt},
-	}, nil, ke.model, map[string]interface{}{
+	}, ke.model, providers.MergeOptions(map[string]interface{}{
 		"max_tokens":  1024,
 		"temperature": 0.1,
-	})
+	}, ke.llmOptions), factsSchema, &result)
 	if err != nil {
 		return nil, fmt.Errorf("LLM extraction call: %w", err)
 	}
 
-	content := strings.TrimSpace(resp.Content)
-	// Strip <think>...</think> reasoning blocks (MiniMax, DeepSeek, etc.)
-	content = thinkTagRe.ReplaceAllString(content, "")
-	// Strip markdown fences if present
-	content = strings.TrimPrefix(content, "```json")
-	content = strings.TrimPrefix(content, "```")
-	content = strings.TrimSuffix(content, "```")
-	content = strings.TrimSpace(content)
-
-	var facts []ExtractedFact
-	if err := json.Unmarshal([]byte(content), &facts); err != nil {
-		// Fallback: try parsing as a single object (some models return {..} instead of [{..}])
-		var single ExtractedFact
-		if err2 := json.Unmarshal([]byte(content), &single); err2 == nil && single.Fact != "" {
-			facts = []ExtractedFact{single}
-		} else {
-			return nil, fmt.Errorf("parse extracted facts: %w (response: %s)", err, truncate(content, 200))
-		}
-	}
-
-	return facts, nil
+	return result.Facts, nil
 }
 
 func (ke *KnowledgeExtractor) consolidateFact(ctx context.Context, fact ExtractedFact, specialist string, opts KnowledgeIndexOpts) error {
@@ -261,7 +308,7 @@ Decide what to do:
 - NOOP: The new fact is essentially the same as an existing one. No action needed.
 - ADD: The new fact is related but distinct from existing facts. Add it.
 
-Return ONLY valid JSON:
+Return ONLY a JSON object:
 {"action": "UPDATE|DELETE|NOOP|ADD", "fact_id": "id_of_existing_fact_if_applicable", "new_fact": "merged fact text for UPDATE"}
 `
 
@@ -276,28 +323,17 @@ func (ke *KnowledgeExtractor) decideAction(ctx context.Context, fact ExtractedFa
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	resp, err := ke.provider.Chat(ctx, []providers.Message{
+	var action ConsolidationAction
+	err := providers.ChatJSON(ctx, ke.provider, []providers.Message{
 		{Role: "user", Content: prompt},
-	}, nil, ke.model, map[string]interface{}{
+	}, ke.model, providers.MergeOptions(map[string]interface{}{
 		"max_tokens":  256,
 		"temperature": 0.1,
-	})
+	}, ke.llmOptions), consolidationSchema, &action)
 	if err != nil {
 		return nil, fmt.Errorf("consolidation LLM call: %w", err)
 	}
 
-	content := strings.TrimSpace(resp.Content)
-	content = thinkTagRe.ReplaceAllString(content, "")
-	content = strings.TrimPrefix(content, "```json")
-	content = strings.TrimPrefix(content, "```")
-	content = strings.TrimSuffix(content, "```")
-	content = strings.TrimSpace(content)
-
-	var action ConsolidationAction
-	if err := json.Unmarshal([]byte(content), &action); err != nil {
-		return nil, fmt.Errorf("parse consolidation action: %w", err)
-	}
-
 	return &action, nil
 }
 
@@ -310,13 +346,13 @@ const specialistExtractionPrompt = `Extract key facts and information from the f
 Each fact should be self-contained and preserve WHO said/did it and WHEN.
 Categories: financial, operational, logistic, contractual, relationship, decision, contact, contextual
 
-Return a JSON array of facts. If no meaningful facts can be extracted, return an empty array [].
+Return a JSON object with a "facts" array. If no meaningful facts can be extracted, return {"facts": []}.
 
 Example output:
-[
+{"facts": [
   {"fact": "Charlie confirmed the venue booking for June 15th at The Grand Hall", "category": "logistic"},
   {"fact": "Budget approved at $5,000 for catering by Sarah on 2024-03-01", "category": "financial"}
-]
+]}
 
 CONTENT:
 %s
@@ -332,35 +368,20 @@ func (ke *KnowledgeExtractor) ExtractSpecialistFacts(ctx context.Context, conten
 
 	prompt := fmt.Sprintf(specialistExtractionPrompt, content)
 
-	resp, err := ke.provider.Chat(ctx, []providers.Message{
+	var result struct {
+		Facts []ExtractedFact `json:"facts"`
+	}
+	err := providers.ChatJSON(ctx, ke.provider, []providers.Message{
 		{Role: "user", Content: prompt},
-	}, nil, ke.model, map[string]interface{}{
+	}, ke.model, providers.MergeOptions(map[string]interface{}{
 		"max_tokens":  1024,
 		"temperature": 0.1,
-	})
+	}, ke.llmOptions), factsSchema, &result)
 	if err != nil {
 		return nil, fmt.Errorf("LLM specialist extraction call: %w", err)
 	}
 
-	c := strings.TrimSpace(resp.Content)
-	c = thinkTagRe.ReplaceAllString(c, "")
-	c = strings.TrimPrefix(c, "```json")
-	c = strings.TrimPrefix(c, "```")
-	c = strings.TrimSuffix(c, "```")
-	c = strings.TrimSpace(c)
-
-	var facts []ExtractedFact
-	if err := json.Unmarshal([]byte(c), &facts); err != nil {
-		// Fallback: try parsing as a single object (MiniMax sometimes returns {..} instead of [{..}])
-		var single ExtractedFact
-		if err2 := json.Unmarshal([]byte(c), &single); err2 == nil && single.Fact != "" {
-			facts = []ExtractedFact{single}
-		} else {
-			return nil, fmt.Errorf("parse specialist facts: %w (response: %s)", err, truncate(c, 200))
-		}
-	}
-
-	return facts, nil
+	return result.Facts, nil
 }
 
 // ExtractAndConsolidateSpecialist runs the specialist-aware extraction pipeline.
@@ -404,16 +425,16 @@ const relationExtractionPrompt = `Extract entity relationships from this convers
 Focus on relationships between people, places, organizations, and concepts.
 
 Examples:
-[
+{"relations": [
   {"s": "Muhammad", "p": "studies_at", "o": "QMUL"},
   {"s": "Fahad", "p": "is_partner_in", "o": "Sikak"}
-]
+]}
 
 CONVERSATION:
 User: %s
 Assistant: %s
 
-Return ONLY a JSON array of triples. If no relationships found, return [].`
+Return ONLY a JSON object with a "relations" array of triples. If no relationships found, return {"relations": []}.`
 
 func (ke *KnowledgeExtractor) extractRelations(ctx context.Context, userMsg, assistantMsg, specialist string) {
 	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
@@ -421,27 +442,19 @@ func (ke *KnowledgeExtractor) extractRelations(ctx context.Context, userMsg, ass
 
 	prompt := fmt.Sprintf(relationExtractionPrompt, truncate(userMsg, 1000), truncate(assistantMsg, 1000))
 
-	resp, err := ke.provider.Chat(ctx, []providers.Message{
+	var result struct {
+		Relations []Relation `json:"relations"`
+	}
+	err := providers.ChatJSON(ctx, ke.provider, []providers.Message{
 		{Role: "user", Content: prompt},
-	}, nil, ke.model, map[string]interface{}{
+	}, ke.model, providers.MergeOptions(map[string]interface{}{
 		"max_tokens":  512,
 		"temperature": 0.1,
-	})
+	}, ke.llmOptions), relationsSchema, &result)
 	if err != nil {
 		return
 	}
-
-	content := strings.TrimSpace(resp.Content)
-	content = thinkTagRe.ReplaceAllString(content, "")
-	content = strings.TrimPrefix(content, "```json")
-	content = strings.TrimPrefix(content, "```")
-	content = strings.TrimSuffix(content, "```")
-	content = strings.TrimSpace(content)
-
-	var relations []Relation
-	if err := json.Unmarshal([]byte(content), &relations); err != nil {
-		return
-	}
+	relations := result.Relations
 
 	for _, r := range relations {
 		if r.Subject == "" || r.Predicate == "" || r.Object == "" {
@@ -88,6 +88,32 @@ func (vs *VectorStore) IndexConversation(ctx context.Context, sessionKey, channe
 	docID := fmt.Sprintf("%s:%d", sessionKey, ts.Unix())
 	content := fmt.Sprintf("User: %s\nAssistant: %s", userMsg, assistantMsg)
 
+	if err := vs.addConversation(ctx, docID, sessionKey, channel, chatID, content, ts); err != nil {
+		logger.ErrorCF("memory", "Failed to index conversation", map[string]interface{}{
+			"error":       err.Error(),
+			"session_key": sessionKey,
+		})
+		return
+	}
+
+	logger.DebugCF("memory", "Indexed conversation turn", map[string]interface{}{
+		"doc_id":      docID,
+		"content_len": len(content),
+	})
+}
+
+// IndexSummary embeds the summary of a session being archived, dated when
+// the conversation last changed. Its turns were already indexed as they
+// happened. The document ID derives from archiveID, so indexing the same
+// archive twice replaces the document.
+func (vs *VectorStore) IndexSummary(ctx context.Context, archiveID, sessionKey, summary string, updated time.Time) error {
+	channel, chatID := parseSessionKey(sessionKey)
+	content := "Summary of an earlier conversation: " + summary
+	return vs.addConversation(ctx, archiveID+":summary", sessionKey, channel, chatID, content, updated)
+}
+
+// addConversation adds a document to the conversations collection.
+func (vs *VectorStore) addConversation(ctx context.Context, docID, sessionKey, channel, chatID, content string, ts time.Time) error {
 	// Truncate very long messages to keep embeddings meaningful
 	// Use rune-safe truncation to avoid splitting multi-byte characters
 	if len(content) > 8000 {
@@ -97,7 +123,7 @@ func (vs *VectorStore) IndexConversation(ctx context.Context, sessionKey, channe
 		}
 	}
 
-	doc := chromem.Document{
+	return vs.conversations.AddDocument(ctx, chromem.Document{
 		ID:      docID,
 		Content: content,
 		Metadata: map[string]string{
@@ -107,19 +133,6 @@ func (vs *VectorStore) IndexConversation(ctx context.Context, sessionKey, channe
 			"timestamp":   ts.Format(time.RFC3339),
 			"date":        ts.Format("2006-01-02"),
 		},
-	}
-
-	if err := vs.conversations.AddDocument(ctx, doc); err != nil {
-		logger.ErrorCF("memory", "Failed to index conversation", map[string]interface{}{
-			"error":       err.Error(),
-			"session_key": sessionKey,
-		})
-		return
-	}
-
-	logger.DebugCF("memory", "Indexed conversation turn", map[string]interface{}{
-		"doc_id":      docID,
-		"content_len": len(content),
 	})
 }
 
@@ -1,7 +1,9 @@
 package metrics
 
 import (
+	"bufio"
 	"encoding/json"
+	"math"
 	"os"
 	"path/filepath"
 	"sync"
@@ -12,30 +14,57 @@ import (
 type TokenEvent struct {
 	Timestamp    string   `json:"ts"`
 	SessionKey   string   `json:"session"`
-	Model        string   `json:"model"`
+	Channel      string   `json:"channel,omitempty"`
+	SenderID     string   `json:"sender,omitempty"`
+	Model        string   `json:"model"`                   // model that answered
+	FallbackFrom string   `json:"fallback_from,omitempty"` // model requested, when a fallback answered
+	Route        string   `json:"route,omitempty"`         // how the turn's model was picked, e.g. "cheap:rules" or "main:length"
 	InputTokens  int      `json:"in"`
 	OutputTokens int      `json:"out"`
 	CacheRead    int      `json:"cache_read,omitempty"`
 	CacheCreate  int      `json:"cache_create,omitempty"`
+	CacheHitRate float64  `json:"cache_hit_rate,omitempty"` // share of prompt tokens read from cache
 	CostUSD      float64  `json:"cost"`
 	Specialist   string   `json:"specialist,omitempty"`
 	ToolsUsed    []string `json:"tools,omitempty"`
 	Iteration    int      `json:"iter"`
 }
 
-// Tracker appends token usage events to a JSONL file.
+// Tracker appends token usage events to a JSONL file. It also keeps each
+// sender's usage for the current day and month in memory, so quota checks
+// do not have to read the file.
 type Tracker struct {
 	filePath string
 	mu       sync.Mutex
+
+	sendersMu sync.Mutex
+	senders   map[string]*SenderUsage // "channel|sender" -> usage
+}
+
+// SenderUsage is one sender's usage in the latest day and month recorded,
+// in local time.
+type SenderUsage struct {
+	Day       string // "2006-01-02"
+	DayTokens int
+	DayCost   float64
+	Month     string // "2006-01"
+	MonthCost float64
 }
 
-// NewTracker creates a tracker that writes to workspace/metrics/tokens.jsonl.
+// NewTracker creates a tracker that writes to workspace/metrics/tokens.jsonl,
+// loading the usage already recorded there.
 func NewTracker(workspace string) *Tracker {
 	dir := filepath.Join(workspace, "metrics")
 	os.MkdirAll(dir, 0755)
-	return &Tracker{
+	t := &Tracker{
 		filePath: filepath.Join(dir, "tokens.jsonl"),
+		senders:  make(map[string]*SenderUsage),
 	}
+	t.Sum(func(e TokenEvent) bool {
+		t.addSenderUsage(e)
+		return false
+	})
+	return t
 }
 
 // Record appends a token event to the JSONL file.
@@ -44,6 +73,7 @@ func (t *Tracker) Record(event TokenEvent) {
 		event.Timestamp = time.Now().Format(time.RFC3339)
 	}
 	event.CostUSD = calculateCost(event.Model, event.InputTokens, event.OutputTokens, event.CacheRead, event.CacheCreate)
+	event.CacheHitRate = cacheHitRate(event.InputTokens, event.CacheRead, event.CacheCreate)
 
 	data, err := json.Marshal(event)
 	if err != nil {
@@ -61,6 +91,127 @@ func (t *Tracker) Record(event TokenEvent) {
 
 	f.Write(data)
 	f.Write([]byte("\n"))
+	t.addSenderUsage(event)
+}
+
+// addSenderUsage counts event towards its sender's usage. Events from
+// before the sender's latest day or month only count towards the periods
+// they fall in.
+func (t *Tracker) addSenderUsage(event TokenEvent) {
+	if event.SenderID == "" {
+		return
+	}
+	ts, err := time.Parse(time.RFC3339, event.Timestamp)
+	if err != nil {
+		return
+	}
+	ts = ts.Local()
+	day, month := ts.Format("2006-01-02"), ts.Format("2006-01")
+
+	t.sendersMu.Lock()
+	defer t.sendersMu.Unlock()
+	key := event.Channel + "|" + event.SenderID
+	u := t.senders[key]
+	if u == nil {
+		u = &SenderUsage{}
+		t.senders[key] = u
+	}
+	if month > u.Month {
+		u.Month, u.MonthCost = month, 0
+	}
+	if month == u.Month {
+		u.MonthCost += event.CostUSD
+	}
+	if day > u.Day {
+		u.Day, u.DayTokens, u.DayCost = day, 0, 0
+	}
+	if day == u.Day {
+		u.DayTokens += event.InputTokens + event.CacheRead + event.CacheCreate + event.OutputTokens
+		u.DayCost += event.CostUSD
+	}
+}
+
+// SenderUsage returns the usage of a sender on channel during the day and
+// month of now.
+func (t *Tracker) SenderUsage(channel, senderID string, now time.Time) SenderUsage {
+	now = now.Local()
+	usage := SenderUsage{Day: now.Format("2006-01-02"), Month: now.Format("2006-01")}
+
+	t.sendersMu.Lock()
+	defer t.sendersMu.Unlock()
+	u := t.senders[channel+"|"+senderID]
+	if u == nil {
+		return usage
+	}
+	if u.Day == usage.Day {
+		usage.DayTokens, usage.DayCost = u.DayTokens, u.DayCost
+	}
+	if u.Month == usage.Month {
+		usage.MonthCost = u.MonthCost
+	}
+	return usage
+}
+
+// Usage is the total token usage and cost of a set of events.
+type Usage struct {
+	Calls        int
+	InputTokens  int
+	OutputTokens int
+	CacheRead    int
+	CacheCreate  int
+	CostUSD      float64
+}
+
+// CacheHitRate returns the share of prompt tokens that were read from the
+// prompt cache.
+func (u Usage) CacheHitRate() float64 {
+	return cacheHitRate(u.InputTokens, u.CacheRead, u.CacheCreate)
+}
+
+// cacheHitRate returns cacheRead as a share of all prompt tokens, rounded to
+// three decimals. Input tokens exclude cached ones, as Anthropic reports them.
+func cacheHitRate(input, cacheRead, cacheCreate int) float64 {
+	total := input + cacheRead + cacheCreate
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(cacheRead)/float64(total)*1000) / 1000
+}
+
+// Sum totals the recorded events for which match returns true. A nil match
+// totals every event.
+func (t *Tracker) Sum(match func(TokenEvent) bool) (Usage, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var usage Usage
+	f, err := os.Open(t.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return usage, nil
+		}
+		return usage, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event TokenEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if match != nil && !match(event) {
+			continue
+		}
+		usage.Calls++
+		usage.InputTokens += event.InputTokens
+		usage.OutputTokens += event.OutputTokens
+		usage.CacheRead += event.CacheRead
+		usage.CacheCreate += event.CacheCreate
+		usage.CostUSD += event.CostUSD
+	}
+	return usage, scanner.Err()
 }
 
 // Model pricing per million tokens (input, output, cache_read, cache_create).
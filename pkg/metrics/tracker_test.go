@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTracker_RecordsCacheHitRate(t *testing.T) {
+	tracker := NewTracker(t.TempDir())
+	tracker.Record(TokenEvent{SessionKey: "a", Model: "claude-sonnet-4-20250514", InputTokens: 100, CacheCreate: 900})
+	tracker.Record(TokenEvent{SessionKey: "a", Model: "claude-sonnet-4-20250514", InputTokens: 100, CacheRead: 900})
+	tracker.Record(TokenEvent{SessionKey: "b", Model: "claude-sonnet-4-20250514", InputTokens: 50})
+
+	f, err := os.Open(tracker.filePath)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer f.Close()
+
+	var rates []float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event TokenEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Unmarshal() error: %v", err)
+		}
+		rates = append(rates, event.CacheHitRate)
+	}
+	if len(rates) != 3 || rates[0] != 0 || rates[1] != 0.9 || rates[2] != 0 {
+		t.Errorf("Expected cache hit rates [0 0.9 0], got %v", rates)
+	}
+
+	usage, err := tracker.Sum(func(e TokenEvent) bool { return e.SessionKey == "a" })
+	if err != nil {
+		t.Fatalf("Sum() error: %v", err)
+	}
+	if usage.Calls != 2 || usage.CacheRead != 900 || usage.CacheHitRate() != 0.45 {
+		t.Errorf("Unexpected usage for session a: %+v, hit rate %v", usage, usage.CacheHitRate())
+	}
+}
+
+func TestTracker_SenderUsage(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.Local)
+	record := func(tracker *Tracker, at time.Time, tokens int) {
+		tracker.Record(TokenEvent{
+			Timestamp:    at.Format(time.RFC3339),
+			Channel:      "telegram",
+			SenderID:     "1",
+			Model:        "claude-sonnet-4-20250514",
+			InputTokens:  tokens,
+			OutputTokens: tokens,
+		})
+	}
+
+	first := NewTracker(dir)
+	record(first, now.Add(-time.Hour), 1000)   // today
+	record(first, now.AddDate(0, 0, -3), 2000) // earlier this month
+	record(first, now.AddDate(0, -1, 0), 4000) // last month
+
+	// A new tracker loads the recorded usage, then keeps counting
+	second := NewTracker(dir)
+	record(second, now.Add(-time.Minute), 500)
+
+	usage := second.SenderUsage("telegram", "1", now)
+	if usage.DayTokens != 3000 {
+		t.Errorf("DayTokens = %d, want 3000", usage.DayTokens)
+	}
+	if want := 3500 * 18.0 / 1e6; math.Abs(usage.MonthCost-want) > 1e-9 {
+		t.Errorf("MonthCost = %v, want %v", usage.MonthCost, want)
+	}
+	if other := second.SenderUsage("telegram", "2", now); other.DayTokens != 0 || other.MonthCost != 0 {
+		t.Errorf("Expected no usage for another sender, got %+v", other)
+	}
+	if tomorrow := second.SenderUsage("telegram", "1", now.AddDate(0, 0, 1)); tomorrow.DayTokens != 0 || tomorrow.MonthCost == 0 {
+		t.Errorf("Expected only the monthly usage to carry over to tomorrow, got %+v", tomorrow)
+	}
+}
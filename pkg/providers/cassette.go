@@ -0,0 +1,343 @@
+package providers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCassetteMismatch is returned on replay when a request matches no
+// recorded interaction.
+var ErrCassetteMismatch = errors.New("request does not match the cassette")
+
+// CassetteMode selects whether a cassette records or replays.
+type CassetteMode int
+
+const (
+	CassetteRecord CassetteMode = iota
+	CassetteReplay
+)
+
+// CassetteRequest is a recorded LLM request.
+type CassetteRequest struct {
+	Model    string                 `json:"model"`
+	Messages []Message              `json:"messages"`
+	Tools    []ToolDefinition       `json:"tools,omitempty"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+// CassetteInteraction is one recorded request and its outcome.
+type CassetteInteraction struct {
+	Request  CassetteRequest `json:"request"`
+	Deltas   []string        `json:"deltas,omitempty"` // streamed content, in order
+	Response *LLMResponse    `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+
+	// Status and RetryAfter are the HTTP status and Retry-After delay
+	// behind Error, so replayed errors classify like the recorded ones.
+	Status     int           `json:"status,omitempty"`
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+}
+
+// replayedError is a recorded error. It unwraps to an *APIError carrying
+// the recorded status, while keeping the recorded message.
+type replayedError struct {
+	msg    string
+	apiErr *APIError
+}
+
+func (e *replayedError) Error() string { return e.msg }
+
+func (e *replayedError) Unwrap() error {
+	if e.apiErr == nil {
+		return nil
+	}
+	return e.apiErr
+}
+
+// Cassette is a JSONL file of LLM interactions. In record mode every call
+// through its providers is appended to the file as it completes; in replay
+// mode calls are answered from the file without network access.
+//
+// Replay matches each request against the interactions not yet used, so
+// concurrent callers (background extraction, summarization) may interleave
+// differently than when recording. System prompts embed the current time,
+// so their content is not compared; everything else must match exactly.
+type Cassette struct {
+	mode CassetteMode
+	path string
+
+	mu           sync.Mutex
+	file         *os.File
+	interactions []CassetteInteraction
+	used         []bool
+	mismatches   int
+}
+
+// OpenCassette opens the cassette at path. Recording truncates the file;
+// replaying loads it.
+func OpenCassette(path string, mode CassetteMode) (*Cassette, error) {
+	c := &Cassette{mode: mode, path: path}
+
+	if mode == CassetteRecord {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("creating cassette: %w", err)
+		}
+		c.file = f
+		return c, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening cassette: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var in CassetteInteraction
+		if err := json.Unmarshal(scanner.Bytes(), &in); err != nil {
+			return nil, fmt.Errorf("cassette %s line %d: %w", path, line, err)
+		}
+		c.interactions = append(c.interactions, in)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading cassette: %w", err)
+	}
+	c.used = make([]bool, len(c.interactions))
+	return c, nil
+}
+
+// Provider returns a provider backed by the cassette. When recording, calls
+// go to inner and are recorded; when replaying, inner is not used and may be
+// nil. Several providers may share one cassette.
+func (c *Cassette) Provider(inner LLMProvider) *CassetteProvider {
+	return &CassetteProvider{cassette: c, inner: inner}
+}
+
+// Mode returns whether the cassette records or replays.
+func (c *Cassette) Mode() CassetteMode {
+	return c.mode
+}
+
+// Remaining returns how many recorded interactions have not been replayed.
+func (c *Cassette) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, used := range c.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
+
+// Verify reports whether a replay went exactly as recorded: every request
+// matched an interaction and every interaction was replayed.
+func (c *Cassette) Verify() error {
+	c.mu.Lock()
+	mismatches := c.mismatches
+	c.mu.Unlock()
+
+	if mismatches > 0 {
+		return fmt.Errorf("%w %s: %d requests were not recorded", ErrCassetteMismatch, c.path, mismatches)
+	}
+	if n := c.Remaining(); n > 0 {
+		return fmt.Errorf("%w %s: %d recorded interactions were not replayed", ErrCassetteMismatch, c.path, n)
+	}
+	return nil
+}
+
+// Close closes the cassette file when recording.
+func (c *Cassette) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+func (c *Cassette) record(in CassetteInteraction) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding cassette interaction: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return fmt.Errorf("cassette %s is closed", c.path)
+	}
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing cassette: %w", err)
+	}
+	return nil
+}
+
+// replay returns the first unused interaction matching req.
+func (c *Cassette) replay(req CassetteRequest) (CassetteInteraction, error) {
+	want, err := normalizeCassetteRequest(req)
+	if err != nil {
+		return CassetteInteraction{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, in := range c.interactions {
+		if c.used[i] {
+			continue
+		}
+		got, err := normalizeCassetteRequest(in.Request)
+		if err != nil {
+			return CassetteInteraction{}, err
+		}
+		if reflect.DeepEqual(want, got) {
+			c.used[i] = true
+			return in, nil
+		}
+	}
+
+	c.mismatches++
+	last := ""
+	if n := len(req.Messages); n > 0 {
+		last = req.Messages[n-1].Role + ": " + req.Messages[n-1].Content
+		if len(last) > 200 {
+			last = last[:200] + "..."
+		}
+	}
+	return CassetteInteraction{}, fmt.Errorf("%w %s: model %s, %d messages, last %q",
+		ErrCassetteMismatch, c.path, req.Model, len(req.Messages), last)
+}
+
+// normalizeCassetteRequest converts req to its generic JSON form, so live
+// requests compare equal to ones decoded from the file. System prompt
+// content is blanked, and tools are sorted by name since the tool registry
+// does not list them in a stable order.
+func normalizeCassetteRequest(req CassetteRequest) (interface{}, error) {
+	msgs := make([]Message, len(req.Messages))
+	copy(msgs, req.Messages)
+	for i := range msgs {
+		if msgs[i].Role == "system" {
+			msgs[i].Content = ""
+		}
+	}
+	req.Messages = msgs
+
+	tools := make([]ToolDefinition, len(req.Tools))
+	copy(tools, req.Tools)
+	sort.SliceStable(tools, func(i, j int) bool {
+		return tools[i].Function.Name < tools[j].Function.Name
+	})
+	req.Tools = tools
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encoding cassette request: %w", err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, fmt.Errorf("decoding cassette request: %w", err)
+	}
+	return generic, nil
+}
+
+// CassetteProvider records calls to an inner provider into a Cassette, or
+// replays them from it.
+type CassetteProvider struct {
+	cassette *Cassette
+	inner    LLMProvider
+}
+
+func (p *CassetteProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	return p.ChatStream(ctx, messages, tools, model, options, nil)
+}
+
+// ChatStream records or replays streamed content deltas along with the
+// response. When recording a provider that cannot stream, it calls Chat.
+func (p *CassetteProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onContent StreamCallback) (*LLMResponse, error) {
+	req := CassetteRequest{Model: model, Messages: messages, Tools: tools, Options: options}
+
+	if p.cassette.mode == CassetteReplay {
+		in, err := p.cassette.replay(req)
+		if err != nil {
+			return nil, err
+		}
+		if onContent != nil {
+			for _, delta := range in.Deltas {
+				onContent(delta)
+			}
+		}
+		if in.Error != "" {
+			replayed := &replayedError{msg: in.Error}
+			if in.Status > 0 {
+				replayed.apiErr = &APIError{StatusCode: in.Status, Body: in.Error, RetryAfter: in.RetryAfter}
+			}
+			return nil, replayed
+		}
+		return in.Response, nil
+	}
+
+	if p.inner == nil {
+		return nil, fmt.Errorf("cassette %s has no provider to record", p.cassette.path)
+	}
+
+	in := CassetteInteraction{Request: req}
+	var resp *LLMResponse
+	var err error
+	if sp, ok := p.inner.(StreamingProvider); ok && onContent != nil {
+		resp, err = sp.ChatStream(ctx, messages, tools, model, options, func(delta string) {
+			in.Deltas = append(in.Deltas, delta)
+			onContent(delta)
+		})
+	} else {
+		resp, err = p.inner.Chat(ctx, messages, tools, model, options)
+	}
+	in.Response = resp
+	if err != nil {
+		in.Error = err.Error()
+		in.Status = statusCode(err)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			in.RetryAfter = apiErr.RetryAfter
+		}
+	}
+
+	if recErr := p.cassette.record(in); recErr != nil {
+		return nil, recErr
+	}
+	return resp, err
+}
+
+// GetDefaultModel returns the inner provider's default model, or when
+// replaying, the model of the first recorded request.
+func (p *CassetteProvider) GetDefaultModel() string {
+	if p.inner != nil {
+		return p.inner.GetDefaultModel()
+	}
+	p.cassette.mu.Lock()
+	defer p.cassette.mu.Unlock()
+	if len(p.cassette.interactions) > 0 {
+		return p.cassette.interactions[0].Request.Model
+	}
+	return ""
+}
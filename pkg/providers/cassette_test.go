@@ -0,0 +1,194 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// scriptedProvider streams a fixed answer per call and counts its calls.
+type scriptedProvider struct {
+	calls int
+}
+
+func (p *scriptedProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	return p.ChatStream(ctx, messages, tools, model, options, nil)
+}
+
+func (p *scriptedProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onContent StreamCallback) (*LLMResponse, error) {
+	p.calls++
+	last := messages[len(messages)-1].Content
+	if last == "fail" {
+		return nil, errors.New("upstream unavailable")
+	}
+	if last == "busy" {
+		return nil, &APIError{StatusCode: 429, Body: "slow down", RetryAfter: 30 * time.Second}
+	}
+	if onContent != nil {
+		onContent("echo: ")
+		onContent(last)
+	}
+	return &LLMResponse{Content: "echo: " + last, FinishReason: "stop", Usage: &UsageInfo{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12}}, nil
+}
+
+func (p *scriptedProvider) GetDefaultModel() string { return "scripted-model" }
+
+func TestCassette_RecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	tools := []ToolDefinition{{Type: "function", Function: ToolFunctionDefinition{Name: "read_file", Parameters: map[string]interface{}{"type": "object"}}}}
+	options := map[string]interface{}{"max_tokens": 1024, "temperature": 0.7}
+	conversation := func(p LLMProvider, system string) ([]*LLMResponse, [][]string, []error) {
+		var resps []*LLMResponse
+		var deltas [][]string
+		var errs []error
+		for _, text := range []string{"hello", "fail", "again"} {
+			msgs := []Message{{Role: "system", Content: system}, {Role: "user", Content: text}}
+			var got []string
+			resp, err := p.(StreamingProvider).ChatStream(context.Background(), msgs, tools, "test-model", options, func(d string) {
+				got = append(got, d)
+			})
+			resps = append(resps, resp)
+			deltas = append(deltas, got)
+			errs = append(errs, err)
+		}
+		return resps, deltas, errs
+	}
+
+	inner := &scriptedProvider{}
+	rec, err := OpenCassette(path, CassetteRecord)
+	if err != nil {
+		t.Fatalf("OpenCassette(record): %v", err)
+	}
+	wantResps, wantDeltas, wantErrs := conversation(rec.Provider(inner), "It is Monday.")
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if inner.calls != 3 {
+		t.Fatalf("Expected 3 recorded calls, got %d", inner.calls)
+	}
+
+	play, err := OpenCassette(path, CassetteReplay)
+	if err != nil {
+		t.Fatalf("OpenCassette(replay): %v", err)
+	}
+	// The system prompt differs (it embeds the time) but must still match
+	gotResps, gotDeltas, gotErrs := conversation(play.Provider(nil), "It is Tuesday.")
+
+	if !reflect.DeepEqual(gotResps, wantResps) {
+		t.Errorf("Replayed responses = %+v, want %+v", gotResps, wantResps)
+	}
+	if !reflect.DeepEqual(gotDeltas, wantDeltas) {
+		t.Errorf("Replayed deltas = %q, want %q", gotDeltas, wantDeltas)
+	}
+	if gotErrs[1] == nil || gotErrs[1].Error() != wantErrs[1].Error() {
+		t.Errorf("Replayed error = %v, want %v", gotErrs[1], wantErrs[1])
+	}
+	if n := play.Remaining(); n != 0 {
+		t.Errorf("Expected every interaction to be replayed, %d remain", n)
+	}
+	if err := play.Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if model := play.Provider(nil).GetDefaultModel(); model != "test-model" {
+		t.Errorf("GetDefaultModel() = %q, want the recorded model", model)
+	}
+}
+
+func TestCassette_ReplayMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	rec, err := OpenCassette(path, CassetteRecord)
+	if err != nil {
+		t.Fatalf("OpenCassette(record): %v", err)
+	}
+	if _, err := rec.Provider(&scriptedProvider{}).Chat(context.Background(), []Message{{Role: "user", Content: "hello"}}, nil, "test-model", nil); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	rec.Close()
+
+	play, err := OpenCassette(path, CassetteReplay)
+	if err != nil {
+		t.Fatalf("OpenCassette(replay): %v", err)
+	}
+	p := play.Provider(nil)
+
+	tests := []struct {
+		name  string
+		msgs  []Message
+		model string
+	}{
+		{"different message", []Message{{Role: "user", Content: "goodbye"}}, "test-model"},
+		{"different model", []Message{{Role: "user", Content: "hello"}}, "other-model"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.Chat(context.Background(), tt.msgs, nil, tt.model, nil)
+			if !errors.Is(err, ErrCassetteMismatch) {
+				t.Errorf("Expected ErrCassetteMismatch, got %v", err)
+			}
+		})
+	}
+
+	// Each interaction replays once
+	msgs := []Message{{Role: "user", Content: "hello"}}
+	if _, err := p.Chat(context.Background(), msgs, nil, "test-model", nil); err != nil {
+		t.Fatalf("Expected the recorded request to replay, got %v", err)
+	}
+	if _, err := p.Chat(context.Background(), msgs, nil, "test-model", nil); !errors.Is(err, ErrCassetteMismatch) {
+		t.Errorf("Expected a second replay to mismatch, got %v", err)
+	}
+	if err := play.Verify(); !errors.Is(err, ErrCassetteMismatch) {
+		t.Errorf("Expected Verify to report the mismatches, got %v", err)
+	}
+}
+
+func TestCassette_VerifyUnreplayed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	rec, err := OpenCassette(path, CassetteRecord)
+	if err != nil {
+		t.Fatalf("OpenCassette(record): %v", err)
+	}
+	if _, err := rec.Provider(&scriptedProvider{}).Chat(context.Background(), []Message{{Role: "user", Content: "hello"}}, nil, "test-model", nil); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	rec.Close()
+
+	play, err := OpenCassette(path, CassetteReplay)
+	if err != nil {
+		t.Fatalf("OpenCassette(replay): %v", err)
+	}
+	if err := play.Verify(); !errors.Is(err, ErrCassetteMismatch) {
+		t.Errorf("Expected Verify to report the unreplayed interaction, got %v", err)
+	}
+}
+
+// TestCassette_ReplaysErrorStatus verifies a replayed error keeps its HTTP
+// status, so it classifies and cools down like the recorded one.
+func TestCassette_ReplaysErrorStatus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	rec, err := OpenCassette(path, CassetteRecord)
+	if err != nil {
+		t.Fatalf("OpenCassette(record): %v", err)
+	}
+	msgs := []Message{{Role: "user", Content: "busy"}}
+	_, recorded := rec.Provider(&scriptedProvider{}).Chat(context.Background(), msgs, nil, "test-model", nil)
+	rec.Close()
+
+	play, err := OpenCassette(path, CassetteReplay)
+	if err != nil {
+		t.Fatalf("OpenCassette(replay): %v", err)
+	}
+	_, replayed := play.Provider(nil).Chat(context.Background(), msgs, nil, "test-model", nil)
+	if replayed == nil || replayed.Error() != recorded.Error() {
+		t.Fatalf("Replayed error = %v, want %v", replayed, recorded)
+	}
+	if got, want := ClassifyError(replayed), ClassifyError(recorded); got != want {
+		t.Errorf("ClassifyError(replayed) = %v, want %v", got, want)
+	}
+	var apiErr *APIError
+	if !errors.As(replayed, &apiErr) || apiErr.StatusCode != 429 || apiErr.RetryAfter != 30*time.Second {
+		t.Errorf("Expected the recorded status and Retry-After, got %+v", apiErr)
+	}
+}
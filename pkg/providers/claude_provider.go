@@ -9,6 +9,7 @@ import (
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/sipeed/picoclaw/pkg/auth"
+	"github.com/sipeed/picoclaw/pkg/logger"
 )
 
 type ClaudeProvider struct {
@@ -88,7 +89,7 @@ func (p *ClaudeProvider) Chat(ctx context.Context, messages []Message, tools []T
 		return nil, fmt.Errorf("claude API call: %w", err)
 	}
 
-	return parseClaudeResponse(resp), nil
+	return structuredClaudeResponse(parseClaudeResponse(resp), options), nil
 }
 
 func (p *ClaudeProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onContent StreamCallback) (*LLMResponse, error) {
@@ -129,7 +130,7 @@ func (p *ClaudeProvider) ChatStream(ctx context.Context, messages []Message, too
 		return nil, fmt.Errorf("claude streaming: %w", stream.Err())
 	}
 
-	return parseClaudeResponse(&message), nil
+	return structuredClaudeResponse(parseClaudeResponse(&message), options), nil
 }
 
 func (p *ClaudeProvider) GetDefaultModel() string {
@@ -213,6 +214,16 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 		}
 	}
 
+	// Extended thinking would need the thinking blocks of earlier tool
+	// calls sent back, which messages do not keep, so the option is dropped
+	if effort, ok := options["reasoning_effort"].(string); ok && effort != "" {
+		logger.WarnCF("provider", "Ignoring reasoning_effort, which Claude models do not support",
+			map[string]interface{}{
+				"model":            model,
+				"reasoning_effort": effort,
+			})
+	}
+
 	maxTokens := int64(4096)
 	if mt, ok := options["max_tokens"].(int); ok {
 		maxTokens = int64(mt)
@@ -225,8 +236,9 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 	}
 
 	if len(system) > 0 {
-		// Enable prompt caching on the last system block
-		system[len(system)-1].CacheControl = anthropic.CacheControlEphemeralParam{Type: "ephemeral"}
+		// Enable prompt caching on the system prompt. Later system blocks,
+		// such as the current time, change between calls and stay uncached.
+		system[0].CacheControl = anthropic.CacheControlEphemeralParam{Type: "ephemeral"}
 		params.System = system
 	}
 
@@ -234,13 +246,74 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 		params.Temperature = anthropic.Float(temp)
 	}
 
+	if topP, ok := options["top_p"].(float64); ok {
+		params.TopP = anthropic.Float(topP)
+	}
+
+	if stop, ok := options["stop"].([]string); ok && len(stop) > 0 {
+		params.StopSequences = stop
+	}
+
+	if schema := responseSchemaOption(options); schema != nil {
+		// Structured output: force a call to a tool whose input is the response
+		tools = append(tools[:len(tools):len(tools)], ToolDefinition{
+			Type: "function",
+			Function: ToolFunctionDefinition{
+				Name:        schema.Name,
+				Description: schema.Description,
+				Parameters:  schema.Schema,
+			},
+		})
+		params.ToolChoice = anthropic.ToolChoiceParamOfTool(schema.Name)
+	}
+
 	if len(tools) > 0 {
 		params.Tools = translateToolsForClaude(tools)
+		// Tool definitions come first in the cache prefix
+		if cc := params.Tools[len(params.Tools)-1].GetCacheControl(); cc != nil {
+			*cc = anthropic.NewCacheControlEphemeralParam()
+		}
 	}
 
+	markCacheBreakpoints(params.Messages)
+
 	return params, nil
 }
 
+// markCacheBreakpoints places two rolling prompt-cache breakpoints on the
+// conversation: one on the last message, which the next call in the turn
+// reads back, and one on the last message of the previous call (the one
+// before the latest assistant reply), which this call reads. Together with
+// the tools and system breakpoints this uses the API's limit of four.
+func markCacheBreakpoints(msgs []anthropic.MessageParam) {
+	if len(msgs) == 0 {
+		return
+	}
+	setCacheBreakpoint(msgs[len(msgs)-1])
+	for i := len(msgs) - 2; i > 0; i-- {
+		if msgs[i].Role == anthropic.MessageParamRoleAssistant {
+			setCacheBreakpoint(msgs[i-1])
+			return
+		}
+	}
+}
+
+// setCacheBreakpoint marks the last content block of msg for caching. The
+// API rejects breakpoints on empty text blocks, so those are skipped.
+func setCacheBreakpoint(msg anthropic.MessageParam) {
+	n := len(msg.Content)
+	if n == 0 {
+		return
+	}
+	last := msg.Content[n-1]
+	if last.OfText != nil && last.OfText.Text == "" {
+		return
+	}
+	if cc := last.GetCacheControl(); cc != nil {
+		*cc = anthropic.NewCacheControlEphemeralParam()
+	}
+}
+
 func translateToolsForClaude(tools []ToolDefinition) []anthropic.ToolUnionParam {
 	result := make([]anthropic.ToolUnionParam, 0, len(tools))
 	for _, t := range tools {
@@ -253,13 +326,7 @@ func translateToolsForClaude(tools []ToolDefinition) []anthropic.ToolUnionParam
 		if desc := t.Function.Description; desc != "" {
 			tool.Description = anthropic.String(desc)
 		}
-		if req, ok := t.Function.Parameters["required"].([]interface{}); ok {
-			required := make([]string, 0, len(req))
-			for _, r := range req {
-				if s, ok := r.(string); ok {
-					required = append(required, s)
-				}
-			}
+		if required := schemaStrings(t.Function.Parameters["required"]); required != nil {
 			tool.InputSchema.Required = required
 		}
 		result = append(result, anthropic.ToolUnionParam{OfTool: &tool})
@@ -314,6 +381,29 @@ func parseClaudeResponse(resp *anthropic.Message) *LLMResponse {
 	}
 }
 
+// structuredClaudeResponse turns the forced tool call of a structured-output
+// request back into JSON content, as other providers return it.
+func structuredClaudeResponse(resp *LLMResponse, options map[string]interface{}) *LLMResponse {
+	schema := responseSchemaOption(options)
+	if schema == nil {
+		return resp
+	}
+	for i, tc := range resp.ToolCalls {
+		if tc.Name != schema.Name {
+			continue
+		}
+		data, err := json.Marshal(tc.Arguments)
+		if err != nil {
+			return resp
+		}
+		resp.Content = string(data)
+		resp.ToolCalls = append(resp.ToolCalls[:i:i], resp.ToolCalls[i+1:]...)
+		resp.FinishReason = "stop"
+		return resp
+	}
+	return resp
+}
+
 func createClaudeTokenSource() func() (string, error) {
 	return func() (string, error) {
 		cred, err := auth.GetCredential("anthropic")
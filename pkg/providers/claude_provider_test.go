@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/anthropics/anthropic-sdk-go"
@@ -31,6 +32,48 @@ func TestBuildClaudeParams_BasicMessage(t *testing.T) {
 	}
 }
 
+func TestBuildClaudeParams_GenerationOptions(t *testing.T) {
+	messages := []Message{
+		{Role: "user", Content: "Hello"},
+	}
+	params, err := buildClaudeParams(messages, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{
+		"max_tokens":  2048,
+		"temperature": 0.2,
+		"top_p":       0.9,
+		"stop":        []string{"END"},
+	})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+	if params.MaxTokens != 2048 {
+		t.Errorf("MaxTokens = %d, want 2048", params.MaxTokens)
+	}
+	if params.Temperature.Value != 0.2 {
+		t.Errorf("Temperature = %v, want 0.2", params.Temperature.Value)
+	}
+	if params.TopP.Value != 0.9 {
+		t.Errorf("TopP = %v, want 0.9", params.TopP.Value)
+	}
+	if len(params.StopSequences) != 1 || params.StopSequences[0] != "END" {
+		t.Errorf("StopSequences = %v, want [END]", params.StopSequences)
+	}
+}
+
+func TestBuildClaudeParams_IgnoresReasoningEffort(t *testing.T) {
+	messages := []Message{
+		{Role: "user", Content: "Hello"},
+	}
+	params, err := buildClaudeParams(messages, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{
+		"reasoning_effort": "high",
+	})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+	if params.Thinking.OfEnabled != nil {
+		t.Errorf("Expected no extended thinking, got %+v", params.Thinking)
+	}
+}
+
 func TestBuildClaudeParams_SystemMessage(t *testing.T) {
 	messages := []Message{
 		{Role: "system", Content: "You are helpful"},
@@ -208,3 +251,112 @@ func createAnthropicTestClient(baseURL, token string) *anthropic.Client {
 	)
 	return &c
 }
+
+func TestBuildClaudeParams_CacheBreakpoints(t *testing.T) {
+	messages := []Message{
+		{Role: "system", Content: "You are helpful"},
+		{Role: "system", Content: "## Current Time\n2026-10-16 12:00 (Friday)"},
+		{Role: "user", Content: "Read the notes"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Name: "read_file", Arguments: map[string]interface{}{"path": "a"}}}},
+		{Role: "tool", Content: "notes", ToolCallID: "call_1"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_2", Name: "read_file", Arguments: map[string]interface{}{"path": "b"}}}},
+		{Role: "tool", Content: "more notes", ToolCallID: "call_2"},
+	}
+	tools := []ToolDefinition{
+		{Type: "function", Function: ToolFunctionDefinition{Name: "read_file", Parameters: map[string]interface{}{}}},
+		{Type: "function", Function: ToolFunctionDefinition{Name: "list_dir", Parameters: map[string]interface{}{}}},
+	}
+
+	params, err := buildClaudeParams(messages, tools, "claude-sonnet-4-5-20250929", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+
+	if params.System[0].CacheControl.Type == "" || params.System[1].CacheControl.Type != "" {
+		t.Error("Expected a cache breakpoint on the system prompt, before the current time")
+	}
+	if params.Tools[0].GetCacheControl().Type != "" || params.Tools[1].GetCacheControl().Type == "" {
+		t.Error("Expected a cache breakpoint on the last tool definition only")
+	}
+
+	// Breakpoints on the last message and on the last message of the
+	// previous call (the first tool result)
+	want := map[int]bool{2: true, 4: true}
+	for i, msg := range params.Messages {
+		marked := msg.Content[len(msg.Content)-1].GetCacheControl().Type != ""
+		if marked != want[i] {
+			t.Errorf("Messages[%d] cache breakpoint = %v, want %v", i, marked, want[i])
+		}
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatalf("json.Marshal() error: %v", err)
+	}
+	if n := strings.Count(string(data), `"cache_control"`); n != 4 {
+		t.Errorf("Expected 4 cache breakpoints in the request, got %d", n)
+	}
+}
+
+func TestClaudeProvider_StructuredOutput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqBody struct {
+			Model      string `json:"model"`
+			ToolChoice struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			} `json:"tool_choice"`
+			Tools []struct {
+				Name        string                 `json:"name"`
+				InputSchema map[string]interface{} `json:"input_schema"`
+			} `json:"tools"`
+		}
+		json.NewDecoder(r.Body).Decode(&reqBody)
+		if reqBody.ToolChoice.Type != "tool" || reqBody.ToolChoice.Name != "triage" {
+			http.Error(w, "expected the schema tool to be forced", http.StatusBadRequest)
+			return
+		}
+		if len(reqBody.Tools) != 1 || reqBody.Tools[0].Name != "triage" || reqBody.Tools[0].InputSchema["required"] == nil {
+			http.Error(w, "expected the schema as the only tool", http.StatusBadRequest)
+			return
+		}
+
+		resp := map[string]interface{}{
+			"id":          "msg_test",
+			"type":        "message",
+			"role":        "assistant",
+			"model":       reqBody.Model,
+			"stop_reason": "tool_use",
+			"content": []map[string]interface{}{
+				{"type": "tool_use", "id": "toolu_1", "name": "triage", "input": map[string]interface{}{"action": "urgent", "summary": "Server down"}},
+			},
+			"usage": map[string]interface{}{"input_tokens": 15, "output_tokens": 8},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	provider := NewClaudeProvider("test-token")
+	provider.client = createAnthropicTestClient(server.URL, "test-token")
+
+	schema := &ResponseSchema{Name: "triage", Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"action":  map[string]interface{}{"type": "string", "enum": []string{"urgent", "normal"}},
+			"summary": map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"action", "summary"},
+	}}
+	var got struct {
+		Action  string `json:"action"`
+		Summary string `json:"summary"`
+	}
+	err := ChatJSON(t.Context(), provider, []Message{{Role: "user", Content: "Triage this"}}, "claude-sonnet-4-5-20250929", nil, schema, &got)
+	if err != nil {
+		t.Fatalf("ChatJSON() error: %v", err)
+	}
+	if got.Action != "urgent" || got.Summary != "Server down" {
+		t.Errorf("ChatJSON() = %+v, want the forced tool input", got)
+	}
+}
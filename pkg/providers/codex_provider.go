@@ -9,6 +9,7 @@ import (
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
 	"github.com/openai/openai-go/v3/responses"
+	"github.com/openai/openai-go/v3/shared"
 	"github.com/sipeed/picoclaw/pkg/auth"
 )
 
@@ -75,7 +76,10 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 	for _, msg := range messages {
 		switch msg.Role {
 		case "system":
-			instructions = msg.Content
+			if instructions != "" {
+				instructions += "\n\n"
+			}
+			instructions += msg.Content
 		case "user":
 			if msg.ToolCallID != "" {
 				inputItems = append(inputItems, responses.ResponseInputItemUnionParam{
@@ -153,6 +157,14 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 		params.Temperature = openai.Opt(temp)
 	}
 
+	if topP, ok := options["top_p"].(float64); ok {
+		params.TopP = openai.Opt(topP)
+	}
+
+	if effort, ok := options["reasoning_effort"].(string); ok && effort != "" {
+		params.Reasoning = shared.ReasoningParam{Effort: shared.ReasoningEffort(effort)}
+	}
+
 	if len(tools) > 0 {
 		params.Tools = translateToolsForCodex(tools)
 	}
@@ -32,14 +32,15 @@ func TestBuildCodexParams_BasicMessage(t *testing.T) {
 func TestBuildCodexParams_SystemAsInstructions(t *testing.T) {
 	messages := []Message{
 		{Role: "system", Content: "You are helpful"},
+		{Role: "system", Content: "Be brief"},
 		{Role: "user", Content: "Hi"},
 	}
 	params := buildCodexParams(messages, nil, "gpt-4o", map[string]interface{}{})
 	if !params.Instructions.Valid() {
 		t.Fatal("Instructions should be set")
 	}
-	if params.Instructions.Or("") != "You are helpful" {
-		t.Errorf("Instructions = %q, want %q", params.Instructions.Or(""), "You are helpful")
+	if want := "You are helpful\n\nBe brief"; params.Instructions.Or("") != want {
+		t.Errorf("Instructions = %q, want %q", params.Instructions.Or(""), want)
 	}
 }
 
@@ -0,0 +1,167 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/v3"
+)
+
+// APIError is a non-200 response from a provider's HTTP API.
+type APIError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // from the Retry-After header, if any
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API request failed:\n  Status: %d\n  Body:   %s", e.StatusCode, e.Body)
+}
+
+// ErrorClass is the kind of failure behind a provider error.
+type ErrorClass int
+
+const (
+	ErrorUnknown ErrorClass = iota
+	ErrorRateLimit
+	ErrorServer
+	ErrorContextLength
+	ErrorAuth
+	ErrorContentPolicy
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorRateLimit:
+		return "rate_limit"
+	case ErrorServer:
+		return "server_error"
+	case ErrorContextLength:
+		return "context_length"
+	case ErrorAuth:
+		return "auth"
+	case ErrorContentPolicy:
+		return "content_policy"
+	default:
+		return "unknown"
+	}
+}
+
+// Phrases providers use in error bodies, checked in order after the status
+// code. Context and content policy errors often come as a plain 400.
+var errorPhrases = []struct {
+	class   ErrorClass
+	phrases []string
+}{
+	{ErrorContextLength, []string{"context_length_exceeded", "context length", "context window", "maximum context", "too many tokens", "prompt is too long", "input is too long"}},
+	{ErrorContentPolicy, []string{"content_filter", "content policy", "content_policy", "content management policy", "blocked the prompt", "blocked the response", "prohibited_content"}},
+	{ErrorRateLimit, []string{"rate limit", "rate_limit", "too many requests", "resource_exhausted", "quota"}},
+	{ErrorAuth, []string{"invalid api key", "invalid_api_key", "incorrect api key", "unauthorized", "authentication", "permission denied"}},
+	{ErrorServer, []string{"overloaded", "internal server error", "bad gateway", "service unavailable", "gateway timeout"}},
+}
+
+// ClassifyError tells what kind of failure err is, from its HTTP status
+// and message.
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ErrorUnknown
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, ep := range errorPhrases[:2] {
+		if containsAny(msg, ep.phrases) {
+			return ep.class
+		}
+	}
+
+	switch status := statusCode(err); {
+	case status == http.StatusTooManyRequests:
+		return ErrorRateLimit
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorAuth
+	case status == http.StatusRequestEntityTooLarge:
+		return ErrorContextLength
+	case status >= 500:
+		return ErrorServer
+	}
+
+	for _, ep := range errorPhrases[2:] {
+		if containsAny(msg, ep.phrases) {
+			return ep.class
+		}
+	}
+	return ErrorUnknown
+}
+
+// statusCode returns the HTTP status behind err, or 0 if there is none.
+func statusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.StatusCode
+	}
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return openaiErr.StatusCode
+	}
+	return 0
+}
+
+func containsAny(s string, phrases []string) bool {
+	for _, p := range phrases {
+		if strings.Contains(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorAction is what a fallback chain does after a failed attempt.
+type errorAction int
+
+const (
+	actionNext  errorAction = iota // try the next provider
+	actionRetry                    // retry the same provider once, then try the next
+	actionFail                     // give up and return the error
+)
+
+// errorPolicy decides how a fallback chain handles a class of errors, and
+// whether the failing provider cools down.
+type errorPolicy struct {
+	action   errorAction
+	cooldown bool
+}
+
+var errorPolicies = map[ErrorClass]errorPolicy{
+	ErrorRateLimit: {action: actionNext, cooldown: true},
+	ErrorServer:    {action: actionRetry, cooldown: true},
+	// Another model may have a larger context window; the provider is fine
+	ErrorContextLength: {action: actionNext},
+	ErrorAuth:          {action: actionNext, cooldown: true},
+	// Refusals are not routed around
+	ErrorContentPolicy: {action: actionFail},
+	ErrorUnknown:       {action: actionNext, cooldown: true},
+}
+
+// policyFor returns the policy for err. Cancellation always fails fast, and
+// other client errors (4xx) are about the request, not the provider, so the
+// provider does not cool down.
+func policyFor(ctx context.Context, err error) (ErrorClass, errorPolicy) {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return ErrorUnknown, errorPolicy{action: actionFail}
+	}
+	class := ClassifyError(err)
+	policy := errorPolicies[class]
+	if status := statusCode(err); class == ErrorUnknown && status >= 400 && status < 500 {
+		policy.cooldown = false
+	}
+	return class, policy
+}
@@ -2,81 +2,229 @@ package providers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/sipeed/picoclaw/pkg/logger"
 )
 
-// FallbackProvider wraps a primary and fallback LLMProvider.
-// If the primary fails, it transparently retries with the fallback.
+// DefaultFallbackCooldown is how long a failing provider is skipped.
+const DefaultFallbackCooldown = 60 * time.Second
+
+// fallbackFailureThreshold is how many failures in a row put a provider and
+// model into cooldown.
+const fallbackFailureThreshold = 3
+
+// FallbackTarget is one provider/model pair of a fallback chain.
+type FallbackTarget struct {
+	Name     string // provider name, for logs and cooldowns
+	Provider LLMProvider
+	Model    string // empty uses the requested model
+}
+
+// FallbackProvider tries an ordered chain of providers. How it handles a
+// failure depends on the error's class: it retries the same provider, moves
+// on to the next one, or fails fast. A provider and model that fail several
+// times in a row cool down and are skipped until the cooldown ends, unless
+// every target is cooling down. Responses name the model that answered in
+// LLMResponse.Model.
 type FallbackProvider struct {
-	primary       LLMProvider
-	fallback      LLMProvider
-	primaryModel  string
-	fallbackModel string
+	targets      []FallbackTarget
+	defaultModel string
+	cooldown     time.Duration
+
+	mu        sync.Mutex
+	failures  map[string]int       // cooldown key -> failures in a row
+	coolUntil map[string]time.Time // cooldown key -> end of its cooldown
+	now       func() time.Time
 }
 
-func NewFallbackProvider(primary LLMProvider, fallback LLMProvider, primaryModel, fallbackModel string) *FallbackProvider {
+// NewFallbackChain creates a provider that tries targets in order. The first
+// target is the primary, and defaultModel its model. A cooldown of 0 uses
+// DefaultFallbackCooldown.
+func NewFallbackChain(targets []FallbackTarget, defaultModel string, cooldown time.Duration) *FallbackProvider {
+	if cooldown <= 0 {
+		cooldown = DefaultFallbackCooldown
+	}
 	return &FallbackProvider{
-		primary:       primary,
-		fallback:      fallback,
-		primaryModel:  primaryModel,
-		fallbackModel: fallbackModel,
+		targets:      targets,
+		defaultModel: defaultModel,
+		cooldown:     cooldown,
+		failures:     make(map[string]int),
+		coolUntil:    make(map[string]time.Time),
+		now:          time.Now,
 	}
 }
 
+// NewFallbackProvider creates a chain of a primary and a single fallback.
+func NewFallbackProvider(primary LLMProvider, fallback LLMProvider, primaryModel, fallbackModel string) *FallbackProvider {
+	return NewFallbackChain([]FallbackTarget{
+		{Name: "primary", Provider: primary},
+		{Name: "fallback", Provider: fallback, Model: fallbackModel},
+	}, primaryModel, 0)
+}
+
 func (p *FallbackProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
-	resp, err := p.primary.Chat(ctx, messages, tools, model, options)
-	if err == nil {
-		return resp, nil
-	}
+	return p.run(ctx, model, func(t FallbackTarget, model string) (*LLMResponse, bool, error) {
+		resp, err := t.Provider.Chat(ctx, messages, tools, model, options)
+		return resp, false, err
+	})
+}
 
-	logger.WarnCF("fallback", fmt.Sprintf("Primary provider failed (%s), falling back to %s: %v", model, p.fallbackModel, err), nil)
+func (p *FallbackProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onContent StreamCallback) (*LLMResponse, error) {
+	return p.run(ctx, model, func(t FallbackTarget, model string) (*LLMResponse, bool, error) {
+		sp, ok := t.Provider.(StreamingProvider)
+		if !ok {
+			resp, err := t.Provider.Chat(ctx, messages, tools, model, options)
+			return resp, false, err
+		}
+		streamed := false
+		resp, err := sp.ChatStream(ctx, messages, tools, model, options, func(delta string) {
+			streamed = true
+			onContent(delta)
+		})
+		return resp, streamed, err
+	})
+}
 
-	fbResp, fbErr := p.fallback.Chat(ctx, messages, tools, p.fallbackModel, options)
-	if fbErr != nil {
-		return nil, fmt.Errorf("primary failed: %w; fallback also failed: %v", err, fbErr)
+// run calls each available target in turn until one answers. call reports
+// whether content already reached the user, after which a failure cannot
+// be retried elsewhere.
+func (p *FallbackProvider) run(ctx context.Context, model string, call func(t FallbackTarget, model string) (*LLMResponse, bool, error)) (*LLMResponse, error) {
+	var errs []error
+	for _, t := range p.available(model) {
+		targetModel := t.modelFor(model)
+
+		for attempt := 1; ; attempt++ {
+			resp, streamed, err := call(t, targetModel)
+			if err == nil {
+				p.succeeded(t, targetModel)
+				resp.Model = targetModel
+				return resp, nil
+			}
+
+			class, policy := policyFor(ctx, err)
+			logger.WarnCF("fallback", fmt.Sprintf("Provider %s failed (%s): %v", t.Name, targetModel, err),
+				map[string]interface{}{
+					"provider":    t.Name,
+					"model":       targetModel,
+					"error_class": class.String(),
+					"attempt":     attempt,
+				})
+			if policy.action == actionFail || streamed {
+				return nil, err
+			}
+			// Providers that retry themselves already backed off and retried
+			if policy.action == actionRetry && attempt == 1 && !retriesRequests(t.Provider, targetModel) {
+				continue
+			}
+			if policy.cooldown {
+				p.failed(t, targetModel, err)
+			}
+			errs = append(errs, fmt.Errorf("%s (%s): %w", t.Name, targetModel, err))
+			break
+		}
 	}
-	return fbResp, nil
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return nil, fmt.Errorf("all %d providers failed: %w", len(errs), errors.Join(errs...))
 }
 
-func (p *FallbackProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onContent StreamCallback) (*LLMResponse, error) {
-	// Try primary with streaming if supported, else fall back to Chat
-	var resp *LLMResponse
-	var err error
-	if sp, ok := p.primary.(StreamingProvider); ok {
-		resp, err = sp.ChatStream(ctx, messages, tools, model, options, onContent)
-	} else {
-		resp, err = p.primary.Chat(ctx, messages, tools, model, options)
+// modelFor returns the model the target serves a request for model with.
+func (t FallbackTarget) modelFor(model string) string {
+	if t.Model != "" {
+		return t.Model
 	}
-	if err == nil {
-		return resp, nil
+	return model
+}
+
+// cooldownKey identifies a target serving a model. Cooldowns are per model,
+// so a failing cheap model does not bench the main model of the same
+// provider.
+func cooldownKey(t FallbackTarget, model string) string {
+	return t.Name + "/" + model
+}
+
+// available returns the targets not cooling down for a request for model,
+// or every target if all of them are.
+func (p *FallbackProvider) available(model string) []FallbackTarget {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	var targets []FallbackTarget
+	for _, t := range p.targets {
+		if now.Before(p.coolUntil[cooldownKey(t, t.modelFor(model))]) {
+			continue
+		}
+		targets = append(targets, t)
 	}
+	if len(targets) == 0 {
+		return p.targets
+	}
+	return targets
+}
+
+// succeeded resets the target's failure count.
+func (p *FallbackProvider) succeeded(t FallbackTarget, model string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.failures, cooldownKey(t, model))
+}
 
-	logger.WarnCF("fallback", fmt.Sprintf("Primary provider failed (%s), falling back to %s: %v", model, p.fallbackModel, err), nil)
+// failed counts a failure of the target. After fallbackFailureThreshold in
+// a row it is skipped for the cooldown, or for as long as the provider
+// asked clients to wait, if that is longer.
+func (p *FallbackProvider) failed(t FallbackTarget, model string, err error) {
+	key := cooldownKey(t, model)
 
-	// Try fallback with streaming if supported, else fall back to Chat
-	if sp, ok := p.fallback.(StreamingProvider); ok {
-		return sp.ChatStream(ctx, messages, tools, p.fallbackModel, options, onContent)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures[key]++
+	if p.failures[key] < fallbackFailureThreshold {
+		return
 	}
-	return p.fallback.Chat(ctx, messages, tools, p.fallbackModel, options)
+	delete(p.failures, key)
+
+	cooldown := p.cooldown
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > cooldown {
+		cooldown = apiErr.RetryAfter
+	}
+	p.coolUntil[key] = p.now().Add(cooldown)
 }
 
 func (p *FallbackProvider) GetDefaultModel() string {
-	return p.primaryModel
+	return p.defaultModel
 }
 
-// Primary returns the underlying primary provider.
-func (p *FallbackProvider) Primary() LLMProvider {
-	return p.primary
+// ResolveModel returns the primary provider serving model, so model lists
+// and context windows come from the primary.
+func (p *FallbackProvider) ResolveModel(model string) (LLMProvider, string) {
+	if r, ok := p.Primary().(ModelResolver); ok {
+		return r.ResolveModel(model)
+	}
+	return p.Primary(), model
 }
 
-// Fallback returns the underlying fallback provider.
-func (p *FallbackProvider) Fallback() LLMProvider {
-	return p.fallback
+// ListModels lists the primary provider's models, or nil if it cannot list
+// them.
+func (p *FallbackProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	if lister, ok := p.Primary().(ModelLister); ok {
+		return lister.ListModels(ctx)
+	}
+	return nil, nil
+}
+
+// Primary returns the provider tried first.
+func (p *FallbackProvider) Primary() LLMProvider {
+	return p.targets[0].Provider
 }
 
-// FallbackModel returns the fallback model name.
-func (p *FallbackProvider) FallbackModel() string {
-	return p.fallbackModel
+// Targets returns the chain, the primary first.
+func (p *FallbackProvider) Targets() []FallbackTarget {
+	return p.targets
 }
@@ -0,0 +1,186 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"429", &APIError{StatusCode: 429, Body: "slow down"}, ErrorRateLimit},
+		{"503", &APIError{StatusCode: 503, Body: "try later"}, ErrorServer},
+		{"401", &APIError{StatusCode: 401, Body: "bad key"}, ErrorAuth},
+		{"context in a 400", &APIError{StatusCode: 400, Body: `{"error":{"code":"context_length_exceeded"}}`}, ErrorContextLength},
+		{"content filter in a 400", &APIError{StatusCode: 400, Body: `{"error":{"code":"content_filter"}}`}, ErrorContentPolicy},
+		{"wrapped", fmt.Errorf("calling: %w", &APIError{StatusCode: 502}), ErrorServer},
+		{"Gemini block", errors.New("Gemini blocked the response: SAFETY"), ErrorContentPolicy},
+		{"overloaded message", errors.New("anthropic: overloaded_error"), ErrorServer},
+		{"network", errors.New("dial tcp: connection refused"), ErrorUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.want {
+				t.Errorf("ClassifyError() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// failingProvider fails with each scripted error in turn, then answers with
+// its name.
+type failingProvider struct {
+	name   string
+	errs   []error
+	models []string
+}
+
+func (p *failingProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	p.models = append(p.models, model)
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return nil, err
+	}
+	return &LLMResponse{Content: p.name}, nil
+}
+
+func (p *failingProvider) GetDefaultModel() string { return "" }
+
+func TestFallbackProvider(t *testing.T) {
+	rateLimited := &APIError{StatusCode: 429}
+	serverError := &APIError{StatusCode: 500}
+	tooLong := &APIError{StatusCode: 400, Body: "prompt is too long"}
+	refused := &APIError{StatusCode: 400, Body: "content_policy_violation"}
+	badRequest := &APIError{StatusCode: 400, Body: "unknown parameter"}
+
+	tests := []struct {
+		name         string
+		primaryErrs  []error
+		backupErrs   []error
+		wantContent  string
+		wantModel    string
+		wantErr      string
+		wantCalls    int // calls to the primary
+		wantFailures int // failures counted towards the primary's cooldown
+	}{
+		{"primary answers", nil, nil, "primary", "main", "", 1, 0},
+		{"rate limit moves on", []error{rateLimited}, nil, "backup", "small", "", 1, 1},
+		{"server error retries first", []error{serverError}, nil, "primary", "main", "", 2, 0},
+		{"repeated server error moves on", []error{serverError, serverError}, nil, "backup", "small", "", 2, 1},
+		{"context too long moves on", []error{tooLong}, nil, "backup", "small", "", 1, 0},
+		{"bad request moves on", []error{badRequest}, nil, "backup", "small", "", 1, 0},
+		{"content policy fails fast", []error{refused}, nil, "", "", "content_policy_violation", 1, 0},
+		{"all fail", []error{rateLimited}, []error{rateLimited}, "", "", "all 2 providers failed", 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &failingProvider{name: "primary", errs: tt.primaryErrs}
+			backup := &failingProvider{name: "backup", errs: tt.backupErrs}
+			chain := NewFallbackChain([]FallbackTarget{
+				{Name: "a", Provider: primary},
+				{Name: "b", Provider: backup, Model: "small"},
+			}, "main", time.Minute)
+
+			resp, err := chain.Chat(t.Context(), nil, nil, "main", nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("Chat() error: %v", err)
+			} else if resp.Content != tt.wantContent || resp.Model != tt.wantModel {
+				t.Errorf("Answered %q by %q, want %q by %q", resp.Content, resp.Model, tt.wantContent, tt.wantModel)
+			}
+			if len(primary.models) != tt.wantCalls {
+				t.Errorf("Primary called %d times, want %d", len(primary.models), tt.wantCalls)
+			}
+			if failures := chain.failures["a/main"]; failures != tt.wantFailures {
+				t.Errorf("Primary failures = %d, want %d", failures, tt.wantFailures)
+			}
+		})
+	}
+}
+
+func TestFallbackProvider_Cooldown(t *testing.T) {
+	now := time.Now()
+	rateLimited := &APIError{StatusCode: 429}
+	primary := &failingProvider{name: "primary", errs: []error{rateLimited, rateLimited, rateLimited}}
+	backup := &failingProvider{name: "backup"}
+	chain := NewFallbackChain([]FallbackTarget{
+		{Name: "a", Provider: primary},
+		{Name: "b", Provider: backup},
+	}, "main", time.Minute)
+	chain.now = func() time.Time { return now }
+
+	for i := 0; i < fallbackFailureThreshold+1; i++ {
+		if _, err := chain.Chat(t.Context(), nil, nil, "main", nil); err != nil {
+			t.Fatalf("Chat() error: %v", err)
+		}
+	}
+	if len(primary.models) != fallbackFailureThreshold {
+		t.Errorf("Expected the cooling primary to be skipped, got %d calls", len(primary.models))
+	}
+
+	// Another model of the same provider is not benched
+	resp, err := chain.Chat(t.Context(), nil, nil, "cheap", nil)
+	if err != nil || resp.Content != "primary" {
+		t.Errorf("Expected the primary to serve another model, got %v, %v", resp, err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	resp, err = chain.Chat(t.Context(), nil, nil, "main", nil)
+	if err != nil || resp.Content != "primary" {
+		t.Errorf("Expected the primary back after its cooldown, got %v, %v", resp, err)
+	}
+}
+
+// retryingProvider is a failingProvider that retries requests itself.
+type retryingProvider struct{ failingProvider }
+
+func (p *retryingProvider) retriesRequests() bool { return true }
+
+func TestFallbackProvider_NoRetryOfRetryingProviders(t *testing.T) {
+	primary := &retryingProvider{failingProvider{name: "primary", errs: []error{&APIError{StatusCode: 500}}}}
+	backup := &failingProvider{name: "backup"}
+	chain := NewFallbackChain([]FallbackTarget{
+		{Name: "a", Provider: primary},
+		{Name: "b", Provider: backup},
+	}, "main", time.Minute)
+
+	resp, err := chain.Chat(t.Context(), nil, nil, "main", nil)
+	if err != nil || resp.Content != "backup" {
+		t.Fatalf("Expected the backup to answer, got %v, %v", resp, err)
+	}
+	if len(primary.models) != 1 {
+		t.Errorf("Primary called %d times, want 1", len(primary.models))
+	}
+}
+
+// streamingFailProvider streams a delta, then fails.
+type streamingFailProvider struct{ failingProvider }
+
+func (p *streamingFailProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onContent StreamCallback) (*LLMResponse, error) {
+	onContent("Hel")
+	return nil, &APIError{StatusCode: 500}
+}
+
+func TestFallbackProvider_NoFallbackAfterStreamedContent(t *testing.T) {
+	backup := &failingProvider{name: "backup"}
+	chain := NewFallbackChain([]FallbackTarget{
+		{Name: "a", Provider: &streamingFailProvider{}},
+		{Name: "b", Provider: backup},
+	}, "main", 0)
+
+	_, err := chain.ChatStream(t.Context(), nil, nil, "main", nil, func(string) {})
+	if err == nil || len(backup.models) != 0 {
+		t.Errorf("Expected the error without trying the backup, got %v with %d backup calls", err, len(backup.models))
+	}
+}
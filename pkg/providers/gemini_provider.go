@@ -0,0 +1,426 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultGeminiAPIBase = "https://generativelanguage.googleapis.com/v1beta"
+
+// geminiSkipSignature stands in for the thought signature Gemini attaches
+// to function calls. We do not keep signatures in the session history, and
+// this documented value tells the API not to validate them.
+const geminiSkipSignature = "skip_thought_signature_validator"
+
+// GeminiProvider talks to the Gemini generateContent API directly, rather
+// than through its OpenAI-compatible endpoint.
+type GeminiProvider struct {
+	apiKey     string
+	apiBase    string
+	httpClient *http.Client
+}
+
+func NewGeminiProvider(apiKey, apiBase, proxy string) *GeminiProvider {
+	if apiBase == "" {
+		apiBase = defaultGeminiAPIBase
+	}
+	client := &http.Client{
+		Timeout: 120 * time.Second,
+	}
+	if proxy != "" {
+		if proxyURL, err := url.Parse(proxy); err == nil {
+			client.Transport = &http.Transport{
+				Proxy: http.ProxyURL(proxyURL),
+			}
+		}
+	}
+
+	return &GeminiProvider{
+		apiKey:     apiKey,
+		apiBase:    strings.TrimRight(apiBase, "/"),
+		httpClient: client,
+	}
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	InlineData       *geminiInlineData       `json:"inlineData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+	ThoughtSignature string                  `json:"thoughtSignature,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFunctionCall struct {
+	ID   string                 `json:"id,omitempty"`
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	ID       string                 `json:"id,omitempty"`
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name                 string                 `json:"name"`
+	Description          string                 `json:"description,omitempty"`
+	ParametersJSONSchema map[string]interface{} `json:"parametersJsonSchema,omitempty"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent        `json:"contents"`
+	Tools             []geminiTool           `json:"tools,omitempty"`
+	GenerationConfig  map[string]interface{} `json:"generationConfig,omitempty"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata *struct {
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+		TotalTokenCount         int `json:"totalTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func (p *GeminiProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	req, err := p.newRequest(ctx, messages, tools, model, options, ":generateContent")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp, body)
+	}
+
+	var apiResponse geminiResponse
+	if err := json.Unmarshal(body, &apiResponse); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	acc := newGeminiAccumulator(nil)
+	acc.add(&apiResponse)
+	return acc.response()
+}
+
+func (p *GeminiProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onContent StreamCallback) (*LLMResponse, error) {
+	req, err := p.newRequest(ctx, messages, tools, model, options, ":streamGenerateContent?alt=sse")
+	if err != nil {
+		return nil, err
+	}
+
+	// Use a client without timeout for streaming — context handles cancellation
+	streamClient := &http.Client{Transport: p.httpClient.Transport}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newAPIError(resp, body)
+	}
+
+	acc := newGeminiAccumulator(onContent)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk); err != nil {
+			continue
+		}
+		acc.add(&chunk)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("SSE stream read error: %w", err)
+	}
+	return acc.response()
+}
+
+func (p *GeminiProvider) GetDefaultModel() string {
+	return "gemini-2.5-flash"
+}
+
+func (p *GeminiProvider) newRequest(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, method string) (*http.Request, error) {
+	jsonData, err := json.Marshal(buildGeminiRequest(messages, tools, options))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Accept "google/gemini-..." and "models/gemini-..." as well
+	model = strings.TrimPrefix(model, "google/")
+	model = strings.TrimPrefix(model, "models/")
+	endpoint := p.apiBase + "/models/" + url.PathEscape(model) + method
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("x-goog-api-key", p.apiKey)
+	}
+	return req, nil
+}
+
+// buildGeminiRequest converts messages to Gemini contents. System messages
+// become the system instruction, tool results become function responses,
+// and consecutive messages of the same role are merged, as Gemini expects
+// turns to alternate.
+func buildGeminiRequest(messages []Message, tools []ToolDefinition, options map[string]interface{}) *geminiRequest {
+	req := &geminiRequest{}
+	toolNames := make(map[string]string)
+
+	var system []geminiPart
+	for _, msg := range messages {
+		var content geminiContent
+		switch msg.Role {
+		case "system":
+			system = append(system, geminiPart{Text: msg.Content})
+			continue
+
+		case "assistant":
+			content.Role = "model"
+			if msg.Content != "" {
+				content.Parts = append(content.Parts, geminiPart{Text: msg.Content})
+			}
+			for i, tc := range msg.ToolCalls {
+				name, args := tc.Name, tc.Arguments
+				if tc.Function != nil {
+					name = tc.Function.Name
+					if len(args) == 0 && tc.Function.Arguments != "" {
+						json.Unmarshal([]byte(tc.Function.Arguments), &args)
+					}
+				}
+				toolNames[tc.ID] = name
+				part := geminiPart{FunctionCall: &geminiFunctionCall{ID: tc.ID, Name: name, Args: args}}
+				if i == 0 {
+					part.ThoughtSignature = geminiSkipSignature
+				}
+				content.Parts = append(content.Parts, part)
+			}
+
+		case "tool":
+			name := toolNames[msg.ToolCallID]
+			if name == "" {
+				name = msg.ToolCallID // the call was trimmed from the history
+			}
+			content.Role = "user"
+			content.Parts = []geminiPart{{FunctionResponse: &geminiFunctionResponse{
+				ID:       msg.ToolCallID,
+				Name:     name,
+				Response: map[string]interface{}{"content": msg.Content},
+			}}}
+
+		default:
+			content.Role = "user"
+			if msg.Content != "" {
+				content.Parts = append(content.Parts, geminiPart{Text: msg.Content})
+			}
+			for _, part := range msg.ContentParts {
+				switch part.Type {
+				case "image":
+					content.Parts = append(content.Parts, geminiPart{InlineData: &geminiInlineData{MimeType: part.MediaType, Data: part.Data}})
+				case "text":
+					content.Parts = append(content.Parts, geminiPart{Text: part.Text})
+				}
+			}
+		}
+
+		if len(content.Parts) == 0 {
+			continue
+		}
+		if n := len(req.Contents); n > 0 && req.Contents[n-1].Role == content.Role {
+			req.Contents[n-1].Parts = append(req.Contents[n-1].Parts, content.Parts...)
+		} else {
+			req.Contents = append(req.Contents, content)
+		}
+	}
+	if len(system) > 0 {
+		req.SystemInstruction = &geminiContent{Parts: system}
+	}
+
+	if len(tools) > 0 {
+		decls := make([]geminiFunctionDeclaration, 0, len(tools))
+		for _, t := range tools {
+			decls = append(decls, geminiFunctionDeclaration{
+				Name:                 t.Function.Name,
+				Description:          t.Function.Description,
+				ParametersJSONSchema: t.Function.Parameters,
+			})
+		}
+		req.Tools = []geminiTool{{FunctionDeclarations: decls}}
+	}
+
+	req.GenerationConfig = geminiGenerationConfig(options)
+	return req
+}
+
+// geminiGenerationConfig maps the generation options to Gemini's
+// generationConfig. A response schema is passed as responseJsonSchema, so
+// the output is constrained natively.
+func geminiGenerationConfig(options map[string]interface{}) map[string]interface{} {
+	cfg := make(map[string]interface{})
+	if maxTokens, ok := options["max_tokens"].(int); ok {
+		cfg["maxOutputTokens"] = maxTokens
+	}
+	if temperature, ok := options["temperature"].(float64); ok {
+		cfg["temperature"] = temperature
+	}
+	if topP, ok := options["top_p"].(float64); ok {
+		cfg["topP"] = topP
+	}
+	if stop, ok := options["stop"].([]string); ok && len(stop) > 0 {
+		cfg["stopSequences"] = stop
+	}
+	if schema := responseSchemaOption(options); schema != nil {
+		cfg["responseMimeType"] = "application/json"
+		cfg["responseJsonSchema"] = schema.Schema
+	}
+	if len(cfg) == 0 {
+		return nil
+	}
+	return cfg
+}
+
+// geminiAccumulator collects a response from one or more chunks.
+type geminiAccumulator struct {
+	onContent    StreamCallback
+	content      strings.Builder
+	toolCalls    []ToolCall
+	finishReason string
+	blockReason  string
+	usage        *UsageInfo
+}
+
+func newGeminiAccumulator(onContent StreamCallback) *geminiAccumulator {
+	return &geminiAccumulator{onContent: onContent}
+}
+
+func (a *geminiAccumulator) add(chunk *geminiResponse) {
+	if chunk.PromptFeedback != nil && chunk.PromptFeedback.BlockReason != "" {
+		a.blockReason = chunk.PromptFeedback.BlockReason
+	}
+	if u := chunk.UsageMetadata; u != nil {
+		// promptTokenCount includes the cached tokens; report them apart, as
+		// Claude does, so they are not counted twice
+		a.usage = &UsageInfo{
+			PromptTokens:         u.PromptTokenCount - u.CachedContentTokenCount,
+			CompletionTokens:     u.CandidatesTokenCount + u.ThoughtsTokenCount,
+			TotalTokens:          u.TotalTokenCount - u.CachedContentTokenCount,
+			CacheReadInputTokens: u.CachedContentTokenCount,
+		}
+	}
+	if len(chunk.Candidates) == 0 {
+		return
+	}
+
+	candidate := chunk.Candidates[0]
+	for _, part := range candidate.Content.Parts {
+		switch {
+		case part.FunctionCall != nil:
+			// Older models send no IDs; synthesized ones must not repeat
+			// across the turns of a session, or results get mismatched
+			id := part.FunctionCall.ID
+			if id == "" {
+				id = fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), len(a.toolCalls))
+			}
+			args := part.FunctionCall.Args
+			if args == nil {
+				args = map[string]interface{}{}
+			}
+			a.toolCalls = append(a.toolCalls, ToolCall{
+				ID:        id,
+				Name:      part.FunctionCall.Name,
+				Arguments: args,
+			})
+		case part.Thought:
+			// Thought summaries are not part of the answer
+		case part.Text != "":
+			a.content.WriteString(part.Text)
+			if a.onContent != nil {
+				a.onContent(part.Text)
+			}
+		}
+	}
+	if candidate.FinishReason != "" {
+		a.finishReason = candidate.FinishReason
+	}
+}
+
+func (a *geminiAccumulator) response() (*LLMResponse, error) {
+	if a.blockReason != "" {
+		return nil, fmt.Errorf("gemini blocked the prompt: %s", a.blockReason)
+	}
+
+	finishReason := "stop"
+	switch a.finishReason {
+	case "", "STOP":
+	case "MAX_TOKENS":
+		finishReason = "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		if a.content.Len() == 0 && len(a.toolCalls) == 0 {
+			return nil, fmt.Errorf("gemini blocked the response: %s", a.finishReason)
+		}
+		finishReason = "content_filter"
+	default:
+		finishReason = strings.ToLower(a.finishReason)
+	}
+	if len(a.toolCalls) > 0 && finishReason == "stop" {
+		finishReason = "tool_calls"
+	}
+
+	return &LLMResponse{
+		Content:      a.content.String(),
+		ToolCalls:    a.toolCalls,
+		FinishReason: finishReason,
+		Usage:        a.usage,
+	}, nil
+}
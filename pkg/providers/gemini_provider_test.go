@@ -0,0 +1,209 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/media"
+)
+
+func TestBuildGeminiRequest(t *testing.T) {
+	messages := []Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "What is in this picture?", ContentParts: []media.ContentPart{
+			{Type: "image", MediaType: "image/png", Data: "aGVsbG8="},
+		}},
+		{Role: "assistant", ToolCalls: []ToolCall{
+			{ID: "call_1", Type: "function", Function: &FunctionCall{Name: "read_file", Arguments: `{"path":"a.md"}`}},
+			{ID: "call_2", Name: "list_dir", Arguments: map[string]interface{}{"path": "."}},
+		}},
+		{Role: "tool", ToolCallID: "call_1", Content: "contents"},
+		{Role: "tool", ToolCallID: "call_2", Content: "a.md"},
+		{Role: "assistant", Content: "A cat."},
+	}
+	tools := []ToolDefinition{{Type: "function", Function: ToolFunctionDefinition{
+		Name: "read_file", Description: "Read a file",
+		Parameters: map[string]interface{}{"type": "object"},
+	}}}
+	options := map[string]interface{}{
+		"max_tokens":         256,
+		"temperature":        0.5,
+		OptionResponseSchema: &ResponseSchema{Name: "x", Schema: map[string]interface{}{"type": "object"}},
+	}
+
+	req := buildGeminiRequest(messages, tools, options)
+
+	if req.SystemInstruction == nil || req.SystemInstruction.Parts[0].Text != "Be brief." {
+		t.Errorf("Expected the system message as system instruction, got %+v", req.SystemInstruction)
+	}
+	var roles []string
+	for _, c := range req.Contents {
+		roles = append(roles, c.Role)
+	}
+	if !reflect.DeepEqual(roles, []string{"user", "model", "user", "model"}) {
+		t.Fatalf("Expected alternating roles with tool results merged, got %v", roles)
+	}
+
+	user := req.Contents[0].Parts
+	if len(user) != 2 || user[1].InlineData == nil || user[1].InlineData.MimeType != "image/png" {
+		t.Errorf("Expected the image as inline data, got %+v", user)
+	}
+	calls := req.Contents[1].Parts
+	if calls[0].FunctionCall.Name != "read_file" || calls[0].FunctionCall.Args["path"] != "a.md" || calls[1].FunctionCall.Name != "list_dir" {
+		t.Errorf("Unexpected function calls: %+v, %+v", calls[0].FunctionCall, calls[1].FunctionCall)
+	}
+	if calls[0].ThoughtSignature == "" {
+		t.Error("Expected a thought signature on the first function call")
+	}
+	results := req.Contents[2].Parts
+	if len(results) != 2 || results[1].FunctionResponse.Name != "list_dir" || results[1].FunctionResponse.Response["content"] != "a.md" {
+		t.Errorf("Unexpected function responses: %+v", results)
+	}
+
+	if req.Tools[0].FunctionDeclarations[0].Name != "read_file" {
+		t.Errorf("Unexpected tools: %+v", req.Tools)
+	}
+	want := map[string]interface{}{
+		"maxOutputTokens":    256,
+		"temperature":        0.5,
+		"responseMimeType":   "application/json",
+		"responseJsonSchema": map[string]interface{}{"type": "object"},
+	}
+	if !reflect.DeepEqual(req.GenerationConfig, want) {
+		t.Errorf("GenerationConfig = %v, want %v", req.GenerationConfig, want)
+	}
+}
+
+func TestGeminiProvider_Chat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/gemini-2.5-flash:generateContent" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("x-goog-api-key") != "test-key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"candidates": [{
+				"content": {"role": "model", "parts": [
+					{"text": "thinking...", "thought": true},
+					{"text": "Let me check."},
+					{"functionCall": {"name": "read_file", "args": {"path": "a.md"}}}
+				]},
+				"finishReason": "STOP"
+			}],
+			"usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 5, "thoughtsTokenCount": 3, "totalTokenCount": 20}
+		}`)
+	}))
+	defer server.Close()
+
+	provider := NewGeminiProvider("test-key", server.URL, "")
+	resp, err := provider.Chat(t.Context(), []Message{{Role: "user", Content: "Read a.md"}}, nil, "google/gemini-2.5-flash", nil)
+	if err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+	if resp.Content != "Let me check." || resp.FinishReason != "tool_calls" {
+		t.Errorf("Got content %q, finish reason %q", resp.Content, resp.FinishReason)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].ID == "" || resp.ToolCalls[0].Name != "read_file" || resp.ToolCalls[0].Arguments["path"] != "a.md" {
+		t.Errorf("Unexpected tool calls: %+v", resp.ToolCalls)
+	}
+	if resp.Usage == nil || resp.Usage.PromptTokens != 12 || resp.Usage.CompletionTokens != 8 {
+		t.Errorf("Unexpected usage: %+v", resp.Usage)
+	}
+
+	// Calls of later turns get IDs of their own
+	again, err := provider.Chat(t.Context(), []Message{{Role: "user", Content: "Read a.md"}}, nil, "google/gemini-2.5-flash", nil)
+	if err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+	if again.ToolCalls[0].ID == resp.ToolCalls[0].ID {
+		t.Errorf("Expected a new tool call ID, got %q twice", resp.ToolCalls[0].ID)
+	}
+}
+
+func TestGeminiAccumulator_CachedTokens(t *testing.T) {
+	var chunk geminiResponse
+	err := json.Unmarshal([]byte(`{"usageMetadata": {"promptTokenCount": 1000, "cachedContentTokenCount": 800, "candidatesTokenCount": 50, "totalTokenCount": 1050}}`), &chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc := newGeminiAccumulator(nil)
+	acc.add(&chunk)
+
+	want := UsageInfo{PromptTokens: 200, CompletionTokens: 50, TotalTokens: 250, CacheReadInputTokens: 800}
+	if acc.usage == nil || *acc.usage != want {
+		t.Errorf("usage = %+v, want %+v", acc.usage, want)
+	}
+}
+
+func TestGeminiProvider_ChatStream(t *testing.T) {
+	chunks := []string{
+		`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hel"}]}}]}`,
+		`{"candidates": [{"content": {"role": "model", "parts": [{"text": "lo"}]}, "finishReason": "MAX_TOKENS"}], "usageMetadata": {"promptTokenCount": 4, "candidatesTokenCount": 2, "totalTokenCount": 6}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/gemini-2.5-pro:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+	}))
+	defer server.Close()
+
+	provider := NewGeminiProvider("test-key", server.URL, "")
+	var deltas []string
+	resp, err := provider.ChatStream(t.Context(), []Message{{Role: "user", Content: "Hi"}}, nil, "gemini-2.5-pro", nil, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+	if resp.Content != "Hello" || resp.FinishReason != "length" || !reflect.DeepEqual(deltas, []string{"Hel", "lo"}) {
+		t.Errorf("Got content %q, finish reason %q, deltas %v", resp.Content, resp.FinishReason, deltas)
+	}
+	if resp.Usage == nil || resp.Usage.TotalTokens != 6 {
+		t.Errorf("Unexpected usage: %+v", resp.Usage)
+	}
+}
+
+func TestGeminiProvider_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"blocked prompt", http.StatusOK, `{"promptFeedback": {"blockReason": "SAFETY"}}`, "blocked the prompt: SAFETY"},
+		{"blocked response", http.StatusOK, `{"candidates": [{"finishReason": "PROHIBITED_CONTENT"}]}`, "blocked the response: PROHIBITED_CONTENT"},
+		{"API error", http.StatusTooManyRequests, `{"error": {"code": 429}}`, "Status: 429"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req geminiRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Errorf("Invalid request body: %v", err)
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			_, err := NewGeminiProvider("k", server.URL, "").Chat(t.Context(), []Message{{Role: "user", Content: "Hi"}}, nil, "gemini-2.5-flash", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -20,12 +20,14 @@ import (
 
 	"github.com/sipeed/picoclaw/pkg/auth"
 	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
 )
 
 type HTTPProvider struct {
 	apiKey     string
 	apiBase    string
 	httpClient *http.Client
+	retry      RetryPolicy
 }
 
 func NewHTTPProvider(apiKey, apiBase, proxy string) *HTTPProvider {
@@ -49,6 +51,15 @@ func NewHTTPProvider(apiKey, apiBase, proxy string) *HTTPProvider {
 	}
 }
 
+// SetRetryPolicy makes p retry failed requests according to policy.
+func (p *HTTPProvider) SetRetryPolicy(policy RetryPolicy) {
+	p.retry = policy
+}
+
+func (p *HTTPProvider) retriesRequests() bool {
+	return p.retry.MaxRetries > 0
+}
+
 // prepareMessages converts messages with ContentParts to OpenAI multimodal format.
 // Messages without ContentParts are passed through unchanged.
 func prepareMessages(messages []Message) []interface{} {
@@ -113,38 +124,22 @@ func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []Too
 		requestBody["tool_choice"] = "auto"
 	}
 
-	if maxTokens, ok := options["max_tokens"].(int); ok {
-		lowerModel := strings.ToLower(model)
-		if strings.Contains(lowerModel, "glm") || strings.Contains(lowerModel, "o1") {
-			requestBody["max_completion_tokens"] = maxTokens
-		} else {
-			requestBody["max_tokens"] = maxTokens
-		}
-	}
-
-	if temperature, ok := options["temperature"].(float64); ok {
-		lowerModel := strings.ToLower(model)
-		// Kimi k2 models only support temperature=1
-		if strings.Contains(lowerModel, "kimi") && strings.Contains(lowerModel, "k2") {
-			requestBody["temperature"] = 1.0
-		} else {
-			requestBody["temperature"] = temperature
-		}
-	}
+	applyGenerationOptions(requestBody, model, options)
 
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", p.apiBase+"/chat/completions", bytes.NewReader(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
+	return withRetries(ctx, p.retry, model, func() (*LLMResponse, error) {
+		return p.chatOnce(ctx, jsonData)
+	})
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	if p.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+func (p *HTTPProvider) chatOnce(ctx context.Context, jsonData []byte) (*LLMResponse, error) {
+	req, err := p.newRequest(ctx, jsonData)
+	if err != nil {
+		return nil, err
 	}
 
 	resp, err := p.httpClient.Do(req)
@@ -159,12 +154,59 @@ func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []Too
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed:\n  Status: %d\n  Body:   %s", resp.StatusCode, string(body))
+		return nil, newAPIError(resp, body)
 	}
 
 	return p.parseResponse(body)
 }
 
+// applyGenerationOptions copies the generation options (max_tokens,
+// temperature, top_p, stop, reasoning_effort, response_schema) into an
+// OpenAI-compatible request body, adjusting for models with non-standard
+// parameters.
+func applyGenerationOptions(requestBody map[string]interface{}, model string, options map[string]interface{}) {
+	lowerModel := strings.ToLower(model)
+
+	if maxTokens, ok := options["max_tokens"].(int); ok {
+		if strings.Contains(lowerModel, "glm") || strings.Contains(lowerModel, "o1") {
+			requestBody["max_completion_tokens"] = maxTokens
+		} else {
+			requestBody["max_tokens"] = maxTokens
+		}
+	}
+
+	if temperature, ok := options["temperature"].(float64); ok {
+		// Kimi k2 models only support temperature=1
+		if strings.Contains(lowerModel, "kimi") && strings.Contains(lowerModel, "k2") {
+			requestBody["temperature"] = 1.0
+		} else {
+			requestBody["temperature"] = temperature
+		}
+	}
+
+	if topP, ok := options["top_p"].(float64); ok {
+		requestBody["top_p"] = topP
+	}
+
+	if stop, ok := options["stop"].([]string); ok && len(stop) > 0 {
+		requestBody["stop"] = stop
+	}
+
+	if effort, ok := options["reasoning_effort"].(string); ok && effort != "" {
+		requestBody["reasoning_effort"] = effort
+	}
+
+	if schema := responseSchemaOption(options); schema != nil {
+		requestBody["response_format"] = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   schema.Name,
+				"schema": schema.Schema,
+			},
+		}
+	}
+}
+
 func (p *HTTPProvider) parseResponse(body []byte) (*LLMResponse, error) {
 	var apiResponse struct {
 		Choices []struct {
@@ -259,41 +301,28 @@ func (p *HTTPProvider) ChatStream(ctx context.Context, messages []Message, tools
 		requestBody["tool_choice"] = "auto"
 	}
 
-	if maxTokens, ok := options["max_tokens"].(int); ok {
-		lowerModel := strings.ToLower(model)
-		if strings.Contains(lowerModel, "glm") || strings.Contains(lowerModel, "o1") {
-			requestBody["max_completion_tokens"] = maxTokens
-		} else {
-			requestBody["max_tokens"] = maxTokens
-		}
-	}
-
-	if temperature, ok := options["temperature"].(float64); ok {
-		lowerModel := strings.ToLower(model)
-		if strings.Contains(lowerModel, "kimi") && strings.Contains(lowerModel, "k2") {
-			requestBody["temperature"] = 1.0
-		} else {
-			requestBody["temperature"] = temperature
-		}
-	}
+	applyGenerationOptions(requestBody, model, options)
 
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", p.apiBase+"/chat/completions", bytes.NewReader(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
+	// A stream that drops before its first token is retried like a failed
+	// request; once content reached the caller it is not
+	return withRetries(ctx, p.retry, model, func() (*LLMResponse, error) {
+		return p.chatStreamOnce(ctx, jsonData, onContent)
+	})
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	if p.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+func (p *HTTPProvider) chatStreamOnce(ctx context.Context, jsonData []byte, onContent StreamCallback) (*LLMResponse, error) {
+	req, err := p.newRequest(ctx, jsonData)
+	if err != nil {
+		return nil, err
 	}
 
 	// Use a client without timeout for streaming — context handles cancellation
-	streamClient := &http.Client{}
+	streamClient := &http.Client{Transport: p.httpClient.Transport}
 	resp, err := streamClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
@@ -302,12 +331,26 @@ func (p *HTTPProvider) ChatStream(ctx context.Context, messages []Message, tools
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed:\n  Status: %d\n  Body:   %s", resp.StatusCode, string(body))
+		return nil, newAPIError(resp, body)
 	}
 
 	return p.parseSSEStream(resp.Body, onContent)
 }
 
+// newRequest creates a chat completions request with body jsonData.
+func (p *HTTPProvider) newRequest(ctx context.Context, jsonData []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiBase+"/chat/completions", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	return req, nil
+}
+
 func (p *HTTPProvider) parseSSEStream(reader io.Reader, onContent StreamCallback) (*LLMResponse, error) {
 	scanner := bufio.NewScanner(reader)
 
@@ -322,6 +365,7 @@ func (p *HTTPProvider) parseSSEStream(reader io.Reader, onContent StreamCallback
 		Arguments string
 	}
 	toolCalls := make(map[int]*toolCallAcc)
+	done := false
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -331,6 +375,7 @@ func (p *HTTPProvider) parseSSEStream(reader io.Reader, onContent StreamCallback
 		}
 		data := strings.TrimPrefix(line, "data: ")
 		if data == "[DONE]" {
+			done = true
 			break
 		}
 
@@ -390,9 +435,16 @@ func (p *HTTPProvider) parseSSEStream(reader io.Reader, onContent StreamCallback
 		}
 	}
 
+	started := fullContent != "" || len(toolCalls) > 0
 	if err := scanner.Err(); err != nil {
+		if !started {
+			return nil, fmt.Errorf("%w: %v", errStreamDropped, err)
+		}
 		return nil, fmt.Errorf("SSE stream read error: %w", err)
 	}
+	if !done && !started && finishReason == "" {
+		return nil, errStreamDropped
+	}
 
 	// Assemble tool calls
 	var resultToolCalls []ToolCall
@@ -461,7 +513,27 @@ func createCodexAuthProvider() (LLMProvider, error) {
 	return NewCodexProviderWithTokenSource(cred.AccessToken, cred.AccountID, createCodexTokenSource()), nil
 }
 
+// CreateProvider creates the provider for cfg. With named instances
+// configured it routes models between them; otherwise it is the provider
+// the fixed provider fields select.
 func CreateProvider(cfg *config.Config) (LLMProvider, error) {
+	if len(cfg.Providers.Instances) > 0 {
+		return NewInstanceRouter(cfg)
+	}
+	return createLegacyProvider(cfg)
+}
+
+func createLegacyProvider(cfg *config.Config) (LLMProvider, error) {
+	inst, err := legacyInstance(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return BuildInstance(inst, cfg)
+}
+
+// legacyInstance describes the provider selected by the fixed provider
+// fields, from agents.defaults.provider or else the model name.
+func legacyInstance(cfg *config.Config) (config.ProviderInstance, error) {
 	model := cfg.Agents.Defaults.Model
 	providerName := strings.ToLower(cfg.Agents.Defaults.Provider)
 
@@ -483,7 +555,7 @@ func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 		case "openai", "gpt":
 			if cfg.Providers.OpenAI.APIKey != "" || cfg.Providers.OpenAI.AuthMethod != "" {
 				if cfg.Providers.OpenAI.AuthMethod == "oauth" || cfg.Providers.OpenAI.AuthMethod == "token" {
-					return createCodexAuthProvider()
+					return config.ProviderInstance{Kind: "openai", AuthMethod: cfg.Providers.OpenAI.AuthMethod}, nil
 				}
 				apiKey = cfg.Providers.OpenAI.APIKey
 				apiBase = cfg.Providers.OpenAI.APIBase
@@ -494,7 +566,7 @@ func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 		case "anthropic", "claude":
 			if cfg.Providers.Anthropic.APIKey != "" || cfg.Providers.Anthropic.AuthMethod != "" {
 				if cfg.Providers.Anthropic.AuthMethod == "oauth" || cfg.Providers.Anthropic.AuthMethod == "token" {
-					return createClaudeAuthProvider()
+					return config.ProviderInstance{Kind: "anthropic", AuthMethod: cfg.Providers.Anthropic.AuthMethod}, nil
 				}
 				apiKey = cfg.Providers.Anthropic.APIKey
 				apiBase = cfg.Providers.Anthropic.APIBase
@@ -521,12 +593,10 @@ func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 			}
 		case "gemini", "google":
 			if cfg.Providers.Gemini.APIKey != "" {
-				apiKey = cfg.Providers.Gemini.APIKey
-				apiBase = cfg.Providers.Gemini.APIBase
-				if apiBase == "" {
-					apiBase = "https://generativelanguage.googleapis.com/v1beta"
-				}
+				return providerInstance("gemini", cfg.Providers.Gemini), nil
 			}
+		case "ollama":
+			return providerInstance("ollama", cfg.Providers.Ollama), nil
 		case "vllm":
 			if cfg.Providers.VLLM.APIBase != "" {
 				apiKey = cfg.Providers.VLLM.APIKey
@@ -541,11 +611,7 @@ func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 				}
 			}
 		case "claude-cli", "claudecode", "claude-code":
-			workspace := cfg.Agents.Defaults.Workspace
-			if workspace == "" {
-				workspace = "."
-			}
-			return NewClaudeCliProvider(workspace), nil
+			return config.ProviderInstance{Kind: "claude-cli"}, nil
 		case "deepseek":
 			if cfg.Providers.DeepSeek.APIKey != "" {
 				apiKey = cfg.Providers.DeepSeek.APIKey
@@ -563,7 +629,7 @@ func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 			} else {
 				apiBase = "localhost:4321"
 			}
-			return NewGitHubCopilotProvider(apiBase, cfg.Providers.GitHubCopilot.ConnectMode, model)
+			return config.ProviderInstance{Kind: "github-copilot", APIBase: apiBase, ConnectMode: cfg.Providers.GitHubCopilot.ConnectMode}, nil
 
 		case "minimax":
 			if cfg.Providers.MiniMax.APIKey != "" {
@@ -599,7 +665,7 @@ func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 
 		case (strings.Contains(lowerModel, "claude") || strings.HasPrefix(model, "anthropic/")) && (cfg.Providers.Anthropic.APIKey != "" || cfg.Providers.Anthropic.AuthMethod != ""):
 			if cfg.Providers.Anthropic.AuthMethod == "oauth" || cfg.Providers.Anthropic.AuthMethod == "token" {
-				return createClaudeAuthProvider()
+				return config.ProviderInstance{Kind: "anthropic", AuthMethod: cfg.Providers.Anthropic.AuthMethod}, nil
 			}
 			apiKey = cfg.Providers.Anthropic.APIKey
 			apiBase = cfg.Providers.Anthropic.APIBase
@@ -610,7 +676,7 @@ func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 
 		case (strings.Contains(lowerModel, "gpt") || strings.HasPrefix(model, "openai/")) && (cfg.Providers.OpenAI.APIKey != "" || cfg.Providers.OpenAI.AuthMethod != ""):
 			if cfg.Providers.OpenAI.AuthMethod == "oauth" || cfg.Providers.OpenAI.AuthMethod == "token" {
-				return createCodexAuthProvider()
+				return config.ProviderInstance{Kind: "openai", AuthMethod: cfg.Providers.OpenAI.AuthMethod}, nil
 			}
 			apiKey = cfg.Providers.OpenAI.APIKey
 			apiBase = cfg.Providers.OpenAI.APIBase
@@ -620,12 +686,7 @@ func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 			}
 
 		case (strings.Contains(lowerModel, "gemini") || strings.HasPrefix(model, "google/")) && cfg.Providers.Gemini.APIKey != "":
-			apiKey = cfg.Providers.Gemini.APIKey
-			apiBase = cfg.Providers.Gemini.APIBase
-			proxy = cfg.Providers.Gemini.Proxy
-			if apiBase == "" {
-				apiBase = "https://generativelanguage.googleapis.com/v1beta"
-			}
+			return providerInstance("gemini", cfg.Providers.Gemini), nil
 
 		case (strings.Contains(lowerModel, "glm") || strings.Contains(lowerModel, "zhipu") || strings.Contains(lowerModel, "zai")) && cfg.Providers.Zhipu.APIKey != "":
 			apiKey = cfg.Providers.Zhipu.APIKey
@@ -659,6 +720,9 @@ func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 				apiBase = "https://api.minimax.io/v1"
 			}
 
+		case strings.HasPrefix(model, "ollama/"):
+			return providerInstance("ollama", cfg.Providers.Ollama), nil
+
 		case cfg.Providers.VLLM.APIBase != "":
 			apiKey = cfg.Providers.VLLM.APIKey
 			apiBase = cfg.Providers.VLLM.APIBase
@@ -674,49 +738,98 @@ func CreateProvider(cfg *config.Config) (LLMProvider, error) {
 					apiBase = "https://openrouter.ai/api/v1"
 				}
 			} else {
-				return nil, fmt.Errorf("no API key configured for model: %s", model)
+				return config.ProviderInstance{}, fmt.Errorf("no API key configured for model: %s", model)
 			}
 		}
 	}
 
 	if apiKey == "" && !strings.HasPrefix(model, "bedrock/") {
-		return nil, fmt.Errorf("no API key configured for provider (model: %s)", model)
+		return config.ProviderInstance{}, fmt.Errorf("no API key configured for provider (model: %s)", model)
 	}
 
 	if apiBase == "" {
-		return nil, fmt.Errorf("no API base configured for provider (model: %s)", model)
+		return config.ProviderInstance{}, fmt.Errorf("no API base configured for provider (model: %s)", model)
 	}
 
-	return NewHTTPProvider(apiKey, apiBase, proxy), nil
+	return config.ProviderInstance{Kind: "openai", APIKey: apiKey, APIBase: apiBase, Proxy: proxy}, nil
 }
 
-// CreateProviderWithFallback creates a provider wrapped with an optional fallback.
-// If fallback_provider and fallback_model are configured, failures on the primary
-// provider automatically retry with the fallback.
+// CreateProviderWithFallback creates a provider wrapped with an optional
+// fallback chain, from fallback_provider/fallback_model and fallbacks. A
+// fallback may name a provider, a named instance, or an "instance/model"
+// model; with neither the primary provider serves the fallback model.
+// Fallbacks that cannot be created are left out.
 func CreateProviderWithFallback(cfg *config.Config) (LLMProvider, error) {
 	primary, err := CreateProvider(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	fbProvider := cfg.Agents.Defaults.FallbackProvider
-	fbModel := cfg.Agents.Defaults.FallbackModel
-	if fbProvider == "" || fbModel == "" {
+	chain := cfg.Agents.Defaults.FallbackChain()
+	if len(chain) == 0 {
 		return primary, nil
 	}
 
+	targets := []FallbackTarget{{Name: primaryName(cfg, primary), Provider: primary}}
+	for _, fb := range chain {
+		target, err := createFallbackTarget(cfg, primary, fb)
+		if err != nil {
+			logger.WarnCF("fallback", "Skipping fallback that cannot be created",
+				map[string]interface{}{
+					"provider": fb.Provider,
+					"model":    fb.Model,
+					"error":    err.Error(),
+				})
+			continue
+		}
+		targets = append(targets, target)
+	}
+	if len(targets) == 1 {
+		return primary, nil
+	}
+
+	cooldown := time.Duration(cfg.Agents.Defaults.FallbackCooldown) * time.Second
+	return NewFallbackChain(targets, cfg.Agents.Defaults.Model, cooldown), nil
+}
+
+// primaryName names the primary provider for cooldowns and logs.
+func primaryName(cfg *config.Config, primary LLMProvider) string {
+	if router, ok := primary.(*InstanceRouter); ok {
+		if name, ok := router.instanceName(cfg.Agents.Defaults.Model); ok {
+			return name
+		}
+	}
+	if cfg.Agents.Defaults.Provider != "" {
+		return cfg.Agents.Defaults.Provider
+	}
+	return "primary"
+}
+
+func createFallbackTarget(cfg *config.Config, primary LLMProvider, fb config.FallbackConfig) (FallbackTarget, error) {
+	if router, ok := primary.(*InstanceRouter); ok {
+		if model, ok := router.instanceModel(fb.Provider, fb.Model); ok {
+			name, _ := router.instanceName(model)
+			return FallbackTarget{Name: name, Provider: router, Model: model}, nil
+		}
+	}
+	if fb.Provider == "" {
+		return FallbackTarget{Name: primaryName(cfg, primary), Provider: primary, Model: fb.Model}, nil
+	}
+
 	// Build a temporary config with the fallback as the primary to reuse CreateProvider
-	fbCfg := *cfg
-	fbCfg.Agents.Defaults.Provider = fbProvider
-	fbCfg.Agents.Defaults.Model = fbModel
+	fbCfg, err := cfg.Clone()
+	if err != nil {
+		return FallbackTarget{}, err
+	}
+	fbCfg.Agents.Defaults.Provider = fb.Provider
+	fbCfg.Agents.Defaults.Model = fb.Model
 	fbCfg.Agents.Defaults.FallbackProvider = "" // prevent recursion
 	fbCfg.Agents.Defaults.FallbackModel = ""
+	fbCfg.Agents.Defaults.Fallbacks = nil
 
-	fallback, err := CreateProvider(&fbCfg)
+	provider, err := CreateProvider(fbCfg)
 	if err != nil {
-		// Fallback creation failed — just use primary without fallback
-		return primary, nil
+		return FallbackTarget{}, err
 	}
-
-	return NewFallbackProvider(primary, fallback, cfg.Agents.Defaults.Model, fbModel), nil
+	return FallbackTarget{Name: fb.Provider, Provider: provider, Model: fb.Model}, nil
 }
@@ -0,0 +1,228 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestApplyGenerationOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		model   string
+		options map[string]interface{}
+		want    map[string]interface{}
+	}{
+		{
+			name:    "no options",
+			model:   "gpt-4o",
+			options: nil,
+			want:    map[string]interface{}{},
+		},
+		{
+			name:  "all options",
+			model: "gpt-4o",
+			options: map[string]interface{}{
+				"max_tokens":       1024,
+				"temperature":      0.2,
+				"top_p":            0.9,
+				"stop":             []string{"END"},
+				"reasoning_effort": "low",
+			},
+			want: map[string]interface{}{
+				"max_tokens":       1024,
+				"temperature":      0.2,
+				"top_p":            0.9,
+				"stop":             []string{"END"},
+				"reasoning_effort": "low",
+			},
+		},
+		{
+			name:    "glm uses max_completion_tokens",
+			model:   "glm-4.7",
+			options: map[string]interface{}{"max_tokens": 1024},
+			want:    map[string]interface{}{"max_completion_tokens": 1024},
+		},
+		{
+			name:    "kimi k2 forces temperature 1",
+			model:   "kimi-k2-0905",
+			options: map[string]interface{}{"temperature": 0.2},
+			want:    map[string]interface{}{"temperature": 1.0},
+		},
+		{
+			name:  "response schema",
+			model: "gpt-4o",
+			options: map[string]interface{}{
+				OptionResponseSchema: &ResponseSchema{Name: "facts", Schema: map[string]interface{}{"type": "object"}},
+			},
+			want: map[string]interface{}{
+				"response_format": map[string]interface{}{
+					"type": "json_schema",
+					"json_schema": map[string]interface{}{
+						"name":   "facts",
+						"schema": map[string]interface{}{"type": "object"},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]interface{}{}
+			applyGenerationOptions(body, tt.model, tt.options)
+			if !reflect.DeepEqual(body, tt.want) {
+				t.Errorf("applyGenerationOptions() = %v, want %v", body, tt.want)
+			}
+		})
+	}
+}
+
+// flakyServer fails the first len(failures) requests with the given
+// statuses, then answers. A status of 0 drops the connection instead.
+func flakyServer(t *testing.T, failures []int, answer func(w http.ResponseWriter)) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		if n <= len(failures) {
+			switch status := failures[n-1]; status {
+			case 0:
+				panic(http.ErrAbortHandler)
+			case http.StatusTooManyRequests:
+				w.Header().Set("Retry-After", "120")
+				fallthrough
+			default:
+				http.Error(w, "try again", status)
+			}
+			return
+		}
+		answer(w)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func answerJSON(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"choices":[{"message":{"content":"ok"},"finish_reason":"stop"}]}`)
+}
+
+func answerStream(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"o\"}}]}\n\n")
+	fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"k\"},\"finish_reason\":\"stop\"}]}\n\n")
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+var testRetryPolicy = RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+func TestHTTPProvider_ChatRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  []int
+		wantErr   string
+		wantCalls int32
+	}{
+		{"server errors are retried", []int{http.StatusBadGateway, http.StatusServiceUnavailable}, "", 3},
+		{"dropped connections are retried", []int{0}, "", 2},
+		{"retries run out", []int{500, 500, 500}, "Status: 500", 3},
+		{"client errors are not retried", []int{http.StatusBadRequest}, "Status: 400", 1},
+		{"a Retry-After past the longest delay is not waited for", []int{http.StatusTooManyRequests}, "Status: 429", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, calls := flakyServer(t, tt.failures, answerJSON)
+			p := NewHTTPProvider("k", server.URL, "")
+			p.SetRetryPolicy(testRetryPolicy)
+
+			resp, err := p.Chat(t.Context(), []Message{{Role: "user", Content: "Hi"}}, nil, "m", nil)
+			if tt.wantErr == "" && (err != nil || resp.Content != "ok") {
+				t.Errorf("Chat() = %+v, %v", resp, err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("Server called %d times, want %d", calls.Load(), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestHTTPProvider_ChatStreamRetries(t *testing.T) {
+	t.Run("a stream dropped before the first token is retried", func(t *testing.T) {
+		dropEmpty := func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/event-stream")
+		}
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				dropEmpty(w)
+				return
+			}
+			answerStream(w)
+		}))
+		defer server.Close()
+
+		p := NewHTTPProvider("k", server.URL, "")
+		p.SetRetryPolicy(testRetryPolicy)
+		var deltas []string
+		resp, err := p.ChatStream(t.Context(), []Message{{Role: "user", Content: "Hi"}}, nil, "m", nil, func(d string) {
+			deltas = append(deltas, d)
+		})
+		if err != nil || resp.Content != "ok" || !reflect.DeepEqual(deltas, []string{"o", "k"}) {
+			t.Errorf("ChatStream() = %+v, %v with deltas %v", resp, err, deltas)
+		}
+		if calls.Load() != 2 {
+			t.Errorf("Server called %d times, want 2", calls.Load())
+		}
+	})
+
+	t.Run("a stream dropped after the first token is not retried", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"o\"}}]}\n\n")
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}))
+		defer server.Close()
+
+		p := NewHTTPProvider("k", server.URL, "")
+		p.SetRetryPolicy(testRetryPolicy)
+		_, err := p.ChatStream(t.Context(), []Message{{Role: "user", Content: "Hi"}}, nil, "m", nil, func(string) {})
+		if err == nil || calls.Load() != 1 {
+			t.Errorf("Expected one failed attempt, got %d calls and error %v", calls.Load(), err)
+		}
+	})
+
+	t.Run("failed stream requests are retried", func(t *testing.T) {
+		server, calls := flakyServer(t, []int{http.StatusServiceUnavailable}, answerStream)
+		p := NewHTTPProvider("k", server.URL, "")
+		p.SetRetryPolicy(testRetryPolicy)
+		resp, err := p.ChatStream(t.Context(), []Message{{Role: "user", Content: "Hi"}}, nil, "m", nil, func(string) {})
+		if err != nil || resp.Content != "ok" || calls.Load() != 2 {
+			t.Errorf("ChatStream() = %+v, %v after %d calls", resp, err, calls.Load())
+		}
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("30"); got != 30*time.Second {
+		t.Errorf("parseRetryAfter(30) = %v", got)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 50*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter(%s) = %v", date, got)
+	}
+	if got := parseRetryAfter("soon"); got != 0 {
+		t.Errorf("parseRetryAfter(soon) = %v", got)
+	}
+}
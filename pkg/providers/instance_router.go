@@ -0,0 +1,158 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// InstanceRouter sends each model to the named provider instance serving it.
+// "name/model" selects instance name, and a model alias selects the first
+// instance that defines it. Other models go to the default provider: the
+// instance agents.defaults.provider names, else the provider the fixed
+// provider fields select, else the first instance.
+type InstanceRouter struct {
+	order     []string
+	instances map[string]LLMProvider
+	aliases   map[string]map[string]string
+	def       LLMProvider
+}
+
+// NewInstanceRouter builds every instance configured in cfg.
+func NewInstanceRouter(cfg *config.Config) (*InstanceRouter, error) {
+	r := &InstanceRouter{
+		instances: make(map[string]LLMProvider),
+		aliases:   make(map[string]map[string]string),
+	}
+	for _, inst := range cfg.Providers.Instances {
+		if inst.Name == "" || strings.Contains(inst.Name, "/") {
+			return nil, fmt.Errorf("provider instance name %q must be non-empty and contain no '/'", inst.Name)
+		}
+		if _, dup := r.instances[inst.Name]; dup {
+			return nil, fmt.Errorf("duplicate provider instance %q", inst.Name)
+		}
+		p, err := BuildInstance(inst, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("provider instance %s: %w", inst.Name, err)
+		}
+		r.order = append(r.order, inst.Name)
+		r.instances[inst.Name] = p
+		r.aliases[inst.Name] = inst.Models
+	}
+
+	if p, ok := r.instances[cfg.Agents.Defaults.Provider]; ok {
+		r.def = p
+		return r, nil
+	}
+	p, err := createLegacyProvider(cfg)
+	if err != nil {
+		logger.DebugCF("provider", "No fixed provider configured, defaulting to the first instance",
+			map[string]interface{}{
+				"instance": r.order[0],
+				"reason":   err.Error(),
+			})
+		p = r.instances[r.order[0]]
+	}
+	r.def = p
+	return r, nil
+}
+
+// ResolveModel returns the provider serving model and the model ID to send it.
+func (r *InstanceRouter) ResolveModel(model string) (LLMProvider, string) {
+	if name, rest, ok := strings.Cut(model, "/"); ok {
+		if p, ok := r.instances[name]; ok {
+			if id, ok := r.aliases[name][rest]; ok {
+				return p, id
+			}
+			return p, rest
+		}
+	}
+	for _, name := range r.order {
+		if id, ok := r.aliases[name][model]; ok {
+			return r.instances[name], id
+		}
+	}
+	return r.def, model
+}
+
+// instanceModel returns the model to route a fallback to an instance, given
+// the fallback provider and model. ok is false if neither names an instance.
+func (r *InstanceRouter) instanceModel(provider, model string) (string, bool) {
+	if _, ok := r.instanceName(model); ok {
+		return model, true
+	}
+	if _, ok := r.instances[provider]; ok {
+		return provider + "/" + model, true
+	}
+	return "", false
+}
+
+// instanceName returns the instance an "instance/model" model names.
+func (r *InstanceRouter) instanceName(model string) (string, bool) {
+	name, _, found := strings.Cut(model, "/")
+	if !found {
+		return "", false
+	}
+	_, ok := r.instances[name]
+	return name, ok
+}
+
+func (r *InstanceRouter) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	p, id := r.ResolveModel(model)
+	return p.Chat(ctx, messages, tools, id, options)
+}
+
+func (r *InstanceRouter) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onContent StreamCallback) (*LLMResponse, error) {
+	p, id := r.ResolveModel(model)
+	if sp, ok := p.(StreamingProvider); ok {
+		return sp.ChatStream(ctx, messages, tools, id, options, onContent)
+	}
+	return p.Chat(ctx, messages, tools, id, options)
+}
+
+func (r *InstanceRouter) GetDefaultModel() string {
+	return r.def.GetDefaultModel()
+}
+
+// ListModels lists the models of every instance that can list them, as
+// "name/model", followed by the aliases.
+func (r *InstanceRouter) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	var models []ModelInfo
+	for _, name := range r.order {
+		lister, ok := r.instances[name].(ModelLister)
+		if !ok {
+			continue
+		}
+		listed, err := lister.ListModels(ctx)
+		if err != nil {
+			logger.WarnCF("provider", "Failed to list an instance's models",
+				map[string]interface{}{
+					"instance": name,
+					"error":    err.Error(),
+				})
+			continue
+		}
+		for _, m := range listed {
+			m.Name = name + "/" + m.Name
+			models = append(models, m)
+		}
+	}
+	for _, name := range r.order {
+		aliases := make([]string, 0, len(r.aliases[name]))
+		for alias := range r.aliases[name] {
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+		for _, alias := range aliases {
+			models = append(models, ModelInfo{
+				Name:        alias,
+				Description: fmt.Sprintf("%s/%s", name, r.aliases[name][alias]),
+			})
+		}
+	}
+	return models, nil
+}
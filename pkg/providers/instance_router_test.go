@@ -0,0 +1,135 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// openAIServer answers chat completions with "<name>:<model>" and records
+// the X-Team header of each request.
+func openAIServer(t *testing.T, name string, teams *[]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Invalid request body: %v", err)
+		}
+		*teams = append(*teams, r.Header.Get("X-Team"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"choices":[{"message":{"content":"%s:%s"},"finish_reason":"stop"}]}`, name, req.Model)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestInstanceRouter(t *testing.T) {
+	var teams []string
+	local := openAIServer(t, "local", &teams)
+	proxy := openAIServer(t, "proxy", &teams)
+
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Provider = "proxy"
+	cfg.Providers.Instances = []config.ProviderInstance{
+		{Name: "local", Kind: "openai", APIBase: local.URL, Models: map[string]string{"fast": "qwen2.5-7b"}},
+		{Name: "proxy", Kind: "openai", APIBase: proxy.URL, APIKey: "k", Headers: map[string]string{"X-Team": "ops"}},
+	}
+
+	provider, err := CreateProvider(cfg)
+	if err != nil {
+		t.Fatalf("CreateProvider() error: %v", err)
+	}
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"local/llama-3.1-8b", "local:llama-3.1-8b"},
+		{"local/fast", "local:qwen2.5-7b"},
+		{"fast", "local:qwen2.5-7b"},
+		{"gpt-4o", "proxy:gpt-4o"},
+		{"openrouter/auto", "proxy:openrouter/auto"},
+	}
+	for _, tt := range tests {
+		resp, err := provider.Chat(t.Context(), []Message{{Role: "user", Content: "Hi"}}, nil, tt.model, nil)
+		if err != nil {
+			t.Fatalf("Chat(%s) error: %v", tt.model, err)
+		}
+		if resp.Content != tt.want {
+			t.Errorf("Chat(%s) answered %q, want %q", tt.model, resp.Content, tt.want)
+		}
+	}
+	want := []string{"", "", "", "ops", "ops"}
+	if fmt.Sprint(teams) != fmt.Sprint(want) {
+		t.Errorf("X-Team headers = %q, want %q", teams, want)
+	}
+}
+
+func TestInstanceRouter_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances []config.ProviderInstance
+	}{
+		{"unknown kind", []config.ProviderInstance{{Name: "a", Kind: "nope"}}},
+		{"duplicate name", []config.ProviderInstance{{Name: "a", Kind: "ollama"}, {Name: "a", Kind: "ollama"}}},
+		{"slash in name", []config.ProviderInstance{{Name: "a/b", Kind: "ollama"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Providers.Instances = tt.instances
+			if _, err := CreateProvider(cfg); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestCreateProviderWithFallback_Instance(t *testing.T) {
+	var teams []string
+	local := openAIServer(t, "local", &teams)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Model = "main/big"
+	cfg.Agents.Defaults.FallbackProvider = "local"
+	cfg.Agents.Defaults.FallbackModel = "small"
+	cfg.Providers.Retry.MaxRetries = 0
+	cfg.Providers.Instances = []config.ProviderInstance{
+		{Name: "main", Kind: "openai", APIBase: failing.URL},
+		{Name: "local", Kind: "openai", APIBase: local.URL},
+	}
+
+	provider, err := CreateProviderWithFallback(cfg)
+	if err != nil {
+		t.Fatalf("CreateProviderWithFallback() error: %v", err)
+	}
+	resp, err := provider.Chat(t.Context(), []Message{{Role: "user", Content: "Hi"}}, nil, "main/big", nil)
+	if err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+	if resp.Content != "local:small" {
+		t.Errorf("Expected the fallback instance to answer, got %q", resp.Content)
+	}
+}
+
+func TestCreateProvider_LegacyFieldsWithoutInstances(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Provider = "ollama"
+
+	provider, err := CreateProvider(cfg)
+	if err != nil {
+		t.Fatalf("CreateProvider() error: %v", err)
+	}
+	if _, ok := provider.(*OllamaProvider); !ok {
+		t.Errorf("CreateProvider() returned %T, want *OllamaProvider", provider)
+	}
+}
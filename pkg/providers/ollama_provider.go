@@ -0,0 +1,463 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultOllamaAPIBase = "http://localhost:11434"
+
+// OllamaProvider talks to a local Ollama server through its native /api/chat
+// API, which unlike the OpenAI shim supports keep_alive and num_ctx.
+type OllamaProvider struct {
+	apiBase    string
+	keepAlive  string // how long Ollama keeps the model loaded, e.g. "30m"; empty uses its default
+	numCtx     int    // context size sent with requests; 0 uses ollamaDefaultNumCtx
+	httpClient *http.Client
+
+	mu         sync.Mutex
+	contextLen map[string]ollamaContextLength // by model
+}
+
+// ollamaContextLength is a model's context length as reported by
+// /api/show, or the error asking for it.
+type ollamaContextLength struct {
+	n   int
+	err error
+	at  time.Time
+}
+
+// ollamaShowRetry is how long a failure to get a model's context length is
+// remembered before asking again.
+const ollamaShowRetry = time.Minute
+
+// ollamaDefaultNumCtx is the context size sent when num_ctx is not configured.
+// It matches Ollama's own default, since a larger context costs memory.
+const ollamaDefaultNumCtx = 4096
+
+func NewOllamaProvider(apiBase, proxy, keepAlive string, numCtx int) *OllamaProvider {
+	if apiBase == "" {
+		apiBase = defaultOllamaAPIBase
+	}
+	// Local models can take a while to load and answer
+	client := &http.Client{
+		Timeout: 10 * time.Minute,
+	}
+	if proxy != "" {
+		if proxyURL, err := url.Parse(proxy); err == nil {
+			client.Transport = &http.Transport{
+				Proxy: http.ProxyURL(proxyURL),
+			}
+		}
+	}
+
+	return &OllamaProvider{
+		apiBase:    strings.TrimSuffix(strings.TrimRight(apiBase, "/"), "/v1"),
+		keepAlive:  keepAlive,
+		numCtx:     numCtx,
+		httpClient: client,
+		contextLen: make(map[string]ollamaContextLength),
+	}
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+func (p *OllamaProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	resp, err := p.postChat(ctx, messages, tools, model, options, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chunk ollamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chunk); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if chunk.Error != "" {
+		return nil, fmt.Errorf("ollama: %s", chunk.Error)
+	}
+
+	acc := &ollamaAccumulator{}
+	acc.add(&chunk)
+	return acc.response(), nil
+}
+
+func (p *OllamaProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, onContent StreamCallback) (*LLMResponse, error) {
+	resp, err := p.postChat(ctx, messages, tools, model, options, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	acc := &ollamaAccumulator{onContent: onContent}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			continue
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		acc.add(&chunk)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("stream read error: %w", err)
+	}
+	return acc.response(), nil
+}
+
+func (p *OllamaProvider) GetDefaultModel() string {
+	return ""
+}
+
+// postChat sends a chat request and returns the response if its status is OK.
+func (p *OllamaProvider) postChat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}, stream bool) (*http.Response, error) {
+	model = strings.TrimPrefix(model, "ollama/")
+	requestBody := map[string]interface{}{
+		"model":    model,
+		"messages": buildOllamaMessages(messages),
+		"stream":   stream,
+	}
+	if len(tools) > 0 {
+		requestBody["tools"] = tools
+	}
+	if p.keepAlive != "" {
+		requestBody["keep_alive"] = p.keepAlive
+	}
+	if schema := responseSchemaOption(options); schema != nil {
+		requestBody["format"] = schema.Schema
+	}
+
+	// Always send num_ctx so the model sees the context the agent budgets for,
+	// whatever the server's default is
+	numCtx, err := p.ContextWindow(ctx, model)
+	if err != nil {
+		numCtx = ollamaDefaultNumCtx
+	}
+	modelOptions := ollamaOptions(options)
+	modelOptions["num_ctx"] = numCtx
+	requestBody["options"] = modelOptions
+
+	var client *http.Client
+	if stream {
+		// Use a client without timeout for streaming — context handles cancellation
+		client = &http.Client{Transport: p.httpClient.Transport}
+	}
+	return p.post(ctx, client, "/api/chat", requestBody)
+}
+
+// post sends body as JSON to path. It uses the provider's client when client
+// is nil.
+func (p *OllamaProvider) post(ctx context.Context, client *http.Client, path string, body interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiBase+path, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return p.do(client, req)
+}
+
+func (p *OllamaProvider) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if client == nil {
+		client = p.httpClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, newAPIError(resp, body)
+	}
+	return resp, nil
+}
+
+// ollamaOptions maps the generation options to Ollama model options.
+func ollamaOptions(options map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	if maxTokens, ok := options["max_tokens"].(int); ok {
+		out["num_predict"] = maxTokens
+	}
+	if temperature, ok := options["temperature"].(float64); ok {
+		out["temperature"] = temperature
+	}
+	if topP, ok := options["top_p"].(float64); ok {
+		out["top_p"] = topP
+	}
+	if stop, ok := options["stop"].([]string); ok && len(stop) > 0 {
+		out["stop"] = stop
+	}
+	return out
+}
+
+// buildOllamaMessages converts messages to Ollama's chat format: images go
+// in a separate list, and tool results are matched to calls by tool name.
+func buildOllamaMessages(messages []Message) []ollamaMessage {
+	toolNames := make(map[string]string)
+	out := make([]ollamaMessage, 0, len(messages))
+	for _, msg := range messages {
+		om := ollamaMessage{Role: msg.Role, Content: msg.Content}
+		for _, part := range msg.ContentParts {
+			switch part.Type {
+			case "image":
+				om.Images = append(om.Images, part.Data)
+			case "text":
+				if om.Content != "" {
+					om.Content += "\n\n"
+				}
+				om.Content += part.Text
+			}
+		}
+		for _, tc := range msg.ToolCalls {
+			var call ollamaToolCall
+			call.Function.Name, call.Function.Arguments = tc.Name, tc.Arguments
+			if tc.Function != nil {
+				call.Function.Name = tc.Function.Name
+				if len(call.Function.Arguments) == 0 && tc.Function.Arguments != "" {
+					json.Unmarshal([]byte(tc.Function.Arguments), &call.Function.Arguments)
+				}
+			}
+			if call.Function.Arguments == nil {
+				call.Function.Arguments = map[string]interface{}{}
+			}
+			toolNames[tc.ID] = call.Function.Name
+			om.ToolCalls = append(om.ToolCalls, call)
+		}
+		if msg.Role == "tool" {
+			om.ToolName = toolNames[msg.ToolCallID]
+		}
+		out = append(out, om)
+	}
+	return out
+}
+
+// ollamaAccumulator collects a response from one or more chunks.
+type ollamaAccumulator struct {
+	onContent  StreamCallback
+	content    strings.Builder
+	toolCalls  []ToolCall
+	doneReason string
+	usage      *UsageInfo
+}
+
+func (a *ollamaAccumulator) add(chunk *ollamaChatResponse) {
+	if chunk.Message.Content != "" {
+		a.content.WriteString(chunk.Message.Content)
+		if a.onContent != nil {
+			a.onContent(chunk.Message.Content)
+		}
+	}
+	for _, tc := range chunk.Message.ToolCalls {
+		args := tc.Function.Arguments
+		if args == nil {
+			args = map[string]interface{}{}
+		}
+		// Ollama does not give tool calls IDs
+		a.toolCalls = append(a.toolCalls, ToolCall{
+			ID:        fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), len(a.toolCalls)),
+			Name:      tc.Function.Name,
+			Arguments: args,
+		})
+	}
+	if chunk.Done {
+		a.doneReason = chunk.DoneReason
+		a.usage = &UsageInfo{
+			PromptTokens:     chunk.PromptEvalCount,
+			CompletionTokens: chunk.EvalCount,
+			TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+		}
+	}
+}
+
+func (a *ollamaAccumulator) response() *LLMResponse {
+	finishReason := "stop"
+	switch {
+	case len(a.toolCalls) > 0:
+		finishReason = "tool_calls"
+	case a.doneReason == "length":
+		finishReason = "length"
+	}
+	return &LLMResponse{
+		Content:      a.content.String(),
+		ToolCalls:    a.toolCalls,
+		FinishReason: finishReason,
+		Usage:        a.usage,
+	}
+}
+
+// ListModels returns the models the Ollama server has pulled.
+func (p *OllamaProvider) ListModels(ctx context.Context) ([]ModelInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.apiBase+"/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := p.do(nil, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tags struct {
+		Models []struct {
+			Name    string `json:"name"`
+			Size    int64  `json:"size"`
+			Details struct {
+				ParameterSize     string `json:"parameter_size"`
+				QuantizationLevel string `json:"quantization_level"`
+			} `json:"details"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	models := make([]ModelInfo, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		var details []string
+		for _, d := range []string{m.Details.ParameterSize, m.Details.QuantizationLevel} {
+			if d != "" {
+				details = append(details, d)
+			}
+		}
+		models = append(models, ModelInfo{
+			Name:        m.Name,
+			Size:        m.Size,
+			Description: strings.Join(details, ", "),
+		})
+	}
+	return models, nil
+}
+
+// ContextWindow returns the context size sent as num_ctx with model's
+// requests: the configured num_ctx, or else ollamaDefaultNumCtx capped at the
+// model's context length.
+func (p *OllamaProvider) ContextWindow(ctx context.Context, model string) (int, error) {
+	if p.numCtx > 0 {
+		return p.numCtx, nil
+	}
+	n, err := p.modelContextLength(ctx, strings.TrimPrefix(model, "ollama/"))
+	if err != nil {
+		return 0, err
+	}
+	return min(n, ollamaDefaultNumCtx), nil
+}
+
+// modelContextLength returns the model's context length from /api/show. The
+// answer is remembered, and so is a failure for ollamaShowRetry.
+func (p *OllamaProvider) modelContextLength(ctx context.Context, model string) (int, error) {
+	p.mu.Lock()
+	cached, ok := p.contextLen[model]
+	p.mu.Unlock()
+	if ok && (cached.err == nil || time.Since(cached.at) < ollamaShowRetry) {
+		return cached.n, cached.err
+	}
+
+	n, err := p.showContextLength(ctx, model)
+	p.mu.Lock()
+	p.contextLen[model] = ollamaContextLength{n: n, err: err, at: time.Now()}
+	p.mu.Unlock()
+	return n, err
+}
+
+// showContextLength asks /api/show for the model's context length.
+func (p *OllamaProvider) showContextLength(ctx context.Context, model string) (int, error) {
+	resp, err := p.post(ctx, nil, "/api/show", map[string]string{"model": model})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var show struct {
+		ModelInfo map[string]interface{} `json:"model_info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	// The key is prefixed with the architecture, e.g. "llama.context_length"
+	for key, value := range show.ModelInfo {
+		if v, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("ollama did not report a context length for %s", model)
+}
+
+// Pull downloads model to the Ollama server, reporting progress as it goes.
+func (p *OllamaProvider) Pull(ctx context.Context, model string, progress func(PullProgress)) error {
+	// Downloads can take much longer than a chat request
+	client := &http.Client{Transport: p.httpClient.Transport}
+	resp, err := p.post(ctx, client, "/api/pull", map[string]interface{}{
+		"model":  strings.TrimPrefix(model, "ollama/"),
+		"stream": true,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var status struct {
+			PullProgress
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &status); err != nil {
+			continue
+		}
+		if status.Error != "" {
+			return fmt.Errorf("ollama: %s", status.Error)
+		}
+		if progress != nil {
+			progress(status.PullProgress)
+		}
+		if status.Status == "success" {
+			// Ask again for the context length of a model that was missing
+			p.mu.Lock()
+			delete(p.contextLen, strings.TrimPrefix(model, "ollama/"))
+			p.mu.Unlock()
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("stream read error: %w", err)
+	}
+	return fmt.Errorf("ollama: pull of %s ended without success", model)
+}
@@ -0,0 +1,171 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/media"
+)
+
+// newOllamaServer stands in for Ollama. Chat requests are decoded into
+// *chatReq and answered with chatResp, one NDJSON line per entry.
+func newOllamaServer(t *testing.T, chatReq *map[string]interface{}, chatResp []string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/show", func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Model string }
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "llama3.2:3b" {
+			http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"model_info": {"general.architecture": "llama", "llama.context_length": 131072}}`)
+	})
+	mux.HandleFunc("/api/chat", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(chatReq)
+		for _, line := range chatResp {
+			fmt.Fprintln(w, line)
+		}
+	})
+	mux.HandleFunc("/api/tags", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"models": [{"name": "llama3.2:3b", "size": 2019393189, "details": {"parameter_size": "3.2B", "quantization_level": "Q4_K_M"}}]}`)
+	})
+	mux.HandleFunc("/api/pull", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"status": "pulling manifest"}`)
+		fmt.Fprintln(w, `{"status": "pulling abc", "total": 100, "completed": 50}`)
+		fmt.Fprintln(w, `{"status": "success"}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOllamaProvider_Chat(t *testing.T) {
+	var req map[string]interface{}
+	server := newOllamaServer(t, &req, []string{
+		`{"message": {"role": "assistant", "content": "", "tool_calls": [{"function": {"name": "list_dir", "arguments": {"path": "."}}}]}, "done": true, "done_reason": "stop", "prompt_eval_count": 30, "eval_count": 10}`,
+	})
+
+	provider := NewOllamaProvider(server.URL+"/v1", "", "30m", 0)
+	messages := []Message{
+		{Role: "user", Content: "What's this?", ContentParts: []media.ContentPart{{Type: "image", MediaType: "image/png", Data: "aGVsbG8="}}},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: &FunctionCall{Name: "read_file", Arguments: `{"path":"a.md"}`}}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "contents"},
+	}
+	resp, err := provider.Chat(t.Context(), messages, nil, "ollama/llama3.2:3b", map[string]interface{}{"max_tokens": 512})
+	if err != nil {
+		t.Fatalf("Chat() error: %v", err)
+	}
+
+	if req["model"] != "llama3.2:3b" || req["keep_alive"] != "30m" || req["stream"] != false {
+		t.Errorf("Unexpected request: %v", req)
+	}
+	// Without num_ctx configured, the default is sent rather than the model's 128K
+	wantOptions := map[string]interface{}{"num_predict": 512.0, "num_ctx": 4096.0}
+	if !reflect.DeepEqual(req["options"], wantOptions) {
+		t.Errorf("options = %v, want %v", req["options"], wantOptions)
+	}
+	msgs := req["messages"].([]interface{})
+	if images := msgs[0].(map[string]interface{})["images"]; !reflect.DeepEqual(images, []interface{}{"aGVsbG8="}) {
+		t.Errorf("Expected the image in images, got %v", images)
+	}
+	if name := msgs[2].(map[string]interface{})["tool_name"]; name != "read_file" {
+		t.Errorf("Expected the tool result to name its tool, got %v", name)
+	}
+
+	if resp.FinishReason != "tool_calls" || len(resp.ToolCalls) != 1 || resp.ToolCalls[0].ID == "" || resp.ToolCalls[0].Arguments["path"] != "." {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+	if resp.Usage == nil || resp.Usage.TotalTokens != 40 {
+		t.Errorf("Unexpected usage: %+v", resp.Usage)
+	}
+}
+
+func TestOllamaProvider_ChatStream(t *testing.T) {
+	var req map[string]interface{}
+	server := newOllamaServer(t, &req, []string{
+		`{"message": {"role": "assistant", "content": "Hel"}, "done": false}`,
+		`{"message": {"role": "assistant", "content": "lo"}, "done": false}`,
+		`{"message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "length", "prompt_eval_count": 5, "eval_count": 2}`,
+	})
+
+	provider := NewOllamaProvider(server.URL, "", "", 4096)
+	var deltas []string
+	resp, err := provider.ChatStream(t.Context(), []Message{{Role: "user", Content: "Hi"}}, nil, "llama3.2:3b", nil, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+	if resp.Content != "Hello" || resp.FinishReason != "length" || !reflect.DeepEqual(deltas, []string{"Hel", "lo"}) {
+		t.Errorf("Got content %q, finish reason %q, deltas %v", resp.Content, resp.FinishReason, deltas)
+	}
+	if opts := req["options"].(map[string]interface{}); opts["num_ctx"] != 4096.0 {
+		t.Errorf("Expected the configured num_ctx, got %v", opts)
+	}
+}
+
+func TestOllamaProvider_ContextWindowIsNumCtxSent(t *testing.T) {
+	tests := []struct {
+		name   string
+		numCtx int
+		model  string
+		want   int
+	}{
+		{"default", 0, "llama3.2:3b", ollamaDefaultNumCtx},
+		{"configured", 32768, "llama3.2:3b", 32768},
+		{"unknown model", 0, "missing", ollamaDefaultNumCtx},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req map[string]interface{}
+			server := newOllamaServer(t, &req, []string{
+				`{"message": {"role": "assistant", "content": "Hi"}, "done": true}`,
+			})
+			provider := NewOllamaProvider(server.URL, "", "", tt.numCtx)
+
+			if _, err := provider.Chat(t.Context(), []Message{{Role: "user", Content: "Hi"}}, nil, tt.model, nil); err != nil {
+				t.Fatalf("Chat() error: %v", err)
+			}
+			sent := req["options"].(map[string]interface{})["num_ctx"]
+			if sent != float64(tt.want) {
+				t.Errorf("num_ctx = %v, want %d", sent, tt.want)
+			}
+			if n, err := provider.ContextWindow(t.Context(), tt.model); err == nil && float64(n) != sent {
+				t.Errorf("ContextWindow = %d, but num_ctx %v was sent", n, sent)
+			}
+		})
+	}
+}
+
+func TestOllamaProvider_Models(t *testing.T) {
+	server := newOllamaServer(t, new(map[string]interface{}), nil)
+	provider := NewOllamaProvider(server.URL, "", "", 0)
+
+	models, err := provider.ListModels(t.Context())
+	if err != nil || len(models) != 1 || models[0].Name != "llama3.2:3b" || models[0].Description != "3.2B, Q4_K_M" {
+		t.Errorf("ListModels = %+v, %v", models, err)
+	}
+
+	if n, err := provider.ContextWindow(t.Context(), "llama3.2:3b"); err != nil || n != ollamaDefaultNumCtx {
+		t.Errorf("ContextWindow = %d, %v; want %d", n, err, ollamaDefaultNumCtx)
+	}
+	if _, err := provider.ContextWindow(t.Context(), "missing"); err == nil {
+		t.Error("Expected an error for a missing model")
+	}
+	if cached := provider.contextLen["missing"]; cached.err == nil {
+		t.Error("Expected the failure to be remembered")
+	}
+
+	var statuses []string
+	err = provider.Pull(t.Context(), "llama3.2:3b", func(p PullProgress) {
+		statuses = append(statuses, p.Status)
+	})
+	if err != nil || !reflect.DeepEqual(statuses, []string{"pulling manifest", "pulling abc", "success"}) {
+		t.Errorf("Pull = %v with statuses %v", err, statuses)
+	}
+}
@@ -0,0 +1,170 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// Factory builds the provider for a configured instance. cfg carries the
+// settings instances share, such as the workspace and default model.
+type Factory func(inst config.ProviderInstance, cfg *config.Config) (LLMProvider, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{}
+)
+
+// RegisterFactory makes a provider kind available to named instances.
+// Registering a kind again replaces its factory.
+func RegisterFactory(kind string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[strings.ToLower(kind)] = f
+}
+
+// Kinds returns the registered provider kinds, sorted.
+func Kinds() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	kinds := make([]string, 0, len(factories))
+	for kind := range factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// BuildInstance creates the provider for inst with the factory of its kind.
+func BuildInstance(inst config.ProviderInstance, cfg *config.Config) (LLMProvider, error) {
+	factoriesMu.RLock()
+	f, ok := factories[strings.ToLower(inst.Kind)]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown provider kind %q (known: %s)", inst.Kind, strings.Join(Kinds(), ", "))
+	}
+	return f(inst, cfg)
+}
+
+func init() {
+	RegisterFactory("openai", newOpenAIInstance)
+	RegisterFactory("anthropic", newAnthropicInstance)
+	RegisterFactory("gemini", newGeminiInstance)
+	RegisterFactory("ollama", newOllamaInstance)
+	RegisterFactory("claude-cli", newClaudeCliInstance)
+	RegisterFactory("github-copilot", newGitHubCopilotInstance)
+}
+
+// providerInstance describes one of the fixed provider fields as an
+// instance of kind.
+func providerInstance(kind string, pc config.ProviderConfig) config.ProviderInstance {
+	return config.ProviderInstance{
+		Kind:        kind,
+		APIKey:      pc.APIKey,
+		APIBase:     pc.APIBase,
+		Proxy:       pc.Proxy,
+		AuthMethod:  pc.AuthMethod,
+		ConnectMode: pc.ConnectMode,
+		KeepAlive:   pc.KeepAlive,
+		NumCtx:      pc.NumCtx,
+	}
+}
+
+func isAuthLogin(method string) bool {
+	return method == "oauth" || method == "token"
+}
+
+// newHTTPInstance creates an OpenAI-compatible provider for inst at apiBase.
+func newHTTPInstance(inst config.ProviderInstance, apiBase string, cfg *config.Config) *HTTPProvider {
+	p := NewHTTPProvider(inst.APIKey, apiBase, inst.Proxy)
+	p.SetRetryPolicy(RetryPolicyFromConfig(cfg.Providers.Retry))
+	withHeaders(p.httpClient, inst.Headers)
+	return p
+}
+
+// newOpenAIInstance serves any OpenAI-compatible endpoint, or OpenAI itself
+// through a login from "picoclaw auth login".
+func newOpenAIInstance(inst config.ProviderInstance, cfg *config.Config) (LLMProvider, error) {
+	if isAuthLogin(inst.AuthMethod) {
+		return createCodexAuthProvider()
+	}
+	apiBase := inst.APIBase
+	if apiBase == "" {
+		apiBase = "https://api.openai.com/v1"
+	}
+	return newHTTPInstance(inst, apiBase, cfg), nil
+}
+
+func newAnthropicInstance(inst config.ProviderInstance, cfg *config.Config) (LLMProvider, error) {
+	if isAuthLogin(inst.AuthMethod) {
+		return createClaudeAuthProvider()
+	}
+	apiBase := inst.APIBase
+	if apiBase == "" {
+		apiBase = "https://api.anthropic.com/v1"
+	}
+	return newHTTPInstance(inst, apiBase, cfg), nil
+}
+
+// newGeminiInstance uses the native Gemini API, unless api_base points at
+// Gemini's OpenAI-compatible endpoint.
+func newGeminiInstance(inst config.ProviderInstance, cfg *config.Config) (LLMProvider, error) {
+	if strings.HasSuffix(strings.TrimRight(inst.APIBase, "/"), "/openai") {
+		return newHTTPInstance(inst, inst.APIBase, cfg), nil
+	}
+	p := NewGeminiProvider(inst.APIKey, inst.APIBase, inst.Proxy)
+	withHeaders(p.httpClient, inst.Headers)
+	return p, nil
+}
+
+func newOllamaInstance(inst config.ProviderInstance, cfg *config.Config) (LLMProvider, error) {
+	p := NewOllamaProvider(inst.APIBase, inst.Proxy, inst.KeepAlive, inst.NumCtx)
+	withHeaders(p.httpClient, inst.Headers)
+	return p, nil
+}
+
+func newClaudeCliInstance(inst config.ProviderInstance, cfg *config.Config) (LLMProvider, error) {
+	workspace := cfg.Agents.Defaults.Workspace
+	if workspace == "" {
+		workspace = "."
+	}
+	return NewClaudeCliProvider(workspace), nil
+}
+
+func newGitHubCopilotInstance(inst config.ProviderInstance, cfg *config.Config) (LLMProvider, error) {
+	apiBase := inst.APIBase
+	if apiBase == "" {
+		apiBase = "localhost:4321"
+	}
+	return NewGitHubCopilotProvider(apiBase, inst.ConnectMode, cfg.Agents.Defaults.Model)
+}
+
+// headerTransport adds fixed headers to every request.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// withHeaders makes client send headers with every request.
+func withHeaders(client *http.Client, headers map[string]string) {
+	if len(headers) == 0 {
+		return
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &headerTransport{base: base, headers: headers}
+}
@@ -0,0 +1,170 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// errStreamDropped reports a stream that ended before its first token, so
+// retrying it shows the user nothing twice.
+var errStreamDropped = errors.New("stream dropped before the first token")
+
+// RetryPolicy controls how failed requests are retried. The zero policy
+// does not retry.
+type RetryPolicy struct {
+	MaxRetries int           // retries after the first attempt
+	BaseDelay  time.Duration // delay before the first retry, doubled for each one after
+	MaxDelay   time.Duration // longest delay; a longer Retry-After is not waited for
+}
+
+// RetryPolicyFromConfig converts the configured retry settings.
+func RetryPolicyFromConfig(rc config.RetryConfig) RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: rc.MaxRetries,
+		BaseDelay:  time.Duration(rc.BaseDelayMS) * time.Millisecond,
+		MaxDelay:   time.Duration(rc.MaxDelaySeconds) * time.Second,
+	}
+}
+
+// requestRetrier is implemented by providers that retry failed requests
+// themselves, which a fallback chain then does not retry again.
+type requestRetrier interface {
+	retriesRequests() bool
+}
+
+// retriesRequests reports whether provider retries its requests for model
+// itself, looking through routers to the provider serving the model.
+func retriesRequests(provider LLMProvider, model string) bool {
+	if r, ok := provider.(ModelResolver); ok {
+		provider, _ = r.ResolveModel(model)
+	}
+	rr, ok := provider.(requestRetrier)
+	return ok && rr.retriesRequests()
+}
+
+// backoff returns the delay before the given retry (1 for the first): an
+// exponential delay with jitter over its upper half, so concurrent callers
+// spread out but still back off.
+func (r RetryPolicy) backoff(retry int) time.Duration {
+	d := r.BaseDelay
+	for i := 1; i < retry && d < r.MaxDelay; i++ {
+		d *= 2
+	}
+	if r.MaxDelay > 0 && d > r.MaxDelay {
+		d = r.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryable reports whether a request that failed with err is worth
+// retrying, and how long the server asked to wait first.
+func retryable(err error) (bool, time.Duration) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests,
+			http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+			529: // Anthropic: overloaded
+			return true, apiErr.RetryAfter
+		}
+		return false, 0
+	}
+	if errors.Is(err, errStreamDropped) {
+		return true, 0
+	}
+	// Connection failures, but not client timeouts, which already waited long
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && !urlErr.Timeout() {
+		return true, 0
+	}
+	return false, 0
+}
+
+// withRetries calls call until it succeeds, fails with an error not worth
+// retrying, or runs out of retries.
+func withRetries(ctx context.Context, policy RetryPolicy, model string, call func() (*LLMResponse, error)) (*LLMResponse, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := call()
+		if err == nil {
+			if attempt > 1 {
+				logger.InfoCF("provider", "LLM request succeeded after retrying",
+					map[string]interface{}{
+						"model":    model,
+						"attempts": attempt,
+					})
+			}
+			return resp, nil
+		}
+
+		retry, retryAfter := retryable(err)
+		delay := policy.backoff(attempt)
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		if !retry || attempt > policy.MaxRetries || ctx.Err() != nil || (policy.MaxDelay > 0 && delay > policy.MaxDelay) {
+			if attempt > 1 {
+				logger.WarnCF("provider", "LLM request failed after retrying",
+					map[string]interface{}{
+						"model":    model,
+						"attempts": attempt,
+						"error":    err.Error(),
+					})
+			}
+			return nil, err
+		}
+
+		logger.WarnCF("provider", "LLM request failed, retrying",
+			map[string]interface{}{
+				"model":   model,
+				"attempt": attempt,
+				"delay":   delay.String(),
+				"error":   err.Error(),
+			})
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// newAPIError describes a non-200 response with body, including how long
+// the server asked clients to wait before retrying.
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter reads a Retry-After header, given in seconds or as an
+// HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
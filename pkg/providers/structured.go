@@ -0,0 +1,271 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// OptionResponseSchema is the options key for a *ResponseSchema. Providers
+// with native structured output constrain their response to the schema:
+// HTTPProvider through response_format, GeminiProvider through
+// responseJsonSchema, ClaudeProvider by forcing a tool call. Others ignore
+// it, and ChatJSON validates their output instead.
+const OptionResponseSchema = "response_schema"
+
+// maxStructuredRetries is how many times ChatJSON asks the model to correct
+// a response that does not match the schema.
+const maxStructuredRetries = 2
+
+// ResponseSchema describes the JSON object a response must contain. Schema
+// is a JSON Schema whose top level is an object, as both OpenAI and
+// Anthropic require.
+type ResponseSchema struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema"`
+}
+
+// schemaRejected records the models whose backend rejected a response
+// schema, such as DeepSeek or older vLLM, so later calls skip it. It is
+// keyed by schemaModel, as the same model name can be served elsewhere.
+var schemaRejected sync.Map
+
+// schemaModel is a model on the provider instance serving it.
+type schemaModel struct {
+	provider LLMProvider
+	model    string
+}
+
+// schemaModelFor returns the instance serving model through provider.
+func schemaModelFor(provider LLMProvider, model string) schemaModel {
+	if r, ok := provider.(ModelResolver); ok {
+		provider, model = r.ResolveModel(model)
+	}
+	return schemaModel{provider: provider, model: model}
+}
+
+// ChatJSON asks provider for a response matching schema and decodes it into
+// out. The schema is passed to the provider as OptionResponseSchema; the
+// response is validated against it in any case, and when it does not match,
+// the model is shown the problem and asked again. When the backend rejects
+// the schema, the request is repeated with the schema in the prompt instead.
+func ChatJSON(ctx context.Context, provider LLMProvider, messages []Message, model string, options map[string]interface{}, schema *ResponseSchema, out interface{}) error {
+	msgs := append([]Message(nil), messages...)
+	callOptions := options
+	key := schemaModelFor(provider, model)
+	_, rejected := schemaRejected.Load(key)
+	if rejected {
+		msgs = withSchemaInstruction(msgs, schema)
+	} else {
+		callOptions = MergeOptions(options, map[string]interface{}{OptionResponseSchema: schema})
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= maxStructuredRetries; attempt++ {
+		resp, err := provider.Chat(ctx, msgs, nil, model, callOptions)
+		if err != nil {
+			if rejected || !schemaUnsupported(err) {
+				return err
+			}
+			logger.WarnCF("provider", "Response schema rejected, validating the reply instead",
+				map[string]interface{}{
+					"model": model,
+					"error": err.Error(),
+				})
+			schemaRejected.Store(key, true)
+			rejected = true
+			callOptions = options
+			msgs = withSchemaInstruction(msgs, schema)
+			attempt-- // the rejected request does not count as an attempt
+			continue
+		}
+
+		content := extractJSON(resp.Content)
+		var value interface{}
+		if err := json.Unmarshal([]byte(content), &value); err != nil {
+			lastErr = fmt.Errorf("response is not valid JSON: %w", err)
+		} else if err := ValidateJSON(schema.Schema, value); err != nil {
+			lastErr = fmt.Errorf("response does not match the schema: %w", err)
+		} else {
+			return json.Unmarshal([]byte(content), out)
+		}
+
+		schemaJSON, _ := json.Marshal(schema.Schema)
+		msgs = append(msgs,
+			Message{Role: "assistant", Content: resp.Content},
+			Message{Role: "user", Content: fmt.Sprintf(
+				"That reply could not be used: %v.\nReply again with ONLY a JSON object matching this schema, no markdown fences or explanation:\n%s",
+				lastErr, schemaJSON)},
+		)
+	}
+	return fmt.Errorf("%s: %w (response: %s)", schema.Name, lastErr, truncateForError(msgs[len(msgs)-2].Content))
+}
+
+// schemaUnsupported reports whether err looks like a backend refusing the
+// response_format parameter rather than the request itself.
+func schemaUnsupported(err error) bool {
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "response_format") || strings.Contains(msg, "json_schema") {
+		return true
+	}
+	switch statusCode(err) {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ClassifyError(err) == ErrorUnknown && !errors.Is(err, context.Canceled)
+	}
+	return false
+}
+
+// withSchemaInstruction returns msgs with the schema spelled out at the end
+// of the last message, for backends without native structured output.
+func withSchemaInstruction(msgs []Message, schema *ResponseSchema) []Message {
+	if len(msgs) == 0 {
+		return msgs
+	}
+	schemaJSON, _ := json.Marshal(schema.Schema)
+	msgs = append([]Message(nil), msgs...)
+	last := &msgs[len(msgs)-1]
+	last.Content += fmt.Sprintf("\n\nReply with ONLY a JSON object matching this schema, no markdown fences or explanation:\n%s", schemaJSON)
+	return msgs
+}
+
+// extractJSON strips reasoning blocks, markdown fences and surrounding prose
+// from a model's JSON reply.
+func extractJSON(content string) string {
+	content = StripThinkingTags(content)
+	content = strings.TrimPrefix(content, "```json")
+	content = strings.TrimPrefix(content, "```")
+	content = strings.TrimSuffix(content, "```")
+	content = strings.TrimSpace(content)
+
+	if json.Valid([]byte(content)) {
+		return content
+	}
+	start, end := strings.Index(content, "{"), strings.LastIndex(content, "}")
+	if start >= 0 && end > start {
+		return content[start : end+1]
+	}
+	return content
+}
+
+// ValidateJSON checks a decoded JSON value against the subset of JSON Schema
+// used for structured output: type, properties, required, items and
+// string enums.
+func ValidateJSON(schema map[string]interface{}, value interface{}) error {
+	return validateJSON(schema, value, "$")
+}
+
+func validateJSON(schema map[string]interface{}, value interface{}, path string) error {
+	if typ, ok := schema["type"].(string); ok && !jsonTypeMatches(typ, value) {
+		return fmt.Errorf("%s: expected %s, got %s", path, typ, jsonTypeName(value))
+	}
+
+	if enum := schemaStrings(schema["enum"]); enum != nil {
+		found := false
+		for _, e := range enum {
+			if e == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range schemaStrings(schema["required"]) {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		for name, propValue := range v {
+			propSchema, ok := props[name].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := validateJSON(propSchema, propValue, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := validateJSON(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// schemaStrings reads a list of strings from a schema written either as Go
+// literals ([]string) or decoded JSON ([]interface{}).
+func schemaStrings(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func jsonTypeMatches(typ string, value interface{}) bool {
+	switch typ {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == float64(int64(n))
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonTypeName(value) == typ
+	}
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func truncateForError(s string) string {
+	if len(s) > 200 {
+		return s[:200] + "..."
+	}
+	return s
+}
+
+// responseSchemaOption returns the response schema requested in options, if any.
+func responseSchemaOption(options map[string]interface{}) *ResponseSchema {
+	schema, _ := options[OptionResponseSchema].(*ResponseSchema)
+	return schema
+}
@@ -0,0 +1,185 @@
+package providers
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+var factsSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"facts": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"fact":     map[string]interface{}{"type": "string"},
+					"category": map[string]interface{}{"type": "string", "enum": []string{"task", "preference"}},
+				},
+				"required": []string{"fact", "category"},
+			},
+		},
+		"count": map[string]interface{}{"type": "integer"},
+	},
+	"required": []string{"facts"},
+}
+
+func TestValidateJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		wantErr string
+	}{
+		{"valid", map[string]interface{}{"facts": []interface{}{map[string]interface{}{"fact": "a", "category": "task"}}, "count": 1.0}, ""},
+		{"empty list", map[string]interface{}{"facts": []interface{}{}}, ""},
+		{"not an object", []interface{}{}, "$: expected object, got array"},
+		{"missing required", map[string]interface{}{}, `$: missing required property "facts"`},
+		{"wrong item type", map[string]interface{}{"facts": []interface{}{"a"}}, "$.facts[0]: expected object, got string"},
+		{"enum", map[string]interface{}{"facts": []interface{}{map[string]interface{}{"fact": "a", "category": "other"}}}, "$.facts[0].category: other is not one of"},
+		{"integer", map[string]interface{}{"facts": []interface{}{}, "count": 1.5}, "$.count: expected integer, got number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateJSON(factsSchema, tt.value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateJSON() error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateJSON() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// replyProvider answers each call with the next of its replies and records
+// the requests it saw.
+type replyProvider struct {
+	replies  []string
+	requests [][]Message
+	options  []map[string]interface{}
+}
+
+func (p *replyProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	p.requests = append(p.requests, messages)
+	p.options = append(p.options, options)
+	reply := p.replies[0]
+	p.replies = p.replies[1:]
+	return &LLMResponse{Content: reply}, nil
+}
+
+func (p *replyProvider) GetDefaultModel() string { return "test-model" }
+
+func TestChatJSON(t *testing.T) {
+	schema := &ResponseSchema{Name: "facts", Schema: factsSchema}
+	type facts struct {
+		Facts []struct {
+			Fact     string `json:"fact"`
+			Category string `json:"category"`
+		} `json:"facts"`
+	}
+
+	t.Run("fenced reply with reasoning", func(t *testing.T) {
+		p := &replyProvider{replies: []string{"<think>hmm</think>\n```json\n{\"facts\": [{\"fact\": \"Likes tea\", \"category\": \"preference\"}]}\n```"}}
+		var got facts
+		if err := ChatJSON(context.Background(), p, []Message{{Role: "user", Content: "extract"}}, "test-model", nil, schema, &got); err != nil {
+			t.Fatalf("ChatJSON() error: %v", err)
+		}
+		if len(got.Facts) != 1 || got.Facts[0].Fact != "Likes tea" {
+			t.Errorf("ChatJSON() = %+v", got)
+		}
+		if p.options[0][OptionResponseSchema] != schema {
+			t.Error("Expected the schema to be passed in the options")
+		}
+	})
+
+	t.Run("retries invalid reply", func(t *testing.T) {
+		p := &replyProvider{replies: []string{
+			`Sure! Here you go: {"facts": [{"fact": "Likes tea"}]}`,
+			`{"facts": [{"fact": "Likes tea", "category": "preference"}]}`,
+		}}
+		var got facts
+		if err := ChatJSON(context.Background(), p, []Message{{Role: "user", Content: "extract"}}, "test-model", nil, schema, &got); err != nil {
+			t.Fatalf("ChatJSON() error: %v", err)
+		}
+		if len(p.requests) != 2 || len(p.requests[1]) != 3 {
+			t.Fatalf("Expected one retry with the invalid reply and a correction, got %d requests", len(p.requests))
+		}
+		if correction := p.requests[1][2].Content; !strings.Contains(correction, `missing required property "category"`) {
+			t.Errorf("Expected the correction to name the problem, got %q", correction)
+		}
+		if got.Facts[0].Category != "preference" {
+			t.Errorf("ChatJSON() = %+v", got)
+		}
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		p := &replyProvider{replies: []string{"no", "still no", "never"}}
+		var got facts
+		err := ChatJSON(context.Background(), p, []Message{{Role: "user", Content: "extract"}}, "test-model", nil, schema, &got)
+		if err == nil || !strings.Contains(err.Error(), "not valid JSON") {
+			t.Errorf("Expected an invalid JSON error, got %v", err)
+		}
+		if len(p.requests) != maxStructuredRetries+1 {
+			t.Errorf("Expected %d attempts, got %d", maxStructuredRetries+1, len(p.requests))
+		}
+	})
+}
+
+// schemaRejectingProvider fails requests that carry a response schema, like
+// backends without json_schema support, and answers the rest with reply.
+type schemaRejectingProvider struct {
+	replyProvider
+	rejected int
+}
+
+func (p *schemaRejectingProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	if responseSchemaOption(options) != nil {
+		p.rejected++
+		return nil, &APIError{StatusCode: 400, Body: `{"error":{"message":"response_format type json_schema is not supported"}}`}
+	}
+	return p.replyProvider.Chat(ctx, messages, tools, model, options)
+}
+
+func TestChatJSON_SchemaRejected(t *testing.T) {
+	schema := &ResponseSchema{Name: "facts", Schema: factsSchema}
+	p := &schemaRejectingProvider{replyProvider: replyProvider{replies: []string{
+		`{"facts": [{"fact": "Likes tea"}]}`,
+		`{"facts": [{"fact": "Likes tea", "category": "preference"}]}`,
+		`{"facts": []}`,
+	}}}
+
+	var got struct {
+		Facts []struct{ Category string } `json:"facts"`
+	}
+	if err := ChatJSON(t.Context(), p, []Message{{Role: "user", Content: "extract"}}, "no-schema-model", nil, schema, &got); err != nil {
+		t.Fatalf("ChatJSON() error: %v", err)
+	}
+	if len(got.Facts) != 1 || got.Facts[0].Category != "preference" {
+		t.Errorf("ChatJSON() = %+v", got)
+	}
+	if !strings.Contains(p.requests[0][0].Content, `"category"`) {
+		t.Errorf("Expected the schema in the prompt, got %q", p.requests[0][0].Content)
+	}
+
+	// The model is remembered, so the next call does not send the schema
+	if err := ChatJSON(t.Context(), p, []Message{{Role: "user", Content: "extract"}}, "no-schema-model", nil, schema, &got); err != nil {
+		t.Fatalf("ChatJSON() error: %v", err)
+	}
+	if p.rejected != 1 {
+		t.Errorf("Expected one rejected request, got %d", p.rejected)
+	}
+
+	// Another provider serving a model of the same name still gets the schema
+	other := &replyProvider{replies: []string{`{"facts": []}`}}
+	if err := ChatJSON(t.Context(), other, []Message{{Role: "user", Content: "extract"}}, "no-schema-model", nil, schema, &got); err != nil {
+		t.Fatalf("ChatJSON() error: %v", err)
+	}
+	if other.options[0][OptionResponseSchema] != schema {
+		t.Error("Expected the schema to be sent to another provider")
+	}
+}
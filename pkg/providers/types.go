@@ -2,6 +2,8 @@ package providers
 
 import (
 	"context"
+	"regexp"
+	"strings"
 
 	"github.com/sipeed/picoclaw/pkg/media"
 )
@@ -24,6 +26,7 @@ type LLMResponse struct {
 	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
 	FinishReason string     `json:"finish_reason"`
 	Usage        *UsageInfo `json:"usage,omitempty"`
+	Model        string     `json:"model,omitempty"` // model that answered, when a fallback chain knows it
 }
 
 type UsageInfo struct {
@@ -40,6 +43,10 @@ type Message struct {
 	ContentParts []media.ContentPart `json:"content_parts,omitempty"`
 	ToolCalls    []ToolCall          `json:"tool_calls,omitempty"`
 	ToolCallID   string              `json:"tool_call_id,omitempty"`
+
+	// Attachments reference media kept with the session history. They are
+	// turned into ContentParts or captions before messages reach a provider.
+	Attachments []media.Attachment `json:"attachments,omitempty"`
 }
 
 type LLMProvider interface {
@@ -47,6 +54,28 @@ type LLMProvider interface {
 	GetDefaultModel() string
 }
 
+// MergeOptions returns a copy of base with the entries of overrides applied
+// on top. Either map may be nil.
+func MergeOptions(base, overrides map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
+
+// thinkTagRe matches <think>...</think> reasoning blocks (including
+// multiline) from models like DeepSeek/MiniMax.
+var thinkTagRe = regexp.MustCompile(`(?s)<think>.*?</think>\s*`)
+
+// StripThinkingTags removes <think>...</think> reasoning blocks from s.
+func StripThinkingTags(s string) string {
+	return strings.TrimSpace(thinkTagRe.ReplaceAllString(s, ""))
+}
+
 type StreamCallback func(contentDelta string)
 
 type StreamingProvider interface {
@@ -64,3 +93,41 @@ type ToolFunctionDefinition struct {
 	Description string                 `json:"description"`
 	Parameters  map[string]interface{} `json:"parameters"`
 }
+
+// ModelInfo describes a model a provider can serve.
+type ModelInfo struct {
+	Name        string
+	Size        int64  // bytes on disk, for local models
+	Description string // e.g. parameter count and quantization
+}
+
+// ModelLister is implemented by providers that can list their models.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+}
+
+// ContextWindowProvider is implemented by providers that know the context
+// size of each model, in tokens.
+type ContextWindowProvider interface {
+	ContextWindow(ctx context.Context, model string) (int, error)
+}
+
+// PullProgress reports a model download in progress.
+type PullProgress struct {
+	Status    string `json:"status"` // e.g. "pulling manifest", "success"
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+}
+
+// ModelPuller is implemented by providers that can download models.
+type ModelPuller interface {
+	Pull(ctx context.Context, model string, progress func(PullProgress)) error
+}
+
+// ModelResolver is implemented by providers that pass each model on to
+// another provider, such as the one serving a named instance.
+type ModelResolver interface {
+	// ResolveModel returns the provider serving model and the model ID to
+	// send it.
+	ResolveModel(model string) (LLMProvider, string)
+}
@@ -0,0 +1,277 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// Export formats accepted by Export.
+const (
+	FormatMarkdown = "md"
+	FormatJSONL    = "jsonl"
+	FormatHTML     = "html"
+)
+
+// Export writes the session to w in the given format: a Markdown
+// transcript, JSONL messages in the OpenAI chat format, or a self-contained
+// HTML page.
+func Export(w io.Writer, s *Session, format string) error {
+	switch format {
+	case FormatMarkdown, "markdown":
+		return ExportMarkdown(w, s)
+	case FormatJSONL:
+		return ExportJSONL(w, s)
+	case FormatHTML:
+		return ExportHTML(w, s)
+	default:
+		return fmt.Errorf("unknown export format %q (want %s, %s or %s)", format, FormatMarkdown, FormatJSONL, FormatHTML)
+	}
+}
+
+// toolCallParts returns the name and JSON arguments of a tool call, which
+// is stored either in OpenAI form (Function) or flattened (Name, Arguments).
+func toolCallParts(tc providers.ToolCall) (string, string) {
+	if tc.Function != nil {
+		return tc.Function.Name, tc.Function.Arguments
+	}
+	args, _ := json.Marshal(tc.Arguments)
+	return tc.Name, string(args)
+}
+
+// prettyJSON indents a JSON document, or returns it unchanged if it is not
+// valid JSON.
+func prettyJSON(s string) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(s), "", "  "); err != nil {
+		return s
+	}
+	return buf.String()
+}
+
+// toolNameOf returns the name of the tool called as id, falling back to the
+// ID itself for results whose call is not in the history.
+func toolNameOf(names map[string]string, id string) string {
+	if name, ok := names[id]; ok && name != "" {
+		return name
+	}
+	return id
+}
+
+// roleTitle names a message's role for a transcript.
+func roleTitle(role string) string {
+	switch role {
+	case "user":
+		return "User"
+	case "assistant":
+		return "Assistant"
+	case "system":
+		return "System"
+	}
+	return role
+}
+
+// fence returns a Markdown code fence longer than any backtick run in s.
+func fence(s string) string {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// ExportMarkdown writes the session as a Markdown transcript, with its
+// summary first and tool calls and results in code blocks.
+func ExportMarkdown(w io.Writer, s *Session) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Session %s\n\n", s.Key)
+	fmt.Fprintf(&b, "- Created: %s\n- Updated: %s\n- Messages: %d\n\n", formatTime(s.Created), formatTime(s.Updated), len(s.Messages))
+
+	if s.Summary != "" {
+		fmt.Fprintf(&b, "## Summary\n\n%s\n\n", strings.TrimSpace(s.Summary))
+	}
+
+	b.WriteString("## Transcript\n")
+	toolNames := make(map[string]string)
+	for _, msg := range s.Messages {
+		if msg.Role == "tool" {
+			content := strings.TrimSpace(msg.Content)
+			f := fence(content)
+			fmt.Fprintf(&b, "\n**Tool result** `%s`:\n\n%s\n%s\n%s\n", toolNameOf(toolNames, msg.ToolCallID), f, content, f)
+			continue
+		}
+
+		fmt.Fprintf(&b, "\n### %s\n", roleTitle(msg.Role))
+		if content := strings.TrimSpace(msg.Content); content != "" {
+			fmt.Fprintf(&b, "\n%s\n", content)
+		}
+		for _, tc := range msg.ToolCalls {
+			name, args := toolCallParts(tc)
+			toolNames[tc.ID] = name
+			args = prettyJSON(args)
+			f := fence(args)
+			fmt.Fprintf(&b, "\n**Tool call** `%s`:\n\n%sjson\n%s\n%s\n", name, f, args, f)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// openAIMessage is a message in the OpenAI chat completions format.
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string                 `json:"id"`
+	Type     string                 `json:"type"`
+	Function providers.FunctionCall `json:"function"`
+}
+
+// ExportJSONL writes one OpenAI-format message per line. The summary, if
+// any, comes first as a system message.
+func ExportJSONL(w io.Writer, s *Session) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if s.Summary != "" {
+		if err := enc.Encode(openAIMessage{
+			Role:    "system",
+			Content: "Summary of the earlier conversation:\n" + s.Summary,
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, msg := range s.Messages {
+		out := openAIMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			ToolCallID: msg.ToolCallID,
+		}
+		for _, tc := range msg.ToolCalls {
+			name, args := toolCallParts(tc)
+			out.ToolCalls = append(out.ToolCalls, openAIToolCall{
+				ID:       tc.ID,
+				Type:     "function",
+				Function: providers.FunctionCall{Name: name, Arguments: args},
+			})
+		}
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// htmlMessage is a message as the HTML template shows it.
+type htmlMessage struct {
+	Role      string
+	Title     string
+	Content   string
+	ToolCalls []htmlToolCall
+}
+
+type htmlToolCall struct {
+	Name string
+	Args string
+}
+
+var htmlTemplate = template.Must(template.New("session").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Session {{.Key}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; max-width: 860px; margin: 2em auto; padding: 0 1em; color: #222; background: #fafafa; }
+h1 { font-size: 1.4em; word-break: break-all; }
+.meta { color: #666; font-size: 0.9em; }
+.summary { background: #fff8dc; border-left: 4px solid #e0c060; padding: 0.5em 1em; margin: 1em 0; white-space: pre-wrap; }
+.msg { border-radius: 8px; padding: 0.6em 1em; margin: 0.8em 0; background: #fff; border: 1px solid #e4e4e4; }
+.msg.user { background: #eef5ff; border-color: #c8dcf5; }
+.msg.tool { background: #f4f4f4; }
+.role { font-weight: 600; font-size: 0.85em; color: #555; margin-bottom: 0.3em; }
+.content { white-space: pre-wrap; word-wrap: break-word; }
+details { margin-top: 0.4em; }
+summary { cursor: pointer; font-family: monospace; }
+pre { background: #f0f0f0; padding: 0.6em; overflow-x: auto; white-space: pre-wrap; word-wrap: break-word; }
+</style>
+</head>
+<body>
+<h1>Session {{.Key}}</h1>
+<p class="meta">Created {{.Created}} · Updated {{.Updated}} · {{len .Messages}} messages</p>
+{{if .Summary}}<div class="summary"><strong>Summary</strong>
+{{.Summary}}</div>{{end}}
+{{range .Messages}}<div class="msg {{.Role}}">
+{{if eq .Role "tool"}}<details><summary>{{.Title}}</summary><pre>{{.Content}}</pre></details>
+{{else}}<div class="role">{{.Title}}</div>
+{{if .Content}}<div class="content">{{.Content}}</div>{{end}}
+{{range .ToolCalls}}<details><summary>🔧 {{.Name}}</summary><pre>{{.Args}}</pre></details>
+{{end}}{{end}}</div>
+{{end}}</body>
+</html>
+`))
+
+// ExportHTML writes the session as a single HTML page with inline styles.
+// Tool calls and results are collapsed.
+func ExportHTML(w io.Writer, s *Session) error {
+	data := struct {
+		Key      string
+		Created  string
+		Updated  string
+		Summary  string
+		Messages []htmlMessage
+	}{
+		Key:     s.Key,
+		Created: formatTime(s.Created),
+		Updated: formatTime(s.Updated),
+		Summary: strings.TrimSpace(s.Summary),
+	}
+
+	toolNames := make(map[string]string)
+	for _, msg := range s.Messages {
+		hm := htmlMessage{
+			Role:    msg.Role,
+			Title:   roleTitle(msg.Role),
+			Content: strings.TrimSpace(msg.Content),
+		}
+		if msg.Role == "tool" {
+			hm.Title = "Result of " + toolNameOf(toolNames, msg.ToolCallID)
+		}
+		for _, tc := range msg.ToolCalls {
+			name, args := toolCallParts(tc)
+			toolNames[tc.ID] = name
+			hm.ToolCalls = append(hm.ToolCalls, htmlToolCall{Name: name, Args: prettyJSON(args)})
+		}
+		data.Messages = append(data.Messages, hm)
+	}
+
+	return htmlTemplate.Execute(w, data)
+}
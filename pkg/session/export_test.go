@@ -0,0 +1,148 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+func exportSession() *Session {
+	return &Session{
+		Key:     "telegram:1",
+		Summary: "User asked about invoices.",
+		Messages: []providers.Message{
+			{Role: "user", Content: "find the <invoice>"},
+			{Role: "assistant", ToolCalls: []providers.ToolCall{{
+				ID: "call_1", Type: "function",
+				Function: &providers.FunctionCall{Name: "read_file", Arguments: `{"path":"inv.md"}`},
+			}}},
+			{Role: "tool", Content: "Invoice #12\n```total: 5```", ToolCallID: "call_1"},
+			{Role: "assistant", Content: "Here it is."},
+		},
+		Created: time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+		Updated: time.Date(2026, 3, 1, 11, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestExport(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string // in order
+	}{
+		{FormatMarkdown, []string{
+			"# Session telegram:1",
+			"## Summary\n\nUser asked about invoices.",
+			"### User\n\nfind the <invoice>",
+			"**Tool call** `read_file`:\n\n```json\n{\n  \"path\": \"inv.md\"\n}\n```",
+			"**Tool result** `read_file`:\n\n````\nInvoice #12\n```total: 5```\n````",
+			"### Assistant\n\nHere it is.",
+		}},
+		{FormatHTML, []string{
+			"<title>Session telegram:1</title>",
+			"User asked about invoices.",
+			"find the &lt;invoice&gt;",
+			"<summary>🔧 read_file</summary>",
+			"<summary>Result of read_file</summary>",
+			"Here it is.",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Export(&buf, exportSession(), tt.format); err != nil {
+				t.Fatalf("Export: %v", err)
+			}
+			out := buf.String()
+			pos := 0
+			for _, want := range tt.want {
+				i := strings.Index(out[pos:], want)
+				if i < 0 {
+					t.Fatalf("Expected %q after offset %d in:\n%s", want, pos, out)
+				}
+				pos += i + len(want)
+			}
+		})
+	}
+}
+
+func TestExportJSONL_OpenAIFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(&buf, exportSession(), FormatJSONL); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected the summary and 4 messages, got %d lines:\n%s", len(lines), buf.String())
+	}
+
+	var msgs []openAIMessage
+	for _, line := range lines {
+		var msg openAIMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", line, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if msgs[0].Role != "system" || !strings.Contains(msgs[0].Content, "invoices") {
+		t.Errorf("Expected the summary as a system message first, got %+v", msgs[0])
+	}
+	call := msgs[2].ToolCalls
+	if len(call) != 1 || call[0].Type != "function" || call[0].Function.Name != "read_file" || call[0].Function.Arguments != `{"path":"inv.md"}` {
+		t.Errorf("Expected an OpenAI tool call, got %+v", call)
+	}
+	if msgs[3].Role != "tool" || msgs[3].ToolCallID != "call_1" {
+		t.Errorf("Expected the tool result to reference its call, got %+v", msgs[3])
+	}
+	if !strings.Contains(lines[1], "<invoice>") {
+		t.Errorf("Expected HTML characters unescaped, got %s", lines[1])
+	}
+}
+
+func TestFindAndGrep(t *testing.T) {
+	store, err := NewJSONStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewJSONStore: %v", err)
+	}
+	old := exportSession()
+	recent := &Session{
+		Key:      "discord:2",
+		Messages: []providers.Message{{Role: "user", Content: "no match here\nbut an INVOICE here"}},
+		Updated:  old.Updated.Add(48 * time.Hour),
+	}
+	for _, s := range []*Session{old, recent} {
+		if err := store.Save(s); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	all, err := Find(store, Filter{})
+	if err != nil || len(all) != 2 || all[0].Key != "discord:2" {
+		t.Fatalf("Expected both sessions, most recent first, got %d, %v", len(all), err)
+	}
+	if got, _ := Find(store, Filter{Channel: "telegram"}); len(got) != 1 || got[0].Key != "telegram:1" {
+		t.Errorf("Expected only the telegram session, got %+v", got)
+	}
+	if got, _ := Find(store, Filter{Since: old.Updated.Add(time.Hour)}); len(got) != 1 || got[0].Key != "discord:2" {
+		t.Errorf("Expected only the recent session, got %+v", got)
+	}
+	if got, _ := Find(store, Filter{Until: old.Updated.Add(time.Hour)}); len(got) != 1 || got[0].Key != "telegram:1" {
+		t.Errorf("Expected only the old session, got %+v", got)
+	}
+
+	matches := Grep(all, regexp.MustCompile(`(?i)invoice`))
+	want := []GrepMatch{
+		{Key: "discord:2", Index: 0, Role: "user", Line: "but an INVOICE here"},
+		{Key: "telegram:1", Index: 0, Role: "user", Line: "find the <invoice>"},
+		{Key: "telegram:1", Index: 2, Role: "tool", Line: "Invoice #12"},
+	}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("Grep = %+v, want %+v", matches, want)
+	}
+}
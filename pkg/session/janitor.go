@@ -0,0 +1,310 @@
+package session
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// RetentionPolicy decides which sessions and messages are archived.
+type RetentionPolicy struct {
+	IdleTTL     time.Duration            // archive sessions idle this long; 0 keeps them
+	ChannelTTL  map[string]time.Duration // per-channel overrides of IdleTTL
+	MaxMessages int                      // archive the oldest turns beyond this many messages; 0 keeps all
+}
+
+// ttlFor returns the idle TTL of the session key.
+func (p RetentionPolicy) ttlFor(key string) time.Duration {
+	if ttl, ok := p.ChannelTTL[ChannelOf(key)]; ok {
+		return ttl
+	}
+	return p.IdleTTL
+}
+
+// ArchiveIndexer is called with a session, or the part of it about to be
+// archived, before it is removed. archiveID names the archive file and is
+// the same whenever the same messages are archived again. If it returns an
+// error the messages are kept for the next sweep.
+type ArchiveIndexer func(ctx context.Context, archiveID string, archived *Session) error
+
+// Janitor applies a RetentionPolicy, archiving expired sessions and the
+// oldest turns of long ones to gzipped JSON files.
+type Janitor struct {
+	sessions   *SessionManager
+	policy     RetentionPolicy
+	archiveDir string
+	mediaDir   string
+	index      ArchiveIndexer
+	now        func() time.Time
+}
+
+// SweepStats counts what a sweep archived.
+type SweepStats struct {
+	Expired  int // sessions archived and removed
+	Trimmed  int // sessions whose oldest turns were archived
+	Archived int // messages archived
+	Media    int // attachment files no session refers to any more, removed
+}
+
+// NewJanitor archives from sessions into archiveDir. index may be nil.
+func NewJanitor(sessions *SessionManager, policy RetentionPolicy, archiveDir string, index ArchiveIndexer) *Janitor {
+	return &Janitor{
+		sessions:   sessions,
+		policy:     policy,
+		archiveDir: archiveDir,
+		index:      index,
+		now:        time.Now,
+	}
+}
+
+// SetMediaDir makes sweeps that archive messages remove the attachment
+// files in dir that no session refers to any more.
+func (j *Janitor) SetMediaDir(dir string) {
+	j.mediaDir = dir
+}
+
+// Run sweeps every interval until ctx is done.
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		j.sweepAndLog(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *Janitor) sweepAndLog(ctx context.Context) {
+	stats, err := j.Sweep(ctx)
+	if err != nil {
+		logger.WarnCF("session", "Session retention sweep failed",
+			map[string]interface{}{
+				"error": err.Error(),
+			})
+	}
+	if stats.Expired > 0 || stats.Trimmed > 0 {
+		logger.InfoCF("session", "Archived sessions",
+			map[string]interface{}{
+				"expired":  stats.Expired,
+				"trimmed":  stats.Trimmed,
+				"messages": stats.Archived,
+				"media":    stats.Media,
+			})
+	}
+}
+
+// Sweep archives every session the policy expires or trims. A session that
+// fails is logged and retried on the next sweep.
+func (j *Janitor) Sweep(ctx context.Context) (SweepStats, error) {
+	var stats SweepStats
+	infos, err := j.sessions.List()
+	if err != nil {
+		return stats, err
+	}
+
+	now := j.now()
+	for _, info := range infos {
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+
+		var err error
+		if ttl := j.policy.ttlFor(info.Key); ttl > 0 && now.Sub(info.Updated) >= ttl {
+			err = j.expire(ctx, info.Key, now.Add(-ttl), &stats)
+		} else if j.policy.MaxMessages > 0 && info.Messages > j.policy.MaxMessages {
+			err = j.trim(ctx, info.Key, &stats)
+		}
+		if err != nil {
+			logger.WarnCF("session", "Failed to archive session",
+				map[string]interface{}{
+					"session_key": info.Key,
+					"error":       err.Error(),
+				})
+		}
+	}
+
+	if j.mediaDir != "" && j.sessions.store != nil && (stats.Expired > 0 || stats.Trimmed > 0) {
+		stats.Media, err = PruneMedia(j.sessions.store, j.mediaDir, now)
+		if err != nil {
+			return stats, fmt.Errorf("prune media: %w", err)
+		}
+	}
+	return stats, nil
+}
+
+// expire archives and removes the session if it is still idle since before.
+func (j *Janitor) expire(ctx context.Context, key string, before time.Time, stats *SweepStats) error {
+	snapshot := j.sessions.Snapshot(key)
+	if snapshot == nil || !snapshot.Updated.Before(before) {
+		return nil
+	}
+
+	if len(snapshot.Messages) > 0 || snapshot.Summary != "" {
+		if err := j.archive(ctx, snapshot); err != nil {
+			return err
+		}
+	}
+	deleted, err := j.sessions.DeleteUnchanged(snapshot)
+	if err != nil || !deleted {
+		return err
+	}
+	stats.Expired++
+	stats.Archived += len(snapshot.Messages)
+	return nil
+}
+
+// trim archives the oldest turns of the session so that at most
+// MaxMessages remain.
+func (j *Janitor) trim(ctx context.Context, key string, stats *SweepStats) error {
+	snapshot := j.sessions.Snapshot(key)
+	if snapshot == nil {
+		return nil
+	}
+	cut := trimPoint(snapshot, j.policy.MaxMessages)
+	if cut == 0 {
+		return nil
+	}
+
+	archived := *snapshot
+	archived.Messages = snapshot.Messages[:cut]
+	archived.Turns = nil
+	archived.Summary = "" // stays with the session, archived when it expires
+	if err := j.archive(ctx, &archived); err != nil {
+		return err
+	}
+	if !j.sessions.DropOldest(snapshot, cut) {
+		return nil
+	}
+	if err := j.sessions.Save(key); err != nil {
+		return err
+	}
+	stats.Trimmed++
+	stats.Archived += cut
+	return nil
+}
+
+// trimPoint returns where to cut the session's history so that at most max
+// messages remain, at the start of a turn. It returns 0 if there is no such
+// cut.
+func trimPoint(s *Session, max int) int {
+	minCut := len(s.Messages) - max
+	if minCut <= 0 {
+		return 0
+	}
+	for _, start := range s.Turns {
+		if start >= minCut {
+			return start
+		}
+	}
+	// Sessions saved before turns were recorded: cut at a user message
+	if len(s.Turns) == 0 {
+		for i := minCut; i < len(s.Messages); i++ {
+			if s.Messages[i].Role == "user" {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+// archive indexes the session and writes it to its archive file. A sweep
+// that archives messages an earlier one already did, because removing them
+// failed or raced with a new message, finds the same file and leaves it.
+func (j *Janitor) archive(ctx context.Context, s *Session) error {
+	archiveID, err := archiveIDFor(s)
+	if err != nil {
+		return err
+	}
+	if j.index != nil {
+		if err := j.index(ctx, archiveID, s); err != nil {
+			return fmt.Errorf("index transcript: %w", err)
+		}
+	}
+	err = writeArchive(filepath.Join(j.archiveDir, archiveID+".json.gz"), s)
+	if os.IsExist(err) {
+		return nil
+	}
+	return err
+}
+
+// archiveIDFor names the archive of s after its key and a hash of the
+// archived messages and summary.
+func archiveIDFor(s *Session) (string, error) {
+	data, err := json.Marshal(struct {
+		Messages []providers.Message
+		Summary  string
+	}{s.Messages, s.Summary})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	name := strings.NewReplacer(":", "_", "/", "_", `\`, "_").Replace(s.Key)
+	return fmt.Sprintf("%s-%x", name, sum[:8]), nil
+}
+
+// writeArchive writes the session as gzipped JSON.
+func writeArchive(path string, s *Session) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(f)
+	enc := json.NewEncoder(zw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// ReadArchive reads a session archive written by a Janitor.
+func ReadArchive(path string) (*Session, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var s Session
+	if err := json.NewDecoder(zr).Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Messages == nil {
+		s.Messages = []providers.Message{}
+	}
+	return &s, nil
+}
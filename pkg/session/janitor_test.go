@@ -0,0 +1,205 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/media"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+func addTurns(sm *SessionManager, key string, contents ...string) {
+	for _, content := range contents {
+		sm.StartTurn(key)
+		sm.AddMessage(key, "user", content)
+		sm.AddMessage(key, "assistant", content+" answer")
+	}
+}
+
+func TestJanitor_ExpiresIdleSessions(t *testing.T) {
+	dir := t.TempDir()
+	archiveDir := filepath.Join(dir, "archive")
+	sm := NewSessionManager(dir)
+	for _, key := range []string{"telegram:1", "discord:2"} {
+		addTurns(sm, key, "hello")
+		if err := sm.Save(key); err != nil {
+			t.Fatalf("Save(%q): %v", key, err)
+		}
+	}
+
+	var indexed []string
+	fail := true
+	index := func(ctx context.Context, archiveID string, s *Session) error {
+		if fail {
+			return errors.New("embedding service down")
+		}
+		indexed = append(indexed, archiveID)
+		return nil
+	}
+	j := NewJanitor(sm, RetentionPolicy{
+		IdleTTL:    24 * time.Hour,
+		ChannelTTL: map[string]time.Duration{"discord": 0},
+	}, archiveDir, index)
+	j.now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+
+	// A session that cannot be indexed is kept for the next sweep
+	if stats, err := j.Sweep(context.Background()); err != nil || stats.Expired != 0 {
+		t.Fatalf("Sweep = %+v, %v; want nothing expired", stats, err)
+	}
+	if sm.Snapshot("telegram:1") == nil {
+		t.Fatal("Expected the session to be kept when indexing fails")
+	}
+
+	fail = false
+	stats, err := j.Sweep(context.Background())
+	if err != nil || stats != (SweepStats{Expired: 1, Archived: 2}) {
+		t.Fatalf("Sweep = %+v, %v; want one session of 2 messages expired", stats, err)
+	}
+	if sm.Snapshot("telegram:1") != nil || NewSessionManager(dir).Snapshot("telegram:1") != nil {
+		t.Error("Expected the expired session to be removed from memory and disk")
+	}
+	if sm.Snapshot("discord:2") == nil {
+		t.Error("Expected the channel without a TTL to keep its session")
+	}
+
+	if len(indexed) != 1 {
+		t.Fatalf("Expected one transcript indexed, got %v", indexed)
+	}
+	archived, err := ReadArchive(filepath.Join(archiveDir, indexed[0]+".json.gz"))
+	if err != nil {
+		t.Fatalf("ReadArchive: %v", err)
+	}
+	if archived.Key != "telegram:1" || !reflect.DeepEqual(contents(archived.Messages), []string{"hello", "hello answer"}) {
+		t.Errorf("Unexpected archive: %+v", archived)
+	}
+}
+
+func TestJanitor_TrimsAtTurnBoundary(t *testing.T) {
+	dir := t.TempDir()
+	archiveDir := filepath.Join(dir, "archive")
+	sm := NewSessionManager(dir)
+	key := "telegram:1"
+	addTurns(sm, key, "one", "two", "three")
+	sm.SetSummary(key, "counting")
+	updated := sm.Snapshot(key).Updated
+
+	j := NewJanitor(sm, RetentionPolicy{IdleTTL: 24 * time.Hour, MaxMessages: 3}, archiveDir, nil)
+	stats, err := j.Sweep(context.Background())
+	if err != nil || stats != (SweepStats{Trimmed: 1, Archived: 4}) {
+		t.Fatalf("Sweep = %+v, %v; want 4 messages trimmed", stats, err)
+	}
+
+	// The kept turn is whole, and trimming is not activity
+	s := sm.Snapshot(key)
+	if got := contents(s.Messages); !reflect.DeepEqual(got, []string{"three", "three answer"}) {
+		t.Errorf("Expected the last turn to be kept, got %v", got)
+	}
+	if !reflect.DeepEqual(s.Turns, []int{0}) || !s.Updated.Equal(updated) || s.Summary != "counting" {
+		t.Errorf("Unexpected session after trim: turns %v, updated %v, summary %q", s.Turns, s.Updated, s.Summary)
+	}
+	if got := contents(NewSessionManager(dir).GetHistory(key)); !reflect.DeepEqual(got, []string{"three", "three answer"}) {
+		t.Errorf("Expected the trimmed session to be saved, got %v", got)
+	}
+
+	files, err := os.ReadDir(archiveDir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one archive file, got %v, %v", files, err)
+	}
+	archived, err := ReadArchive(filepath.Join(archiveDir, files[0].Name()))
+	if err != nil {
+		t.Fatalf("ReadArchive: %v", err)
+	}
+	if got := contents(archived.Messages); !reflect.DeepEqual(got, []string{"one", "one answer", "two", "two answer"}) {
+		t.Errorf("Expected the first two turns archived, got %v", got)
+	}
+}
+
+func TestJanitor_RearchivingReusesTheArchive(t *testing.T) {
+	dir := t.TempDir()
+	archiveDir := filepath.Join(dir, "archive")
+	sm := NewSessionManager(dir)
+	addTurns(sm, "telegram:1", "hello")
+
+	var indexed []string
+	index := func(ctx context.Context, archiveID string, s *Session) error {
+		indexed = append(indexed, archiveID)
+		return nil
+	}
+	j := NewJanitor(sm, RetentionPolicy{}, archiveDir, index)
+
+	// As when removing the session failed after it was archived
+	for i := 0; i < 2; i++ {
+		j.now = func() time.Time { return time.Now().Add(time.Duration(i) * time.Hour) }
+		if err := j.archive(context.Background(), sm.Snapshot("telegram:1")); err != nil {
+			t.Fatalf("archive #%d: %v", i+1, err)
+		}
+	}
+
+	files, err := os.ReadDir(archiveDir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one archive file, got %v, %v", files, err)
+	}
+	if len(indexed) != 2 || indexed[0] != indexed[1] {
+		t.Errorf("Expected both sweeps to index under the same ID, got %v", indexed)
+	}
+}
+
+func TestJanitor_PrunesUnreferencedMedia(t *testing.T) {
+	dir := t.TempDir()
+	mediaDir := filepath.Join(dir, MediaDirname)
+	if err := os.MkdirAll(mediaDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"shared.jpg", "expired.jpg"} {
+		if err := os.WriteFile(filepath.Join(mediaDir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attach := func(names ...string) providers.Message {
+		msg := providers.Message{Role: "user", Content: "photo"}
+		for _, name := range names {
+			msg.Attachments = append(msg.Attachments, media.Attachment{Path: filepath.Join(mediaDir, name), MediaType: "image/jpeg"})
+		}
+		return msg
+	}
+
+	sm := NewSessionManager(dir)
+	sm.AddFullMessage("telegram:1", attach("shared.jpg", "expired.jpg"))
+	sm.AddFullMessage("discord:2", attach("shared.jpg"))
+	for _, key := range []string{"telegram:1", "discord:2"} {
+		if err := sm.Save(key); err != nil {
+			t.Fatalf("Save(%q): %v", key, err)
+		}
+	}
+
+	j := NewJanitor(sm, RetentionPolicy{
+		IdleTTL:    24 * time.Hour,
+		ChannelTTL: map[string]time.Duration{"discord": 0},
+	}, filepath.Join(dir, "archive"), nil)
+	j.SetMediaDir(mediaDir)
+	j.now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+
+	stats, err := j.Sweep(context.Background())
+	if err != nil || stats.Expired != 1 || stats.Media != 1 {
+		t.Fatalf("Sweep = %+v, %v; want one session expired and one file removed", stats, err)
+	}
+	if _, err := os.Stat(filepath.Join(mediaDir, "expired.jpg")); !os.IsNotExist(err) {
+		t.Error("Expected the expired session's own attachment to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(mediaDir, "shared.jpg")); err != nil {
+		t.Errorf("Expected the attachment another session refers to be kept: %v", err)
+	}
+
+	// Media stored for a turn whose session is not saved yet is kept
+	if err := os.WriteFile(filepath.Join(mediaDir, "new.jpg"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := PruneMedia(sm.store, mediaDir, time.Now()); err != nil || removed != 0 {
+		t.Errorf("PruneMedia = %d, %v; want the recent file kept", removed, err)
+	}
+}
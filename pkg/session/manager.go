@@ -1,45 +1,123 @@
 package session
 
 import (
-	"encoding/json"
 	"os"
-	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/providers"
 )
 
 type Session struct {
-	Key      string              `json:"key"`
-	Messages []providers.Message `json:"messages"`
-	Summary  string              `json:"summary,omitempty"`
-	Created  time.Time           `json:"created"`
-	Updated  time.Time           `json:"updated"`
+	Key          string              `json:"key"`
+	Messages     []providers.Message `json:"messages"`
+	Summary      string              `json:"summary,omitempty"`
+	Continuation string              `json:"continuation,omitempty"` // unfinished task for /continue
+	Turns        []int               `json:"turns,omitempty"`        // index in Messages where each turn starts
+	PlanMode     bool                `json:"plan_mode,omitempty"`    // read-only tools only, see /plan
+	Plan         string              `json:"plan,omitempty"`         // last plan proposed in plan mode, for /approve
+	Created      time.Time           `json:"created"`
+	Updated      time.Time           `json:"updated"`
+
+	// Bookkeeping for stores that save only new messages: Messages[0] is
+	// message number firstSeq of the session, the store already holds the
+	// first savedLen messages, and rev changes whenever saved messages are
+	// removed.
+	firstSeq int64
+	savedLen int
+	rev      int
+}
+
+// forget records that the session's messages from index start on were
+// removed, and the first cut messages were dropped from the front.
+func (s *Session) forget(start, cut int) {
+	if start < s.savedLen {
+		s.savedLen = start
+	}
+	s.firstSeq += int64(cut)
+	s.savedLen -= cut
+	if s.savedLen < 0 {
+		s.savedLen = 0
+	}
+	s.rev++
 }
 
 type SessionManager struct {
 	sessions map[string]*Session
+	missing  map[string]bool // keys the store has no session for
 	mu       sync.RWMutex
-	storage  string
+	store    SessionStore
 }
 
+// NewSessionManager keeps sessions in JSON files in the storage directory,
+// or only in memory if storage is empty.
 func NewSessionManager(storage string) *SessionManager {
-	sm := &SessionManager{
+	if storage == "" {
+		return NewSessionManagerWithStore(nil)
+	}
+	os.MkdirAll(storage, 0755)
+	return NewSessionManagerWithStore(&JSONStore{dir: storage})
+}
+
+// NewSessionManagerWithStore keeps sessions in store. A nil store keeps them
+// only in memory.
+func NewSessionManagerWithStore(store SessionStore) *SessionManager {
+	return &SessionManager{
 		sessions: make(map[string]*Session),
-		storage:  storage,
+		missing:  make(map[string]bool),
+		store:    store,
 	}
+}
+
+// Close closes the session store. Unsaved changes are lost.
+func (sm *SessionManager) Close() error {
+	if sm.store == nil {
+		return nil
+	}
+	return sm.store.Close()
+}
 
-	if storage != "" {
-		os.MkdirAll(storage, 0755)
-		sm.loadSessions()
+// load makes sure the session for key is in memory if the store has it.
+// Callers must not hold sm.mu.
+func (sm *SessionManager) load(key string) {
+	if sm.store == nil {
+		return
 	}
 
-	return sm
+	sm.mu.RLock()
+	_, loaded := sm.sessions[key]
+	missing := sm.missing[key]
+	sm.mu.RUnlock()
+	if loaded || missing {
+		return
+	}
+
+	session, err := sm.store.Load(key)
+	if err != nil {
+		logger.WarnCF("session", "Failed to load session",
+			map[string]interface{}{
+				"session_key": key,
+				"error":       err.Error(),
+			})
+		return
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if _, ok := sm.sessions[key]; ok {
+		return
+	}
+	if session == nil {
+		sm.missing[key] = true
+		return
+	}
+	sm.sessions[key] = session
 }
 
 func (sm *SessionManager) GetOrCreate(key string) *Session {
+	sm.load(key)
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -69,6 +147,8 @@ func (sm *SessionManager) AddMessage(sessionKey, role, content string) {
 // AddFullMessage adds a complete message with tool calls and tool call ID to the session.
 // This is used to save the full conversation flow including tool calls and tool results.
 func (sm *SessionManager) AddFullMessage(sessionKey string, msg providers.Message) {
+	sm.load(sessionKey)
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -87,6 +167,8 @@ func (sm *SessionManager) AddFullMessage(sessionKey string, msg providers.Messag
 }
 
 func (sm *SessionManager) GetHistory(key string) []providers.Message {
+	sm.load(key)
+
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
@@ -101,6 +183,8 @@ func (sm *SessionManager) GetHistory(key string) []providers.Message {
 }
 
 func (sm *SessionManager) GetSummary(key string) string {
+	sm.load(key)
+
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
@@ -112,6 +196,8 @@ func (sm *SessionManager) GetSummary(key string) string {
 }
 
 func (sm *SessionManager) SetSummary(key string, summary string) {
+	sm.load(key)
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -122,7 +208,176 @@ func (sm *SessionManager) SetSummary(key string, summary string) {
 	}
 }
 
+// GetContinuation returns the task left unfinished by the session's last turn,
+// or "" if there is none.
+func (sm *SessionManager) GetContinuation(key string) string {
+	sm.load(key)
+
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	session, ok := sm.sessions[key]
+	if !ok {
+		return ""
+	}
+	return session.Continuation
+}
+
+// SetContinuation records the task to resume with /continue. An empty task
+// clears it.
+func (sm *SessionManager) SetContinuation(key, task string) {
+	sm.load(key)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, ok := sm.sessions[key]
+	if ok && session.Continuation != task {
+		session.Continuation = task
+		session.Updated = time.Now()
+	}
+}
+
+// GetPlanMode reports whether the session is in plan mode.
+func (sm *SessionManager) GetPlanMode(key string) bool {
+	sm.load(key)
+
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	session, ok := sm.sessions[key]
+	return ok && session.PlanMode
+}
+
+// SetPlanMode turns plan mode on or off, creating the session if needed.
+// Leaving plan mode discards any plan awaiting approval.
+func (sm *SessionManager) SetPlanMode(key string, on bool) {
+	session := sm.GetOrCreate(key)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session.PlanMode = on
+	if !on {
+		session.Plan = ""
+	}
+	session.Updated = time.Now()
+}
+
+// GetPlan returns the plan awaiting /approve, or "" if there is none.
+func (sm *SessionManager) GetPlan(key string) string {
+	sm.load(key)
+
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	session, ok := sm.sessions[key]
+	if !ok {
+		return ""
+	}
+	return session.Plan
+}
+
+// SetPlan records the plan to carry out with /approve. An empty plan clears
+// it.
+func (sm *SessionManager) SetPlan(key, plan string) {
+	sm.load(key)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, ok := sm.sessions[key]
+	if ok && session.Plan != plan {
+		session.Plan = plan
+		session.Updated = time.Now()
+	}
+}
+
+// Reset clears the session's history, summary, continuation and pending
+// plan, keeping the session itself and its plan mode.
+func (sm *SessionManager) Reset(key string) {
+	sm.load(key)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, ok := sm.sessions[key]
+	if !ok {
+		return
+	}
+	session.forget(0, len(session.Messages))
+	session.Messages = []providers.Message{}
+	session.Turns = nil
+	session.Summary = ""
+	session.Continuation = ""
+	session.Plan = ""
+	session.Updated = time.Now()
+}
+
+// StartTurn marks the next message added to the session as the start of a
+// new turn.
+func (sm *SessionManager) StartTurn(key string) {
+	sm.load(key)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, ok := sm.sessions[key]
+	if !ok {
+		session = &Session{
+			Key:      key,
+			Messages: []providers.Message{},
+			Created:  time.Now(),
+		}
+		sm.sessions[key] = session
+	}
+	session.Turns = append(session.Turns, len(session.Messages))
+}
+
+// PopTurn removes the session's last turn and returns its messages, the
+// first of which is the user message that started it. Sessions saved before
+// turns were recorded fall back to the last user message. It returns false
+// if there is no complete turn left in the history.
+func (sm *SessionManager) PopTurn(key string) ([]providers.Message, bool) {
+	sm.load(key)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, ok := sm.sessions[key]
+	if !ok {
+		return nil, false
+	}
+
+	start := -1
+	if n := len(session.Turns); n > 0 {
+		start = session.Turns[n-1]
+		session.Turns = session.Turns[:n-1]
+	} else {
+		for i := len(session.Messages) - 1; i >= 0; i-- {
+			if session.Messages[i].Role == "user" {
+				start = i
+				break
+			}
+		}
+	}
+	if start < 0 || start >= len(session.Messages) {
+		return nil, false
+	}
+
+	turn := make([]providers.Message, len(session.Messages)-start)
+	copy(turn, session.Messages[start:])
+	session.forget(start, 0)
+	session.Messages = session.Messages[:start]
+	session.Continuation = ""
+	session.Plan = ""
+	session.Updated = time.Now()
+	return turn, true
+}
+
 func (sm *SessionManager) TruncateHistory(key string, keepLast int) {
+	sm.load(key)
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -132,7 +387,9 @@ func (sm *SessionManager) TruncateHistory(key string, keepLast int) {
 	}
 
 	if keepLast <= 0 {
+		session.forget(0, len(session.Messages))
 		session.Messages = []providers.Message{}
+		session.Turns = nil
 		session.Updated = time.Now()
 		return
 	}
@@ -141,126 +398,146 @@ func (sm *SessionManager) TruncateHistory(key string, keepLast int) {
 		return
 	}
 
-	session.Messages = session.Messages[len(session.Messages)-keepLast:]
+	session.dropFront(len(session.Messages) - keepLast)
 	session.Updated = time.Now()
 }
 
-// sanitizeFilename converts a session key into a cross-platform safe filename.
-// Session keys use "channel:chatID" (e.g. "telegram:123456") but ':' is the
-// volume separator on Windows, so filepath.Base would misinterpret the key.
-// We replace it with '_'. The original key is preserved inside the JSON file,
-// so loadSessions still maps back to the right in-memory key.
-func sanitizeFilename(key string) string {
-	return strings.ReplaceAll(key, ":", "_")
-}
-
-func (sm *SessionManager) Save(key string) error {
-	if sm.storage == "" {
-		return nil
+// dropFront removes the first cut messages.
+func (s *Session) dropFront(cut int) {
+	// Shift turn starts to the truncated history; turns that started
+	// before the cut are no longer complete and are dropped.
+	turns := s.Turns[:0]
+	for _, start := range s.Turns {
+		if start >= cut {
+			turns = append(turns, start-cut)
+		}
 	}
+	s.Turns = turns
 
-	filename := sanitizeFilename(key)
+	s.forget(len(s.Messages), cut)
+	s.Messages = s.Messages[cut:]
+}
 
-	// filepath.IsLocal rejects empty names, "..", absolute paths, and
-	// OS-reserved device names (NUL, COM1 … on Windows).
-	// The extra checks reject "." and any directory separators so that
-	// the session file is always written directly inside sm.storage.
-	if filename == "." || !filepath.IsLocal(filename) || strings.ContainsAny(filename, `/\`) {
-		return os.ErrInvalid
+// List describes every session, in memory or in the store.
+func (sm *SessionManager) List() ([]SessionInfo, error) {
+	var infos []SessionInfo
+	if sm.store != nil {
+		stored, err := sm.store.List()
+		if err != nil {
+			return nil, err
+		}
+		infos = stored
 	}
 
-	// Snapshot under read lock, then perform slow file I/O after unlock.
 	sm.mu.RLock()
-	stored, ok := sm.sessions[key]
-	if !ok {
-		sm.mu.RUnlock()
-		return nil
-	}
+	defer sm.mu.RUnlock()
 
-	snapshot := Session{
-		Key:     stored.Key,
-		Summary: stored.Summary,
-		Created: stored.Created,
-		Updated: stored.Updated,
+	// Sessions in memory may be ahead of the store
+	seen := make(map[string]int, len(infos))
+	for i, info := range infos {
+		seen[info.Key] = i
 	}
-	if len(stored.Messages) > 0 {
-		snapshot.Messages = make([]providers.Message, len(stored.Messages))
-		copy(snapshot.Messages, stored.Messages)
-	} else {
-		snapshot.Messages = []providers.Message{}
+	for key, session := range sm.sessions {
+		info := SessionInfo{Key: key, Messages: len(session.Messages), Updated: session.Updated}
+		if i, ok := seen[key]; ok {
+			infos[i] = info
+		} else {
+			infos = append(infos, info)
+		}
 	}
-	sm.mu.RUnlock()
+	return infos, nil
+}
 
-	data, err := json.MarshalIndent(snapshot, "", "  ")
-	if err != nil {
-		return err
-	}
+// Snapshot returns a copy of the session for key, or nil if there is none.
+func (sm *SessionManager) Snapshot(key string) *Session {
+	sm.load(key)
 
-	sessionPath := filepath.Join(sm.storage, filename+".json")
-	tmpFile, err := os.CreateTemp(sm.storage, "session-*.tmp")
-	if err != nil {
-		return err
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	session, ok := sm.sessions[key]
+	if !ok {
+		return nil
 	}
+	return session.clone()
+}
 
-	tmpPath := tmpFile.Name()
-	cleanup := true
-	defer func() {
-		if cleanup {
-			_ = os.Remove(tmpPath)
-		}
-	}()
+// DeleteUnchanged removes the session snapshot was taken from, in memory
+// and in the store, unless it changed since. It reports whether the session
+// was removed.
+func (sm *SessionManager) DeleteUnchanged(snapshot *Session) (bool, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 
-	if _, err := tmpFile.Write(data); err != nil {
-		_ = tmpFile.Close()
-		return err
+	session, ok := sm.sessions[snapshot.Key]
+	if !ok || !session.unchangedSince(snapshot) {
+		return false, nil
 	}
-	if err := tmpFile.Chmod(0644); err != nil {
-		_ = tmpFile.Close()
-		return err
-	}
-	if err := tmpFile.Sync(); err != nil {
-		_ = tmpFile.Close()
-		return err
-	}
-	if err := tmpFile.Close(); err != nil {
-		return err
+	if sm.store != nil {
+		if err := sm.store.Delete(snapshot.Key); err != nil {
+			return false, err
+		}
 	}
+	delete(sm.sessions, snapshot.Key)
+	delete(sm.missing, snapshot.Key)
+	return true, nil
+}
 
-	if err := os.Rename(tmpPath, sessionPath); err != nil {
-		return err
+// DropOldest removes the first n messages of the session snapshot was taken
+// from, unless messages were removed from it since. Unlike TruncateHistory
+// it does not count as activity. It reports whether messages were removed.
+func (sm *SessionManager) DropOldest(snapshot *Session, n int) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, ok := sm.sessions[snapshot.Key]
+	if !ok || session.rev != snapshot.rev || n > len(session.Messages) {
+		return false
 	}
-	cleanup = false
-	return nil
+	session.dropFront(n)
+	return true
 }
 
-func (sm *SessionManager) loadSessions() error {
-	files, err := os.ReadDir(sm.storage)
-	if err != nil {
-		return err
-	}
+// unchangedSince reports whether s is as it was when snapshot was taken.
+func (s *Session) unchangedSince(snapshot *Session) bool {
+	return s.rev == snapshot.rev && s.Updated.Equal(snapshot.Updated) && len(s.Messages) == len(snapshot.Messages)
+}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
+// clone returns a copy of s that shares no slices with it.
+func (s *Session) clone() *Session {
+	c := *s
+	c.Turns = append([]int(nil), s.Turns...)
+	c.Messages = make([]providers.Message, len(s.Messages))
+	copy(c.Messages, s.Messages)
+	return &c
+}
 
-		if filepath.Ext(file.Name()) != ".json" {
-			continue
-		}
+// Save writes the session for key to the store.
+func (sm *SessionManager) Save(key string) error {
+	if sm.store == nil {
+		return nil
+	}
 
-		sessionPath := filepath.Join(sm.storage, file.Name())
-		data, err := os.ReadFile(sessionPath)
-		if err != nil {
-			continue
-		}
+	// Snapshot under read lock, then perform slow I/O after unlock.
+	sm.mu.RLock()
+	stored, ok := sm.sessions[key]
+	if !ok {
+		sm.mu.RUnlock()
+		return nil
+	}
 
-		var session Session
-		if err := json.Unmarshal(data, &session); err != nil {
-			continue
-		}
+	snapshot := stored.clone()
+	sm.mu.RUnlock()
 
-		sm.sessions[session.Key] = &session
+	if err := sm.store.Save(snapshot); err != nil {
+		return err
 	}
 
+	// Messages removed while saving stay unsaved until the next save.
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.sessions[key] == stored && stored.rev == snapshot.rev {
+		stored.savedLen = len(snapshot.Messages)
+	}
 	return nil
 }
@@ -4,6 +4,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
 )
 
 func TestSanitizeFilename(t *testing.T) {
@@ -72,3 +74,115 @@ func TestSave_RejectsPathTraversal(t *testing.T) {
 		}
 	}
 }
+
+func TestContinuation_PersistsAcrossReload(t *testing.T) {
+	tmpDir := t.TempDir()
+	sm := NewSessionManager(tmpDir)
+
+	key := "telegram:123456"
+	sm.GetOrCreate(key)
+	sm.SetContinuation(key, "finish the report")
+	if err := sm.Save(key); err != nil {
+		t.Fatalf("Save(%q) failed: %v", key, err)
+	}
+
+	sm2 := NewSessionManager(tmpDir)
+	if got := sm2.GetContinuation(key); got != "finish the report" {
+		t.Errorf("expected continuation %q after reload, got %q", "finish the report", got)
+	}
+
+	sm2.SetContinuation(key, "")
+	if got := sm2.GetContinuation(key); got != "" {
+		t.Errorf("expected continuation to be cleared, got %q", got)
+	}
+}
+
+func TestPlanMode_PersistsAcrossReload(t *testing.T) {
+	tmpDir := t.TempDir()
+	sm := NewSessionManager(tmpDir)
+
+	key := "telegram:123456"
+	sm.SetPlanMode(key, true)
+	sm.SetPlan(key, "1. Write the report")
+	if err := sm.Save(key); err != nil {
+		t.Fatalf("Save(%q) failed: %v", key, err)
+	}
+
+	sm2 := NewSessionManager(tmpDir)
+	if !sm2.GetPlanMode(key) || sm2.GetPlan(key) != "1. Write the report" {
+		t.Errorf("expected plan mode and plan after reload, got %v, %q", sm2.GetPlanMode(key), sm2.GetPlan(key))
+	}
+
+	sm2.Reset(key)
+	if !sm2.GetPlanMode(key) || sm2.GetPlan(key) != "" {
+		t.Errorf("expected Reset to keep plan mode and drop the plan, got %v, %q", sm2.GetPlanMode(key), sm2.GetPlan(key))
+	}
+
+	sm2.SetPlan(key, "1. Write the report")
+	sm2.SetPlanMode(key, false)
+	if sm2.GetPlanMode(key) || sm2.GetPlan(key) != "" {
+		t.Errorf("expected leaving plan mode to drop the plan, got %v, %q", sm2.GetPlanMode(key), sm2.GetPlan(key))
+	}
+}
+
+func TestPopTurn_RemovesWholeTurn(t *testing.T) {
+	sm := NewSessionManager(t.TempDir())
+	key := "telegram:123"
+
+	sm.StartTurn(key)
+	sm.AddMessage(key, "user", "first")
+	sm.AddMessage(key, "assistant", "first answer")
+
+	sm.StartTurn(key)
+	sm.AddMessage(key, "user", "second")
+	sm.AddFullMessage(key, providers.Message{
+		Role:      "assistant",
+		ToolCalls: []providers.ToolCall{{ID: "call_1", Name: "read_file"}},
+	})
+	sm.AddFullMessage(key, providers.Message{Role: "tool", Content: "contents", ToolCallID: "call_1"})
+	sm.AddMessage(key, "assistant", "second answer")
+	sm.SetContinuation(key, "second")
+
+	turn, ok := sm.PopTurn(key)
+	if !ok {
+		t.Fatal("Expected a turn to pop")
+	}
+	if len(turn) != 4 || turn[0].Content != "second" {
+		t.Errorf("Expected the 4-message second turn, got %+v", turn)
+	}
+	if history := sm.GetHistory(key); len(history) != 2 || history[1].Content != "first answer" {
+		t.Errorf("Expected only the first turn to remain, got %+v", history)
+	}
+	if got := sm.GetContinuation(key); got != "" {
+		t.Errorf("Expected continuation to be cleared, got %q", got)
+	}
+
+	if _, ok := sm.PopTurn(key); !ok {
+		t.Fatal("Expected the first turn to pop")
+	}
+	if _, ok := sm.PopTurn(key); ok {
+		t.Error("Expected no turn left to pop")
+	}
+}
+
+func TestTruncateHistory_ShiftsTurns(t *testing.T) {
+	sm := NewSessionManager("")
+	key := "telegram:123"
+
+	for _, content := range []string{"one", "two", "three"} {
+		sm.StartTurn(key)
+		sm.AddMessage(key, "user", content)
+		sm.AddMessage(key, "assistant", content+" answer")
+	}
+
+	// Cut in the middle of the second turn: only the third stays complete
+	sm.TruncateHistory(key, 3)
+
+	turn, ok := sm.PopTurn(key)
+	if !ok || turn[0].Content != "three" || len(turn) != 2 {
+		t.Fatalf("Expected the third turn, got %+v", turn)
+	}
+	if _, ok := sm.PopTurn(key); ok {
+		t.Error("Expected the partial second turn not to be poppable")
+	}
+}
@@ -0,0 +1,49 @@
+package session
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/media"
+)
+
+// mediaGracePeriod keeps attachments stored this recently, whose sessions
+// may not have been saved yet, from being pruned.
+const mediaGracePeriod = time.Hour
+
+// MediaRefs counts, by file name, the references of stored sessions to
+// attachment files. Files are named by content hash, so one file may be
+// shared by several messages and sessions.
+func MediaRefs(store SessionStore) (map[string]int, error) {
+	infos, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]int)
+	for _, info := range infos {
+		s, err := store.Load(info.Key)
+		if err != nil {
+			return nil, err
+		}
+		if s == nil {
+			continue
+		}
+		for _, m := range s.Messages {
+			for _, a := range m.Attachments {
+				refs[filepath.Base(a.Path)]++
+			}
+		}
+	}
+	return refs, nil
+}
+
+// PruneMedia removes the attachment files in dir that no stored session
+// refers to any more, and returns how many it removed.
+func PruneMedia(store SessionStore, dir string, now time.Time) (int, error) {
+	refs, err := MediaRefs(store)
+	if err != nil {
+		return 0, err
+	}
+	return media.Prune(dir, func(name string) bool { return refs[name] > 0 }, now.Add(-mediaGracePeriod))
+}
@@ -0,0 +1,141 @@
+package session
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Filter selects sessions by channel and last activity. Zero fields match
+// every session.
+type Filter struct {
+	Channel string    // channel part of the session key, e.g. "telegram"
+	Since   time.Time // updated at or after
+	Until   time.Time // updated before
+}
+
+// ChannelOf returns the channel part of a "channel:chatID" session key.
+func ChannelOf(key string) string {
+	channel, _, _ := strings.Cut(key, ":")
+	return channel
+}
+
+// Matches reports whether s passes the filter.
+func (f Filter) Matches(s *Session) bool {
+	if f.Channel != "" && ChannelOf(s.Key) != f.Channel {
+		return false
+	}
+	if !f.Since.IsZero() && s.Updated.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !s.Updated.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Find loads the sessions in store that pass f, most recently updated first.
+func Find(store SessionStore, f Filter) ([]*Session, error) {
+	infos, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []*Session
+	for _, info := range infos {
+		// Skip loading sessions the filter rules out
+		if !f.Matches(&Session{Key: info.Key, Updated: info.Updated}) {
+			continue
+		}
+		s, err := store.Load(info.Key)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil && f.Matches(s) {
+			sessions = append(sessions, s)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Updated.After(sessions[j].Updated)
+	})
+	return sessions, nil
+}
+
+// GrepMatch is a line of a session message that matched Grep.
+type GrepMatch struct {
+	Key   string // session key
+	Index int    // message index in the session
+	Role  string
+	Line  string
+}
+
+// maxGrepLine is the longest line Grep returns; longer lines are cut around
+// the match.
+const maxGrepLine = 200
+
+// Grep returns the lines of message content and tool call arguments in
+// sessions that match re.
+func Grep(sessions []*Session, re *regexp.Regexp) []GrepMatch {
+	var matches []GrepMatch
+	for _, s := range sessions {
+		for i, msg := range s.Messages {
+			texts := []string{msg.Content}
+			for _, tc := range msg.ToolCalls {
+				name, args := toolCallParts(tc)
+				texts = append(texts, name+" "+args)
+			}
+			for _, text := range texts {
+				for _, line := range strings.Split(text, "\n") {
+					line = strings.TrimSpace(line)
+					loc := re.FindStringIndex(line)
+					if loc == nil {
+						continue
+					}
+					matches = append(matches, GrepMatch{
+						Key:   s.Key,
+						Index: i,
+						Role:  msg.Role,
+						Line:  clipAround(line, loc[0], loc[1]),
+					})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// clipAround shortens line to about maxGrepLine bytes, keeping the match
+// between start and end in view.
+func clipAround(line string, start, end int) string {
+	if len(line) <= maxGrepLine {
+		return line
+	}
+	from := start - (maxGrepLine-(end-start))/2
+	if from < 0 {
+		from = 0
+	}
+	to := from + maxGrepLine
+	if to > len(line) {
+		to = len(line)
+		from = to - maxGrepLine
+	}
+	// Avoid cutting UTF-8 sequences in half
+	for from > 0 && !utf8.RuneStart(line[from]) {
+		from--
+	}
+	for to < len(line) && !utf8.RuneStart(line[to]) {
+		to++
+	}
+
+	clipped := line[from:to]
+	if from > 0 {
+		clipped = "…" + clipped
+	}
+	if to < len(line) {
+		clipped += "…"
+	}
+	return clipped
+}
@@ -0,0 +1,187 @@
+package session
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+
+	_ "modernc.org/sqlite" // pure-Go driver, registers "sqlite"
+)
+
+// sqliteSchema keeps each message as its own row, so saving a session only
+// writes the messages added since it was last saved. Everything else about
+// a session is one JSON document, like a session file without messages.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	key     TEXT PRIMARY KEY,
+	updated TEXT NOT NULL,
+	data    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS messages (
+	session_key TEXT NOT NULL,
+	seq         INTEGER NOT NULL,
+	role        TEXT NOT NULL,
+	content     TEXT NOT NULL,
+	data        TEXT NOT NULL,
+	PRIMARY KEY (session_key, seq)
+);
+`
+
+// SQLiteStore keeps sessions in a SQLite database.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time; sharing one connection avoids
+	// busy errors between our own goroutines.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create session tables: %w", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Load(key string) (*Session, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM sessions WHERE key = ?`, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, fmt.Errorf("session %s: %w", key, err)
+	}
+
+	rows, err := s.db.Query(`SELECT seq, data FROM messages WHERE session_key = ? ORDER BY seq`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	session.Messages = []providers.Message{}
+	for rows.Next() {
+		var seq int64
+		var msgData string
+		if err := rows.Scan(&seq, &msgData); err != nil {
+			return nil, err
+		}
+		var msg providers.Message
+		if err := json.Unmarshal([]byte(msgData), &msg); err != nil {
+			return nil, fmt.Errorf("session %s message %d: %w", key, seq, err)
+		}
+		if len(session.Messages) == 0 {
+			session.firstSeq = seq
+		}
+		session.Messages = append(session.Messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	session.savedLen = len(session.Messages)
+	return &session, nil
+}
+
+// Save writes the session's metadata and the messages the database does not
+// hold yet, and deletes rows for messages the session no longer has.
+func (s *SQLiteStore) Save(session *Session) error {
+	meta := *session
+	meta.Messages = nil
+	data, err := json.Marshal(&meta)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO sessions (key, updated, data) VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET updated = excluded.updated, data = excluded.data`,
+		session.Key, session.Updated.UTC().Format(time.RFC3339Nano), string(data)); err != nil {
+		return err
+	}
+
+	saved := session.firstSeq + int64(session.savedLen)
+	if _, err := tx.Exec(`DELETE FROM messages WHERE session_key = ? AND (seq < ? OR seq >= ?)`,
+		session.Key, session.firstSeq, saved); err != nil {
+		return err
+	}
+
+	if session.savedLen < len(session.Messages) {
+		stmt, err := tx.Prepare(`INSERT INTO messages (session_key, seq, role, content, data) VALUES (?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for i, msg := range session.Messages[session.savedLen:] {
+			msgData, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			if _, err := stmt.Exec(session.Key, saved+int64(i), msg.Role, msg.Content, string(msgData)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Delete(key string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM messages WHERE session_key = ?`, key); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE key = ?`, key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) List() ([]SessionInfo, error) {
+	rows, err := s.db.Query(`SELECT s.key, s.updated,
+		(SELECT COUNT(*) FROM messages m WHERE m.session_key = s.key)
+		FROM sessions s ORDER BY s.key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var infos []SessionInfo
+	for rows.Next() {
+		var info SessionInfo
+		var updated string
+		if err := rows.Scan(&info.Key, &updated, &info.Messages); err != nil {
+			return nil, err
+		}
+		info.Updated, _ = time.Parse(time.RFC3339Nano, updated)
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,253 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// SessionStore persists sessions for a SessionManager, which loads each
+// session from the store the first time it is used. Implementations must be
+// safe for concurrent use.
+type SessionStore interface {
+	// Load returns the stored session for key, or nil if there is none.
+	Load(key string) (*Session, error)
+	// Save persists session, replacing any stored session with its key.
+	// The caller must not modify session during the call.
+	Save(session *Session) error
+	// Delete removes the stored session for key, if any.
+	Delete(key string) error
+	// List describes every stored session.
+	List() ([]SessionInfo, error)
+	Close() error
+}
+
+// SessionInfo describes a stored session without its messages.
+type SessionInfo struct {
+	Key      string
+	Messages int
+	Updated  time.Time
+}
+
+// Store backends accepted by OpenStore.
+const (
+	StoreJSON   = "json"
+	StoreSQLite = "sqlite"
+)
+
+// SQLiteFilename is the SQLite store's database file in the sessions directory.
+const SQLiteFilename = "sessions.db"
+
+// MediaDirname is the directory of session attachments in the sessions
+// directory.
+const MediaDirname = "media"
+
+// OpenStore opens the named store backend in dir. An empty kind is the
+// JSON-file store.
+func OpenStore(kind, dir string) (SessionStore, error) {
+	switch kind {
+	case "", StoreJSON:
+		return NewJSONStore(dir)
+	case StoreSQLite:
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		return NewSQLiteStore(filepath.Join(dir, SQLiteFilename))
+	default:
+		return nil, fmt.Errorf("unknown session store %q (want %q or %q)", kind, StoreJSON, StoreSQLite)
+	}
+}
+
+// CopySessions copies every session in from to to, returning how many were
+// copied. Sessions already in to are overwritten.
+func CopySessions(from, to SessionStore) (int, error) {
+	infos, err := from.List()
+	if err != nil {
+		return 0, err
+	}
+
+	copied := 0
+	for _, info := range infos {
+		key := info.Key
+		session, err := from.Load(key)
+		if err != nil {
+			return copied, fmt.Errorf("load %s: %w", key, err)
+		}
+		if session == nil {
+			continue
+		}
+		// Stores that save incrementally must write every message.
+		session.firstSeq, session.savedLen = 0, 0
+		if err := to.Save(session); err != nil {
+			return copied, fmt.Errorf("save %s: %w", key, err)
+		}
+		copied++
+	}
+	return copied, nil
+}
+
+// JSONStore keeps each session in its own JSON file, rewritten in full on
+// every save.
+type JSONStore struct {
+	dir string
+}
+
+func NewJSONStore(dir string) (*JSONStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &JSONStore{dir: dir}, nil
+}
+
+// sanitizeFilename converts a session key into a cross-platform safe filename.
+// Session keys use "channel:chatID" (e.g. "telegram:123456") but ':' is the
+// volume separator on Windows, so filepath.Base would misinterpret the key.
+// We replace it with '_'. The original key is preserved inside the JSON file,
+// so List still maps back to the right key.
+func sanitizeFilename(key string) string {
+	return strings.ReplaceAll(key, ":", "_")
+}
+
+// path returns the file of the session key.
+func (s *JSONStore) path(key string) (string, error) {
+	filename := sanitizeFilename(key)
+
+	// filepath.IsLocal rejects empty names, "..", absolute paths, and
+	// OS-reserved device names (NUL, COM1 … on Windows).
+	// The extra checks reject "." and any directory separators so that
+	// the session file is always written directly inside s.dir.
+	if filename == "." || !filepath.IsLocal(filename) || strings.ContainsAny(filename, `/\`) {
+		return "", os.ErrInvalid
+	}
+	return filepath.Join(s.dir, filename+".json"), nil
+}
+
+func (s *JSONStore) Load(key string) (*Session, error) {
+	sessionPath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	session, err := readSessionFile(sessionPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Different keys can share a filename; the file belongs to the key
+	// inside it.
+	if session.Key != key {
+		return nil, nil
+	}
+	return session, nil
+}
+
+func readSessionFile(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if session.Messages == nil {
+		session.Messages = []providers.Message{}
+	}
+	return &session, nil
+}
+
+func (s *JSONStore) Save(session *Session) error {
+	sessionPath, err := s.path(session.Key)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(s.dir, "session-*.tmp")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmpFile.Name()
+	cleanup := true
+	defer func() {
+		if cleanup {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Chmod(0644); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, sessionPath); err != nil {
+		return err
+	}
+	cleanup = false
+	return nil
+}
+
+func (s *JSONStore) Delete(key string) error {
+	sessionPath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if session, err := readSessionFile(sessionPath); err == nil && session.Key != key {
+		return nil
+	}
+	if err := os.Remove(sessionPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List reads every session file. Unreadable files are skipped.
+func (s *JSONStore) List() ([]SessionInfo, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []SessionInfo
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		session, err := readSessionFile(filepath.Join(s.dir, file.Name()))
+		if err != nil {
+			continue
+		}
+		infos = append(infos, SessionInfo{
+			Key:      session.Key,
+			Messages: len(session.Messages),
+			Updated:  session.Updated,
+		})
+	}
+	return infos, nil
+}
+
+func (s *JSONStore) Close() error {
+	return nil
+}
@@ -0,0 +1,131 @@
+package session
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+func openSQLite(t *testing.T, path string) *SQLiteStore {
+	t.Helper()
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func contents(msgs []providers.Message) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.Content
+	}
+	return out
+}
+
+func TestSQLiteStore_SavesIncrementally(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SQLiteFilename)
+	sm := NewSessionManagerWithStore(openSQLite(t, path))
+	key := "telegram:123"
+
+	for _, content := range []string{"one", "two", "three"} {
+		sm.StartTurn(key)
+		sm.AddMessage(key, "user", content)
+		sm.AddMessage(key, "assistant", content+" answer")
+		if err := sm.Save(key); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	// Rewrite the end and drop the front between saves
+	if _, ok := sm.PopTurn(key); !ok {
+		t.Fatal("Expected a turn to pop")
+	}
+	sm.StartTurn(key)
+	sm.AddMessage(key, "user", "four")
+	sm.AddFullMessage(key, providers.Message{
+		Role:      "assistant",
+		ToolCalls: []providers.ToolCall{{ID: "call_1", Name: "read_file"}},
+	})
+	sm.TruncateHistory(key, 4)
+	sm.SetContinuation(key, "four")
+	sm.SetPlanMode(key, true)
+	if err := sm.Save(key); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	want := sm.GetHistory(key)
+	if !reflect.DeepEqual(contents(want), []string{"two", "two answer", "four", ""}) {
+		t.Fatalf("Unexpected history before reload: %v", contents(want))
+	}
+
+	sm2 := NewSessionManagerWithStore(openSQLite(t, path))
+	got := sm2.GetHistory(key)
+	if !reflect.DeepEqual(contents(got), contents(want)) {
+		t.Fatalf("Expected history after reload to be %v, got %v", contents(want), contents(got))
+	}
+	if len(got[3].ToolCalls) != 1 || got[3].ToolCalls[0].ID != "call_1" {
+		t.Errorf("Expected the tool call to round-trip, got %+v", got[3])
+	}
+	if sm2.GetContinuation(key) != "four" || !sm2.GetPlanMode(key) {
+		t.Errorf("Expected continuation and plan mode after reload, got %q, %v", sm2.GetContinuation(key), sm2.GetPlanMode(key))
+	}
+	if turn, ok := sm2.PopTurn(key); !ok || turn[0].Content != "four" {
+		t.Errorf("Expected turns to round-trip, got %+v", turn)
+	}
+
+	// Saving after a reset leaves no stale rows behind
+	sm2.Reset(key)
+	sm2.AddMessage(key, "user", "five")
+	if err := sm2.Save(key); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	sm3 := NewSessionManagerWithStore(openSQLite(t, path))
+	if got := contents(sm3.GetHistory(key)); !reflect.DeepEqual(got, []string{"five"}) {
+		t.Errorf("Expected only the message after reset, got %v", got)
+	}
+}
+
+func TestCopySessions_JSONToSQLite(t *testing.T) {
+	dir := t.TempDir()
+	sm := NewSessionManager(dir)
+	for _, key := range []string{"telegram:1", "discord:2"} {
+		sm.StartTurn(key)
+		sm.AddMessage(key, "user", "hello from "+key)
+		sm.SetSummary(key, "summary of "+key)
+		if err := sm.Save(key); err != nil {
+			t.Fatalf("Save(%q): %v", key, err)
+		}
+	}
+
+	from, err := OpenStore(StoreJSON, dir)
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+	to := openSQLite(t, filepath.Join(dir, SQLiteFilename))
+	copied, err := CopySessions(from, to)
+	if err != nil || copied != 2 {
+		t.Fatalf("CopySessions = %d, %v; want 2, nil", copied, err)
+	}
+
+	infos, err := to.List()
+	if err != nil || len(infos) != 2 || infos[0].Key != "discord:2" || infos[1].Key != "telegram:1" || infos[1].Messages != 1 {
+		t.Fatalf("List = %+v, %v", infos, err)
+	}
+	migrated := NewSessionManagerWithStore(to)
+	if got := migrated.GetHistory("telegram:1"); len(got) != 1 || got[0].Content != "hello from telegram:1" {
+		t.Errorf("Expected the migrated message, got %+v", got)
+	}
+	if got := migrated.GetSummary("discord:2"); got != "summary of discord:2" {
+		t.Errorf("Expected the migrated summary, got %q", got)
+	}
+
+	if err := to.Delete("telegram:1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if session, err := to.Load("telegram:1"); err != nil || session != nil {
+		t.Errorf("Expected no session after Delete, got %+v, %v", session, err)
+	}
+}
@@ -22,15 +22,32 @@ Below are recent knowledge entries extracted from conversations involving this s
 3. What could you do better next time?
 4. Any recurring topics or entities to track more closely?
 
-Keep your notes concise and actionable (max 10 bullet points).
+Keep your notes concise and actionable (max 10 notes, one sentence each).
 
 RECENT KNOWLEDGE:
 %s
 
-Write your self-improvement notes below:`
+Return ONLY a JSON object: {"notes": ["first note", "second note"]}`
+
+// reviewSchema is the response schema of the review prompt.
+var reviewSchema = &providers.ResponseSchema{
+	Name:        "specialist_review",
+	Description: "Self-improvement notes for the specialist",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"notes": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string"},
+			},
+		},
+		"required": []string{"notes"},
+	},
+}
 
 // ReviewSpecialist analyzes recent specialist interactions and writes learnings.
-func ReviewSpecialist(ctx context.Context, name string, provider providers.LLMProvider, model string, store *memory.VectorStore, workspace string) error {
+// options (e.g. from the "review" generation profile) override the call's defaults.
+func ReviewSpecialist(ctx context.Context, name string, provider providers.LLMProvider, model string, options map[string]interface{}, store *memory.VectorStore, workspace string) error {
 	if store == nil {
 		return fmt.Errorf("vector store not available")
 	}
@@ -59,15 +76,21 @@ func ReviewSpecialist(ctx context.Context, name string, provider providers.LLMPr
 	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
-	resp, err := provider.Chat(ctx, []providers.Message{
+	var review struct {
+		Notes []string `json:"notes"`
+	}
+	err = providers.ChatJSON(ctx, provider, []providers.Message{
 		{Role: "user", Content: prompt},
-	}, nil, model, map[string]interface{}{
+	}, model, providers.MergeOptions(map[string]interface{}{
 		"max_tokens":  1024,
 		"temperature": 0.3,
-	})
+	}, options), reviewSchema, &review)
 	if err != nil {
 		return fmt.Errorf("review LLM call: %w", err)
 	}
+	if len(review.Notes) == 0 {
+		return nil
+	}
 
 	// Write to LEARNINGS.md
 	learningsPath := filepath.Join(workspace, "specialists", name, "LEARNINGS.md")
@@ -80,8 +103,9 @@ func ReviewSpecialist(ctx context.Context, name string, provider providers.LLMPr
 	defer f.Close()
 
 	f.WriteString(header)
-	f.WriteString(strings.TrimSpace(resp.Content))
-	f.WriteString("\n")
+	for _, note := range review.Notes {
+		f.WriteString("- " + strings.TrimPrefix(strings.TrimSpace(note), "- ") + "\n")
+	}
 
 	logger.InfoCF("specialist", "Specialist review completed", map[string]interface{}{
 		"specialist":    name,
@@ -92,10 +116,10 @@ func ReviewSpecialist(ctx context.Context, name string, provider providers.LLMPr
 }
 
 // ReviewAllSpecialists runs a review for each specialist that has knowledge entries.
-func ReviewAllSpecialists(ctx context.Context, loader *SpecialistLoader, provider providers.LLMProvider, model string, store *memory.VectorStore, workspace string) {
+func ReviewAllSpecialists(ctx context.Context, loader *SpecialistLoader, provider providers.LLMProvider, model string, options map[string]interface{}, store *memory.VectorStore, workspace string) {
 	specialists := loader.ListSpecialists()
 	for _, s := range specialists {
-		if err := ReviewSpecialist(ctx, s.Name, provider, model, store, workspace); err != nil {
+		if err := ReviewSpecialist(ctx, s.Name, provider, model, options, store, workspace); err != nil {
 			logger.WarnCF("specialist", "Review failed", map[string]interface{}{
 				"specialist": s.Name,
 				"error":      err.Error(),
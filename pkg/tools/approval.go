@@ -0,0 +1,299 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/constants"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+var (
+	// ErrApprovalDenied is returned when the user rejects a tool call.
+	ErrApprovalDenied = errors.New("denied by the user")
+	// ErrApprovalTimeout is returned when the user does not answer in time.
+	ErrApprovalTimeout = errors.New("approval request timed out")
+	// ErrNoApprovalChannel is returned when there is no user to ask.
+	ErrNoApprovalChannel = errors.New("no user channel to ask for approval")
+)
+
+// ApprovalRule marks calls to Tool as requiring approval. When Args is set,
+// only calls whose arguments match every pattern (argument name to regular
+// expression) need approval.
+type ApprovalRule struct {
+	Tool string
+	Args map[string]string
+}
+
+type compiledApprovalRule struct {
+	tool string
+	args map[string]*regexp.Regexp
+}
+
+// ApprovalPolicy decides which tool calls need the user's approval.
+type ApprovalPolicy struct {
+	rules []compiledApprovalRule
+}
+
+// NewApprovalPolicy compiles rules into a policy. A rule with an invalid
+// pattern is kept without argument filters, so it requires approval for every
+// call to its tool, and the error is returned alongside the policy.
+func NewApprovalPolicy(rules []ApprovalRule) (*ApprovalPolicy, error) {
+	policy := &ApprovalPolicy{}
+	var errs []error
+	for _, rule := range rules {
+		compiled := compiledApprovalRule{tool: rule.Tool}
+		for name, pattern := range rule.Args {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("approval rule for %s: argument %s: %w", rule.Tool, name, err))
+				compiled.args = nil
+				break
+			}
+			if compiled.args == nil {
+				compiled.args = make(map[string]*regexp.Regexp)
+			}
+			compiled.args[name] = re
+		}
+		policy.rules = append(policy.rules, compiled)
+	}
+	return policy, errors.Join(errs...)
+}
+
+// Requires reports whether a call to the named tool with args needs approval.
+func (p *ApprovalPolicy) Requires(name string, args map[string]interface{}) bool {
+	if p == nil {
+		return false
+	}
+	for _, rule := range p.rules {
+		if rule.tool != name {
+			continue
+		}
+		matched := true
+		for arg, re := range rule.args {
+			value := ""
+			if v, ok := args[arg]; ok && v != nil {
+				value = fmt.Sprintf("%v", v)
+			}
+			if !re.MatchString(value) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ApprovalRequest describes a tool call waiting for approval and where the
+// request for it should be sent.
+type ApprovalRequest struct {
+	Tool     string
+	Args     map[string]interface{}
+	Channel  string
+	ChatID   string
+	Metadata map[string]string
+	SenderID string // user whose turn made the call; only they may answer
+}
+
+// Approver asks a user to approve a tool call. RequestApproval blocks until
+// the user answers, the request times out or ctx is done, and returns nil only
+// if the call was approved.
+type Approver interface {
+	RequestApproval(ctx context.Context, req ApprovalRequest) error
+}
+
+// approvalCallbackPrefix starts the data of approval buttons; pressing one
+// arrives as an inbound message "approval:<id>:yes" or "approval:<id>:no".
+const approvalCallbackPrefix = "approval:"
+
+var (
+	approveWords = map[string]bool{"yes": true, "y": true, "approve": true, "approved": true, "allow": true, "ok": true}
+	denyWords    = map[string]bool{"no": true, "n": true, "deny": true, "reject": true, "cancel": true}
+)
+
+type pendingApproval struct {
+	id      string
+	req     ApprovalRequest
+	created time.Time
+	reply   chan bool
+}
+
+// ApprovalManager is an Approver that sends approval requests to the chat the
+// tool call originated from and waits for the user's reply. Replies are fed
+// in through HandleReply before the message would reach the agent.
+type ApprovalManager struct {
+	bus     *bus.MessageBus
+	timeout time.Duration
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[string]*pendingApproval
+}
+
+// NewApprovalManager creates an approval manager that publishes requests on
+// msgBus and gives up after timeout.
+func NewApprovalManager(msgBus *bus.MessageBus, timeout time.Duration) *ApprovalManager {
+	return &ApprovalManager{
+		bus:     msgBus,
+		timeout: timeout,
+		pending: make(map[string]*pendingApproval),
+	}
+}
+
+// RequestApproval sends an approval request with Approve/Deny buttons and
+// waits for the answer.
+func (m *ApprovalManager) RequestApproval(ctx context.Context, req ApprovalRequest) error {
+	if req.Channel == "" || req.ChatID == "" || constants.IsInternalChannel(req.Channel) {
+		return ErrNoApprovalChannel
+	}
+
+	m.mu.Lock()
+	m.nextID++
+	p := &pendingApproval{
+		id:      fmt.Sprintf("%d", m.nextID),
+		req:     req,
+		created: time.Now(),
+		reply:   make(chan bool, 1),
+	}
+	m.pending[p.id] = p
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.pending, p.id)
+		m.mu.Unlock()
+	}()
+
+	logger.InfoCF("approval", "Requesting approval",
+		map[string]interface{}{
+			"id":      p.id,
+			"tool":    req.Tool,
+			"channel": req.Channel,
+			"chat_id": req.ChatID,
+		})
+
+	m.bus.PublishOutbound(bus.OutboundMessage{
+		Channel:  req.Channel,
+		ChatID:   req.ChatID,
+		Content:  formatApprovalRequest(req, m.timeout),
+		Metadata: req.Metadata,
+		Buttons: []bus.Button{
+			{Text: "Approve", Data: approvalCallbackPrefix + p.id + ":yes"},
+			{Text: "Deny", Data: approvalCallbackPrefix + p.id + ":no"},
+		},
+	})
+
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+
+	select {
+	case approved := <-p.reply:
+		logger.InfoCF("approval", "Approval answered",
+			map[string]interface{}{
+				"id":       p.id,
+				"tool":     req.Tool,
+				"approved": approved,
+			})
+		if !approved {
+			return ErrApprovalDenied
+		}
+		return nil
+	case <-timer.C:
+		m.bus.PublishOutbound(bus.OutboundMessage{
+			Channel:  req.Channel,
+			ChatID:   req.ChatID,
+			Content:  fmt.Sprintf("Approval request for `%s` expired; the call was not run.", req.Tool),
+			Metadata: req.Metadata,
+		})
+		return ErrApprovalTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// HandleReply resolves a pending approval from an inbound message. It returns
+// true if the message was an approval answer and must not be processed
+// further. Button presses name their request; a plain "yes" or "no" answers
+// the oldest request pending in the same chat. Only the user whose turn made
+// the call may answer; others in a group chat are ignored.
+func (m *ApprovalManager) HandleReply(msg bus.InboundMessage) bool {
+	content := strings.TrimSpace(msg.Content)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if strings.HasPrefix(content, approvalCallbackPrefix) {
+		id, answer, _ := strings.Cut(strings.TrimPrefix(content, approvalCallbackPrefix), ":")
+		if p, ok := m.pending[id]; ok && mayAnswer(p.req, msg) {
+			m.resolve(p, answer == "yes")
+		}
+		// Presses on expired requests are swallowed too
+		return true
+	}
+
+	word := strings.ToLower(strings.Trim(content, ".! "))
+	if !approveWords[word] && !denyWords[word] {
+		return false
+	}
+
+	var candidates []*pendingApproval
+	for _, p := range m.pending {
+		if mayAnswer(p.req, msg) {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].created.Before(candidates[j].created)
+	})
+	m.resolve(candidates[0], approveWords[word])
+	return true
+}
+
+// resolve delivers the answer and forgets the request. Callers hold m.mu.
+func (m *ApprovalManager) resolve(p *pendingApproval, approved bool) {
+	delete(m.pending, p.id)
+	p.reply <- approved
+}
+
+// mayAnswer reports whether msg came from the chat (and forum topic) an
+// approval request was sent to, and from the user who made the request.
+// Requests from turns without a sender, such as cron jobs, may be answered
+// by anyone in the chat.
+func mayAnswer(req ApprovalRequest, msg bus.InboundMessage) bool {
+	return req.Channel == msg.Channel &&
+		req.ChatID == msg.ChatID &&
+		req.Metadata["thread_id"] == msg.Metadata["thread_id"] &&
+		(req.SenderID == "" || req.SenderID == msg.SenderID)
+}
+
+// formatApprovalRequest renders the message asking the user to approve a call.
+func formatApprovalRequest(req ApprovalRequest, timeout time.Duration) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Approval needed to run `%s`:\n", req.Tool)
+
+	names := make([]string, 0, len(req.Args))
+	for name := range req.Args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&sb, "- %s: %s\n", name, utils.Truncate(fmt.Sprintf("%v", req.Args[name]), 300))
+	}
+
+	fmt.Fprintf(&sb, "\nReply yes to approve or no to deny (expires in %s).", timeout.Round(time.Second))
+	return sb.String()
+}
@@ -0,0 +1,246 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+)
+
+func TestApprovalPolicy_Requires(t *testing.T) {
+	policy, err := NewApprovalPolicy([]ApprovalRule{
+		{Tool: "exec"},
+		{Tool: "email", Args: map[string]string{"action": "^(send|reply)$"}},
+	})
+	if err != nil {
+		t.Fatalf("NewApprovalPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		tool string
+		args map[string]interface{}
+		want bool
+	}{
+		{"tool without filters", "exec", map[string]interface{}{"command": "ls"}, true},
+		{"matching argument", "email", map[string]interface{}{"action": "send"}, true},
+		{"non-matching argument", "email", map[string]interface{}{"action": "recent"}, false},
+		{"missing argument", "email", map[string]interface{}{}, false},
+		{"unlisted tool", "read_file", map[string]interface{}{"path": "x"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Requires(tt.tool, tt.args); got != tt.want {
+				t.Errorf("Requires(%q, %v) = %v, want %v", tt.tool, tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApprovalPolicy_InvalidPatternFailsClosed(t *testing.T) {
+	policy, err := NewApprovalPolicy([]ApprovalRule{
+		{Tool: "email", Args: map[string]string{"action": "("}},
+	})
+	if err == nil {
+		t.Fatal("Expected an error for an invalid pattern")
+	}
+	if !policy.Requires("email", map[string]interface{}{"action": "recent"}) {
+		t.Error("Expected a rule with an invalid pattern to require approval for every call")
+	}
+}
+
+// requestApproval starts an approval request and returns the published
+// request message and a channel delivering the result.
+func requestApproval(t *testing.T, m *ApprovalManager, msgBus *bus.MessageBus, req ApprovalRequest) (bus.OutboundMessage, <-chan error) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- m.RequestApproval(context.Background(), req)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	out, ok := msgBus.SubscribeOutbound(ctx)
+	if !ok {
+		t.Fatal("Timed out waiting for the approval request")
+	}
+	return out, done
+}
+
+func waitApproval(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the approval result")
+		return nil
+	}
+}
+
+func TestApprovalManager(t *testing.T) {
+	req := ApprovalRequest{
+		Tool:     "exec",
+		Args:     map[string]interface{}{"command": "rm -rf build"},
+		Channel:  "telegram",
+		ChatID:   "42",
+		Metadata: map[string]string{"thread_id": "7"},
+		SenderID: "alice",
+	}
+	inbound := func(content, threadID string) bus.InboundMessage {
+		return bus.InboundMessage{
+			Channel:  "telegram",
+			SenderID: "alice",
+			ChatID:   "42",
+			Content:  content,
+			Metadata: map[string]string{"thread_id": threadID},
+		}
+	}
+
+	t.Run("button approves", func(t *testing.T) {
+		msgBus := bus.NewMessageBus()
+		m := NewApprovalManager(msgBus, time.Second)
+		out, done := requestApproval(t, m, msgBus, req)
+
+		if !strings.Contains(out.Content, "rm -rf build") || len(out.Buttons) != 2 {
+			t.Fatalf("Unexpected approval request: %+v", out)
+		}
+		if !m.HandleReply(inbound(out.Buttons[0].Data, "7")) {
+			t.Fatal("Expected the button press to be handled")
+		}
+		if err := waitApproval(t, done); err != nil {
+			t.Errorf("Expected approval, got %v", err)
+		}
+	})
+
+	t.Run("text reply denies", func(t *testing.T) {
+		msgBus := bus.NewMessageBus()
+		m := NewApprovalManager(msgBus, time.Second)
+		_, done := requestApproval(t, m, msgBus, req)
+
+		if m.HandleReply(inbound("no", "8")) {
+			t.Fatal("Expected a reply from another topic to be ignored")
+		}
+		if m.HandleReply(inbound("what is this?", "7")) {
+			t.Fatal("Expected an unrelated message to be ignored")
+		}
+		if !m.HandleReply(inbound("No.", "7")) {
+			t.Fatal("Expected the reply to be handled")
+		}
+		if err := waitApproval(t, done); !errors.Is(err, ErrApprovalDenied) {
+			t.Errorf("Expected ErrApprovalDenied, got %v", err)
+		}
+	})
+
+	t.Run("other users cannot answer", func(t *testing.T) {
+		msgBus := bus.NewMessageBus()
+		m := NewApprovalManager(msgBus, time.Second)
+		out, done := requestApproval(t, m, msgBus, req)
+
+		press := inbound(out.Buttons[0].Data, "7")
+		press.SenderID = "mallory"
+		if !m.HandleReply(press) {
+			t.Fatal("Expected another user's button press to be swallowed")
+		}
+		reply := inbound("yes", "7")
+		reply.SenderID = "mallory"
+		if m.HandleReply(reply) {
+			t.Fatal("Expected another user's reply to be ignored")
+		}
+		if !m.HandleReply(inbound("yes", "7")) {
+			t.Fatal("Expected the requester's reply to be handled")
+		}
+		if err := waitApproval(t, done); err != nil {
+			t.Errorf("Expected approval, got %v", err)
+		}
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		msgBus := bus.NewMessageBus()
+		m := NewApprovalManager(msgBus, 20*time.Millisecond)
+		_, done := requestApproval(t, m, msgBus, req)
+
+		if err := waitApproval(t, done); !errors.Is(err, ErrApprovalTimeout) {
+			t.Errorf("Expected ErrApprovalTimeout, got %v", err)
+		}
+		if m.HandleReply(inbound("yes", "7")) {
+			t.Error("Expected a reply after the timeout to be ignored")
+		}
+	})
+
+	t.Run("internal channel", func(t *testing.T) {
+		m := NewApprovalManager(bus.NewMessageBus(), time.Second)
+		err := m.RequestApproval(context.Background(), ApprovalRequest{Tool: "exec", Channel: "cli", ChatID: "direct"})
+		if !errors.Is(err, ErrNoApprovalChannel) {
+			t.Errorf("Expected ErrNoApprovalChannel, got %v", err)
+		}
+	})
+}
+
+// countingTool counts how often it runs.
+type countingTool struct {
+	runs atomic.Int32
+}
+
+func (t *countingTool) Name() string        { return "exec" }
+func (t *countingTool) Description() string { return "counting test tool" }
+
+func (t *countingTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+}
+
+func (t *countingTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	t.runs.Add(1)
+	return SilentResult("ran")
+}
+
+// staticApprover answers every request with err.
+type staticApprover struct {
+	err  error
+	last ApprovalRequest
+}
+
+func (a *staticApprover) RequestApproval(ctx context.Context, req ApprovalRequest) error {
+	a.last = req
+	return a.err
+}
+
+func TestToolRegistry_ExecuteWithContext_Approval(t *testing.T) {
+	policy, _ := NewApprovalPolicy([]ApprovalRule{{Tool: "exec"}})
+
+	t.Run("approved call runs", func(t *testing.T) {
+		tool := &countingTool{}
+		approver := &staticApprover{}
+		r := NewToolRegistry()
+		r.Register(tool)
+		r.SetApproval(policy, approver)
+
+		result := r.ExecuteWithContext(context.Background(), "exec", nil, "telegram", "42", nil, nil)
+		if result.IsError || tool.runs.Load() != 1 {
+			t.Fatalf("Expected the approved call to run, got %+v", result)
+		}
+		if approver.last.Channel != "telegram" || approver.last.ChatID != "42" {
+			t.Errorf("Expected the request to target telegram:42, got %s:%s", approver.last.Channel, approver.last.ChatID)
+		}
+	})
+
+	t.Run("denied call does not run", func(t *testing.T) {
+		tool := &countingTool{}
+		r := NewToolRegistry()
+		r.Register(tool)
+		r.SetApproval(policy, &staticApprover{err: ErrApprovalDenied})
+
+		result := r.ExecuteWithContext(context.Background(), "exec", nil, "telegram", "42", nil, nil)
+		if !result.IsError || !errors.Is(result.Err, ErrApprovalDenied) {
+			t.Errorf("Expected a denial error result, got %+v", result)
+		}
+		if n := tool.runs.Load(); n != 0 {
+			t.Errorf("Expected the denied call not to run, ran %d times", n)
+		}
+	})
+}
@@ -11,26 +11,52 @@ type Tool interface {
 }
 
 // ContextualTool is an optional interface that tools can implement
-// to receive the current message context (channel, chatID)
+// to receive a default message context (channel, chatID). Per-call values
+// arrive through the ExecutionContext on the Execute ctx and take precedence.
 type ContextualTool interface {
 	Tool
 	SetContext(channel, chatID string)
 }
 
 // MetadataAwareTool is an optional interface that tools can implement
-// to receive inbound message metadata (thread_id, message_id, etc.)
+// to receive default inbound message metadata (thread_id, message_id, etc.).
+// Per-call metadata arrives through the ExecutionContext.
 type MetadataAwareTool interface {
 	Tool
 	SetMetadata(metadata map[string]string)
 }
 
 // SessionAwareTool is an optional interface that tools can implement
-// to receive the current conversation summary for context continuity.
+// to receive a default conversation summary for context continuity.
+// Per-call summaries arrive through the ExecutionContext.
 type SessionAwareTool interface {
 	Tool
 	SetSessionSummary(summary string)
 }
 
+// SerialTool is an optional interface for tools whose calls must not overlap
+// with other tool calls from the same LLM response, e.g. because they run
+// commands, write files or send messages whose order matters.
+type SerialTool interface {
+	Tool
+	Serial() bool
+}
+
+// ReadOnlyTool is an optional interface for tools that declare themselves
+// free of side effects: they only read files, the web or memory. Tools that
+// do not implement it are assumed to have side effects, and are hidden in
+// plan mode.
+type ReadOnlyTool interface {
+	Tool
+	ReadOnly() bool
+}
+
+// IsReadOnly reports whether tool declares itself free of side effects.
+func IsReadOnly(tool Tool) bool {
+	ro, ok := tool.(ReadOnlyTool)
+	return ok && ro.ReadOnly()
+}
+
 // AsyncCallback is a function type that async tools use to notify completion.
 // When an async tool finishes its work, it calls this callback with the result.
 //
@@ -55,7 +81,8 @@ type AsyncCallback func(ctx context.Context, result *ToolResult)
 // asynchronous execution with completion callbacks.
 //
 // Async tools return immediately with an AsyncResult, then notify completion
-// via the callback set by SetCallback.
+// via the callback from the ExecutionContext, or the one set by SetCallback
+// when the call carries none.
 //
 // This is useful for:
 // - Long-running operations that shouldn't block the agent loop
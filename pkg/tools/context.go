@@ -0,0 +1,118 @@
+package tools
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ExecutionContext carries the per-call context a tool needs to act on behalf
+// of the current turn: where the triggering message came from, its metadata,
+// the conversation summary and the async completion callback.
+//
+// It travels on context.Context rather than being stored on the tool, so a
+// single tool instance can serve several sessions concurrently. The Set*
+// methods of ContextualTool, MetadataAwareTool, SessionAwareTool and AsyncTool
+// only provide defaults for calls made without an ExecutionContext.
+type ExecutionContext struct {
+	Channel        string
+	ChatID         string
+	Metadata       map[string]string
+	SenderID       string // user whose message started the turn
+	SessionSummary string
+	AsyncCallback  AsyncCallback
+	ReadOnly       bool // plan mode: only read-only tools may run
+
+	turn *turnState // shared by every context derived within the same turn
+}
+
+// turnState records what tools did during one processing turn.
+type turnState struct {
+	messageSent atomic.Bool
+}
+
+type executionContextKey struct{}
+
+// NewExecutionContext creates an execution context for a new processing turn.
+func NewExecutionContext(channel, chatID string, metadata map[string]string) *ExecutionContext {
+	return &ExecutionContext{
+		Channel:  channel,
+		ChatID:   chatID,
+		Metadata: metadata,
+		turn:     &turnState{},
+	}
+}
+
+// WithExecutionContext returns a copy of ctx carrying ec.
+func WithExecutionContext(ctx context.Context, ec *ExecutionContext) context.Context {
+	return context.WithValue(ctx, executionContextKey{}, ec)
+}
+
+// ExecutionContextFrom returns the execution context attached to ctx, or nil.
+func ExecutionContextFrom(ctx context.Context) *ExecutionContext {
+	if ctx == nil {
+		return nil
+	}
+	ec, _ := ctx.Value(executionContextKey{}).(*ExecutionContext)
+	return ec
+}
+
+// Derive returns a copy of ec with the non-empty arguments overriding the
+// inherited values. The copy shares turn state with ec, so a message sent from
+// a nested call is still visible to the turn that started it. Derive on a nil
+// receiver starts a new turn.
+func (ec *ExecutionContext) Derive(channel, chatID string, metadata map[string]string) *ExecutionContext {
+	if ec == nil {
+		return NewExecutionContext(channel, chatID, metadata)
+	}
+
+	derived := *ec
+	if channel != "" {
+		derived.Channel = channel
+	}
+	if chatID != "" {
+		derived.ChatID = chatID
+	}
+	if metadata != nil {
+		derived.Metadata = metadata
+	}
+	if derived.turn == nil {
+		derived.turn = &turnState{}
+	}
+	return &derived
+}
+
+// MessageSent reports whether the message tool delivered a message during
+// the turn this context belongs to.
+func (ec *ExecutionContext) MessageSent() bool {
+	return ec != nil && ec.turn != nil && ec.turn.messageSent.Load()
+}
+
+func (ec *ExecutionContext) markMessageSent() {
+	if ec != nil && ec.turn != nil {
+		ec.turn.messageSent.Store(true)
+	}
+}
+
+// routeFromContext returns the channel and chat ID from the execution context
+// in ctx, falling back to the given defaults for any value it does not set.
+func routeFromContext(ctx context.Context, defaultChannel, defaultChatID string) (string, string) {
+	channel, chatID := defaultChannel, defaultChatID
+	if ec := ExecutionContextFrom(ctx); ec != nil {
+		if ec.Channel != "" {
+			channel = ec.Channel
+		}
+		if ec.ChatID != "" {
+			chatID = ec.ChatID
+		}
+	}
+	return channel, chatID
+}
+
+// metadataFromContext returns the inbound metadata from the execution context
+// in ctx, falling back to the given default.
+func metadataFromContext(ctx context.Context, defaultMetadata map[string]string) map[string]string {
+	if ec := ExecutionContextFrom(ctx); ec != nil && ec.Metadata != nil {
+		return ec.Metadata
+	}
+	return defaultMetadata
+}
@@ -26,6 +26,11 @@ func (t *EditFileTool) Name() string {
 	return "edit_file"
 }
 
+// Serial keeps edits ordered with respect to other file operations.
+func (t *EditFileTool) Serial() bool {
+	return true
+}
+
 func (t *EditFileTool) Description() string {
 	return "Edit a file by replacing old_text with new_text. The old_text must exist exactly in the file."
 }
@@ -114,6 +119,11 @@ func (t *AppendFileTool) Name() string {
 	return "append_file"
 }
 
+// Serial keeps appends ordered with respect to other file operations.
+func (t *AppendFileTool) Serial() bool {
+	return true
+}
+
 func (t *AppendFileTool) Description() string {
 	return "Append content to the end of a file"
 }
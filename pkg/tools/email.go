@@ -47,6 +47,11 @@ func (t *EmailTool) Name() string {
 	return "email"
 }
 
+// Serial keeps mailbox changes and outgoing mail in order.
+func (t *EmailTool) Serial() bool {
+	return true
+}
+
 func (t *EmailTool) Description() string {
 	return "Access your M365 email inbox. Can list recent/unread emails, search by sender or subject, read full email bodies, mark as read, archive, list folders, send new emails, and reply to existing emails. Uses OAuth2 with auto-refresh."
 }
@@ -49,6 +49,10 @@ func (t *ReadFileTool) Name() string {
 	return "read_file"
 }
 
+func (t *ReadFileTool) ReadOnly() bool {
+	return true
+}
+
 func (t *ReadFileTool) Description() string {
 	return "Read the contents of a file"
 }
@@ -98,6 +102,11 @@ func (t *WriteFileTool) Name() string {
 	return "write_file"
 }
 
+// Serial keeps writes ordered with respect to other file operations.
+func (t *WriteFileTool) Serial() bool {
+	return true
+}
+
 func (t *WriteFileTool) Description() string {
 	return "Write content to a file"
 }
@@ -160,6 +169,10 @@ func (t *ListDirTool) Name() string {
 	return "list_dir"
 }
 
+func (t *ListDirTool) ReadOnly() bool {
+	return true
+}
+
 func (t *ListDirTool) Description() string {
 	return "List files and directories in a path"
 }
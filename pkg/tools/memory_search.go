@@ -21,6 +21,10 @@ func (t *MemorySearchTool) Name() string {
 	return "search_memory"
 }
 
+func (t *MemorySearchTool) ReadOnly() bool {
+	return true
+}
+
 func (t *MemorySearchTool) Description() string {
 	return "Search your memory of past conversations and knowledge about the user. You SHOULD call this proactively at the start of conversations and whenever the user mentions anything that might relate to prior context, preferences, or past discussions. Do not wait to be asked — if prior knowledge could help, search first."
 }
@@ -3,6 +3,7 @@ package tools
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 )
 
 type SendCallback func(channel, chatID, content string, metadata map[string]string) error
@@ -11,7 +12,7 @@ type MessageTool struct {
 	sendCallback    SendCallback
 	defaultChannel  string
 	defaultChatID   string
-	sentInRound     bool              // Tracks whether a message was sent in the current processing round
+	sentInRound     atomic.Bool       // Tracks whether a message was sent since the last SetContext
 	inboundMetadata map[string]string // Metadata from the inbound message (thread_id, etc.)
 }
 
@@ -23,6 +24,11 @@ func (t *MessageTool) Name() string {
 	return "message"
 }
 
+// Serial keeps messages in the order the model sent them.
+func (t *MessageTool) Serial() bool {
+	return true
+}
+
 func (t *MessageTool) Description() string {
 	return "Send a message to the user on a chat channel. Use this to deliver results, updates, or alerts. For Telegram forum topics, include thread_id to target a specific topic."
 }
@@ -55,12 +61,14 @@ func (t *MessageTool) Parameters() map[string]interface{} {
 func (t *MessageTool) SetContext(channel, chatID string) {
 	t.defaultChannel = channel
 	t.defaultChatID = chatID
-	t.sentInRound = false // Reset send tracking for new processing round
+	t.sentInRound.Store(false) // Reset send tracking for new processing round
 }
 
-// HasSentInRound returns true if the message tool sent a message during the current round.
+// HasSentInRound returns true if the message tool sent a message since the
+// last SetContext. Concurrent callers should use ExecutionContext.MessageSent,
+// which is scoped to a single turn.
 func (t *MessageTool) HasSentInRound() bool {
-	return t.sentInRound
+	return t.sentInRound.Load()
 }
 
 func (t *MessageTool) SetSendCallback(callback SendCallback) {
@@ -81,11 +89,12 @@ func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{})
 	channel, _ := args["channel"].(string)
 	chatID, _ := args["chat_id"].(string)
 
+	defaultChannel, defaultChatID := routeFromContext(ctx, t.defaultChannel, t.defaultChatID)
 	if channel == "" {
-		channel = t.defaultChannel
+		channel = defaultChannel
 	}
 	if chatID == "" {
-		chatID = t.defaultChatID
+		chatID = defaultChatID
 	}
 
 	if channel == "" || chatID == "" {
@@ -100,8 +109,8 @@ func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{})
 	var metadata map[string]string
 	if threadID, ok := args["thread_id"].(string); ok && threadID != "" {
 		metadata = map[string]string{"thread_id": threadID}
-	} else if t.inboundMetadata != nil {
-		if threadID, ok := t.inboundMetadata["thread_id"]; ok && threadID != "" {
+	} else if inbound := metadataFromContext(ctx, t.inboundMetadata); inbound != nil {
+		if threadID, ok := inbound["thread_id"]; ok && threadID != "" {
 			metadata = map[string]string{"thread_id": threadID}
 		}
 	}
@@ -114,7 +123,8 @@ func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{})
 		}
 	}
 
-	t.sentInRound = true
+	t.sentInRound.Store(true)
+	ExecutionContextFrom(ctx).markMessageSent()
 	// Silent: user already received the message directly
 	return &ToolResult{
 		ForLLM: fmt.Sprintf("Message sent to %s:%s", channel, chatID),
@@ -357,3 +357,45 @@ func TestMessageTool_Parameters(t *testing.T) {
 		t.Error("Expected thread_id type to be 'string'")
 	}
 }
+
+func TestMessageTool_Execute_UsesExecutionContext(t *testing.T) {
+	tool := NewMessageTool()
+	tool.SetContext("default-channel", "default-chat-id")
+
+	var sentChannel, sentChatID string
+	var sentMetadata map[string]string
+	tool.SetSendCallback(func(channel, chatID, content string, metadata map[string]string) error {
+		sentChannel = channel
+		sentChatID = chatID
+		sentMetadata = metadata
+		return nil
+	})
+
+	ec := NewExecutionContext("telegram", "-1003732393703", map[string]string{"thread_id": "35"})
+	ctx := WithExecutionContext(context.Background(), ec)
+
+	result := tool.Execute(ctx, map[string]interface{}{"content": "Hello"})
+	if result.IsError {
+		t.Fatalf("Expected success, got error: %s", result.ForLLM)
+	}
+
+	// Per-call context takes precedence over the SetContext defaults
+	if sentChannel != "telegram" || sentChatID != "-1003732393703" {
+		t.Errorf("Expected telegram:-1003732393703, got %s:%s", sentChannel, sentChatID)
+	}
+	if sentMetadata["thread_id"] != "35" {
+		t.Errorf("Expected thread_id '35' from execution context, got %v", sentMetadata)
+	}
+
+	// The send is recorded on the turn, including through derived contexts
+	if !ec.MessageSent() {
+		t.Error("Expected MessageSent=true on the execution context")
+	}
+	other := NewExecutionContext("telegram", "other", nil)
+	if other.MessageSent() {
+		t.Error("Expected an unrelated turn to be unaffected")
+	}
+	if !ec.Derive("", "", nil).MessageSent() {
+		t.Error("Expected derived context to share turn state")
+	}
+}
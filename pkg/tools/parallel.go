@@ -0,0 +1,57 @@
+package tools
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// ToolCallFunc executes a single tool call and returns its result.
+type ToolCallFunc func(ctx context.Context, tc providers.ToolCall) *ToolResult
+
+// IsSerial reports whether calls to the named tool must run on their own.
+func (r *ToolRegistry) IsSerial(name string) bool {
+	tool, ok := r.Get(name)
+	if !ok {
+		return false
+	}
+	st, ok := tool.(SerialTool)
+	return ok && st.Serial()
+}
+
+// ExecuteToolCalls runs the tool calls from one LLM response and returns the
+// results in call order. Consecutive calls to parallel-safe tools run
+// concurrently, at most maxParallel at a time; a call to a SerialTool waits
+// for every call before it and runs alone. maxParallel <= 1 runs every call
+// sequentially.
+func (r *ToolRegistry) ExecuteToolCalls(ctx context.Context, calls []providers.ToolCall, maxParallel int, execute ToolCallFunc) []*ToolResult {
+	results := make([]*ToolResult, len(calls))
+	if maxParallel <= 1 {
+		for i, tc := range calls {
+			results[i] = execute(ctx, tc)
+		}
+		return results
+	}
+
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for i, tc := range calls {
+		if r.IsSerial(tc.Name) {
+			wg.Wait()
+			results[i] = execute(ctx, tc)
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, tc providers.ToolCall) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = execute(ctx, tc)
+		}(i, tc)
+	}
+	wg.Wait()
+
+	return results
+}
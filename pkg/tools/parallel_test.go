@@ -0,0 +1,130 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// concurrencyTool records how many of its calls overlap.
+type concurrencyTool struct {
+	name    string
+	serial  bool
+	tracker *concurrencyTracker
+}
+
+type concurrencyTracker struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+	// overlaps is incremented when a serial call runs alongside another call.
+	overlaps atomic.Int32
+}
+
+func (t *concurrencyTool) Name() string        { return t.name }
+func (t *concurrencyTool) Description() string { return "concurrency test tool" }
+func (t *concurrencyTool) Serial() bool        { return t.serial }
+
+func (t *concurrencyTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+}
+
+func (t *concurrencyTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	tr := t.tracker
+	tr.mu.Lock()
+	tr.running++
+	if tr.running > tr.peak {
+		tr.peak = tr.running
+	}
+	if t.serial && tr.running > 1 {
+		tr.overlaps.Add(1)
+	}
+	tr.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	tr.mu.Lock()
+	if t.serial && tr.running > 1 {
+		tr.overlaps.Add(1)
+	}
+	tr.running--
+	tr.mu.Unlock()
+
+	return SilentResult(fmt.Sprintf("%v", args["id"]))
+}
+
+func TestToolRegistry_ExecuteToolCalls(t *testing.T) {
+	tests := []struct {
+		name        string
+		calls       []string // tool name per call
+		maxParallel int
+		wantPeak    int
+	}{
+		{
+			name:        "sequential when limit is one",
+			calls:       []string{"fetch", "fetch", "fetch"},
+			maxParallel: 1,
+			wantPeak:    1,
+		},
+		{
+			name:        "independent calls run concurrently",
+			calls:       []string{"fetch", "fetch", "fetch"},
+			maxParallel: 4,
+			wantPeak:    3,
+		},
+		{
+			name:        "limit caps concurrency",
+			calls:       []string{"fetch", "fetch", "fetch", "fetch"},
+			maxParallel: 2,
+			wantPeak:    2,
+		},
+		{
+			name:        "serial tool runs alone",
+			calls:       []string{"fetch", "fetch", "write", "fetch", "fetch"},
+			maxParallel: 4,
+			wantPeak:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &concurrencyTracker{}
+			r := NewToolRegistry()
+			r.Register(&concurrencyTool{name: "fetch", tracker: tracker})
+			r.Register(&concurrencyTool{name: "write", serial: true, tracker: tracker})
+
+			calls := make([]providers.ToolCall, len(tt.calls))
+			for i, name := range tt.calls {
+				calls[i] = providers.ToolCall{
+					ID:        fmt.Sprintf("call_%d", i),
+					Name:      name,
+					Arguments: map[string]interface{}{"id": i},
+				}
+			}
+
+			results := r.ExecuteToolCalls(context.Background(), calls, tt.maxParallel, func(ctx context.Context, tc providers.ToolCall) *ToolResult {
+				return r.ExecuteWithContext(ctx, tc.Name, tc.Arguments, "", "", nil, nil)
+			})
+
+			if len(results) != len(calls) {
+				t.Fatalf("Expected %d results, got %d", len(calls), len(results))
+			}
+			for i, result := range results {
+				if result.ForLLM != fmt.Sprintf("%d", i) {
+					t.Errorf("Result %d out of order: got %q", i, result.ForLLM)
+				}
+			}
+			if tracker.peak != tt.wantPeak {
+				t.Errorf("Expected peak concurrency %d, got %d", tt.wantPeak, tracker.peak)
+			}
+			if n := tracker.overlaps.Load(); n != 0 {
+				t.Errorf("Serial tool overlapped with other calls %d times", n)
+			}
+		})
+	}
+}
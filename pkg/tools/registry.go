@@ -11,8 +11,11 @@ import (
 )
 
 type ToolRegistry struct {
-	tools map[string]Tool
-	mu    sync.RWMutex
+	tools    map[string]Tool
+	mu       sync.RWMutex
+	allowed  map[string]bool // nil allows every tool
+	policy   *ApprovalPolicy
+	approver Approver
 }
 
 func NewToolRegistry() *ToolRegistry {
@@ -21,12 +24,43 @@ func NewToolRegistry() *ToolRegistry {
 	}
 }
 
+// Register adds tool to the registry, unless an allowlist excludes it.
 func (r *ToolRegistry) Register(tool Tool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if r.allowed != nil && !r.allowed[tool.Name()] {
+		logger.DebugCF("tool", "Tool not in allowlist, skipping registration",
+			map[string]interface{}{
+				"tool": tool.Name(),
+			})
+		return
+	}
 	r.tools[tool.Name()] = tool
 }
 
+// SetAllowlist restricts the registry to the named tools. Registered tools
+// not in names are removed and later registrations of them are ignored. An
+// empty list allows every tool again, though removed tools stay removed.
+func (r *ToolRegistry) SetAllowlist(names []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(names) == 0 {
+		r.allowed = nil
+		return
+	}
+
+	r.allowed = make(map[string]bool, len(names))
+	for _, name := range names {
+		r.allowed[name] = true
+	}
+	for name := range r.tools {
+		if !r.allowed[name] {
+			delete(r.tools, name)
+		}
+	}
+}
+
 func (r *ToolRegistry) Get(name string) (Tool, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -34,15 +68,23 @@ func (r *ToolRegistry) Get(name string) (Tool, bool) {
 	return tool, ok
 }
 
+// SetApproval makes calls matching policy wait for approver before they run.
+// A nil policy or approver turns approval off.
+func (r *ToolRegistry) SetApproval(policy *ApprovalPolicy, approver Approver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = policy
+	r.approver = approver
+}
+
 func (r *ToolRegistry) Execute(ctx context.Context, name string, args map[string]interface{}) *ToolResult {
 	return r.ExecuteWithContext(ctx, name, args, "", "", nil, nil)
 }
 
 // ExecuteWithContext executes a tool with channel/chatID context and optional async callback.
-// If the tool implements AsyncTool and a non-nil callback is provided,
-// the callback will be set on the tool before execution.
-// If the tool implements MetadataAwareTool and metadata is non-nil,
-// SetMetadata will be called before execution.
+// The channel, chatID, metadata and callback are attached to ctx as an
+// ExecutionContext derived from any context already present, so concurrent
+// calls to the same tool instance never observe each other's values.
 func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args map[string]interface{}, channel, chatID string, asyncCallback AsyncCallback, metadata map[string]string) *ToolResult {
 	logger.InfoCF("tool", "Tool execution started",
 		map[string]interface{}{
@@ -59,23 +101,29 @@ func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args
 		return ErrorResult(fmt.Sprintf("tool %q not found", name)).WithError(fmt.Errorf("tool not found"))
 	}
 
-	// If tool implements ContextualTool, set context
-	if contextualTool, ok := tool.(ContextualTool); ok && channel != "" && chatID != "" {
-		contextualTool.SetContext(channel, chatID)
+	ec := ExecutionContextFrom(ctx).Derive(channel, chatID, metadata)
+	if asyncCallback != nil {
+		ec.AsyncCallback = asyncCallback
+		if _, ok := tool.(AsyncTool); ok {
+			logger.DebugCF("tool", "Async callback injected",
+				map[string]interface{}{
+					"tool": name,
+				})
+		}
 	}
+	ctx = WithExecutionContext(ctx, ec)
 
-	// If tool implements AsyncTool and callback is provided, set callback
-	if asyncTool, ok := tool.(AsyncTool); ok && asyncCallback != nil {
-		asyncTool.SetCallback(asyncCallback)
-		logger.DebugCF("tool", "Async callback injected",
+	if ec.ReadOnly && !IsReadOnly(tool) {
+		logger.WarnCF("tool", "Tool with side effects blocked in plan mode",
 			map[string]interface{}{
 				"tool": name,
 			})
+		return ErrorResult(fmt.Sprintf("Tool %q has side effects and cannot run in plan mode. Put this step in your plan instead.", name)).
+			WithError(fmt.Errorf("tool not allowed in plan mode"))
 	}
 
-	// If tool implements MetadataAwareTool and metadata is provided, set metadata
-	if metaTool, ok := tool.(MetadataAwareTool); ok && metadata != nil {
-		metaTool.SetMetadata(metadata)
+	if result := r.checkApproval(ctx, name, args, ec); result != nil {
+		return result
 	}
 
 	start := time.Now()
@@ -108,6 +156,37 @@ func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args
 	return result
 }
 
+// checkApproval asks for approval when the policy requires it. It returns nil
+// if the call may run, or the result to report to the LLM instead.
+func (r *ToolRegistry) checkApproval(ctx context.Context, name string, args map[string]interface{}, ec *ExecutionContext) *ToolResult {
+	r.mu.RLock()
+	policy, approver := r.policy, r.approver
+	r.mu.RUnlock()
+
+	if approver == nil || !policy.Requires(name, args) {
+		return nil
+	}
+
+	err := approver.RequestApproval(ctx, ApprovalRequest{
+		Tool:     name,
+		Args:     args,
+		Channel:  ec.Channel,
+		ChatID:   ec.ChatID,
+		Metadata: ec.Metadata,
+		SenderID: ec.SenderID,
+	})
+	if err == nil {
+		return nil
+	}
+
+	logger.WarnCF("tool", "Tool call not approved",
+		map[string]interface{}{
+			"tool":  name,
+			"error": err.Error(),
+		})
+	return ErrorResult(fmt.Sprintf("Tool %q was not run: %v. Do not retry it unless the user asks you to.", name, err)).WithError(err)
+}
+
 func (r *ToolRegistry) GetDefinitions() []map[string]interface{} {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -122,11 +201,24 @@ func (r *ToolRegistry) GetDefinitions() []map[string]interface{} {
 // ToProviderDefs converts tool definitions to provider-compatible format.
 // This is the format expected by LLM provider APIs.
 func (r *ToolRegistry) ToProviderDefs() []providers.ToolDefinition {
+	return r.providerDefs(false)
+}
+
+// ReadOnlyProviderDefs is ToProviderDefs restricted to read-only tools, for
+// turns in plan mode.
+func (r *ToolRegistry) ReadOnlyProviderDefs() []providers.ToolDefinition {
+	return r.providerDefs(true)
+}
+
+func (r *ToolRegistry) providerDefs(readOnly bool) []providers.ToolDefinition {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	definitions := make([]providers.ToolDefinition, 0, len(r.tools))
 	for _, tool := range r.tools {
+		if readOnly && !IsReadOnly(tool) {
+			continue
+		}
 		schema := ToolToSchema(tool)
 
 		// Safely extract nested values with type checks
@@ -0,0 +1,33 @@
+package tools
+
+import (
+	"context"
+	"testing"
+)
+
+func TestToolRegistry_PlanMode(t *testing.T) {
+	exec := &countingTool{}
+	r := NewToolRegistry()
+	r.Register(exec)
+	r.Register(NewThinkTool())
+
+	defs := r.ReadOnlyProviderDefs()
+	if len(defs) != 1 || defs[0].Function.Name != "think" {
+		t.Errorf("Expected only the read-only tool to be offered, got %+v", defs)
+	}
+	if len(r.ToProviderDefs()) != 2 {
+		t.Error("Expected every tool to be offered outside plan mode")
+	}
+
+	ec := NewExecutionContext("telegram", "42", nil)
+	ec.ReadOnly = true
+	ctx := WithExecutionContext(context.Background(), ec)
+
+	result := r.ExecuteWithContext(ctx, "exec", nil, "", "", nil, nil)
+	if !result.IsError || exec.runs.Load() != 0 {
+		t.Errorf("Expected a tool with side effects to be blocked in plan mode, got %+v", result)
+	}
+	if result := r.ExecuteWithContext(ctx, "think", map[string]interface{}{"thought": "look first"}, "", "", nil, nil); result.IsError {
+		t.Errorf("Expected a read-only tool to run in plan mode, got %+v", result)
+	}
+}
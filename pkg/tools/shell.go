@@ -70,6 +70,11 @@ func (t *ExecTool) Name() string {
 	return "exec"
 }
 
+// Serial keeps commands in the order the model issued them.
+func (t *ExecTool) Serial() bool {
+	return true
+}
+
 func (t *ExecTool) Description() string {
 	if !t.restrictToWorkspace {
 		return "Execute any shell command on this VPS and return its output. You have full system access — install packages, manage services, edit configs, deploy software."
@@ -68,7 +68,12 @@ func (t *SpawnTool) Execute(ctx context.Context, args map[string]interface{}) *T
 	}
 
 	// Pass callback to manager for async completion notification
-	result, err := t.manager.Spawn(ctx, task, label, t.originChannel, t.originChatID, t.callback)
+	originChannel, originChatID := routeFromContext(ctx, t.originChannel, t.originChatID)
+	callback := t.callback
+	if ec := ExecutionContextFrom(ctx); ec != nil && ec.AsyncCallback != nil {
+		callback = ec.AsyncCallback
+	}
+	result, err := t.manager.Spawn(ctx, task, label, originChannel, originChatID, callback)
 	if err != nil {
 		return ErrorResult(fmt.Sprintf("failed to spawn subagent: %v", err))
 	}
@@ -16,13 +16,6 @@ import (
 	"github.com/sipeed/picoclaw/pkg/state"
 )
 
-// thinkTagPattern matches <think>...</think> reasoning blocks (including multiline).
-var thinkTagPattern = regexp.MustCompile(`(?s)<think>.*?</think>\s*`)
-
-func stripThinkTags(s string) string {
-	return strings.TrimSpace(thinkTagPattern.ReplaceAllString(s, ""))
-}
-
 // ---------------------------------------------------------------------------
 // ConsultSpecialistTool — consult a domain specialist
 // ---------------------------------------------------------------------------
@@ -36,12 +29,18 @@ type ConsultSpecialistTool struct {
 	vectorStore    *memory.VectorStore
 	extractor      *memory.KnowledgeExtractor
 	maxIter        int
+	maxParallel    int
 	workspace      string
+	llmOptions     SpecialistOptionsFunc
 	channel        string
 	chatID         string
 	sessionSummary string // conversation summary for context continuity
 }
 
+// SpecialistOptionsFunc returns the generation options for LLM calls made on
+// behalf of the named specialist.
+type SpecialistOptionsFunc func(specialist string) map[string]any
+
 // ConsultSpecialistConfig holds configuration for creating a ConsultSpecialistTool.
 type ConsultSpecialistConfig struct {
 	Loader      *specialists.SpecialistLoader
@@ -51,7 +50,9 @@ type ConsultSpecialistConfig struct {
 	VectorStore *memory.VectorStore
 	Extractor   *memory.KnowledgeExtractor
 	MaxIter     int
+	MaxParallel int
 	Workspace   string
+	LLMOptions  SpecialistOptionsFunc // optional; overrides the default generation options
 }
 
 func NewConsultSpecialistTool(cfg ConsultSpecialistConfig) *ConsultSpecialistTool {
@@ -63,7 +64,9 @@ func NewConsultSpecialistTool(cfg ConsultSpecialistConfig) *ConsultSpecialistToo
 		vectorStore: cfg.VectorStore,
 		extractor:   cfg.Extractor,
 		maxIter:     cfg.MaxIter,
+		maxParallel: cfg.MaxParallel,
 		workspace:   cfg.Workspace,
+		llmOptions:  cfg.LLMOptions,
 		channel:     "specialist",
 		chatID:      "direct",
 	}
@@ -158,8 +161,12 @@ func (t *ConsultSpecialistTool) Execute(ctx context.Context, args map[string]int
 		"Do the whole job — chain tool calls, read files, run scripts. Don't stop halfway to ask permission."
 
 	// Inject conversation summary so specialist has context continuity
-	if t.sessionSummary != "" {
-		systemPrompt += "\n\n## Conversation Context\n\nThe user has been discussing the following (summary of recent conversation):\n\n" + t.sessionSummary
+	sessionSummary := t.sessionSummary
+	if ec := ExecutionContextFrom(ctx); ec != nil && ec.SessionSummary != "" {
+		sessionSummary = ec.SessionSummary
+	}
+	if sessionSummary != "" {
+		systemPrompt += "\n\n## Conversation Context\n\nThe user has been discussing the following (summary of recent conversation):\n\n" + sessionSummary
 	}
 
 	// Build messages
@@ -174,22 +181,28 @@ func (t *ConsultSpecialistTool) Execute(ctx context.Context, args map[string]int
 	}
 
 	// Run tool loop with specialist's tools
+	channel, chatID := routeFromContext(ctx, t.channel, t.chatID)
+	llmOptions := map[string]any{
+		"max_tokens":  8192,
+		"temperature": 0.4,
+	}
+	if t.llmOptions != nil {
+		llmOptions = providers.MergeOptions(llmOptions, t.llmOptions(specialistName))
+	}
 	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
-		Provider:      t.provider,
-		Model:         t.model,
-		Tools:         t.tools,
-		MaxIterations: t.maxIter,
-		LLMOptions: map[string]any{
-			"max_tokens":  8192,
-			"temperature": 0.4,
-		},
-	}, messages, t.channel, t.chatID)
+		Provider:         t.provider,
+		Model:            t.model,
+		Tools:            t.tools,
+		MaxIterations:    t.maxIter,
+		LLMOptions:       llmOptions,
+		MaxParallelTools: t.maxParallel,
+	}, messages, channel, chatID)
 
 	if err != nil {
 		return ErrorResult(fmt.Sprintf("Specialist consultation failed: %v", err))
 	}
 
-	result := stripThinkTags(loopResult.Content)
+	result := providers.StripThinkingTags(loopResult.Content)
 
 	// Async: extract knowledge from the consultation into specialist-scoped memory
 	if t.extractor != nil {
@@ -217,12 +230,13 @@ func (t *ConsultSpecialistTool) Execute(ctx context.Context, args map[string]int
 // ---------------------------------------------------------------------------
 
 type CreateSpecialistTool struct {
-	loader    *specialists.SpecialistLoader
-	provider  providers.LLMProvider
-	model     string
-	workspace string
-	extractor *memory.KnowledgeExtractor
-	store     *memory.VectorStore
+	loader     *specialists.SpecialistLoader
+	provider   providers.LLMProvider
+	model      string
+	workspace  string
+	extractor  *memory.KnowledgeExtractor
+	store      *memory.VectorStore
+	llmOptions SpecialistOptionsFunc
 }
 
 func NewCreateSpecialistTool(loader *specialists.SpecialistLoader, provider providers.LLMProvider, model, workspace string, extractor *memory.KnowledgeExtractor, store *memory.VectorStore) *CreateSpecialistTool {
@@ -236,6 +250,12 @@ func NewCreateSpecialistTool(loader *specialists.SpecialistLoader, provider prov
 	}
 }
 
+// SetLLMOptions sets the function resolving generation options for the
+// persona-drafting call.
+func (t *CreateSpecialistTool) SetLLMOptions(fn SpecialistOptionsFunc) {
+	t.llmOptions = fn
+}
+
 func (t *CreateSpecialistTool) Name() string { return "create_specialist" }
 
 func (t *CreateSpecialistTool) Description() string {
@@ -327,12 +347,16 @@ Now generate the SPECIALIST.md content for the "%s" specialist focused on: %s
 
 Return ONLY the file content, no explanation.`, name, description, name, description)
 
-	resp, err := t.provider.Chat(ctx, []providers.Message{
-		{Role: "user", Content: personaPrompt},
-	}, nil, t.model, map[string]interface{}{
+	llmOptions := map[string]interface{}{
 		"max_tokens":  1024,
 		"temperature": 0.4,
-	})
+	}
+	if t.llmOptions != nil {
+		llmOptions = providers.MergeOptions(llmOptions, t.llmOptions(name))
+	}
+	resp, err := t.provider.Chat(ctx, []providers.Message{
+		{Role: "user", Content: personaPrompt},
+	}, nil, t.model, llmOptions)
 	if err != nil {
 		// Fallback: generate a basic persona without LLM
 		titleName := strings.ToUpper(name[:1]) + name[1:]
@@ -625,14 +649,14 @@ func (t *LinkTopicTool) Execute(ctx context.Context, args map[string]interface{}
 
 	// Extract thread_id from metadata
 	threadID := ""
-	if t.metadata != nil {
-		threadID = t.metadata["thread_id"]
+	if metadata := metadataFromContext(ctx, t.metadata); metadata != nil {
+		threadID = metadata["thread_id"]
 	}
 	if threadID == "" {
 		return ErrorResult("This tool must be used from within a forum topic (no thread_id in metadata).")
 	}
 
-	chatID := t.chatID
+	_, chatID := routeFromContext(ctx, t.channel, t.chatID)
 	if chatID == "" {
 		return ErrorResult("No chat context available.")
 	}
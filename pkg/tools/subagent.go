@@ -30,6 +30,7 @@ type SubagentManager struct {
 	workspace     string
 	tools         *ToolRegistry
 	maxIterations int
+	llmOptions    map[string]any
 	nextID        int
 }
 
@@ -54,6 +55,13 @@ func (sm *SubagentManager) SetTools(tools *ToolRegistry) {
 	sm.tools = tools
 }
 
+// SetLLMOptions sets the generation options used for subagent LLM calls.
+func (sm *SubagentManager) SetLLMOptions(options map[string]any) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.llmOptions = options
+}
+
 // RegisterTool registers a tool for subagent execution.
 func (sm *SubagentManager) RegisterTool(tool Tool) {
 	sm.mu.Lock()
@@ -125,6 +133,7 @@ func (sm *SubagentManager) runTask(ctx context.Context, task *SubagentTask, call
 	sm.mu.RLock()
 	tools := sm.tools
 	maxIter := sm.maxIterations
+	llmOptions := sm.llmOptions
 	sm.mu.RUnlock()
 
 	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
@@ -132,10 +141,10 @@ func (sm *SubagentManager) runTask(ctx context.Context, task *SubagentTask, call
 		Model:         sm.defaultModel,
 		Tools:         tools,
 		MaxIterations: maxIter,
-		LLMOptions: map[string]any{
+		LLMOptions: providers.MergeOptions(map[string]any{
 			"max_tokens":  8192,
 			"temperature": 0.4,
-		},
+		}, llmOptions),
 	}, messages, task.OriginChannel, task.OriginChatID)
 
 	sm.mu.Lock()
@@ -279,10 +288,12 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]interface{})
 	}
 
 	// Use RunToolLoop to execute with tools (same as async SpawnTool)
+	originChannel, originChatID := routeFromContext(ctx, t.originChannel, t.originChatID)
 	sm := t.manager
 	sm.mu.RLock()
 	tools := sm.tools
 	maxIter := sm.maxIterations
+	llmOptions := sm.llmOptions
 	sm.mu.RUnlock()
 
 	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
@@ -290,11 +301,11 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]interface{})
 		Model:         sm.defaultModel,
 		Tools:         tools,
 		MaxIterations: maxIter,
-		LLMOptions: map[string]any{
+		LLMOptions: providers.MergeOptions(map[string]any{
 			"max_tokens":  8192,
 			"temperature": 0.4,
-		},
-	}, messages, t.originChannel, t.originChatID)
+		}, llmOptions),
+	}, messages, originChannel, originChatID)
 
 	if err != nil {
 		return ErrorResult(fmt.Sprintf("Subagent execution failed: %v", err)).WithError(err)
@@ -22,6 +22,8 @@ func NewManageTelegramTool(bot *telego.Bot) *ManageTelegramTool {
 
 func (t *ManageTelegramTool) Name() string { return "manage_telegram" }
 
+func (t *ManageTelegramTool) Serial() bool { return true }
+
 func (t *ManageTelegramTool) Description() string {
 	return "Manage Telegram forum topics and messages. Actions: create_topic (create a new forum topic), close_topic / reopen_topic (manage topic state), pin_message / unpin_message (pin or unpin a message), get_chat_info (get chat details). Requires being in a Telegram chat context."
 }
@@ -71,15 +73,16 @@ func (t *ManageTelegramTool) Execute(ctx context.Context, args map[string]interf
 	}
 
 	// Parse chat ID from context
-	chatID, err := parseTelegramChatID(t.chatID)
+	_, contextChatID := routeFromContext(ctx, "", t.chatID)
+	chatID, err := parseTelegramChatID(contextChatID)
 	if err != nil {
 		return ErrorResult("No valid Telegram chat context. This tool can only be used from a Telegram chat.")
 	}
 
 	// Parse thread_id from metadata
 	threadID := 0
-	if t.metadata != nil {
-		if tid := t.metadata["thread_id"]; tid != "" {
+	if metadata := metadataFromContext(ctx, t.metadata); metadata != nil {
+		if tid := metadata["thread_id"]; tid != "" {
 			fmt.Sscanf(tid, "%d", &threadID)
 		}
 	}
@@ -14,6 +14,10 @@ func (t *ThinkTool) Name() string {
 	return "think"
 }
 
+func (t *ThinkTool) ReadOnly() bool {
+	return true
+}
+
 func (t *ThinkTool) Description() string {
 	return "Use this tool to think through a problem step-by-step before acting. Your thought is private and not shown to the user. Use it when you need to reason about complex decisions, plan multi-step actions, or analyze information before responding."
 }
@@ -23,6 +23,10 @@ type ToolLoopConfig struct {
 	Tools         *ToolRegistry
 	MaxIterations int
 	LLMOptions    map[string]any
+
+	// MaxParallelTools limits how many tool calls from one LLM response run
+	// concurrently. Values <= 1 run them sequentially.
+	MaxParallelTools int
 }
 
 // ToolLoopResult contains the result of running the tool loop.
@@ -113,22 +117,27 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 		}
 		messages = append(messages, assistantMsg)
 
-		// 7. Execute tool calls
-		for _, tc := range response.ToolCalls {
-			argsJSON, _ := json.Marshal(tc.Arguments)
-			argsPreview := utils.Truncate(string(argsJSON), 200)
-			logger.InfoCF("toolloop", fmt.Sprintf("Tool call: %s(%s)", tc.Name, argsPreview),
-				map[string]any{
-					"tool":      tc.Name,
-					"iteration": iteration,
-				})
+		// 7. Execute tool calls (results come back in call order)
+		var toolResults []*ToolResult
+		if config.Tools != nil {
+			toolResults = config.Tools.ExecuteToolCalls(ctx, response.ToolCalls, config.MaxParallelTools, func(ctx context.Context, tc providers.ToolCall) *ToolResult {
+				argsJSON, _ := json.Marshal(tc.Arguments)
+				argsPreview := utils.Truncate(string(argsJSON), 200)
+				logger.InfoCF("toolloop", fmt.Sprintf("Tool call: %s(%s)", tc.Name, argsPreview),
+					map[string]any{
+						"tool":      tc.Name,
+						"iteration": iteration,
+					})
+
+				// Execute tool (no async callback for subagents - they run independently)
+				return config.Tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, channel, chatID, nil, nil)
+			})
+		}
 
-			// Execute tool (no async callback for subagents - they run independently)
-			var toolResult *ToolResult
-			if config.Tools != nil {
-				toolResult = config.Tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, channel, chatID, nil, nil)
-			} else {
-				toolResult = ErrorResult("No tools available")
+		for i, tc := range response.ToolCalls {
+			toolResult := ErrorResult("No tools available")
+			if toolResults != nil {
+				toolResult = toolResults[i]
 			}
 
 			// Determine content for LLM